@@ -3,33 +3,49 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 
+	"github.com/jcgfreitas/pb_api/internal/auth"
+	"github.com/jcgfreitas/pb_api/internal/config"
+	"github.com/jcgfreitas/pb_api/internal/domain"
 	"github.com/jcgfreitas/pb_api/internal/handlers"
+	"github.com/jcgfreitas/pb_api/internal/metrics"
+	"github.com/jcgfreitas/pb_api/internal/ratelimit"
 	"github.com/jcgfreitas/pb_api/internal/repository"
+	"github.com/jcgfreitas/pb_api/internal/repository/migrations"
 	"github.com/jcgfreitas/pb_api/internal/service"
+	"github.com/jcgfreitas/pb_api/internal/tracing"
+	"github.com/jcgfreitas/pb_api/internal/webhook"
 	"github.com/sirupsen/logrus"
 
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/gormtrace"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/migrate"
 	"github.com/jcgfreitas/pb_api/pkg/gormdb/postgres"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/sqlite"
 )
 
+// jwksTimeout bounds the fetch of the JWKS of the identity provider
+const jwksTimeout = 10 * time.Second
+
 func main() {
-	// flag management
-	var wait time.Duration
-	flag.DurationVar(&wait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish")
-	host := flag.String("host", "localhost", "postgres db hostname")
-	user := flag.String("user", "postgres", "postgres db username")
-	dbName := flag.String("name", "postgres", "postgres db name")
-	password := flag.String("password", "password1", "postgres db password")
-	port := flag.String("port", "5432", "postgres db port number")
-	debug := flag.Bool("debug", true, "debug logger level")
-	drop := flag.Bool("dropTable", false, "drop coupons table rows")
-	flag.Parse()
+	// settings of the defaults, config file, environment and flags
+	cfg, args, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv, usage)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	// start logger
 	logger := logrus.New()
@@ -37,44 +53,260 @@ func main() {
 		DisableColors: false,
 		FullTimestamp: true,
 	})
-	if *debug {
+	if cfg.Log.Debug {
 		logger.SetLevel(logrus.DebugLevel)
 	}
 
-	// start db connection
-	logger.Info("starting db connection")
-	db, err := postgres.Open(*host, *port, *user, *dbName, *password)
-	if err != nil {
-		logger.WithError(err).Fatal("failed to connect to database")
+	// the db of the store, nil for the memory store
+	var openDB func() (*gorm.DB, error)
+	switch cfg.Store.Type {
+	case config.StorePostgres:
+		openDB = func() (*gorm.DB, error) { return postgres.Open(cfg.PostgresConfig()) }
+	case config.StoreSQLite:
+		openDB = func() (*gorm.DB, error) { return sqlite.Open(cfg.Store.SQLitePath) }
+	}
+
+	// subcommands
+	if len(args) > 0 {
+		switch args[0] {
+		case "config":
+			if err := runConfig(cfg, args[1:]); err != nil {
+				logger.WithError(err).Fatal("config failed")
+			}
+			return
+		case "migrate":
+			if openDB == nil {
+				logger.WithField("store", cfg.Store.Type).Fatal("migrate requires a db store")
+			}
+			db, migrator := openMigrator(logger, openDB)
+			defer db.Close()
+			if err := runMigrate(migrator, logger, args[1:]); err != nil {
+				logger.WithError(err).Fatal("migrate failed")
+			}
+			return
+		case "keys":
+			if openDB == nil {
+				logger.WithField("store", cfg.Store.Type).Fatal("keys requires a db store")
+			}
+			db, err := openDB()
+			if err != nil {
+				logger.WithError(err).Fatal("failed to connect to database")
+			}
+			defer db.Close()
+			if err := runKeys(auth.NewKeys(repository.NewKeys(db, cfg.Timeouts())), args[1:]); err != nil {
+				logger.WithError(err).Fatal("keys failed")
+			}
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command: %s\n", args[0])
+			os.Exit(2)
+		}
+	}
+
+	// tracing, the statements of every gorm db are traced once the callbacks are registered
+	var tracer *tracing.Tracer
+	shutdownTracing := func(ctx context.Context) error { return nil }
+	if traceCfg := cfg.TracingConfig(); traceCfg.Enabled() {
+		exporter, err := tracing.NewExporter(context.Background(), traceCfg)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to start tracing")
+		}
+		tp := tracing.NewProvider(exporter, traceCfg)
+		gormtrace.Register(gorm.DefaultCallback, tp)
+		tracer = tracing.New(tp)
+		shutdownTracing = tp.Shutdown
+		logger.WithField("endpoint", traceCfg.Endpoint).Info("exporting traces")
 	}
-	defer db.Close()
 
 	// create handler and its chained dependencies
-	repo := repository.New(db)
-	if *drop {
-		repo.Reset()
+	m := metrics.New()
+	var repo service.Repository
+	// checks holds the health checks of the dependencies other than the store itself
+	var checks []handlers.HealthCheck
+	var keyRepo domain.KeyRepository
+	var webhookRepo domain.WebhookRepository
+	if openDB == nil {
+		logger.Warn("using the memory store, coupons are lost on shutdown")
+		memRepo := repository.NewMemory()
+		repo = memRepo
+		keyRepo = repository.NewMemoryKeys()
+		webhookRepo = repository.NewMemoryWebhooks(memRepo)
+	} else {
+		db, migrator := openMigrator(logger, openDB)
+		if cfg.Store.Migrate {
+			applied, err := migrator.Up()
+			if err != nil {
+				logger.WithError(err).Fatal("failed to apply migrations")
+			}
+			logger.WithField("applied", len(applied)).Info("migrations applied")
+		}
+		checks = append(checks, handlers.HealthCheck{Name: "migrations", Check: migrationsApplied(migrator)})
+		registerDB(logger, m, cfg.Store.Type, db)
+		gormRepo := repository.New(db, cfg.Timeouts())
+		if addrs := cfg.Postgres.Replicas; len(addrs) > 0 {
+			replicas := openReplicas(logger, addrs, cfg.PostgresConfig())
+			for i, replica := range replicas {
+				// the primary serves the reads of a failed replica, so the API is still ready
+				checks = append(checks, handlers.HealthCheck{Name: "replica " + addrs[i], Check: replica.DB().PingContext, Optional: true})
+				registerDB(logger, m, addrs[i], replica)
+			}
+			gormRepo = gormRepo.WithReplicas(replicas, cfg.ReplicaOptions())
+			logger.WithField("replicas", len(replicas)).Info("reading from replicas")
+		}
+		defer gormRepo.Close()
+		repo = gormRepo
+		keyRepo = repository.NewKeys(db, cfg.Timeouts())
+		webhookRepo = repository.NewWebhooks(db, cfg.Timeouts())
+	}
+	repo = repository.NewInstrumented(repo, m)
+	if tracer != nil {
+		repo = tracer.Repository(repo)
+	}
+	authEnabled := cfg.Auth.APIKeys || cfg.Auth.JWT.Enabled()
+	svc := service.NewService(repo, logger).WithMetrics(m)
+	if authEnabled {
+		svc = svc.WithAuthorization()
+	}
+	var s handlers.Service = svc
+	if tracer != nil {
+		s = tracer.Service(s)
 	}
-	s := service.NewService(repo, logger)
 	h := handlers.NewHandlers(s, logger)
+	health := handlers.NewHealth(logger, cfg.Server.HealthTimeout, append([]handlers.HealthCheck{{Name: cfg.Store.Type, Check: s.Ping}}, checks...)...)
+	keys := auth.NewKeys(keyRepo)
+	kh := handlers.NewKeys(keys, logger)
+	whService := webhook.NewWebhooks(webhookRepo)
+	if cfg.Webhooks.AllowPrivateNetworks {
+		logger.Warn("webhooks on private networks are accepted")
+		whService = whService.WithPrivateNetworks()
+	}
+	wh := handlers.NewWebhooks(whService, logger)
+
+	// the routes require the scopes of the API key or JWT once authentication is enabled, health and metrics stay open
+	require := func(scopes ...string) func(http.HandlerFunc) http.HandlerFunc {
+		return func(next http.HandlerFunc) http.HandlerFunc { return next }
+	}
+	if authEnabled {
+		authKeys := keys
+		if !cfg.Auth.APIKeys {
+			authKeys = nil
+		}
+		authenticator := auth.NewAuthenticator(authKeys, logger)
+		if cfg.Auth.JWT.Enabled() {
+			authenticator = authenticator.WithTokens(auth.NewTokens(cfg.TokenConfig(openJWKS(logger, cfg.Auth.JWT))))
+			logger.WithField("issuer", cfg.Auth.JWT.Issuer).Info("accepting JWT bearer tokens")
+		}
+		require = authenticator.Require
+	}
+	if cfg.Auth.APIKeys {
+		if openDB == nil {
+			// the memory store starts without keys, the keys endpoints need one to create the others
+			created, err := keys.CreateKey(context.Background(), "bootstrap", "", []string{domain.ScopeAdmin})
+			if err != nil {
+				logger.WithError(err).Fatal("failed to create the bootstrap key")
+			}
+			logger.WithField("key", created.Key).Warn("created the admin key of the memory store")
+		}
+	}
+	// the coupon routes reach the coupons of the tenant of the caller, or of the X-Tenant-ID header
+	tenant := handlers.WithTenant(logger)
+	coupons := func(scopes ...string) func(http.HandlerFunc) http.HandlerFunc {
+		return func(next http.HandlerFunc) http.HandlerFunc { return require(scopes...)(tenant(next)) }
+	}
+	read, write, del := coupons(domain.ScopeCouponsRead), coupons(domain.ScopeCouponsWrite), coupons(domain.ScopeCouponsDelete)
+	admin := require(domain.ScopeAdmin)
+	// the routes limit the rate of the requests of each client once enabled, after its authentication so it is keyed
+	// by its API key or customer, and the failed coupon lookups lock it out of the lookups
+	limit := func(route string) func(http.HandlerFunc) http.HandlerFunc {
+		return func(next http.HandlerFunc) http.HandlerFunc { return next }
+	}
+	lookup := limit
+	var limiter *ratelimit.Limiter
+	if cfg.RateLimit.Enabled {
+		limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), cfg.RateLimitConfig(), logger).WithMetrics(m)
+		limit, lookup = limiter.Limit, limiter.Lookup
+	}
+
+	// every request gets an id, set on the logs of its handling and on its access log line
+	requestID := handlers.WithRequestID(logger)
+	access := func(next http.Handler) http.Handler { return next }
+	if cfg.Log.AccessLog {
+		access = handlers.AccessLog(newAccessLogger())
+	}
 
 	// router creation and assignment of handlers
 	r := mux.NewRouter()
-	r.HandleFunc(h.CreateCouponPath(), h.CreateCouponHandler).Methods("POST")
-	r.HandleFunc(h.GetCouponsPath(), h.GetCouponsHandler).Methods("GET")
-	r.HandleFunc(h.GetCouponPath(), h.GetCouponHandler).Methods("GET")
-	r.HandleFunc(h.DeleteCouponPath(), h.DeleteCouponHandler).Methods("DELETE")
-	r.HandleFunc(h.UpdateCouponPath(), h.UpdateCouponHandler).Methods("PUT")
+	// mux runs no middleware for unmatched requests, they are logged and counted by wrapping the handlers responding to them
+	unmatched := func(next http.Handler) http.Handler { return requestID(access(m.HTTP(next))) }
+	r.NotFoundHandler = unmatched(http.NotFoundHandler())
+	r.MethodNotAllowedHandler = unmatched(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	r.Use(requestID, access, m.HTTP)
+	if tracer != nil {
+		r.Use(tracer.HTTP)
+	}
+	r.Use(handlers.Consistency)
+	// requests are canceled after requestTimeout, except exports which outlive the server write timeout
+	timeout := func(next http.HandlerFunc) http.HandlerFunc { return handlers.WithTimeout(cfg.Server.RequestTimeout, next) }
+	r.HandleFunc(health.LivePath(), health.LiveHandler).Methods("GET")
+	r.HandleFunc(health.ReadyPath(), health.ReadyHandler).Methods("GET")
+	r.HandleFunc(health.HealthPath(), health.HealthHandler).Methods("GET")
+	r.Handle(m.Path(), m.Handler()).Methods("GET")
+	r.HandleFunc(h.CreateCouponPath(), write(limit("create_coupon")(timeout(h.CreateCouponHandler)))).Methods("POST")
+	r.HandleFunc(h.GetCouponsPath(), read(limit("get_coupons")(timeout(h.GetCouponsHandler)))).Methods("GET")
+	r.HandleFunc(h.CouponsStatsPath(), read(limit("coupons_stats")(timeout(h.CouponsStatsHandler)))).Methods("GET")
+	r.HandleFunc(h.ExportCouponsPath(), read(limit("export_coupons")(h.ExportCouponsHandler))).Methods("GET")
+	r.HandleFunc(h.ImportCouponsPath(), write(limit("import_coupons")(timeout(h.ImportCouponsHandler)))).Methods("POST")
+	// a batch both writes and deletes coupons
+	batch := coupons(domain.ScopeCouponsWrite, domain.ScopeCouponsDelete)
+	r.HandleFunc(h.BatchCouponsPath(), batch(limit("batch_coupons")(timeout(h.BatchCouponsHandler)))).Methods("POST")
+	r.HandleFunc(h.GetCouponPath(), read(lookup("get_coupon")(timeout(h.GetCouponHandler)))).Methods("GET")
+	r.HandleFunc(h.DeleteCouponPath(), del(limit("delete_coupon")(timeout(h.DeleteCouponHandler)))).Methods("DELETE")
+	r.HandleFunc(h.UpdateCouponPath(), write(limit("update_coupon")(timeout(h.UpdateCouponHandler)))).Methods("PUT")
+	// the audit entries name the callers changing the coupons, they are only read with the audit:read scope
+	audit := coupons(domain.ScopeAuditRead)
+	r.HandleFunc(h.CouponHistoryPath(), audit(limit("coupon_history")(timeout(h.CouponHistoryHandler)))).Methods("GET")
+	r.HandleFunc(h.AuditPath(), audit(limit("audit")(timeout(h.AuditHandler)))).Methods("GET")
+	// the webhooks are those of the tenant of the caller, like its coupons
+	webhooks := coupons(domain.ScopeWebhooks)
+	r.HandleFunc(wh.WebhooksPath(), webhooks(limit("create_webhook")(timeout(wh.CreateWebhookHandler)))).Methods("POST")
+	r.HandleFunc(wh.WebhooksPath(), webhooks(limit("list_webhooks")(timeout(wh.ListWebhooksHandler)))).Methods("GET")
+	r.HandleFunc(wh.WebhookPath(), webhooks(limit("get_webhook")(timeout(wh.GetWebhookHandler)))).Methods("GET")
+	r.HandleFunc(wh.WebhookPath(), webhooks(limit("delete_webhook")(timeout(wh.DeleteWebhookHandler)))).Methods("DELETE")
+	r.HandleFunc(wh.DeliveriesPath(), webhooks(limit("list_deliveries")(timeout(wh.ListDeliveriesHandler)))).Methods("GET")
+	r.HandleFunc(wh.RedeliverPath(), webhooks(limit("redeliver")(timeout(wh.RedeliverHandler)))).Methods("POST")
+	if cfg.Auth.APIKeys {
+		r.HandleFunc(kh.KeysPath(), admin(limit("create_key")(timeout(kh.CreateKeyHandler)))).Methods("POST")
+		r.HandleFunc(kh.KeysPath(), admin(limit("list_keys")(timeout(kh.ListKeysHandler)))).Methods("GET")
+		r.HandleFunc(kh.RotateKeyPath(), admin(limit("rotate_key")(timeout(kh.RotateKeyHandler)))).Methods("POST")
+		r.HandleFunc(kh.RevokeKeyPath(), admin(limit("revoke_key")(timeout(kh.RevokeKeyHandler)))).Methods("DELETE")
+	}
+	if limiter != nil {
+		if unknown := limiter.Unknown(); len(unknown) > 0 {
+			logger.WithField("routes", unknown).Warn("ignoring the rate limits of unknown routes")
+		}
+	}
 
 	srv := &http.Server{
-		Addr: "0.0.0.0:8080",
+		Addr: cfg.Server.Addr,
 		// Good practice to set timeouts to avoid Slowloris attacks.
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 		Handler:      r, // Pass our instance of gorilla/mux in.
 	}
 
-	logger.Info("starting server")
+	// the coupon events of the outbox are delivered to the webhooks until the shutdown
+	dispatchCtx, stopDispatch := context.WithCancel(context.Background())
+	dispatched := make(chan struct{})
+	dispatcher := webhook.NewDispatcher(webhookRepo, webhook.NewClient(cfg.Webhooks.AllowPrivateNetworks), cfg.WebhookConfig(), logger).WithMetrics(m)
+	go func() {
+		defer close(dispatched)
+		dispatcher.Run(dispatchCtx)
+	}()
+
+	logger.WithField("addr", cfg.Server.Addr).Info("starting server")
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
 		if err := srv.ListenAndServe(); err != nil {
@@ -89,13 +321,23 @@ func main() {
 
 	// Block until we receive our signal.
 	<-c
+	// fail the readiness first so no new request is routed to the server while it drains
+	health.Shutdown()
+	logger.Info("readiness failing, draining requests")
 
 	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.GracefulTimeout)
 	defer cancel()
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	srv.Shutdown(ctx)
+	// the attempts interrupted are made again once the API restarts
+	stopDispatch()
+	<-dispatched
+	// export the spans of the last requests
+	if err := shutdownTracing(ctx); err != nil {
+		logger.WithError(err).Warn("failed to export the last traces")
+	}
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
 	// to finalize based on context cancellation.
@@ -103,3 +345,218 @@ func main() {
 	os.Exit(0)
 
 }
+
+// openMigrator connects to the db and loads the migrations of its dialect, exiting on failure
+func openMigrator(logger *logrus.Logger, openDB func() (*gorm.DB, error)) (*gorm.DB, *migrate.Migrator) {
+	logger.Info("starting db connection")
+	db, err := openDB()
+	if err != nil {
+		logger.WithError(err).Fatal("failed to connect to database")
+	}
+
+	fsys, err := migrations.FS(db.Dialect().GetName())
+	if err != nil {
+		db.Close()
+		logger.WithError(err).Fatal("failed to load migrations")
+	}
+	migrator, err := migrate.New(db, fsys)
+	if err != nil {
+		db.Close()
+		logger.WithError(err).Fatal("failed to load migrations")
+	}
+	return db, migrator
+}
+
+// openReplicas connects to the postgres read replicas at the host:port addresses, exiting on failure
+// The replicas share the other settings of cfg, its DSN aside
+func openReplicas(logger *logrus.Logger, addrs []string, cfg postgres.Config) []*gorm.DB {
+	cfg.DSN = ""
+	replicas := make([]*gorm.DB, 0, len(addrs))
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+		if err != nil {
+			logger.WithError(err).WithField("replica", addr).Fatal("invalid read replica address")
+		}
+		cfg.Host, cfg.Port = host, port
+		db, err := postgres.Open(cfg)
+		if err != nil {
+			logger.WithError(err).WithField("replica", addr).Fatal("failed to connect to read replica")
+		}
+		replicas = append(replicas, db)
+	}
+	return replicas
+}
+
+// openJWKS returns the key set of the JWT, nil if neither its file nor its URL is set, exiting on failure
+func openJWKS(logger *logrus.Logger, cfg config.JWT) *auth.JWKS {
+	switch {
+	case cfg.JWKSFile != "":
+		jwks, err := auth.NewJWKSFile(cfg.JWKSFile, cfg.JWKSRefresh)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to load the jwks file")
+		}
+		return jwks
+	case cfg.JWKSURL != "":
+		return auth.NewJWKSURL(cfg.JWKSURL, &http.Client{Timeout: jwksTimeout}, cfg.JWKSRefresh)
+	}
+	return nil
+}
+
+// newAccessLogger returns the logger of the access log, one JSON object per line on stdout
+// It is kept apart from the API logger so the access log is parsed without the API logs
+func newAccessLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(os.Stdout)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	return logger
+}
+
+// registerDB exports the connection pool stats of db under name, exiting on failure
+func registerDB(logger *logrus.Logger, m *metrics.Metrics, name string, db *gorm.DB) {
+	if err := m.RegisterDB(name, db.DB()); err != nil {
+		logger.WithError(err).WithField("db", name).Fatal("failed to register db metrics")
+	}
+}
+
+// migrationsApplied returns a health check failing while migrations are pending
+func migrationsApplied(m *migrate.Migrator) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		pending, err := m.Pending()
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d pending migrations", pending)
+		}
+		return nil
+	}
+}
+
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintf(fs.Output(), `Usage: %s [flags] [command]
+
+Without command the API server is started.
+
+Commands:
+  config print        print the settings in YAML, secrets redacted
+  migrate up          apply every pending migration
+  migrate down [n]    roll back the last n applied migrations (default 1)
+  migrate status      list the migrations and whether they are applied
+  keys create [-tenant <id>] <name> <scope>...
+                      create an API key granted the scopes, on the tenant only if set, and print it
+  keys list           list the API keys
+  keys revoke <id>    revoke the API key with id
+
+Every flag is also set by the %s<FLAG> environment variable, like %s for -db-read-timeout.
+The flags override the environment variables, which override the -config file.
+
+Flags:
+`, fs.Name(), config.EnvPrefix, config.EnvName("db-read-timeout"))
+	fs.PrintDefaults()
+}
+
+// runConfig executes the config subcommand
+func runConfig(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("unknown config command, expected: print")
+	}
+	return cfg.Print(os.Stdout)
+}
+
+// runMigrate executes the migrate subcommand
+func runMigrate(m *migrate.Migrator, logger *logrus.Logger, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command: up, down or status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, mig := range applied {
+			logger.WithField("version", mig.Version).WithField("name", mig.Name).Info("migration applied")
+		}
+		if err == nil && len(applied) == 0 {
+			logger.Info("no pending migration")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if _, err := fmt.Sscanf(args[1], "%d", &steps); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations to roll back: %s", args[1])
+			}
+		}
+		rolledBack, err := m.Down(steps)
+		for _, mig := range rolledBack {
+			logger.WithField("version", mig.Version).WithField("name", mig.Name).Info("migration rolled back")
+		}
+		return err
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, st := range status {
+			appliedAt := "pending"
+			if st.Applied {
+				appliedAt = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, appliedAt)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command: %s", args[0])
+}
+
+// runKeys executes the keys subcommand
+func runKeys(keys *auth.Keys, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing keys command: create, list or revoke")
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		tenant := fs.String("tenant", "", "the only tenant reached with the key, every tenant if empty")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() < 2 {
+			return fmt.Errorf("usage: keys create [-tenant <id>] <name> <scope>..., scopes: %s", strings.Join(domain.Scopes, ", "))
+		}
+		created, err := keys.CreateKey(ctx, fs.Arg(0), *tenant, fs.Args()[1:])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d\t%s\n", created.ID, created.Key)
+		return nil
+	case "list":
+		list, err := keys.ListKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range list {
+			revokedAt := "active"
+			if k.RevokedAt != nil {
+				revokedAt = "revoked " + k.RevokedAt.Format(time.RFC3339)
+			}
+			tenant := k.Tenant
+			if tenant == "" {
+				tenant = "*"
+			}
+			fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Prefix, k.Name, tenant, strings.Join(k.Scopes, " "), revokedAt)
+		}
+		return nil
+	case "revoke":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: keys revoke <id>")
+		}
+		var id uint
+		if _, err := fmt.Sscanf(args[1], "%d", &id); err != nil {
+			return fmt.Errorf("invalid key id: %s", args[1])
+		}
+		return keys.RevokeKey(ctx, id)
+	}
+	return fmt.Errorf("unknown keys command: %s", args[0])
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// minJWKSRefresh bounds how often an unknown key id reloads the key set
+	minJWKSRefresh = 10 * time.Second
+	// maxJWKSSize bounds the key set fetched from a URL
+	maxJWKSSize = 1 << 20
+)
+
+// errUnknownKey is returned for the key ids missing from the key set
+var errUnknownKey = errors.New("unknown key id")
+
+// JWKS caches the public keys of a JSON Web Key Set verifying the RS256 and ES256 tokens
+// The set is reloaded once refresh elapsed, and sooner for an unknown key id so a rotated key is picked up.
+// If a reload fails the previous keys are kept. It is safe for concurrent use
+type JWKS struct {
+	load    func(ctx context.Context) ([]byte, error)
+	refresh time.Duration
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+}
+
+// NewJWKSFile returns the key set of the file at path, it is read right away so an invalid file fails the startup
+func NewJWKSFile(path string, refresh time.Duration) (*JWKS, error) {
+	j := &JWKS{
+		load:    func(ctx context.Context) ([]byte, error) { return os.ReadFile(path) },
+		refresh: refresh,
+	}
+	if err := j.reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// NewJWKSURL returns the key set served at url, like the jwks_uri of an OpenID provider
+// It is fetched with client on the first token, so the API starts while the provider is unreachable
+func NewJWKSURL(url string, client *http.Client, refresh time.Duration) *JWKS {
+	return &JWKS{
+		load: func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+		},
+		refresh: refresh,
+	}
+}
+
+// Key returns the public key with kid, an empty kid selects the key of a set holding a single key
+//
+// It returns errUnknownKey if there is no such key
+func (j *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	age := time.Since(j.loadedAt)
+	key, ok := j.key(kid)
+	if j.keys == nil || age > j.refresh || (!ok && age > minJWKSRefresh) {
+		if err := j.reload(ctx); err != nil {
+			if j.keys == nil {
+				return nil, err
+			}
+			// the previous keys are kept, a provider outage does not fail the tokens of the cached keys
+			j.loadedAt = time.Now()
+		}
+		key, ok = j.key(kid)
+	}
+	if !ok {
+		return nil, errUnknownKey
+	}
+	return key, nil
+}
+
+// key returns the cached key with kid, j must be locked
+func (j *JWKS) key(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, true
+		}
+	}
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+// reload loads and parses the key set, j must be locked
+func (j *JWKS) reload(ctx context.Context) error {
+	data, err := j.load(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to load jwks")
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	j.keys, j.loadedAt = keys, time.Now()
+	return nil
+}
+
+// jwk is a JSON Web Key, only the fields of the RSA and P-256 public keys are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signature keys of the key set data by key id
+// The keys of other types or uses are skipped, a set without any usable key is an error
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrap(err, "failed to decode jwks")
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k)
+		case "EC":
+			key, err = ecKey(k)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid jwk %q", k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks holds no RSA or P-256 signature key")
+	}
+	return keys, nil
+}
+
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+func ecKey(k jwk) (*ecdsa.PublicKey, error) {
+	if k.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid P-256 coordinates")
+	}
+	// ecdh checks the point is on the curve
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
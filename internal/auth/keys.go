@@ -0,0 +1,197 @@
+// Package auth authenticates the API callers and checks the scopes of the routes they call
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+const (
+	// keyPrefix starts every API key, so a leaked key is recognized by secret scanners
+	keyPrefix = "pb_"
+	// prefixBytes and secretBytes are the random bytes of the public prefix and of the secret of a key
+	prefixBytes = 6
+	secretBytes = 32
+	// keyLength is the length of a key: pb_{prefix}_{secret} with hex encoded prefix and secret
+	keyLength = len(keyPrefix) + 2*prefixBytes + 1 + 2*secretBytes
+	// maxKeyName bounds the names of the keys
+	maxKeyName = 100
+)
+
+// ErrUnauthenticated is returned for the credentials which authenticate no caller
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Keys manages the API keys and authenticates the callers bearing them
+// A key is made of a public prefix, stored as is to find the key, and of a secret. Only the SHA-256 hash of the key
+// is stored: the secret is random, unlike a password it needs no slow hash to resist brute force
+type Keys struct {
+	repo domain.KeyRepository
+}
+
+// NewKeys is the Keys constructor
+func NewKeys(repo domain.KeyRepository) *Keys {
+	return &Keys{repo: repo}
+}
+
+// CreateKey creates a key granted scopes on tenant, or on every tenant if tenant is empty.
+// A caller bound to a tenant only creates keys of its tenant, the default one.
+// The key itself is only returned here
+//
+// It returns a InvalidArgsError if the name is empty or too long, if the tenant is invalid, or if the scopes are empty
+// or unknown, and a ForbiddenError if the caller is bound to another tenant
+func (k *Keys) CreateKey(ctx context.Context, name, tenant string, scopes []string) (domain.CreatedAPIKey, error) {
+	if bound := domain.BoundTenant(ctx); bound != "" {
+		if tenant != "" && tenant != bound {
+			return domain.CreatedAPIKey{}, domain.NewForbiddenError("caller bound to another tenant: " + tenant)
+		}
+		tenant = bound
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxKeyName {
+		return domain.CreatedAPIKey{}, domain.NewInvalidArgsError(fmt.Sprintf("key name must have 1 to %d characters", maxKeyName))
+	}
+	if tenant != "" && !domain.IsTenantID(tenant) {
+		return domain.CreatedAPIKey{}, domain.NewInvalidArgsError("invalid tenant: " + tenant)
+	}
+	scopes, err := checkScopes(scopes)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+
+	created, err := newKey(name, tenant, scopes)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	if err := k.repo.NewAPIKey(ctx, &created.APIKey); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	return created, nil
+}
+
+// ListKeys returns every key, revoked ones included, without their hash
+// A caller bound to a tenant only lists the keys of its tenant
+func (k *Keys) ListKeys(ctx context.Context) ([]domain.APIKey, error) {
+	var keys []domain.APIKey
+	if err := k.repo.ListAPIKeys(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateKey replaces the key with id by a new key with the same name, tenant and scopes, the key with id is revoked
+//
+// It returns a APIKeyNotFoundError if there is no key with id, if it is revoked, or if it is not a key of the tenant
+// the caller is bound to
+func (k *Keys) RotateKey(ctx context.Context, id uint) (domain.CreatedAPIKey, error) {
+	var old domain.APIKey
+	if err := k.repo.GetAPIKeyByID(ctx, id, &old); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	if old.RevokedAt != nil {
+		return domain.CreatedAPIKey{}, domain.NewAPIKeyNotFoundError()
+	}
+
+	created, err := newKey(old.Name, old.Tenant, old.Scopes)
+	if err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	if err := k.repo.RotateAPIKey(ctx, id, &created.APIKey); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	return created, nil
+}
+
+// RevokeKey revokes the key with id, it authenticates no request from now on
+//
+// It returns a APIKeyNotFoundError if there is no key with id, or if it is not a key of the tenant the caller is
+// bound to
+func (k *Keys) RevokeKey(ctx context.Context, id uint) error {
+	return k.repo.RevokeAPIKey(ctx, id)
+}
+
+// Authenticate returns the caller bearing key, its roles are those of its scopes and its tenant that of the key
+//
+// It returns ErrUnauthenticated if the key is malformed, unknown or revoked
+func (k *Keys) Authenticate(ctx context.Context, key string) (domain.Principal, error) {
+	prefix, ok := parseKey(key)
+	if !ok {
+		return domain.Principal{}, ErrUnauthenticated
+	}
+
+	var stored domain.APIKey
+	if err := k.repo.GetAPIKeyByPrefix(ctx, prefix, &stored); err != nil {
+		if _, ok := err.(domain.APIKeyNotFoundError); ok {
+			return domain.Principal{}, ErrUnauthenticated
+		}
+		return domain.Principal{}, err
+	}
+	if stored.RevokedAt != nil || subtle.ConstantTimeCompare([]byte(hashKey(key)), []byte(stored.Hash)) != 1 {
+		return domain.Principal{}, ErrUnauthenticated
+	}
+	return domain.Principal{
+		Subject: fmt.Sprintf("apikey:%d", stored.ID),
+		Scopes:  stored.Scopes,
+		Roles:   scopeRoles(stored.Scopes),
+		Tenant:  stored.Tenant,
+	}, nil
+}
+
+// checkScopes returns the scopes without duplicates
+// It returns a InvalidArgsError if there is none or if one is unknown
+func checkScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, domain.NewInvalidArgsError("a key needs at least one scope")
+	}
+	var checked []string
+	seen := make(map[string]bool)
+	for _, s := range scopes {
+		if !domain.IsScope(s) {
+			return nil, domain.NewInvalidArgsError("unknown scope: " + s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			checked = append(checked, s)
+		}
+	}
+	return checked, nil
+}
+
+// newKey generates a key granted scopes on tenant
+func newKey(name, tenant string, scopes []string) (domain.CreatedAPIKey, error) {
+	random := make([]byte, prefixBytes+secretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return domain.CreatedAPIKey{}, err
+	}
+	prefix := hex.EncodeToString(random[:prefixBytes])
+	key := keyPrefix + prefix + "_" + hex.EncodeToString(random[prefixBytes:])
+	return domain.CreatedAPIKey{
+		APIKey: domain.APIKey{Name: name, Prefix: prefix, Hash: hashKey(key), Scopes: scopes, Tenant: tenant},
+		Key:    key,
+	}, nil
+}
+
+// parseKey returns the public prefix of key, false if key is not shaped like a key
+func parseKey(key string) (string, bool) {
+	if len(key) != keyLength || !strings.HasPrefix(key, keyPrefix) {
+		return "", false
+	}
+	prefix := key[len(keyPrefix) : len(keyPrefix)+2*prefixBytes]
+	if key[len(keyPrefix)+2*prefixBytes] != '_' {
+		return "", false
+	}
+	return prefix, true
+}
+
+// hashKey returns the hex encoded SHA-256 hash of key
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
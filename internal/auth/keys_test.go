@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateKey(t *testing.T) {
+	keys := NewKeys(repository.NewMemoryKeys())
+	ctx := context.Background()
+
+	created, err := keys.CreateKey(ctx, " ci ", "", []string{domain.ScopeCouponsRead, domain.ScopeCouponsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ci", created.Name)
+	assert.Equal(t, []string{domain.ScopeCouponsRead}, created.Scopes)
+	assert.Len(t, created.Key, keyLength)
+	assert.True(t, strings.HasPrefix(created.Key, keyPrefix+created.Prefix+"_"))
+	assert.NotContains(t, created.Hash, created.Key)
+
+	for name, args := range map[string]struct {
+		name   string
+		tenant string
+		scopes []string
+	}{
+		"emptyName":     {"", "", []string{domain.ScopeAdmin}},
+		"longName":      {strings.Repeat("a", maxKeyName+1), "", []string{domain.ScopeAdmin}},
+		"invalidTenant": {"ci", "Acme Inc", []string{domain.ScopeAdmin}},
+		"noScope":       {"ci", "", nil},
+		"unknownScope":  {"ci", "", []string{"coupons:all"}},
+	} {
+		_, err := keys.CreateKey(ctx, args.name, args.tenant, args.scopes)
+		assert.IsType(t, domain.InvalidArgsError{}, err, name)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys := NewKeys(repository.NewMemoryKeys())
+	ctx := context.Background()
+	created, err := keys.CreateKey(ctx, "ci", "", []string{domain.ScopeCouponsWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := keys.Authenticate(ctx, created.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "apikey:1", p.Subject)
+	assert.True(t, p.HasScope(domain.ScopeCouponsWrite))
+	assert.False(t, p.HasScope(domain.ScopeCouponsRead))
+	// a key changing coupons is a coupon admin for the service
+	assert.Equal(t, []string{domain.RoleCouponAdmin}, p.Roles)
+
+	// same prefix, other secret
+	forged := created.Key[:len(created.Key)-1] + "0"
+	if forged == created.Key {
+		forged = created.Key[:len(created.Key)-1] + "1"
+	}
+	for _, key := range []string{"", "pb_short", forged, strings.Replace(created.Key, created.Prefix, "000000000000", 1)} {
+		_, err := keys.Authenticate(ctx, key)
+		assert.Equal(t, ErrUnauthenticated, err, key)
+	}
+
+	if err := keys.RevokeKey(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = keys.Authenticate(ctx, created.Key)
+	assert.Equal(t, ErrUnauthenticated, err)
+}
+
+func TestAuthenticateTenant(t *testing.T) {
+	keys := NewKeys(repository.NewMemoryKeys())
+	ctx := context.Background()
+	created, err := keys.CreateKey(ctx, "acme", "acme", []string{domain.ScopeCouponsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := keys.Authenticate(ctx, created.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "acme", p.Tenant)
+
+	// the rotated key keeps the tenant
+	rotated, err := keys.RotateKey(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "acme", rotated.Tenant)
+}
+
+func TestKeysBoundTenant(t *testing.T) {
+	keys := NewKeys(repository.NewMemoryKeys())
+	other, err := keys.CreateKey(context.Background(), "globex", "globex", []string{domain.ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := domain.WithPrincipal(context.Background(), domain.Principal{Subject: "apikey:9", Scopes: []string{domain.ScopeAdmin}, Tenant: "acme"})
+
+	// the keys of an admin bound to a tenant are those of its tenant
+	created, err := keys.CreateKey(acme, "ci", "", []string{domain.ScopeCouponsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "acme", created.Tenant)
+	created, err = keys.CreateKey(acme, "ci", "acme", []string{domain.ScopeCouponsRead})
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", created.Tenant)
+	_, err = keys.CreateKey(acme, "ci", "globex", []string{domain.ScopeAdmin})
+	assert.IsType(t, domain.ForbiddenError{}, err)
+
+	list, err := keys.ListKeys(acme)
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+	for _, k := range list {
+		assert.Equal(t, "acme", k.Tenant)
+	}
+	_, err = keys.RotateKey(acme, other.ID)
+	assert.IsType(t, domain.APIKeyNotFoundError{}, err)
+	assert.IsType(t, domain.APIKeyNotFoundError{}, keys.RevokeKey(acme, other.ID))
+	// the key of the other tenant is left as is
+	_, err = keys.Authenticate(context.Background(), other.Key)
+	assert.Nil(t, err)
+
+	// an admin bound to no tenant reaches every key
+	list, err = keys.ListKeys(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, list, 3)
+}
+
+func TestRotateKey(t *testing.T) {
+	keys := NewKeys(repository.NewMemoryKeys())
+	ctx := context.Background()
+	old, err := keys.CreateKey(ctx, "ci", "", []string{domain.ScopeCouponsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := keys.RotateKey(ctx, old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, old.ID, rotated.ID)
+	assert.NotEqual(t, old.Key, rotated.Key)
+	assert.Equal(t, old.Name, rotated.Name)
+	assert.Equal(t, old.Scopes, rotated.Scopes)
+
+	_, err = keys.Authenticate(ctx, old.Key)
+	assert.Equal(t, ErrUnauthenticated, err)
+	_, err = keys.Authenticate(ctx, rotated.Key)
+	assert.NoError(t, err)
+
+	_, err = keys.RotateKey(ctx, old.ID)
+	assert.IsType(t, domain.APIKeyNotFoundError{}, err)
+	_, err = keys.RotateKey(ctx, 42)
+	assert.IsType(t, domain.APIKeyNotFoundError{}, err)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+)
+
+const (
+	// apiKeyHeader carries an API key, like the Authorization header with the Bearer scheme which also carries the JWT
+	apiKeyHeader  = "X-API-Key"
+	bearerScheme  = "bearer "
+	authenticate  = `Bearer realm="pb_api"`
+	principalName = "principal"
+)
+
+// Authenticator authenticates the requests with their API key or JWT and checks the scopes of their routes
+type Authenticator struct {
+	keys   *Keys
+	tokens *Tokens
+	logger *logrus.Logger
+}
+
+// NewAuthenticator is the Authenticator constructor, keys is nil if the API keys are disabled
+func NewAuthenticator(keys *Keys, logger *logrus.Logger) *Authenticator {
+	return &Authenticator{keys: keys, logger: logger}
+}
+
+// WithTokens returns a copy of a also authenticating the JWT bearers with tokens
+func (a *Authenticator) WithTokens(tokens *Tokens) *Authenticator {
+	c := *a
+	c.tokens = tokens
+	return &c
+}
+
+// Require returns a middleware serving the requests of the callers granted every scope with next
+// Requests without valid credentials are answered with a 401 and those of callers lacking a scope with a 403.
+// The caller is set on the request context, see domain.PrincipalFrom, and on its logs
+func (a *Authenticator) Require(scopes ...string) func(next http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log := ctxlog.Entry(r.Context(), a.logger)
+			p, err := a.authenticate(r.Context(), credential(r))
+			if errors.Is(err, ErrUnauthenticated) {
+				log.WithError(err).Debug("unauthenticated request")
+				w.Header().Set("WWW-Authenticate", authenticate)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.WithError(err).Error("failed to authenticate request")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			log = log.WithField(principalName, p.Subject)
+			for _, s := range scopes {
+				if !p.HasScope(s) {
+					log.WithField("scope", s).Debug("missing scope")
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+
+			ctx := ctxlog.WithEntry(domain.WithPrincipal(r.Context(), p), log)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// authenticate returns the caller bearing credential, the API keys are told apart from the JWT by their prefix
+func (a *Authenticator) authenticate(ctx context.Context, credential string) (domain.Principal, error) {
+	switch {
+	case credential == "":
+		return domain.Principal{}, ErrUnauthenticated
+	case a.keys != nil && strings.HasPrefix(credential, keyPrefix):
+		return a.keys.Authenticate(ctx, credential)
+	case a.tokens != nil:
+		return a.tokens.Authenticate(ctx, credential)
+	}
+	return domain.Principal{}, ErrUnauthenticated
+}
+
+// credential returns the API key of r, from the X-API-Key header or else from the Authorization header
+func credential(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len(bearerScheme) && strings.ToLower(auth[:len(bearerScheme)]) == bearerScheme {
+		return strings.TrimSpace(auth[len(bearerScheme):])
+	}
+	return ""
+}
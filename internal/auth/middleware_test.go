@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/repository"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequire(t *testing.T) {
+	keys := NewKeys(repository.NewMemoryKeys())
+	reader, err := keys.CreateKey(context.Background(), "reader", "", []string{domain.ScopeCouponsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := keys.CreateKey(context.Background(), "admin", "", []string{domain.ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var principal domain.Principal
+	handler := NewAuthenticator(keys, logrus.New()).Require(domain.ScopeCouponsRead)(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = domain.PrincipalFrom(r.Context())
+	})
+
+	for name, tc := range map[string]struct {
+		header, value string
+		code          int
+		subject       string
+	}{
+		"missing":    {"", "", http.StatusUnauthorized, ""},
+		"invalid":    {"X-API-Key", "pb_nope", http.StatusUnauthorized, ""},
+		"apiKey":     {"X-API-Key", reader.Key, http.StatusOK, "apikey:1"},
+		"bearer":     {"Authorization", "Bearer " + reader.Key, http.StatusOK, "apikey:1"},
+		"basic":      {"Authorization", "Basic " + reader.Key, http.StatusUnauthorized, ""},
+		"adminScope": {"X-API-Key", admin.Key, http.StatusOK, "apikey:2"},
+	} {
+		principal = domain.Principal{}
+		r := httptest.NewRequest("GET", "/coupons", nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, tc.code, w.Code, name)
+		assert.Equal(t, tc.subject, principal.Subject, name)
+		if tc.code == http.StatusUnauthorized {
+			assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"), name)
+		}
+	}
+}
+
+func TestRequireForbidden(t *testing.T) {
+	keys := NewKeys(repository.NewMemoryKeys())
+	reader, err := keys.CreateKey(context.Background(), "reader", "", []string{domain.ScopeCouponsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	called := false
+	handler := NewAuthenticator(keys, logrus.New()).Require(domain.ScopeCouponsWrite, domain.ScopeCouponsDelete)(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	r := httptest.NewRequest("POST", "/coupons/batch", nil)
+	r.Header.Set("X-API-Key", reader.Key)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, called)
+}
+
+func TestRequireTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	apiKeys := NewKeys(repository.NewMemoryKeys())
+	created, err := apiKeys.CreateKey(context.Background(), "reader", "", []string{domain.ScopeCouponsRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var principal domain.Principal
+	authenticator := NewAuthenticator(apiKeys, logrus.New()).WithTokens(newTestTokens(t, keys, nil))
+	handler := authenticator.Require(domain.ScopeCouponsWrite)(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = domain.PrincipalFrom(r.Context())
+	})
+
+	for name, tc := range map[string]struct {
+		credential string
+		code       int
+	}{
+		"admin":  {sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", []string{"pb-admins"}, nil)), http.StatusOK},
+		"viewer": {sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("bob", []string{"coupon-viewer"}, nil)), http.StatusForbidden},
+		"apiKey": {created.Key, http.StatusForbidden},
+		"forged": {sign(t, jwt.SigningMethodRS256, "rsa1", newTestKeys(t).rsa, claims("eve", []string{"pb-admins"}, nil)), http.StatusUnauthorized},
+	} {
+		principal = domain.Principal{}
+		r := httptest.NewRequest("POST", "/coupons", nil)
+		r.Header.Set("Authorization", "Bearer "+tc.credential)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, tc.code, w.Code, name)
+		if tc.code == http.StatusOK {
+			assert.Equal(t, "jwt:alice", principal.Subject, name)
+			assert.Equal(t, []string{domain.RoleCouponAdmin}, principal.Roles, name)
+		}
+	}
+
+	// without API keys a key is refused
+	handler = NewAuthenticator(nil, logrus.New()).WithTokens(newTestTokens(t, keys, nil)).Require()(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest("GET", "/coupons", nil)
+	r.Header.Set("X-API-Key", created.Key)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
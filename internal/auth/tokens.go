@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// TokenConfig holds the settings verifying the JWT bearer tokens of an identity provider
+type TokenConfig struct {
+	// Issuer and Audience must match the iss and aud claims of the tokens
+	Issuer   string
+	Audience string
+	// HS256Secret verifies the HS256 tokens, they are refused if it is empty
+	HS256Secret []byte
+	// JWKS verifies the RS256 and ES256 tokens, they are refused if it is nil
+	JWKS *JWKS
+	// RoleClaim is the claim holding the roles, a dotted path like realm_access.roles reads a nested claim.
+	// It holds a list of strings or a space separated string
+	RoleClaim string
+	// RoleMapping maps the values of the role claim to the roles, values mapping to no known role are dropped
+	RoleMapping map[string]string
+	// TenantClaim is the claim holding the tenant of the caller, a dotted path like RoleClaim.
+	// A token without it reaches every tenant
+	TenantClaim string
+	// Leeway is the clock skew allowed on the exp, nbf and iat claims
+	Leeway time.Duration
+}
+
+// Tokens authenticates the callers bearing a JWT
+type Tokens struct {
+	cfg    TokenConfig
+	parser *jwt.Parser
+}
+
+// NewTokens is the Tokens constructor
+func NewTokens(cfg TokenConfig) *Tokens {
+	var methods []string
+	if len(cfg.HS256Secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.JWKS != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+	return &Tokens{
+		cfg: cfg,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(methods),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithLeeway(cfg.Leeway),
+			jwt.WithExpirationRequired(),
+			jwt.WithIssuedAt(),
+		),
+	}
+}
+
+// Authenticate returns the caller bearing token, its subject is "jwt:{sub}", its roles are mapped from the role claim
+// and its tenant is the tenant claim. The scopes of the caller are those of its roles
+//
+// It returns an error wrapping ErrUnauthenticated if the token is malformed, expired, not signed by a trusted key,
+// not issued for the API or names an invalid tenant, and another error if the key set could not be loaded
+func (t *Tokens) Authenticate(ctx context.Context, token string) (domain.Principal, error) {
+	// keyErr is the failure to load the key set, it is not the fault of the caller
+	var keyErr error
+	claims := jwt.MapClaims{}
+	_, err := t.parser.ParseWithClaims(token, claims, func(tok *jwt.Token) (interface{}, error) {
+		if tok.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+			return t.cfg.HS256Secret, nil
+		}
+		kid, _ := tok.Header["kid"].(string)
+		key, err := t.cfg.JWKS.Key(ctx, kid)
+		if err != nil && err != errUnknownKey {
+			keyErr = err
+		}
+		return key, err
+	})
+	if keyErr != nil {
+		return domain.Principal{}, keyErr
+	}
+	if err != nil {
+		return domain.Principal{}, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return domain.Principal{}, fmt.Errorf("%w: missing sub claim", ErrUnauthenticated)
+	}
+	tenant, err := t.tenant(claims)
+	if err != nil {
+		return domain.Principal{}, err
+	}
+	roles := t.roles(claims)
+	return domain.Principal{Subject: "jwt:" + sub, Roles: roles, Scopes: roleScopes(roles), Tenant: tenant}, nil
+}
+
+// tenant returns the tenant claim of claims, empty if there is none or no claim is configured
+func (t *Tokens) tenant(claims jwt.MapClaims) (string, error) {
+	if t.cfg.TenantClaim == "" {
+		return "", nil
+	}
+	switch v := lookupClaim(claims, t.cfg.TenantClaim).(type) {
+	case nil:
+		return "", nil
+	case string:
+		if domain.IsTenantID(v) {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%w: invalid %s claim", ErrUnauthenticated, t.cfg.TenantClaim)
+}
+
+// roles returns the known roles mapped from the values of the role claim of claims
+func (t *Tokens) roles(claims jwt.MapClaims) []string {
+	var values []string
+	switch v := lookupClaim(claims, t.cfg.RoleClaim).(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	var roles []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		role, ok := t.cfg.RoleMapping[v]
+		if !ok {
+			role = v
+		}
+		if domain.IsRole(role) && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// lookupClaim returns the claim at the dotted path, nil if there is none
+func lookupClaim(claims map[string]interface{}, path string) interface{} {
+	var v interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+// roleScopes returns the scopes granted to the roles, the routes of a token caller are those of its roles
+func roleScopes(roles []string) []string {
+	var scopes []string
+	for _, r := range roles {
+		switch r {
+		case domain.RoleCouponAdmin:
+			scopes = append(scopes, domain.ScopeCouponsRead, domain.ScopeCouponsWrite, domain.ScopeCouponsDelete, domain.ScopeAuditRead, domain.ScopeWebhooks)
+		case domain.RoleCouponViewer:
+			scopes = append(scopes, domain.ScopeCouponsRead)
+		}
+	}
+	return scopes
+}
+
+// scopeRoles returns the roles of an API key granted scopes
+// A key changing coupons is a coupon admin for the service, its routes stay restricted to its scopes
+func scopeRoles(scopes []string) []string {
+	var roles []string
+	for _, s := range scopes {
+		switch s {
+		case domain.ScopeCouponsWrite, domain.ScopeCouponsDelete, domain.ScopeAdmin:
+			return []string{domain.RoleCouponAdmin}
+		case domain.ScopeCouponsRead:
+			roles = []string{domain.RoleCouponViewer}
+		}
+	}
+	return roles
+}
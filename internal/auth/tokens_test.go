@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	issuer   = "https://idp.example.com"
+	audience = "pb_api"
+	secret   = "0123456789abcdef0123456789abcdef"
+)
+
+// testKeys are signing keys generated for the tests
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+// jwks returns the key set of the public keys, the RSA key is "rsa1" and the EC key "ec1"
+func (k testKeys) jwks(t *testing.T) []byte {
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	pad := func(i *big.Int) []byte { return i.FillBytes(make([]byte, 32)) }
+	data, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(pad(k.ec.X)), "y": b64(pad(k.ec.Y))},
+		{"kty": "oct", "kid": "skipped", "k": "c2VjcmV0"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// claims returns valid claims for the subject with roles, edit changes them
+func claims(sub string, roles interface{}, edit func(jwt.MapClaims)) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"iss":   issuer,
+		"aud":   audience,
+		"sub":   sub,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": roles,
+	}
+	if edit != nil {
+		edit(c)
+	}
+	return c
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, c jwt.MapClaims) string {
+	tok := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func writeJWKS(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestTokens(t *testing.T, keys testKeys, edit func(*TokenConfig)) *Tokens {
+	jwks, err := NewJWKSFile(writeJWKS(t, keys.jwks(t)), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := TokenConfig{
+		Issuer:      issuer,
+		Audience:    audience,
+		HS256Secret: []byte(secret),
+		JWKS:        jwks,
+		RoleClaim:   "roles",
+		RoleMapping: map[string]string{"pb-admins": domain.RoleCouponAdmin},
+		TenantClaim: "tenant",
+		Leeway:      time.Minute,
+	}
+	if edit != nil {
+		edit(&cfg)
+	}
+	return NewTokens(cfg)
+}
+
+func TestTokensAuthenticate(t *testing.T) {
+	keys := newTestKeys(t)
+	tokens := newTestTokens(t, keys, nil)
+
+	for name, tc := range map[string]struct {
+		token string
+		roles []string
+	}{
+		"hs256":    {sign(t, jwt.SigningMethodHS256, "", []byte(secret), claims("alice", []string{"coupon-viewer"}, nil)), []string{domain.RoleCouponViewer}},
+		"rs256":    {sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", []string{"pb-admins"}, nil)), []string{domain.RoleCouponAdmin}},
+		"es256":    {sign(t, jwt.SigningMethodES256, "ec1", keys.ec, claims("alice", "pb-admins coupon-viewer", nil)), []string{domain.RoleCouponAdmin, domain.RoleCouponViewer}},
+		"noRole":   {sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", []string{"unknown"}, nil)), nil},
+		"audience": {sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) { c["aud"] = []string{"other", audience} })), nil},
+	} {
+		p, err := tokens.Authenticate(context.Background(), tc.token)
+		if !assert.Nil(t, err, name) {
+			continue
+		}
+		assert.Equal(t, "jwt:alice", p.Subject, name)
+		assert.Equal(t, tc.roles, p.Roles, name)
+	}
+}
+
+func TestTokensScopes(t *testing.T) {
+	keys := newTestKeys(t)
+	tokens := newTestTokens(t, keys, nil)
+
+	p, err := tokens.Authenticate(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", []string{"pb-admins"}, nil)))
+	assert.Nil(t, err)
+	assert.True(t, p.HasScope(domain.ScopeCouponsDelete))
+	assert.True(t, p.HasScope(domain.ScopeAuditRead))
+	assert.True(t, p.HasScope(domain.ScopeWebhooks))
+	assert.False(t, p.HasScope(domain.ScopeAdmin))
+
+	p, err = tokens.Authenticate(context.Background(), sign(t, jwt.SigningMethodHS256, "", []byte(secret), claims("bob", []string{"coupon-viewer"}, nil)))
+	assert.Nil(t, err)
+	assert.True(t, p.HasScope(domain.ScopeCouponsRead))
+	assert.False(t, p.HasScope(domain.ScopeCouponsWrite))
+}
+
+func TestTokensNestedRoleClaim(t *testing.T) {
+	keys := newTestKeys(t)
+	tokens := newTestTokens(t, keys, func(cfg *TokenConfig) { cfg.RoleClaim = "realm_access.roles" })
+
+	token := sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) {
+		c["realm_access"] = map[string]interface{}{"roles": []string{"pb-admins"}}
+	}))
+	p, err := tokens.Authenticate(context.Background(), token)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{domain.RoleCouponAdmin}, p.Roles)
+}
+
+func TestTokensTenant(t *testing.T) {
+	keys := newTestKeys(t)
+	tokens := newTestTokens(t, keys, nil)
+
+	p, err := tokens.Authenticate(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) { c["tenant"] = "acme" })))
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", p.Tenant)
+
+	// without tenant claim the caller picks its tenant
+	p, err = tokens.Authenticate(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, nil)))
+	assert.Nil(t, err)
+	assert.Equal(t, "", p.Tenant)
+
+	for name, tenant := range map[string]interface{}{"invalid": "Acme Inc", "notString": 42} {
+		_, err := tokens.Authenticate(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) { c["tenant"] = tenant })))
+		assert.True(t, errors.Is(err, ErrUnauthenticated), name)
+	}
+}
+
+func TestTokensRefused(t *testing.T) {
+	keys := newTestKeys(t)
+	tokens := newTestTokens(t, keys, nil)
+	other := newTestKeys(t)
+	hour := time.Hour
+
+	for name, token := range map[string]string{
+		"malformed":   "not.a.token",
+		"expired":     sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-hour).Unix() })),
+		"noExpiry":    sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) { delete(c, "exp") })),
+		"notBefore":   sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(hour).Unix() })),
+		"issuer":      sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })),
+		"audience":    sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, func(c jwt.MapClaims) { c["aud"] = "other" })),
+		"noSubject":   sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("", nil, nil)),
+		"otherKey":    sign(t, jwt.SigningMethodRS256, "rsa1", other.rsa, claims("alice", nil, nil)),
+		"unknownKid":  sign(t, jwt.SigningMethodRS256, "rsa2", keys.rsa, claims("alice", nil, nil)),
+		"wrongKeyAlg": sign(t, jwt.SigningMethodES256, "rsa1", keys.ec, claims("alice", nil, nil)),
+		"otherSecret": sign(t, jwt.SigningMethodHS256, "", []byte("fedcba9876543210fedcba9876543210"), claims("alice", nil, nil)),
+		"hs384":       sign(t, jwt.SigningMethodHS384, "", []byte(secret), claims("alice", nil, nil)),
+		"none":        sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, claims("alice", nil, nil)),
+	} {
+		_, err := tokens.Authenticate(context.Background(), token)
+		assert.True(t, errors.Is(err, ErrUnauthenticated), "%s: %v", name, err)
+	}
+}
+
+func TestTokensMethods(t *testing.T) {
+	keys := newTestKeys(t)
+
+	// without secret the HS256 tokens are refused, even signed with the public key of the key set
+	tokens := newTestTokens(t, keys, func(cfg *TokenConfig) { cfg.HS256Secret = nil })
+	_, err := tokens.Authenticate(context.Background(), sign(t, jwt.SigningMethodHS256, "", []byte(secret), claims("alice", nil, nil)))
+	assert.True(t, errors.Is(err, ErrUnauthenticated))
+
+	// without key set the RS256 tokens are refused
+	tokens = newTestTokens(t, keys, func(cfg *TokenConfig) { cfg.JWKS = nil })
+	_, err = tokens.Authenticate(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, nil)))
+	assert.True(t, errors.Is(err, ErrUnauthenticated))
+}
+
+func TestJWKSFile(t *testing.T) {
+	keys := newTestKeys(t)
+
+	jwks, err := NewJWKSFile(writeJWKS(t, keys.jwks(t)), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwks.Key(context.Background(), "rsa1")
+	assert.Nil(t, err)
+	assert.True(t, keys.rsa.PublicKey.Equal(key))
+	key, err = jwks.Key(context.Background(), "ec1")
+	assert.Nil(t, err)
+	assert.True(t, keys.ec.PublicKey.Equal(key))
+	_, err = jwks.Key(context.Background(), "skipped")
+	assert.Equal(t, errUnknownKey, err)
+	// the kid is required with several keys
+	_, err = jwks.Key(context.Background(), "")
+	assert.Equal(t, errUnknownKey, err)
+
+	for name, data := range map[string]string{
+		"json":     `{`,
+		"empty":    `{"keys":[]}`,
+		"curve":    `{"keys":[{"kty":"EC","kid":"a","crv":"P-384","x":"AA","y":"AA"}]}`,
+		"offCurve": `{"keys":[{"kty":"EC","kid":"a","crv":"P-256","x":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `","y":"` + base64.RawURLEncoding.EncodeToString(make([]byte, 32)) + `"}]}`,
+	} {
+		_, err := NewJWKSFile(writeJWKS(t, []byte(data)), time.Hour)
+		assert.NotNil(t, err, name)
+	}
+	_, err = NewJWKSFile(filepath.Join(t.TempDir(), "missing.json"), time.Hour)
+	assert.NotNil(t, err)
+}
+
+func TestJWKSURL(t *testing.T) {
+	first, second := newTestKeys(t), newTestKeys(t)
+
+	var fetches int32
+	var failing atomic.Bool
+	var set atomic.Value
+	set.Store(first.jwks(t))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(set.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	jwks := NewJWKSURL(srv.URL, srv.Client(), time.Hour)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&fetches))
+	key, err := jwks.Key(context.Background(), "rsa1")
+	assert.Nil(t, err)
+	assert.True(t, first.rsa.PublicKey.Equal(key))
+	_, err = jwks.Key(context.Background(), "ec1")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// the provider replaces its keys, an unknown kid reloads the set once minJWKSRefresh elapsed
+	set.Store(second.jwks(t))
+	_, err = jwks.Key(context.Background(), "rsa2")
+	assert.Equal(t, errUnknownKey, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	jwks.loadedAt = jwks.loadedAt.Add(-minJWKSRefresh - time.Second)
+	key, err = jwks.Key(context.Background(), "rsa1")
+	assert.Nil(t, err)
+	assert.True(t, first.rsa.PublicKey.Equal(key))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	_, err = jwks.Key(context.Background(), "rsa2")
+	assert.Equal(t, errUnknownKey, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// the cached keys outlive a failing provider
+	failing.Store(true)
+	jwks.loadedAt = jwks.loadedAt.Add(-2 * time.Hour)
+	key, err = jwks.Key(context.Background(), "rsa1")
+	assert.Nil(t, err)
+	assert.True(t, second.rsa.PublicKey.Equal(key))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+}
+
+func TestJWKSURLUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	keys := newTestKeys(t)
+	tokens := newTestTokens(t, keys, func(cfg *TokenConfig) { cfg.JWKS = NewJWKSURL(srv.URL, srv.Client(), time.Hour) })
+	_, err := tokens.Authenticate(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa1", keys.rsa, claims("alice", nil, nil)))
+	assert.NotNil(t, err)
+	// the caller is not at fault
+	assert.False(t, errors.Is(err, ErrUnauthenticated))
+}
@@ -0,0 +1,438 @@
+// Package config loads the settings of the API
+//
+// Every setting has a default, which a YAML or TOML config file, then the environment variables and at last the
+// flags override. The secrets may be read from files, so they appear neither in the process listing nor in the
+// environment of the process.
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/auth"
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/ratelimit"
+	"github.com/jcgfreitas/pb_api/internal/repository"
+	"github.com/jcgfreitas/pb_api/internal/tracing"
+	"github.com/jcgfreitas/pb_api/internal/webhook"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/postgres"
+)
+
+// coupons stores
+const (
+	StorePostgres = "postgres"
+	StoreSQLite   = "sqlite"
+	StoreMemory   = "memory"
+)
+
+// redacted replaces the secrets printed by Redacted
+const redacted = "[REDACTED]"
+
+// minHS256Secret is the minimum length of the HS256 secret, the length of the hash
+const minHS256Secret = 32
+
+// Config holds the settings of the API
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Log       Log       `yaml:"log" toml:"log"`
+	Store     Store     `yaml:"store" toml:"store"`
+	Postgres  Postgres  `yaml:"postgres" toml:"postgres"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+	Auth      Auth      `yaml:"auth" toml:"auth"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Webhooks  Webhooks  `yaml:"webhooks" toml:"webhooks"`
+}
+
+// Server holds the http server settings
+type Server struct {
+	// Addr is the host:port the server listens on
+	Addr         string        `yaml:"addr" toml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// RequestTimeout cancels the requests running longer, exports excluded, 0 for no timeout
+	RequestTimeout time.Duration `yaml:"request_timeout" toml:"request_timeout"`
+	// GracefulTimeout is how long the shutdown waits for the requests being served
+	GracefulTimeout time.Duration `yaml:"graceful_timeout" toml:"graceful_timeout"`
+	// HealthTimeout is the maximum duration of each health check
+	HealthTimeout time.Duration `yaml:"health_timeout" toml:"health_timeout"`
+}
+
+// Log holds the logging settings
+type Log struct {
+	Debug bool `yaml:"debug" toml:"debug"`
+	// AccessLog logs one JSON line per request to stdout
+	AccessLog bool `yaml:"access_log" toml:"access_log"`
+}
+
+// Store holds the settings of the coupons store, whatever its type
+type Store struct {
+	// Type is one of StorePostgres, StoreSQLite or StoreMemory
+	Type       string `yaml:"type" toml:"type"`
+	SQLitePath string `yaml:"sqlite_path" toml:"sqlite_path"`
+	// Migrate applies the pending schema migrations before starting the server
+	Migrate       bool          `yaml:"migrate" toml:"migrate"`
+	ReadTimeout   time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout  time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	ExportTimeout time.Duration `yaml:"export_timeout" toml:"export_timeout"`
+}
+
+// Postgres holds the settings of the postgres store and of its read replicas
+type Postgres struct {
+	// DSN is a secret, it may hold the password
+	DSN string `yaml:"dsn" toml:"dsn"`
+	// DSNFile is a file holding the DSN, it takes precedence over DSN
+	DSNFile  string `yaml:"dsn_file" toml:"dsn_file"`
+	Host     string `yaml:"host" toml:"host"`
+	Port     string `yaml:"port" toml:"port"`
+	User     string `yaml:"user" toml:"user"`
+	Name     string `yaml:"name" toml:"name"`
+	Password string `yaml:"password" toml:"password"`
+	// PasswordFile is a file holding the password, it takes precedence over Password
+	PasswordFile    string        `yaml:"password_file" toml:"password_file"`
+	SSLMode         string        `yaml:"sslmode" toml:"sslmode"`
+	SSLRootCert     string        `yaml:"sslrootcert" toml:"sslrootcert"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnectRetries  int           `yaml:"connect_retries" toml:"connect_retries"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff" toml:"retry_backoff"`
+	// Replicas are the host:port of the read replicas, they share the other settings except the DSN
+	Replicas          []string      `yaml:"replicas" toml:"replicas"`
+	ReplicaStickiness time.Duration `yaml:"replica_stickiness" toml:"replica_stickiness"`
+	ReplicaRetry      time.Duration `yaml:"replica_retry" toml:"replica_retry"`
+}
+
+// Tracing holds the settings of the trace export
+type Tracing struct {
+	OTLPEndpoint string  `yaml:"otlp_endpoint" toml:"otlp_endpoint"`
+	OTLPInsecure bool    `yaml:"otlp_insecure" toml:"otlp_insecure"`
+	SampleRatio  float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// Auth holds the authentication settings
+type Auth struct {
+	// APIKeys requires an API key on every coupons and keys route, health and metrics excluded
+	APIKeys bool `yaml:"api_keys" toml:"api_keys"`
+	JWT     JWT  `yaml:"jwt" toml:"jwt"`
+}
+
+// JWT holds the settings of the JWT bearer tokens, they are accepted once a secret or a key set is set
+type JWT struct {
+	Issuer   string `yaml:"issuer" toml:"issuer"`
+	Audience string `yaml:"audience" toml:"audience"`
+	// HS256Secret is a secret verifying the HS256 tokens
+	HS256Secret string `yaml:"hs256_secret" toml:"hs256_secret"`
+	// HS256SecretFile is a file holding the secret, it takes precedence over HS256Secret
+	HS256SecretFile string `yaml:"hs256_secret_file" toml:"hs256_secret_file"`
+	// JWKSFile and JWKSURL are the key set verifying the RS256 and ES256 tokens, only one of them is set
+	JWKSFile    string        `yaml:"jwks_file" toml:"jwks_file"`
+	JWKSURL     string        `yaml:"jwks_url" toml:"jwks_url"`
+	JWKSRefresh time.Duration `yaml:"jwks_refresh" toml:"jwks_refresh"`
+	// RoleClaim is the claim holding the roles, dotted for a nested claim like realm_access.roles
+	RoleClaim string `yaml:"role_claim" toml:"role_claim"`
+	// RoleMapping maps the values of the role claim, like the groups of the identity provider, to the roles
+	RoleMapping map[string]string `yaml:"role_mapping" toml:"role_mapping"`
+	// TenantClaim is the claim holding the tenant of the caller, the tokens without it reach every tenant
+	TenantClaim string        `yaml:"tenant_claim" toml:"tenant_claim"`
+	Leeway      time.Duration `yaml:"leeway" toml:"leeway"`
+}
+
+// RateLimit holds the settings of the rate limits of the coupons and keys routes
+type RateLimit struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Default is the limit of the routes missing from Routes, like 100/1m by ip, see ratelimit.ParseLimit
+	Default string `yaml:"default" toml:"default"`
+	// Routes maps the route names, like get_coupon, to their limit, off for none
+	Routes map[string]string `yaml:"routes" toml:"routes"`
+	// TrustForwarded keys the clients by the X-Forwarded-For header, only behind a proxy setting it
+	TrustForwarded bool `yaml:"trust_forwarded" toml:"trust_forwarded"`
+	// LockoutFailures is the number of lookups of unknown coupons within LockoutWindow locking a client out
+	// for LockoutDuration, 0 for no lockout
+	LockoutFailures int           `yaml:"lockout_failures" toml:"lockout_failures"`
+	LockoutWindow   time.Duration `yaml:"lockout_window" toml:"lockout_window"`
+	LockoutDuration time.Duration `yaml:"lockout_duration" toml:"lockout_duration"`
+}
+
+// Webhooks holds the schedule of the webhook deliveries and the networks they are delivered to
+type Webhooks struct {
+	// Interval is the wait between two rounds of deliveries
+	Interval time.Duration `yaml:"interval" toml:"interval"`
+	// Timeout bounds each attempt of a delivery
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// MaxAttempts is the number of failed attempts after which a delivery is dead
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts"`
+	// Backoff is the wait after the first failed attempt, doubled after each other one up to MaxBackoff
+	Backoff    time.Duration `yaml:"backoff" toml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff" toml:"max_backoff"`
+	// AllowPrivateNetworks accepts the webhooks on loopback, private and link-local addresses, for local development
+	AllowPrivateNetworks bool `yaml:"allow_private_networks" toml:"allow_private_networks"`
+}
+
+// Enabled reports whether the JWT are accepted
+func (j JWT) Enabled() bool {
+	return j.HS256Secret != "" || j.JWKSFile != "" || j.JWKSURL != ""
+}
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            "0.0.0.0:8080",
+			ReadTimeout:     time.Second * 15,
+			WriteTimeout:    time.Second * 15,
+			IdleTimeout:     time.Second * 60,
+			RequestTimeout:  time.Second * 15,
+			GracefulTimeout: time.Second * 15,
+			HealthTimeout:   time.Second * 2,
+		},
+		Log: Log{Debug: true, AccessLog: true},
+		Store: Store{
+			Type:          StorePostgres,
+			SQLitePath:    "pb_api.db",
+			ReadTimeout:   time.Second * 5,
+			WriteTimeout:  time.Second * 10,
+			ExportTimeout: time.Minute * 10,
+		},
+		Postgres: Postgres{
+			Host:              "localhost",
+			Port:              "5432",
+			User:              "postgres",
+			Name:              "postgres",
+			Password:          "password1",
+			SSLMode:           postgres.SSLDisable,
+			MaxOpenConns:      20,
+			MaxIdleConns:      5,
+			ConnMaxLifetime:   time.Minute * 30,
+			ConnectRetries:    5,
+			RetryBackoff:      time.Second,
+			ReplicaStickiness: time.Second * 5,
+			ReplicaRetry:      time.Second * 30,
+		},
+		Tracing: Tracing{SampleRatio: 1},
+		Auth: Auth{
+			JWT: JWT{JWKSRefresh: time.Hour, RoleClaim: "roles", TenantClaim: "tenant", Leeway: time.Minute},
+		},
+		RateLimit: RateLimit{
+			Default:         "300/1m",
+			Routes:          map[string]string{"get_coupon": "60/1m"},
+			LockoutFailures: 20,
+			LockoutWindow:   time.Minute,
+			LockoutDuration: time.Minute * 15,
+		},
+		Webhooks: Webhooks{
+			Interval:    time.Second,
+			Timeout:     time.Second * 10,
+			MaxAttempts: 8,
+			Backoff:     time.Second * 10,
+			MaxBackoff:  time.Hour,
+		},
+	}
+}
+
+// Validate checks the settings, the returned error lists every invalid one
+func (c Config) Validate() error {
+	var invalid []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			invalid = append(invalid, fmt.Sprintf(format, args...))
+		}
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		invalid = append(invalid, "server.addr: "+err.Error())
+	}
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.request_timeout", c.Server.RequestTimeout},
+		{"server.graceful_timeout", c.Server.GracefulTimeout},
+		{"store.read_timeout", c.Store.ReadTimeout},
+		{"store.write_timeout", c.Store.WriteTimeout},
+		{"store.export_timeout", c.Store.ExportTimeout},
+		{"postgres.conn_max_lifetime", c.Postgres.ConnMaxLifetime},
+		{"postgres.retry_backoff", c.Postgres.RetryBackoff},
+		{"auth.jwt.leeway", c.Auth.JWT.Leeway},
+		{"rate_limit.lockout_window", c.RateLimit.LockoutWindow},
+		{"rate_limit.lockout_duration", c.RateLimit.LockoutDuration},
+		{"webhooks.backoff", c.Webhooks.Backoff},
+		{"webhooks.max_backoff", c.Webhooks.MaxBackoff},
+	} {
+		check(d.d >= 0, "%s: negative duration %s", d.name, d.d)
+	}
+	check(c.Server.HealthTimeout > 0, "server.health_timeout: must be positive")
+
+	switch c.Store.Type {
+	case StorePostgres, StoreMemory:
+	case StoreSQLite:
+		check(c.Store.SQLitePath != "", "store.sqlite_path: required by the %s store", StoreSQLite)
+	default:
+		invalid = append(invalid, "store.type: unknown store "+c.Store.Type)
+	}
+
+	if c.Store.Type == StorePostgres {
+		switch c.Postgres.SSLMode {
+		case "", postgres.SSLDisable, postgres.SSLRequire, postgres.SSLVerifyCA, postgres.SSLVerifyFull:
+		default:
+			invalid = append(invalid, "postgres.sslmode: unknown sslmode "+c.Postgres.SSLMode)
+		}
+		check(c.Postgres.MaxOpenConns >= 0, "postgres.max_open_conns: must not be negative")
+		check(c.Postgres.MaxIdleConns >= 0, "postgres.max_idle_conns: must not be negative")
+		check(c.Postgres.ConnectRetries >= 0, "postgres.connect_retries: must not be negative")
+	}
+	if len(c.Postgres.Replicas) > 0 {
+		check(c.Store.Type == StorePostgres, "postgres.replicas: read replicas require the %s store", StorePostgres)
+		for _, addr := range c.Postgres.Replicas {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				invalid = append(invalid, "postgres.replicas: "+err.Error())
+			}
+		}
+	}
+
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio: must be between 0 and 1")
+
+	if jwt := c.Auth.JWT; jwt.Enabled() {
+		check(jwt.Issuer != "", "auth.jwt.issuer: required by the JWT")
+		check(jwt.Audience != "", "auth.jwt.audience: required by the JWT")
+		check(jwt.HS256Secret == "" || len(jwt.HS256Secret) >= minHS256Secret, "auth.jwt.hs256_secret: must have at least %d bytes", minHS256Secret)
+		check(jwt.JWKSFile == "" || jwt.JWKSURL == "", "auth.jwt.jwks_url: only one of jwks_file and jwks_url is set")
+		check(jwt.JWKSRefresh > 0, "auth.jwt.jwks_refresh: must be positive")
+		check(jwt.RoleClaim != "", "auth.jwt.role_claim: required by the JWT")
+		for value, role := range jwt.RoleMapping {
+			check(domain.IsRole(role), "auth.jwt.role_mapping: unknown role %s for %s", role, value)
+		}
+	}
+
+	if rl := c.RateLimit; rl.Enabled {
+		if _, err := ratelimit.ParseLimit(rl.Default); err != nil {
+			invalid = append(invalid, "rate_limit.default: "+err.Error())
+		}
+		for route, limit := range rl.Routes {
+			if _, err := ratelimit.ParseLimit(limit); err != nil {
+				invalid = append(invalid, "rate_limit.routes: "+route+": "+err.Error())
+			}
+		}
+		check(rl.LockoutFailures >= 0, "rate_limit.lockout_failures: must not be negative")
+		check(rl.LockoutFailures == 0 || rl.LockoutWindow > 0, "rate_limit.lockout_window: required by the lockout")
+		check(rl.LockoutFailures == 0 || rl.LockoutDuration > 0, "rate_limit.lockout_duration: required by the lockout")
+	}
+
+	check(c.Webhooks.Interval > 0, "webhooks.interval: must be positive")
+	check(c.Webhooks.Timeout > 0, "webhooks.timeout: must be positive")
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts: must be positive")
+	check(c.Webhooks.Backoff <= c.Webhooks.MaxBackoff, "webhooks.max_backoff: must not be less than webhooks.backoff")
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(invalid, "; "))
+	}
+	return nil
+}
+
+// Redacted returns a copy of c whose secrets are replaced, to be printed or logged
+func (c Config) Redacted() Config {
+	if c.Postgres.DSN != "" {
+		c.Postgres.DSN = redacted
+	}
+	if c.Postgres.Password != "" {
+		c.Postgres.Password = redacted
+	}
+	if c.Auth.JWT.HS256Secret != "" {
+		c.Auth.JWT.HS256Secret = redacted
+	}
+	return c
+}
+
+// PostgresConfig returns the settings of the postgres primary db
+func (c Config) PostgresConfig() postgres.Config {
+	p := c.Postgres
+	return postgres.Config{
+		DSN:             p.DSN,
+		Host:            p.Host,
+		Port:            p.Port,
+		User:            p.User,
+		Name:            p.Name,
+		Password:        p.Password,
+		SSLMode:         p.SSLMode,
+		SSLRootCert:     p.SSLRootCert,
+		MaxOpenConns:    p.MaxOpenConns,
+		MaxIdleConns:    p.MaxIdleConns,
+		ConnMaxLifetime: p.ConnMaxLifetime,
+		ConnectRetries:  p.ConnectRetries,
+		RetryBackoff:    p.RetryBackoff,
+	}
+}
+
+// ReplicaOptions returns the routing settings of the read replicas
+func (c Config) ReplicaOptions() repository.ReplicaOptions {
+	return repository.ReplicaOptions{Stickiness: c.Postgres.ReplicaStickiness, RetryAfter: c.Postgres.ReplicaRetry}
+}
+
+// Timeouts returns the timeouts of the store operations
+func (c Config) Timeouts() repository.Timeouts {
+	return repository.Timeouts{Read: c.Store.ReadTimeout, Write: c.Store.WriteTimeout, Export: c.Store.ExportTimeout}
+}
+
+// TracingConfig returns the settings of the trace export
+func (c Config) TracingConfig() tracing.Config {
+	return tracing.Config{
+		Endpoint:    c.Tracing.OTLPEndpoint,
+		Insecure:    c.Tracing.OTLPInsecure,
+		SampleRatio: c.Tracing.SampleRatio,
+	}
+}
+
+// RateLimitConfig returns the limits of the routes, the settings are valid, see Validate
+func (c Config) RateLimitConfig() ratelimit.Config {
+	rl := c.RateLimit
+	cfg := ratelimit.Config{
+		Routes: make(map[string]ratelimit.Limit, len(rl.Routes)),
+		Lockout: ratelimit.Lockout{
+			Failures: rl.LockoutFailures,
+			Window:   rl.LockoutWindow,
+			Duration: rl.LockoutDuration,
+		},
+		TrustForwarded: rl.TrustForwarded,
+	}
+	cfg.Default, _ = ratelimit.ParseLimit(rl.Default)
+	for route, limit := range rl.Routes {
+		cfg.Routes[route], _ = ratelimit.ParseLimit(limit)
+	}
+	return cfg
+}
+
+// WebhookConfig returns the schedule of the webhook deliveries
+func (c Config) WebhookConfig() webhook.Config {
+	wh := c.Webhooks
+	return webhook.Config{
+		Interval:    wh.Interval,
+		Timeout:     wh.Timeout,
+		MaxAttempts: wh.MaxAttempts,
+		Backoff:     wh.Backoff,
+		MaxBackoff:  wh.MaxBackoff,
+	}
+}
+
+// TokenConfig returns the settings of the JWT, jwks is the key set of the jwks file or URL, nil if neither is set
+func (c Config) TokenConfig(jwks *auth.JWKS) auth.TokenConfig {
+	j := c.Auth.JWT
+	var secret []byte
+	if j.HS256Secret != "" {
+		secret = []byte(j.HS256Secret)
+	}
+	return auth.TokenConfig{
+		Issuer:      j.Issuer,
+		Audience:    j.Audience,
+		HS256Secret: secret,
+		JWKS:        jwks,
+		RoleClaim:   j.RoleClaim,
+		RoleMapping: j.RoleMapping,
+		TenantClaim: j.TenantClaim,
+		Leeway:      j.Leeway,
+	}
+}
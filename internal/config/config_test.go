@@ -0,0 +1,261 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jcgfreitas/pb_api/internal/ratelimit"
+	"github.com/jcgfreitas/pb_api/internal/webhook"
+)
+
+func noUsage(fs *flag.FlagSet) {}
+
+// env returns a lookupEnv of vars
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := vars[k]
+		return v, ok
+	}
+}
+
+// writeFile writes content to the file name of a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefault(t *testing.T) {
+	cfg, args, err := Load("pb_api", nil, env(nil), noUsage)
+	assert.Nil(t, err)
+	assert.Empty(t, args)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "pb_api.yaml", `
+server:
+  addr: 127.0.0.1:9090
+  request_timeout: 20s
+store:
+  type: sqlite
+  read_timeout: 1s
+postgres:
+  replicas: [replica1:5432]
+`)
+	cfg, args, err := Load("pb_api", []string{"-db-read-timeout=3s", "-auth-api-keys", "migrate", "up"}, env(map[string]string{
+		"PB_API_CONFIG":          path,
+		"PB_API_REQUEST_TIMEOUT": "30s",
+		"PB_API_DB_READ_TIMEOUT": "2s",
+		"PB_API_STORE":           "postgres",
+		"PB_API_REPLICAS":        "replica2:5432, replica3:5432",
+	}), noUsage)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"migrate", "up"}, args)
+
+	// the file overrides the defaults
+	assert.Equal(t, "127.0.0.1:9090", cfg.Server.Addr)
+	assert.Equal(t, Default().Server.WriteTimeout, cfg.Server.WriteTimeout)
+	// the environment overrides the file
+	assert.Equal(t, 30*time.Second, cfg.Server.RequestTimeout)
+	assert.Equal(t, StorePostgres, cfg.Store.Type)
+	assert.Equal(t, []string{"replica2:5432", "replica3:5432"}, cfg.Postgres.Replicas)
+	// the flags override the environment
+	assert.Equal(t, 3*time.Second, cfg.Store.ReadTimeout)
+	assert.True(t, cfg.Auth.APIKeys)
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	path := writeFile(t, "pb_api.toml", `
+[store]
+type = "memory"
+write_timeout = "1m"
+
+[tracing]
+sample_ratio = 0.5
+`)
+	cfg, _, err := Load("pb_api", []string{"-config", path}, env(map[string]string{"PB_API_CONFIG": "missing.yaml"}), noUsage)
+	assert.Nil(t, err)
+	assert.Equal(t, StoreMemory, cfg.Store.Type)
+	assert.Equal(t, time.Minute, cfg.Store.WriteTimeout)
+	assert.Equal(t, 0.5, cfg.Tracing.SampleRatio)
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	cases := map[string]string{
+		"unknownYAMLKey": writeFile(t, "pb_api.yaml", "server:\n  adr: :8080\n"),
+		"unknownTOMLKey": writeFile(t, "pb_api.toml", "[server]\nadr = \":8080\"\n"),
+		"invalidYAML":    writeFile(t, "pb_api.yml", "server: [\n"),
+		"format":         writeFile(t, "pb_api.json", "{}"),
+		"missing":        filepath.Join(t.TempDir(), "pb_api.yaml"),
+	}
+	for name, path := range cases {
+		_, _, err := Load("pb_api", []string{"-config=" + path}, env(nil), noUsage)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	password := writeFile(t, "password", "s3cret\n")
+	dsn := writeFile(t, "dsn", "postgres://pb:s3cret@db/pb")
+
+	cfg, _, err := Load("pb_api", []string{"-password=flag", "-password-file=" + password}, env(map[string]string{
+		"PB_API_DSN_FILE": dsn,
+	}), noUsage)
+	assert.Nil(t, err)
+	// the files take precedence over the secrets themselves
+	assert.Equal(t, "s3cret", cfg.Postgres.Password)
+	assert.Equal(t, "postgres://pb:s3cret@db/pb", cfg.PostgresConfig().DSN)
+
+	_, _, err = Load("pb_api", []string{"-password-file=" + filepath.Join(t.TempDir(), "missing")}, env(nil), noUsage)
+	assert.NotNil(t, err)
+
+	secret := writeFile(t, "secret", "0123456789abcdef0123456789abcdef\n")
+	cfg, _, err = Load("pb_api", []string{"-jwt-hs256-secret-file=" + secret, "-jwt-issuer=https://idp", "-jwt-audience=pb_api"}, env(nil), noUsage)
+	assert.Nil(t, err)
+	assert.True(t, cfg.Auth.JWT.Enabled())
+	assert.Equal(t, []byte("0123456789abcdef0123456789abcdef"), cfg.TokenConfig(nil).HS256Secret)
+}
+
+func TestLoadRoleMapping(t *testing.T) {
+	cfg, _, err := Load("pb_api", []string{"-jwt-role-mapping= pb-admins=coupon-admin, pb-users = coupon-viewer,"}, env(nil), noUsage)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"pb-admins": "coupon-admin", "pb-users": "coupon-viewer"}, cfg.Auth.JWT.RoleMapping)
+	assert.Equal(t, "pb-admins=coupon-admin,pb-users=coupon-viewer", (*mapValue)(&cfg.Auth.JWT.RoleMapping).String())
+
+	_, _, err = Load("pb_api", []string{"-jwt-role-mapping=pb-admins"}, env(nil), noUsage)
+	assert.NotNil(t, err)
+}
+
+func TestLoadRateLimit(t *testing.T) {
+	cfg, _, err := Load("pb_api", []string{"-rate-limit", "-rate-limit-default=10/1s by ip", "-rate-limit-routes=get_coupon=5/1m,audit=off"}, env(nil), noUsage)
+	assert.Nil(t, err)
+	rl := cfg.RateLimitConfig()
+	assert.Equal(t, ratelimit.Limit{Requests: 10, Period: time.Second, By: ratelimit.KeyByIP}, rl.Default)
+	assert.Equal(t, map[string]ratelimit.Limit{
+		"get_coupon": {Requests: 5, Period: time.Minute, By: ratelimit.KeyByAPIKey},
+		"audit":      {},
+	}, rl.Routes)
+	assert.Equal(t, ratelimit.Lockout{Failures: 20, Window: time.Minute, Duration: time.Minute * 15}, rl.Lockout)
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	cfg, _, err := Load("pb_api", []string{"-webhook-max-attempts=3", "-webhook-backoff=1s", "-webhook-allow-private-networks"}, env(map[string]string{"PB_API_WEBHOOK_MAX_BACKOFF": "1m"}), noUsage)
+	assert.Nil(t, err)
+	assert.Equal(t, webhook.Config{
+		Interval:    time.Second,
+		Timeout:     time.Second * 10,
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+	}, cfg.WebhookConfig())
+	assert.True(t, cfg.Webhooks.AllowPrivateNetworks)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, _, err := Load("pb_api", []string{"-unknown"}, env(nil), noUsage)
+	assert.NotNil(t, err)
+
+	_, _, err = Load("pb_api", []string{"-h"}, env(nil), noUsage)
+	assert.Equal(t, flag.ErrHelp, err)
+
+	_, _, err = Load("pb_api", nil, env(map[string]string{"PB_API_DB_READ_TIMEOUT": "soon"}), noUsage)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "PB_API_DB_READ_TIMEOUT")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(c *Config)
+		errs   []string
+	}{
+		{"default", func(c *Config) {}, nil},
+		{"memory", func(c *Config) { c.Store.Type = StoreMemory; c.Postgres.SSLMode = "unknown" }, nil},
+		{"addr", func(c *Config) { c.Server.Addr = "8080" }, []string{"server.addr"}},
+		{"durations", func(c *Config) {
+			c.Server.WriteTimeout = -time.Second
+			c.Store.ExportTimeout = -time.Second
+			c.Server.HealthTimeout = 0
+		}, []string{"server.write_timeout", "store.export_timeout", "server.health_timeout"}},
+		{"store", func(c *Config) { c.Store.Type = "mongo" }, []string{"store.type"}},
+		{"sqlitePath", func(c *Config) { c.Store.Type = StoreSQLite; c.Store.SQLitePath = "" }, []string{"store.sqlite_path"}},
+		{"postgres", func(c *Config) {
+			c.Postgres.SSLMode = "prefer"
+			c.Postgres.MaxOpenConns = -1
+		}, []string{"postgres.sslmode", "postgres.max_open_conns"}},
+		{"replicas", func(c *Config) {
+			c.Store.Type = StoreSQLite
+			c.Postgres.Replicas = []string{"replica1"}
+		}, []string{"postgres.replicas: read replicas require", "postgres.replicas: address replica1"}},
+		{"sampleRatio", func(c *Config) { c.Tracing.SampleRatio = 2 }, []string{"tracing.sample_ratio"}},
+		{"jwt", func(c *Config) {
+			c.Auth.JWT = JWT{Issuer: "https://idp", Audience: "pb_api", JWKSURL: "https://idp/jwks", JWKSRefresh: time.Hour, RoleClaim: "roles"}
+		}, nil},
+		{"jwtInvalid", func(c *Config) {
+			c.Auth.JWT.HS256Secret = "short"
+			c.Auth.JWT.JWKSFile = "jwks.json"
+			c.Auth.JWT.JWKSURL = "https://idp/jwks"
+			c.Auth.JWT.RoleMapping = map[string]string{"admins": "root"}
+		}, []string{"auth.jwt.issuer", "auth.jwt.audience", "auth.jwt.hs256_secret", "auth.jwt.jwks_url", "auth.jwt.role_mapping: unknown role root"}},
+		{"webhooks", func(c *Config) {
+			c.Webhooks.MaxAttempts = 0
+			c.Webhooks.Backoff = time.Hour * 2
+		}, []string{"webhooks.max_attempts", "webhooks.max_backoff"}},
+		// the limits are only checked once enabled
+		{"rateLimitDisabled", func(c *Config) { c.RateLimit.Default = "fast" }, nil},
+		{"rateLimit", func(c *Config) {
+			c.RateLimit.Enabled = true
+			c.RateLimit.Default = "fast"
+			c.RateLimit.Routes = map[string]string{"get_coupon": "10/1m by user"}
+			c.RateLimit.LockoutWindow = 0
+		}, []string{"rate_limit.default", "rate_limit.routes: get_coupon", "rate_limit.lockout_window"}},
+	}
+	for _, c := range cases {
+		cfg := Default()
+		c.modify(&cfg)
+		err := cfg.Validate()
+		if len(c.errs) == 0 {
+			assert.Nil(t, err, c.name)
+			continue
+		}
+		if assert.NotNil(t, err, c.name) {
+			for _, e := range c.errs {
+				assert.Contains(t, err.Error(), e, c.name)
+			}
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := Default()
+	cfg.Postgres.DSN = "postgres://pb:s3cret@db/pb"
+	cfg.Postgres.Password = "s3cret"
+	cfg.Postgres.PasswordFile = "/run/secrets/password"
+	cfg.Auth.JWT.HS256Secret = "s3cret"
+
+	var buf bytes.Buffer
+	assert.Nil(t, cfg.Print(&buf))
+	assert.False(t, strings.Contains(buf.String(), "s3cret"))
+	assert.Equal(t, "s3cret", cfg.Postgres.Password)
+
+	// the printed config is a valid config file
+	printed := Default()
+	assert.Nil(t, yaml.Unmarshal(buf.Bytes(), &printed))
+	assert.Equal(t, redacted, printed.Postgres.Password)
+	assert.Equal(t, redacted, printed.Postgres.DSN)
+	assert.Equal(t, redacted, printed.Auth.JWT.HS256Secret)
+	assert.Equal(t, "/run/secrets/password", printed.Postgres.PasswordFile)
+	assert.Equal(t, cfg.Server, printed.Server)
+}
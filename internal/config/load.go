@@ -0,0 +1,277 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/sqlite"
+)
+
+const (
+	// EnvPrefix prefixes the environment variable of every flag, PB_API_DB_READ_TIMEOUT sets -db-read-timeout
+	EnvPrefix = "PB_API_"
+	// configFlag sets the config file
+	configFlag = "config"
+)
+
+// Load returns the settings of the API, args are the command line arguments without the program name
+// The defaults are overridden by the config file set by -config or PB_API_CONFIG, then by the environment variables
+// and at last by the flags of args. The secret files are read and the merged settings validated.
+// The arguments left after the flags, the command, are returned along.
+//
+// usage prints the help of the flags, Load returns flag.ErrHelp if args ask for it
+func Load(name string, args []string, lookupEnv func(string) (string, bool), usage func(fs *flag.FlagSet)) (Config, []string, error) {
+	// the flags are parsed a first time to find the config file, they override it once it is loaded
+	var path string
+	scratch := Default()
+	if err := newFlagSet(name, &scratch, &path, usage).Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+	if path == "" {
+		path, _ = lookupEnv(EnvName(configFlag))
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, nil, err
+		}
+	}
+	fs := newFlagSet(name, &cfg, &path, usage)
+	if err := setEnv(fs, lookupEnv); err != nil {
+		return Config{}, nil, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+	if err := cfg.readSecrets(); err != nil {
+		return Config{}, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// EnvName returns the environment variable setting the flag name
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// Print writes c to w in YAML, the format of the config files, with its secrets redacted
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return errors.Wrap(err, "failed to encode config")
+	}
+	return enc.Close()
+}
+
+// newFlagSet returns the flags setting cfg, and path for the config file
+func newFlagSet(name string, cfg *Config, path *string, usage func(fs *flag.FlagSet)) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() { usage(fs) }
+	fs.StringVar(path, configFlag, "", "YAML or TOML config file, overridden by the environment variables and the flags")
+
+	s := &cfg.Server
+	fs.StringVar(&s.Addr, "addr", s.Addr, "host:port the server listens on")
+	fs.DurationVar(&s.ReadTimeout, "read-timeout", s.ReadTimeout, "the maximum duration for reading a request, body included")
+	fs.DurationVar(&s.WriteTimeout, "write-timeout", s.WriteTimeout, "the maximum duration for writing a response")
+	fs.DurationVar(&s.IdleTimeout, "idle-timeout", s.IdleTimeout, "the maximum duration a keep-alive connection waits for the next request")
+	fs.DurationVar(&s.RequestTimeout, "request-timeout", s.RequestTimeout, "the maximum duration of a request, exports excluded, 0 for no timeout")
+	fs.DurationVar(&s.GracefulTimeout, "graceful-timeout", s.GracefulTimeout, "the duration for which the server gracefully wait for existing connections to finish")
+	fs.DurationVar(&s.HealthTimeout, "health-timeout", s.HealthTimeout, "the maximum duration of each health check")
+
+	fs.BoolVar(&cfg.Log.Debug, "debug", cfg.Log.Debug, "debug logger level")
+	fs.BoolVar(&cfg.Log.AccessLog, "access-log", cfg.Log.AccessLog, "log one JSON line per request to stdout")
+
+	st := &cfg.Store
+	fs.StringVar(&st.Type, "store", st.Type, "coupons store: postgres, sqlite or memory, the memory store is lost on shutdown")
+	fs.StringVar(&st.SQLitePath, "sqlite-path", st.SQLitePath, "sqlite db file, or "+sqlite.Memory+" for a db lost on shutdown")
+	fs.BoolVar(&st.Migrate, "migrate", st.Migrate, "apply pending schema migrations before starting the server")
+	fs.DurationVar(&st.ReadTimeout, "db-read-timeout", st.ReadTimeout, "the maximum duration of a db read, 0 for no timeout")
+	fs.DurationVar(&st.WriteTimeout, "db-write-timeout", st.WriteTimeout, "the maximum duration of a db write or transaction, 0 for no timeout")
+	fs.DurationVar(&st.ExportTimeout, "db-export-timeout", st.ExportTimeout, "the maximum duration of a coupons export, 0 for no timeout")
+
+	pg := &cfg.Postgres
+	fs.StringVar(&pg.DSN, "dsn", pg.DSN, "postgres db connection string or URL, it replaces the host, port, user, name, password and ssl flags")
+	fs.StringVar(&pg.DSNFile, "dsn-file", pg.DSNFile, "file holding the postgres db connection string or URL, it takes precedence over -dsn")
+	fs.StringVar(&pg.Host, "host", pg.Host, "postgres db hostname")
+	fs.StringVar(&pg.User, "user", pg.User, "postgres db username")
+	fs.StringVar(&pg.Name, "name", pg.Name, "postgres db name")
+	fs.StringVar(&pg.Password, "password", pg.Password, "postgres db password, prefer -password-file as the flags show up in the process listing")
+	fs.StringVar(&pg.PasswordFile, "password-file", pg.PasswordFile, "file holding the postgres db password, it takes precedence over -password")
+	fs.StringVar(&pg.Port, "port", pg.Port, "postgres db port number")
+	fs.StringVar(&pg.SSLMode, "sslmode", pg.SSLMode, "postgres db ssl mode: disable, require, verify-ca or verify-full")
+	fs.StringVar(&pg.SSLRootCert, "sslrootcert", pg.SSLRootCert, "CA file verifying the postgres db certificate with the verify-ca and verify-full ssl modes")
+	fs.IntVar(&pg.MaxOpenConns, "db-max-open-conns", pg.MaxOpenConns, "the maximum number of open postgres connections, 0 for unlimited")
+	fs.IntVar(&pg.MaxIdleConns, "db-max-idle-conns", pg.MaxIdleConns, "the maximum number of idle postgres connections")
+	fs.DurationVar(&pg.ConnMaxLifetime, "db-conn-max-lifetime", pg.ConnMaxLifetime, "the maximum duration a postgres connection is reused, 0 for forever")
+	fs.IntVar(&pg.ConnectRetries, "db-connect-retries", pg.ConnectRetries, "the number of times a failed postgres connection is retried at startup")
+	fs.DurationVar(&pg.RetryBackoff, "db-retry-backoff", pg.RetryBackoff, "the wait before the first postgres connection retry, doubled after every retry")
+	fs.Var((*listValue)(&pg.Replicas), "replicas", "comma separated host:port of the postgres read replicas, they share the other postgres flags except -dsn")
+	fs.DurationVar(&pg.ReplicaStickiness, "replica-stickiness", pg.ReplicaStickiness, "the duration for which reads go to the primary db after a write")
+	fs.DurationVar(&pg.ReplicaRetry, "replica-retry", pg.ReplicaRetry, "the duration for which a failed read replica is left out")
+
+	tr := &cfg.Tracing
+	fs.StringVar(&tr.OTLPEndpoint, "otlp-endpoint", tr.OTLPEndpoint, "host:port of the OTLP HTTP collector receiving the traces, tracing is disabled if empty")
+	fs.BoolVar(&tr.OTLPInsecure, "otlp-insecure", tr.OTLPInsecure, "send the traces to the OTLP collector over plain HTTP")
+	fs.Float64Var(&tr.SampleRatio, "trace-sample-ratio", tr.SampleRatio, "the ratio of the traces started by the API which are sampled, between 0 and 1")
+
+	fs.BoolVar(&cfg.Auth.APIKeys, "auth-api-keys", cfg.Auth.APIKeys, "require an API key granted the scopes of the route on every coupons and keys request")
+	jwt := &cfg.Auth.JWT
+	fs.StringVar(&jwt.Issuer, "jwt-issuer", jwt.Issuer, "the iss claim of the JWT bearer tokens")
+	fs.StringVar(&jwt.Audience, "jwt-audience", jwt.Audience, "the aud claim the JWT bearer tokens must hold")
+	fs.StringVar(&jwt.HS256Secret, "jwt-hs256-secret", jwt.HS256Secret, "secret verifying the HS256 tokens, prefer -jwt-hs256-secret-file")
+	fs.StringVar(&jwt.HS256SecretFile, "jwt-hs256-secret-file", jwt.HS256SecretFile, "file holding the secret verifying the HS256 tokens, it takes precedence over -jwt-hs256-secret")
+	fs.StringVar(&jwt.JWKSFile, "jwt-jwks-file", jwt.JWKSFile, "JWKS file verifying the RS256 and ES256 tokens")
+	fs.StringVar(&jwt.JWKSURL, "jwt-jwks-url", jwt.JWKSURL, "JWKS URL of the identity provider verifying the RS256 and ES256 tokens")
+	fs.DurationVar(&jwt.JWKSRefresh, "jwt-jwks-refresh", jwt.JWKSRefresh, "the duration the JWKS is cached, an unknown key id reloads it sooner")
+	fs.StringVar(&jwt.RoleClaim, "jwt-role-claim", jwt.RoleClaim, "the claim holding the roles of the tokens, dotted for a nested claim like realm_access.roles")
+	fs.StringVar(&jwt.TenantClaim, "jwt-tenant-claim", jwt.TenantClaim, "the claim holding the tenant of the tokens, empty to let every token pick its tenant")
+	fs.Var((*mapValue)(&jwt.RoleMapping), "jwt-role-mapping", "comma separated claim=role pairs mapping the role claim values to the coupon-admin and coupon-viewer roles")
+	fs.DurationVar(&jwt.Leeway, "jwt-leeway", jwt.Leeway, "the clock skew allowed on the exp, nbf and iat claims of the tokens")
+
+	rl := &cfg.RateLimit
+	fs.BoolVar(&rl.Enabled, "rate-limit", rl.Enabled, "limit the rate of the requests of every client on the coupons and keys routes")
+	fs.StringVar(&rl.Default, "rate-limit-default", rl.Default, "the limit of the routes missing from -rate-limit-routes, like 100/1m or 100/1m by ip, the key being api_key, ip or customer")
+	fs.Var((*mapValue)(&rl.Routes), "rate-limit-routes", "comma separated route=limit pairs like get_coupon=60/1m by ip, off for no limit")
+	fs.BoolVar(&rl.TrustForwarded, "rate-limit-trust-forwarded", rl.TrustForwarded, "key the client IPs by the X-Forwarded-For header, only behind a proxy setting it")
+	fs.IntVar(&rl.LockoutFailures, "lockout-failures", rl.LockoutFailures, "the number of lookups of unknown coupons within -lockout-window locking a client out, 0 for no lockout")
+	fs.DurationVar(&rl.LockoutWindow, "lockout-window", rl.LockoutWindow, "the duration within which the failed lookups of a client are counted")
+	fs.DurationVar(&rl.LockoutDuration, "lockout-duration", rl.LockoutDuration, "the duration for which a client is locked out of the lookups")
+
+	wh := &cfg.Webhooks
+	fs.DurationVar(&wh.Interval, "webhook-interval", wh.Interval, "the wait between two rounds of webhook deliveries")
+	fs.DurationVar(&wh.Timeout, "webhook-timeout", wh.Timeout, "the maximum duration of each attempt of a webhook delivery")
+	fs.IntVar(&wh.MaxAttempts, "webhook-max-attempts", wh.MaxAttempts, "the number of failed attempts after which a webhook delivery is dead")
+	fs.DurationVar(&wh.Backoff, "webhook-backoff", wh.Backoff, "the wait after the first failed attempt of a webhook delivery, doubled after each other one")
+	fs.DurationVar(&wh.MaxBackoff, "webhook-max-backoff", wh.MaxBackoff, "the maximum wait between two attempts of a webhook delivery")
+	fs.BoolVar(&wh.AllowPrivateNetworks, "webhook-allow-private-networks", wh.AllowPrivateNetworks, "accept the webhooks on loopback, private and link-local addresses, for local development only")
+	return fs
+}
+
+// setEnv sets the flags of fs whose environment variable is set
+func setEnv(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := lookupEnv(EnvName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, v); setErr != nil {
+			err = errors.Wrapf(setErr, "invalid value %q for %s", v, EnvName(f.Name))
+		}
+	})
+	return err
+}
+
+// loadFile decodes the config file at path into cfg, its format is given by its extension
+// Keys missing from the file keep their value, unknown keys are an error so a misspelt setting is not ignored
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read config file")
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// an empty file holds no document
+		if err := dec.Decode(cfg); err != nil && err != io.EOF {
+			return errors.Wrapf(err, "failed to decode config file %s", path)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode config file %s", path)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown keys in config file %s: %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("unknown config file format %s: expected .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// readSecrets replaces the secrets whose file is set by the content of the file, without its trailing newline
+func (c *Config) readSecrets() error {
+	for _, secret := range []struct {
+		file  string
+		value *string
+	}{
+		{c.Postgres.PasswordFile, &c.Postgres.Password},
+		{c.Postgres.DSNFile, &c.Postgres.DSN},
+		{c.Auth.JWT.HS256SecretFile, &c.Auth.JWT.HS256Secret},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		data, err := os.ReadFile(secret.file)
+		if err != nil {
+			return errors.Wrap(err, "failed to read secret file")
+		}
+		*secret.value = strings.TrimRight(string(data), "\r\n")
+	}
+	return nil
+}
+
+// listValue is a flag.Value of comma separated values
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set replaces the values, blank values are dropped
+func (l *listValue) Set(v string) error {
+	*l = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// mapValue is a flag.Value of comma separated key=value pairs
+type mapValue map[string]string
+
+func (m *mapValue) String() string {
+	pairs := make([]string, 0, len(*m))
+	for k, v := range *m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set replaces the pairs, blank pairs are dropped
+func (m *mapValue) Set(v string) error {
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return fmt.Errorf("invalid pair %q: expected key=value", pair)
+		}
+		pairs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	*m = pairs
+	return nil
+}
@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// scopes granted to the API keys
+const (
+	ScopeCouponsRead   = "coupons:read"
+	ScopeCouponsWrite  = "coupons:write"
+	ScopeCouponsDelete = "coupons:delete"
+	// ScopeAuditRead reads the audit entries of the coupon changes
+	ScopeAuditRead = "audit:read"
+	// ScopeWebhooks manages the webhooks notified of the coupon changes and reads their deliveries
+	ScopeWebhooks = "webhooks:manage"
+	// ScopeAdmin grants every scope, the management of the API keys included
+	ScopeAdmin = "admin"
+)
+
+// Scopes holds every scope in order
+var Scopes = []string{ScopeCouponsRead, ScopeCouponsWrite, ScopeCouponsDelete, ScopeAuditRead, ScopeWebhooks, ScopeAdmin}
+
+// IsScope reports whether scope is a known scope
+func IsScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a key authenticating the API callers
+// Only the hash of the key is stored, the key itself is shown once when it is created
+type APIKey struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	// Prefix is the public part of the key, it identifies the key without revealing it
+	Prefix string   `json:"prefix"`
+	Hash   string   `json:"-"`
+	Scopes []string `json:"scopes"`
+	// Tenant is the only tenant reached with the key, empty if the key reaches every tenant
+	Tenant string `json:"tenant,omitempty"`
+	// RevokedAt is set once the key is revoked, a revoked key authenticates no request
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatedAPIKey is a new APIKey along with the key itself
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// KeyRepository stores the API keys
+// The keys read by id, listed, revoked and rotated are those of the tenant of the caller of ctx if it is bound to one,
+// see BoundTenant, and every key otherwise
+type KeyRepository interface {
+	// NewAPIKey creates k, its ID and CreatedAt are set
+	NewAPIKey(ctx context.Context, k *APIKey) error
+	// GetAPIKeyByID reads the key with id, revoked or not
+	GetAPIKeyByID(ctx context.Context, id uint, k *APIKey) error
+	// GetAPIKeyByPrefix reads the key with prefix, revoked or not, whatever its tenant since it authenticates the caller
+	GetAPIKeyByPrefix(ctx context.Context, prefix string, k *APIKey) error
+	// ListAPIKeys reads every key in id order, revoked ones included
+	ListAPIKeys(ctx context.Context, keys *[]APIKey) error
+	// RevokeAPIKey revokes the key with id, revoking a revoked key is a no-op
+	RevokeAPIKey(ctx context.Context, id uint) error
+	// RotateAPIKey revokes the key with id and creates k in its place atomically, the key with id must not be revoked
+	RotateAPIKey(ctx context.Context, id uint, k *APIKey) error
+}
@@ -0,0 +1,128 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// actions recorded by the audit entries
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	// AuditRedeem is reserved for the redemptions of the coupons. There is no redeem operation yet, so no entry
+	// records it and IsAuditAction rejects it until the operation is added and records it in its transaction
+	AuditRedeem = "redeem"
+)
+
+// AnonymousActor is the actor of the changes made while authentication is disabled
+const AnonymousActor = "anonymous"
+
+// AuditEntry records a change of a coupon, it is never updated nor deleted
+// The repositories write it in the transaction of the change, so every change committed has its entry
+type AuditEntry struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"timestamp"`
+	Tenanted
+	// Actor is the subject of the caller, see Principal, or AnonymousActor
+	Actor    string `json:"actor"`
+	Action   string `json:"action"`
+	CouponID uint   `json:"coupon_id"`
+	// Changes holds the changed coupon fields, keyed by their API name
+	Changes   map[string]AuditChange `json:"changes"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// AuditChange holds the JSON values of a coupon field before and after a change
+// Before is empty for a created coupon and After for a deleted one
+type AuditChange struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditQuery filters the audit entries, its zero values match every entry
+type AuditQuery struct {
+	Actor     string
+	Action    string
+	CouponID  uint
+	RequestID string
+	// From and To bound the time of the entries, both included
+	From time.Time
+	To   time.Time
+	// Limit and Offset page the entries, every entry is returned if Limit is 0
+	Limit  uint
+	Offset uint
+}
+
+// IsAuditAction reports whether action is a recorded action, AuditRedeem is not recorded yet
+func IsAuditAction(action string) bool {
+	switch action {
+	case AuditCreate, AuditUpdate, AuditDelete:
+		return true
+	}
+	return false
+}
+
+// NewAuditEntry returns the entry of the change of a coupon from before to after by the caller of ctx
+// before is nil for a created coupon and after for a deleted one. Its id and time are set by the repository
+func NewAuditEntry(ctx context.Context, action string, before, after *Coupon) AuditEntry {
+	e := AuditEntry{
+		Tenanted:  Tenanted{TenantID: TenantFrom(ctx)},
+		Actor:     AnonymousActor,
+		Action:    action,
+		Changes:   diffCoupons(before, after),
+		RequestID: RequestIDFrom(ctx),
+	}
+	if p, ok := PrincipalFrom(ctx); ok {
+		e.Actor = p.Subject
+	}
+	if after != nil {
+		e.CouponID = after.ID
+	} else if before != nil {
+		e.CouponID = before.ID
+	}
+	return e
+}
+
+// auditedFields holds the coupon fields whose changes are recorded
+var auditedFields = []string{"name", "brand", "value", "expiry"}
+
+// diffCoupons returns the audited fields which differ between before and after, either of which may be nil
+func diffCoupons(before, after *Coupon) map[string]AuditChange {
+	changes := make(map[string]AuditChange)
+	for _, f := range auditedFields {
+		var change AuditChange
+		if before != nil {
+			change.Before = marshalField(*before, f)
+		}
+		if after != nil {
+			change.After = marshalField(*after, f)
+		}
+		if string(change.Before) != string(change.After) {
+			changes[f] = change
+		}
+	}
+	return changes
+}
+
+// marshalField returns the JSON value of the field of c
+func marshalField(c Coupon, field string) json.RawMessage {
+	// the values of the coupon fields always marshal
+	b, _ := json.Marshal(couponFields[field].value(c))
+	return b
+}
+
+// requestIDKey is the context key of the request id
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the id of its request, recorded by the audit entries
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the id of the request of ctx, or "" if there is none
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
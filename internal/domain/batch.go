@@ -0,0 +1,42 @@
+package domain
+
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "best_effort"
+
+	BatchStatusOK       = "ok"
+	BatchStatusInvalid  = "invalid"
+	BatchStatusNotFound = "not_found"
+	BatchStatusFailed   = "failed"
+	BatchStatusAborted  = "aborted"
+)
+
+// BatchRequest is a list of coupon operations executed together
+// Mode is either BatchModeAtomic, where every operation is applied or none is, or BatchModeBestEffort
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is a single create, update or delete operation of a BatchRequest
+// ID is required by update and delete, Coupon by create and update
+type BatchOperation struct {
+	Op     string    `json:"op"`
+	ID     uint      `json:"id,omitempty"`
+	Coupon APICoupon `json:"coupon"`
+}
+
+// BatchResult is the outcome of a BatchOperation
+// ID holds the id of the created, updated or deleted coupon
+// An aborted operation was rolled back, or never executed, because another operation of an atomic batch failed
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	ID     uint   `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "context"
+
+// primaryReadsKey is the context key marking the requests which must read from the primary db
+type primaryReadsKey struct{}
+
+// WithPrimaryReads returns a copy of ctx whose reads are never served by a read replica,
+// so they see every write committed before them
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// PrimaryReads reports whether the reads of ctx must be served by the primary db
+func PrimaryReads(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return primary
+}
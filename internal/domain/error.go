@@ -31,3 +31,66 @@ func (err InvalidArgsError) Error() string {
 func NewInvalidArgsError(msg string) error {
 	return InvalidArgsError{msg: msg}
 }
+
+const (
+	APIKeyNotFoundErrorMessage = "api key not found"
+)
+
+// APIKeyNotFoundError is the error passed when the api key does not exist in the DB, or is revoked
+type APIKeyNotFoundError struct{}
+
+// Error implements the error interface
+func (err APIKeyNotFoundError) Error() string {
+	return APIKeyNotFoundErrorMessage
+}
+
+// NewAPIKeyNotFoundError is the constructor for APIKeyNotFoundError
+func NewAPIKeyNotFoundError() error {
+	return APIKeyNotFoundError{}
+}
+
+// ForbiddenError is the error passed when the caller lacks the role of an operation
+type ForbiddenError struct {
+	msg string
+}
+
+// Error implements the error interface
+func (err ForbiddenError) Error() string {
+	return err.msg
+}
+
+// NewForbiddenError is the constructor for ForbiddenError
+func NewForbiddenError(msg string) error {
+	return ForbiddenError{msg: msg}
+}
+
+const (
+	WebhookNotFoundErrorMessage  = "webhook not found"
+	DeliveryNotFoundErrorMessage = "webhook delivery not found"
+)
+
+// WebhookNotFoundError is the error passed when the webhook does not exist in the DB
+type WebhookNotFoundError struct{}
+
+// Error implements the error interface
+func (err WebhookNotFoundError) Error() string {
+	return WebhookNotFoundErrorMessage
+}
+
+// NewWebhookNotFoundError is the constructor for WebhookNotFoundError
+func NewWebhookNotFoundError() error {
+	return WebhookNotFoundError{}
+}
+
+// DeliveryNotFoundError is the error passed when the delivery does not exist in the DB, or is not one of its webhook
+type DeliveryNotFoundError struct{}
+
+// Error implements the error interface
+func (err DeliveryNotFoundError) Error() string {
+	return DeliveryNotFoundErrorMessage
+}
+
+// NewDeliveryNotFoundError is the constructor for DeliveryNotFoundError
+func NewDeliveryNotFoundError() error {
+	return DeliveryNotFoundError{}
+}
@@ -0,0 +1,33 @@
+package domain
+
+const (
+	ImportStatusCreated = "created"
+	ImportStatusValid   = "valid"
+	ImportStatusInvalid = "invalid"
+	ImportStatusFailed  = "failed"
+)
+
+// ImportRow is a coupon read from an import file
+// Err holds the error that prevented the row from being decoded, in which case Coupon is incomplete
+type ImportRow struct {
+	Line   int
+	Coupon APICoupon
+	Err    error
+}
+
+// ImportResult is the outcome of importing a single row
+type ImportResult struct {
+	Line   int      `json:"line"`
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImportReport sums up a coupon import, holding the result of every row in the file order
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Created int            `json:"created"`
+	Valid   int            `json:"valid"`
+	Invalid int            `json:"invalid"`
+	Failed  int            `json:"failed"`
+	Rows    []ImportResult `json:"rows"`
+}
@@ -0,0 +1,76 @@
+package domain
+
+import "context"
+
+// roles of the callers, the service authorizes the coupon changes with them
+const (
+	// RoleCouponAdmin may create, update and delete coupons
+	RoleCouponAdmin = "coupon-admin"
+	// RoleCouponViewer may only read coupons
+	RoleCouponViewer = "coupon-viewer"
+)
+
+// Roles holds every role in order
+var Roles = []string{RoleCouponAdmin, RoleCouponViewer}
+
+// IsRole reports whether role is a known role
+func IsRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is the authenticated caller of a request
+// The scopes restrict the routes it calls, the roles what the service lets it do
+type Principal struct {
+	// Subject identifies the caller, like "apikey:12" for the API key 12 or "jwt:{sub}" for a token
+	Subject string
+	Scopes  []string
+	Roles   []string
+	// Tenant is the only tenant the caller reaches, empty if it picks its tenant with each request
+	Tenant string
+}
+
+// HasScope reports whether the principal is granted scope, ScopeAdmin grants every scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the principal has role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// BoundTenant returns the only tenant the caller of ctx reaches, empty if it reaches every tenant or if the request
+// is not authenticated
+func BoundTenant(ctx context.Context) string {
+	p, _ := PrincipalFrom(ctx)
+	return p.Tenant
+}
+
+// principalKey is the context key of the Principal
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated caller of its request
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the authenticated caller of the request of ctx, false if the request is not authenticated
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,78 @@
+package domain
+
+const (
+	// FilterEqual matches the records whose column equals any of the values
+	FilterEqual = "eq"
+	// FilterLess matches the records whose column is lesser than the value
+	FilterLess = "lt"
+	// FilterGreater matches the records whose column is greater than the value
+	FilterGreater = "gt"
+	// FilterILike matches the records whose column case-insensitively equals any of the values
+	FilterILike = "ilike"
+	// FilterPrefix matches the records whose column case-insensitively starts with any of the values
+	FilterPrefix = "prefix"
+)
+
+// CouponFilter is a condition on a coupon column
+// Values holds a single value for FilterLess and FilterGreater, and strings for FilterILike and FilterPrefix
+type CouponFilter struct {
+	Column string
+	Op     string
+	Values []interface{}
+}
+
+// CouponQuery describes a query over the coupon records: its filters, selected columns and page
+//
+// A CouponQuery is immutable, every method returns a new query and leaves the receiver untouched,
+// so a query can be shared and extended by concurrent requests. The zero value matches every coupon
+type CouponQuery struct {
+	filters []CouponFilter
+	columns []string
+	limit   uint
+	page    uint
+}
+
+// Where returns a copy of the query with an additional filter, every filter of a query must match
+func (q CouponQuery) Where(column, op string, values ...interface{}) CouponQuery {
+	filters := make([]CouponFilter, len(q.filters), len(q.filters)+1)
+	copy(filters, q.filters)
+	q.filters = append(filters, CouponFilter{Column: column, Op: op, Values: append([]interface{}(nil), values...)})
+	return q
+}
+
+// Select returns a copy of the query only reading the given columns
+func (q CouponQuery) Select(columns []string) CouponQuery {
+	q.columns = append([]string(nil), columns...)
+	return q
+}
+
+// Paginate returns a copy of the query limited to the page of limit records
+// Pages start at 1, a 0 limit reads every record
+func (q CouponQuery) Paginate(limit, page uint) CouponQuery {
+	q.limit = limit
+	q.page = page
+	return q
+}
+
+// Filters returns the filters of the query
+func (q CouponQuery) Filters() []CouponFilter {
+	return append([]CouponFilter(nil), q.filters...)
+}
+
+// Columns returns the selected columns, empty if every column is read
+func (q CouponQuery) Columns() []string {
+	return append([]string(nil), q.columns...)
+}
+
+// Limit returns the maximum number of records of the query, 0 if it is unlimited
+func (q CouponQuery) Limit() uint {
+	return q.limit
+}
+
+// Offset returns the number of records skipped by the query page
+func (q CouponQuery) Offset() uint {
+	if q.page == 0 {
+		return 0
+	}
+	return q.limit * (q.page - 1)
+}
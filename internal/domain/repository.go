@@ -0,0 +1,28 @@
+//go:generate mockgen -package mocks -destination ../../mocks/repository.go github.com/jcgfreitas/pb_api/internal/domain Repository
+
+package domain
+
+import "context"
+
+// Repository is the abstraction over the repository layer that handles db requests
+// Every coupon change is recorded by an AuditEntry written in the same transaction
+// It is declared here rather than in the service so the WithTx callback, which receives a Repository,
+// can be implemented and mocked without importing the service
+type Repository interface {
+	// WithTx runs fn with a Repository bound to a transaction, committed if fn returns nil and rolled back otherwise
+	// Calling WithTx on the Repository passed to fn opens a savepoint, so a nested failure only rolls back its own work
+	WithTx(ctx context.Context, fn func(tx Repository) error) error
+	NewCoupon(ctx context.Context, APIc APICoupon) (uint, error)
+	GetCouponByID(ctx context.Context, id uint, columns []string, c *Coupon) error
+	DeleteCoupon(ctx context.Context, id uint) error
+	UpdateCoupon(ctx context.Context, id uint, APIc APICoupon) error
+	QueryCoupons(ctx context.Context, coupons *[]Coupon, q CouponQuery) error
+	ExportCoupons(ctx context.Context, fn func(c Coupon) error, q CouponQuery) error
+	CouponStats(ctx context.Context, stats *[]CouponStats, groupBy []string, q CouponQuery) error
+	// CouponHistory reads the audit entries of the coupon with id in id order, those of a deleted coupon included
+	CouponHistory(ctx context.Context, id uint, entries *[]AuditEntry) error
+	// QueryAudit reads the audit entries matching q in id order
+	QueryAudit(ctx context.Context, entries *[]AuditEntry, q AuditQuery) error
+	// Ping checks that the store is reachable
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,31 @@
+package domain
+
+const (
+	StatsGroupBrand       = "brand"
+	StatsGroupStatus      = "status"
+	StatsGroupExpiryMonth = "expiry_month"
+
+	CouponStatusActive  = "active"
+	CouponStatusExpired = "expired"
+)
+
+// CouponStats holds the aggregated values of a group of coupons
+// The group fields are only set when the coupons were grouped by them
+type CouponStats struct {
+	Brand       *string `json:"brand,omitempty"`
+	Status      *string `json:"status,omitempty"`
+	ExpiryMonth *string `json:"expiry_month,omitempty"`
+	Count       uint    `json:"count"`
+	Sum         uint    `json:"sum"`
+	Min         uint    `json:"min"`
+	Max         uint    `json:"max"`
+}
+
+// IsStatsGroup reports whether coupons stats can be grouped by group
+func IsStatsGroup(group string) bool {
+	switch group {
+	case StatsGroupBrand, StatsGroupStatus, StatsGroupExpiryMonth:
+		return true
+	}
+	return false
+}
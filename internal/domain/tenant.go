@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"context"
+	"regexp"
+)
+
+// DefaultTenant owns the entities of the requests naming no tenant, like every request of a single tenant deployment
+const DefaultTenant = "default"
+
+// tenantPattern matches the tenant ids
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// IsTenantID reports whether id is a valid tenant id: 1 to 64 lowercase letters, digits, _ or -, starting with
+// a letter or a digit
+func IsTenantID(id string) bool {
+	return tenantPattern.MatchString(id)
+}
+
+// Tenanted is embedded by the entities owned by a tenant
+// The repositories set it on creation and scope every query of a context to the tenant of the context,
+// so an entity of a tenant is never read or changed by another one
+type Tenanted struct {
+	TenantID string `json:"-"`
+}
+
+// tenantKey is the context key of the tenant
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx whose entities are those of tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of ctx, DefaultTenant if ctx has none
+func TenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
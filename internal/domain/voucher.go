@@ -9,6 +9,7 @@ import (
 // Coupon is the base structure representing coupons which are stored in our database
 type Coupon struct {
 	gorm.Model
+	Tenanted
 	Name   string    `json:"name"`
 	Brand  string    `json:"brand"`
 	Value  uint      `json:"value"`
@@ -55,3 +56,91 @@ func UpdateCoupon(c Coupon, APIc APICoupon) Coupon {
 	}
 	return c
 }
+
+// couponField describes a coupon field that can be selected through the API
+type couponField struct {
+	column string
+	key    string
+	value  func(c Coupon) interface{}
+}
+
+// couponFields holds the selectable coupon fields, keyed by their API name
+// DeletedAt is left out on purpose, soft deleted coupons are never returned
+var couponFields = map[string]couponField{
+	"id":         {column: "id", key: "ID", value: func(c Coupon) interface{} { return c.ID }},
+	"created_at": {column: "created_at", key: "CreatedAt", value: func(c Coupon) interface{} { return c.CreatedAt }},
+	"updated_at": {column: "updated_at", key: "UpdatedAt", value: func(c Coupon) interface{} { return c.UpdatedAt }},
+	"name":       {column: "name", key: "name", value: func(c Coupon) interface{} { return c.Name }},
+	"brand":      {column: "brand", key: "brand", value: func(c Coupon) interface{} { return c.Brand }},
+	"value":      {column: "value", key: "value", value: func(c Coupon) interface{} { return c.Value }},
+	"expiry":     {column: "expiry", key: "expiry", value: func(c Coupon) interface{} { return c.Expiry }},
+}
+
+// CouponFieldNames holds the selectable coupon fields in their natural order
+var CouponFieldNames = []string{"id", "created_at", "updated_at", "name", "brand", "value", "expiry"}
+
+// IsCouponField reports whether field can be selected through the API
+func IsCouponField(field string) bool {
+	_, ok := couponFields[field]
+	return ok
+}
+
+// IsCouponColumn reports whether column is the db column of a selectable coupon field
+func IsCouponColumn(column string) bool {
+	for _, f := range couponFields {
+		if f.column == column {
+			return true
+		}
+	}
+	return false
+}
+
+// CouponColumns returns the db columns of the given fields
+// The id column is always included since it is used to detect missing records
+func CouponColumns(fields []string) []string {
+	columns := []string{couponFields["id"].column}
+	for _, f := range fields {
+		if f != "id" {
+			columns = append(columns, couponFields[f].column)
+		}
+	}
+	return columns
+}
+
+// CouponFieldValue returns the value of a coupon field, field must be a selectable field
+func CouponFieldValue(c Coupon, field string) interface{} {
+	return couponFields[field].value(c)
+}
+
+// CouponResponse is a coupon as marshalled by the API, every selectable field without the deletion time and tenant
+type CouponResponse struct {
+	ID        uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Name      string    `json:"name"`
+	Brand     string    `json:"brand"`
+	Value     uint      `json:"value"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// SelectCouponFields restricts a coupon to the given fields before it is marshalled
+// If no fields are given every selectable field is returned, see CouponResponse
+func SelectCouponFields(c Coupon, fields []string) interface{} {
+	if len(fields) == 0 {
+		return CouponResponse{
+			ID:        c.ID,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Name:      c.Name,
+			Brand:     c.Brand,
+			Value:     c.Value,
+			Expiry:    c.Expiry,
+		}
+	}
+	selected := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		cf := couponFields[f]
+		selected[cf.key] = cf.value(c)
+	}
+	return selected
+}
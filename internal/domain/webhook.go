@@ -0,0 +1,185 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// types of the coupon events notified to the webhooks
+const (
+	EventCouponCreated = "coupon.created"
+	EventCouponUpdated = "coupon.updated"
+	EventCouponDeleted = "coupon.deleted"
+	// EventCouponExpired is written once the expiry of a coupon passed, see WebhookRepository.ExpireCoupons
+	EventCouponExpired = "coupon.expired"
+	// EventCouponRedeemed is reserved for the redemptions of the coupons, like AuditRedeem. There is no redeem
+	// operation yet, so it is left out of EventTypes until the operation is added and writes it in its transaction
+	EventCouponRedeemed = "coupon.redeemed"
+)
+
+// EventTypes holds every event type in order
+var EventTypes = []string{EventCouponCreated, EventCouponUpdated, EventCouponDeleted, EventCouponExpired}
+
+// eventTypes maps the audited actions to the type of their event
+var eventTypes = map[string]string{
+	AuditCreate: EventCouponCreated,
+	AuditUpdate: EventCouponUpdated,
+	AuditDelete: EventCouponDeleted,
+}
+
+// IsEventType reports whether t is a known event type
+func IsEventType(t string) bool {
+	for _, et := range EventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// states of the deliveries
+const (
+	// DeliveryPending deliveries are attempted until delivered or dead
+	DeliveryPending = "pending"
+	// DeliveryDelivered deliveries were answered with a 2xx status by their webhook
+	DeliveryDelivered = "delivered"
+	// DeliveryDead deliveries failed every attempt, they are only attempted again once redelivered
+	DeliveryDead = "dead"
+)
+
+// IsDeliveryStatus reports whether status is a state of the deliveries
+func IsDeliveryStatus(status string) bool {
+	switch status {
+	case DeliveryPending, DeliveryDelivered, DeliveryDead:
+		return true
+	}
+	return false
+}
+
+// Event is a change of a coupon notified to the webhooks of its tenant subscribed to its type
+// The repositories write it in the transaction of the change, like its audit entry, so no committed change is
+// left unnotified even if the API stops before its delivery
+type Event struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Tenanted
+	Type string    `json:"type"`
+	Data EventData `json:"data"`
+}
+
+// EventData is the coupon change of an Event
+type EventData struct {
+	CouponID uint `json:"coupon_id"`
+	// Changes holds the changed coupon fields, see AuditEntry
+	Changes   map[string]AuditChange `json:"changes"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// NewEvent returns the event of the change recorded by e, its id and time are set by the repository
+func NewEvent(e AuditEntry) Event {
+	return Event{
+		Tenanted: e.Tenanted,
+		Type:     eventTypes[e.Action],
+		Data:     EventData{CouponID: e.CouponID, Changes: e.Changes, RequestID: e.RequestID},
+	}
+}
+
+// NewExpiredEvent returns the event of the expiry of c, which changes none of its fields, its id and time are set by
+// the repository
+func NewExpiredEvent(c Coupon) Event {
+	return Event{
+		Tenanted: c.Tenanted,
+		Type:     EventCouponExpired,
+		Data:     EventData{CouponID: c.ID, Changes: map[string]AuditChange{}},
+	}
+}
+
+// Webhook is the subscription of a URL to the events of a tenant
+type Webhook struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Tenanted
+	URL string `json:"url"`
+	// Events holds the subscribed event types
+	Events []string `json:"events"`
+	// Secret signs the payloads delivered to the URL, it is only shown when the webhook is created
+	Secret string `json:"-"`
+}
+
+// Subscribes reports whether w is subscribed to the events of type t
+func (w Webhook) Subscribes(t string) bool {
+	for _, e := range w.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// CreatedWebhook is a new Webhook along with its secret
+type CreatedWebhook struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+// Delivery is the delivery of an event to a webhook, it logs the attempts made
+type Delivery struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Tenanted
+	WebhookID uint   `json:"webhook_id"`
+	EventID   uint   `json:"event_id"`
+	EventType string `json:"event_type"`
+	// Payload is the body posted to the webhook, the JSON Event
+	Payload json.RawMessage `json:"payload"`
+	Status  string          `json:"status"`
+	// Attempts is the number of attempts made, NextAttemptAt the time of the next one while pending
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	LastStatusCode int       `json:"last_status_code,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+	// DeliveredAt is set once the webhook accepted the payload
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// DeliveryQuery filters the deliveries of a webhook, its zero values match every delivery
+type DeliveryQuery struct {
+	Status string
+	// Limit and Offset page the deliveries, every delivery is returned if Limit is 0
+	Limit  uint
+	Offset uint
+}
+
+// WebhookRepository stores the webhooks, the outbox of the events and their deliveries
+// The webhooks and the deliveries are those of the tenant of the context, see TenantFrom, except for the methods
+// of the dispatcher which handle every tenant
+type WebhookRepository interface {
+	// NewWebhook creates w, its ID, CreatedAt and tenant are set
+	NewWebhook(ctx context.Context, w *Webhook) error
+	// GetWebhookByID reads the webhook with id
+	GetWebhookByID(ctx context.Context, id uint, w *Webhook) error
+	// ListWebhooks reads every webhook in id order
+	ListWebhooks(ctx context.Context, webhooks *[]Webhook) error
+	// DeleteWebhook deletes the webhook with id along with its deliveries
+	DeleteWebhook(ctx context.Context, id uint) error
+	// QueryDeliveries reads the deliveries of the webhook with id matching q, the latest first
+	QueryDeliveries(ctx context.Context, webhookID uint, deliveries *[]Delivery, q DeliveryQuery) error
+	// Redeliver sets the delivery with id of the webhook with webhookID pending with no attempt, to be attempted now
+	Redeliver(ctx context.Context, webhookID, id uint) error
+
+	// ExpireCoupons writes the EventCouponExpired event of up to limit coupons whose expiry passed in the outbox,
+	// and marks them notified in the same transaction. A coupon is notified once per expiry, again only if its expiry
+	// is changed and passes again. The coupons without expiry never expire. It returns the number of coupons expired
+	ExpireCoupons(ctx context.Context, limit int) (int, error)
+	// FanOut turns up to limit events of the outbox into a pending delivery for each webhook subscribed to them,
+	// and removes them from the outbox. It returns the number of events fanned out
+	FanOut(ctx context.Context, limit int) (int, error)
+	// ClaimDeliveries reads up to limit pending deliveries whose next attempt is due, and postpones their next attempt
+	// by lease so no other dispatcher claims them while they are attempted
+	ClaimDeliveries(ctx context.Context, lease time.Duration, limit int, deliveries *[]Delivery) error
+	// UpdateDelivery writes the outcome of an attempt of d: its status, attempts, next attempt, last status code and
+	// error, and delivery time
+	UpdateDelivery(ctx context.Context, d *Delivery) error
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+	"github.com/jcgfreitas/pb_api/pkg/statuswriter"
+)
+
+const (
+	// RequestIDHeader carries the id correlating the logs of a request, it is set on every response
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds the ids accepted from the clients, longer ids are replaced
+	maxRequestIDLength = 128
+)
+
+// WithRequestID is a mux middleware assigning an id to every request
+// The id of the X-Request-ID header is kept if it is valid, so the logs of a request are correlated with its caller,
+// otherwise a random id is generated. The id is set on the response header and the request context carries a
+// logrus entry of logger with a request_id field, which the handlers, service and repository log with
+func WithRequestID(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			ctx := domain.WithRequestID(r.Context(), id)
+			ctx = ctxlog.WithEntry(ctx, logger.WithField("request_id", id))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestID returns the id assigned to the request of ctx by WithRequestID, or "" if there is none
+func RequestID(ctx context.Context) string {
+	return domain.RequestIDFrom(ctx)
+}
+
+// AccessLog is a mux middleware logging one line per request to logger once it is handled
+// The line holds the method, route template, status code, body bytes, duration and client ip of the request,
+// and its id if WithRequestID runs before. Like the metrics middleware, set it as the router NotFoundHandler and
+// MethodNotAllowedHandler wrapper as well to log the unmatched requests
+func AccessLog(logger *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := statuswriter.Wrap(w)
+			next.ServeHTTP(sw, r)
+
+			fields := logrus.Fields{
+				"method":      r.Method,
+				"route":       Route(r),
+				"status":      sw.Status(),
+				"bytes":       sw.Bytes(),
+				"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+				"client_ip":   clientIP(r),
+			}
+			if id := RequestID(r.Context()); id != "" {
+				fields["request_id"] = id
+			}
+			logger.WithFields(fields).Info("request")
+		})
+	}
+}
+
+// validRequestID reports whether id is short and made of printable ascii characters only,
+// so a client cannot forge log lines with it
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 128 bits hex id
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// the system randomness never fails on the supported platforms, a timestamp id still correlates the logs
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// clientIP returns the ip of the client connection, the X-Forwarded-For header is ignored as any client may set it
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+)
+
+func TestWithRequestID(t *testing.T) {
+	cases := []struct {
+		name string
+		id   string
+		kept bool
+	}{
+		{"missing", "", false},
+		{"valid", "4bf92f3577b34da6", true},
+		{"forged", "abc\nlevel=error", false},
+		{"tooLong", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, c := range cases {
+		logger, hook := test.NewNullLogger()
+		h := WithRequestID(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctxlog.Entry(r.Context(), logrus.New()).Info("handled")
+		}))
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/coupons", http.NoBody)
+		if c.id != "" {
+			r.Header.Set(RequestIDHeader, c.id)
+		}
+		h.ServeHTTP(w, r)
+
+		id := w.Header().Get(RequestIDHeader)
+		if c.kept {
+			assert.Equal(t, c.id, id, c.name)
+		} else {
+			assert.Len(t, id, 32, c.name)
+		}
+		// the handler logs with the entry of the request, whatever its own logger
+		if assert.Len(t, hook.Entries, 1, c.name) {
+			assert.Equal(t, id, hook.LastEntry().Data["request_id"], c.name)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	r := mux.NewRouter()
+	r.NotFoundHandler = WithRequestID(logrus.New())(AccessLog(logger)(http.NotFoundHandler()))
+	r.Use(WithRequestID(logrus.New()), AccessLog(logger))
+	r.HandleFunc(getCouponPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	}).Methods("GET")
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/coupons/1", http.NoBody)
+	req.Header.Set(RequestIDHeader, "abc")
+	r.ServeHTTP(w, req)
+
+	assert.Len(t, hook.Entries, 1)
+	entry := hook.LastEntry()
+	assert.Equal(t, logrus.InfoLevel, entry.Level)
+	assert.Equal(t, "GET", entry.Data["method"])
+	assert.Equal(t, getCouponPath, entry.Data["route"])
+	assert.Equal(t, http.StatusCreated, entry.Data["status"])
+	assert.Equal(t, int64(8), entry.Data["bytes"])
+	assert.Equal(t, "192.0.2.1", entry.Data["client_ip"])
+	assert.Equal(t, "abc", entry.Data["request_id"])
+	assert.Contains(t, entry.Data, "duration_ms")
+
+	// unmatched requests are logged by the wrapped NotFoundHandler
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", http.NoBody))
+	assert.Len(t, hook.Entries, 2)
+	assert.Equal(t, UnmatchedRoute, hook.LastEntry().Data["route"])
+	assert.Equal(t, http.StatusNotFound, hook.LastEntry().Data["status"])
+	assert.NotEmpty(t, hook.LastEntry().Data["request_id"])
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+const (
+	couponHistoryPath = "/coupons/{id:[0-9]+}/history"
+	auditPath         = "/audit"
+)
+
+// CouponHistoryHandler responds with the audit entries of the coupon associated with an id, oldest first
+// The history of a deleted coupon is kept
+func (h *Handlers) CouponHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := h.getID(w, r)
+	if err != nil {
+		return
+	}
+
+	entries := []domain.AuditEntry{}
+	if err := h.service.CouponHistory(r.Context(), id, &entries); err != nil {
+		if _, ok := err.(domain.CouponNotFoundError); ok {
+			h.log(r).WithError(err).WithField("id", id).Debug("coupon history not found")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.serverError(w, h.log(r).WithField("id", id), err, "failed to get coupon history")
+		return
+	}
+	writeJSON(w, h.log(r), http.StatusOK, entries)
+}
+
+// CouponHistoryPath returns the url path associated with the CouponHistoryHandler
+func (h *Handlers) CouponHistoryPath() string {
+	return couponHistoryPath
+}
+
+// AuditHandler responds with the audit entries matching the query filters, oldest first
+func (h *Handlers) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	entries := []domain.AuditEntry{}
+	if err := h.service.GetAudit(r.Context(), &entries, r.URL.Query()); err != nil {
+		if _, ok := err.(domain.InvalidArgsError); ok {
+			h.log(r).WithError(err).Debug("invalid audit query")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		h.serverError(w, h.log(r).WithField("query", r.URL.Query()), err, "failed to get audit entries")
+		return
+	}
+	writeJSON(w, h.log(r), http.StatusOK, entries)
+}
+
+// AuditPath returns the url path associated with the AuditHandler
+func (h *Handlers) AuditPath() string {
+	return auditPath
+}
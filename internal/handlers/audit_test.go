@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// auditRouter returns a router serving the audit routes of h
+func auditRouter(h *TestHandlers) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc(h.CouponHistoryPath(), h.CouponHistoryHandler).Methods("GET")
+	router.HandleFunc(h.AuditPath(), h.AuditHandler).Methods("GET")
+	return router
+}
+
+func TestCouponHistoryHandler(t *testing.T) {
+	t.Run("success", testCouponHistorySuccess)
+	t.Run("notFound", testCouponHistoryNotFound)
+	t.Run("serviceError", testCouponHistoryServiceError)
+}
+
+func testCouponHistorySuccess(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/1/history", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().CouponHistory(gomock.Any(), uint(1), gomock.Any()).
+		Do(func(ctx context.Context, id uint, entries *[]domain.AuditEntry) {
+			*entries = []domain.AuditEntry{{
+				ID:        4,
+				CreatedAt: time.Unix(0, 0).UTC(),
+				Tenanted:  domain.Tenanted{TenantID: "acme"},
+				Actor:     "apikey:1",
+				Action:    domain.AuditUpdate,
+				CouponID:  1,
+				Changes:   map[string]domain.AuditChange{"value": {Before: []byte("10"), After: []byte("20")}},
+				RequestID: "req-1",
+			}}
+		}).
+		Return(nil)
+
+	auditRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, http.StatusOK, h.w.Code)
+	assert.JSONEq(t, `[{"id":4,"timestamp":"1970-01-01T00:00:00Z","actor":"apikey:1","action":"update","coupon_id":1,
+		"changes":{"value":{"before":10,"after":20}},"request_id":"req-1"}]`, h.w.Body.String())
+}
+
+func testCouponHistoryNotFound(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/1/history", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().CouponHistory(gomock.Any(), uint(1), gomock.Any()).Return(domain.NewCouponNotFoundError())
+
+	auditRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, http.StatusNotFound, h.w.Code)
+}
+
+func testCouponHistoryServiceError(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/1/history", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().CouponHistory(gomock.Any(), uint(1), gomock.Any()).Return(errors.New(""))
+
+	auditRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, http.StatusInternalServerError, h.w.Code)
+}
+
+func TestAuditHandler(t *testing.T) {
+	t.Run("success", testAuditSuccess)
+	t.Run("invalidArgs", testAuditInvalidArgs)
+	t.Run("serviceError", testAuditServiceError)
+}
+
+func testAuditSuccess(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/audit?actor=apikey:1", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().GetAudit(gomock.Any(), gomock.Any(), map[string][]string{"actor": {"apikey:1"}}).Return(nil)
+
+	auditRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, http.StatusOK, h.w.Code)
+	assert.JSONEq(t, `[]`, h.w.Body.String())
+}
+
+func testAuditInvalidArgs(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/audit?action=redeem", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().GetAudit(gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.NewInvalidArgsError(""))
+
+	auditRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, http.StatusBadRequest, h.w.Code)
+}
+
+func testAuditServiceError(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/audit", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().GetAudit(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New(""))
+
+	auditRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, http.StatusInternalServerError, h.w.Code)
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+const (
+	exportCouponsPath = "/coupons/export"
+	formatQuery       = "format"
+	formatCSV         = "csv"
+	formatNDJSON      = "ndjson"
+	csvContentType    = "text/csv"
+	ndjsonContentType = "application/x-ndjson"
+	// exportChunkSize is the number of coupons written between each flush
+	exportChunkSize = 100
+)
+
+// couponEncoder writes coupons in an export format
+type couponEncoder interface {
+	Encode(c domain.Coupon) error
+	Flush() error
+}
+
+// ExportCouponsHandler streams every coupon matching the query filters as CSV or NDJSON
+// The format is chosen with the format query parameter or, if it is missing, with the Accept header
+func (h *Handlers) ExportCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	args := r.URL.Query()
+	format, err := couponsFormat(args.Get(formatQuery), r.Header.Get("Accept"))
+	if err != nil {
+		h.log(r).WithError(err).Debug("invalid export format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	args.Del(formatQuery)
+
+	fields, err := h.service.ParseFields(args)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(fields) == 0 {
+		fields = domain.CouponFieldNames
+	}
+
+	// exports outlive the server write timeout, the deadline is lifted when the writer supports it
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	var enc couponEncoder
+	var started bool
+	count := 0
+	err = h.service.ExportCoupons(r.Context(), args, func(c domain.Coupon) error {
+		if !started {
+			started = true
+			enc = startExport(w, format, fields)
+		}
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+		if count++; count%exportChunkSize == 0 {
+			return enc.Flush()
+		}
+		return nil
+	})
+
+	if err != nil {
+		if started {
+			// the status was already sent, the truncated body is all that can be done
+			log := h.log(r).WithError(err).WithField("query", r.URL.Query())
+			if errors.Is(err, context.Canceled) {
+				log.Debug("coupons export canceled")
+				return
+			}
+			log.Error("failed to export coupons")
+			return
+		}
+		if _, ok := err.(domain.InvalidArgsError); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		h.serverError(w, h.log(r).WithField("query", r.URL.Query()), err, "failed to export coupons")
+		return
+	}
+
+	if !started {
+		enc = startExport(w, format, fields)
+	}
+	if err := enc.Flush(); err != nil {
+		h.log(r).WithError(err).Error("failed to flush coupons export")
+	}
+}
+
+// ExportCouponsPath returns the url path associated with the ExportCouponsHandler
+func (h *Handlers) ExportCouponsPath() string {
+	return exportCouponsPath
+}
+
+// couponsFormat picks the format of a coupons stream from the format query parameter or,
+// if it is empty, from the media types of the Accept or Content-Type header
+// NDJSON is used when neither of them asks for a format
+func couponsFormat(format, mediaTypes string) (string, error) {
+	switch format {
+	case formatCSV, formatNDJSON:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unknown format: %s", format)
+	}
+
+	for _, a := range strings.Split(mediaTypes, ",") {
+		mediaType := strings.TrimSpace(strings.Split(a, ";")[0])
+		switch mediaType {
+		case csvContentType:
+			return formatCSV, nil
+		case ndjsonContentType, "application/ndjson":
+			return formatNDJSON, nil
+		}
+	}
+	return formatNDJSON, nil
+}
+
+// startExport sends the response headers and returns the encoder of the chosen format
+func startExport(w http.ResponseWriter, format string, fields []string) couponEncoder {
+	var enc couponEncoder
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", csvContentType)
+		enc = newCSVEncoder(w, fields)
+	default:
+		w.Header().Set("Content-Type", ndjsonContentType)
+		enc = &ndjsonEncoder{w: w, enc: json.NewEncoder(w), fields: fields}
+	}
+	w.WriteHeader(http.StatusOK)
+	return enc
+}
+
+// csvEncoder writes coupons as CSV records, preceded by a header record with the field names
+type csvEncoder struct {
+	w      io.Writer
+	csv    *csv.Writer
+	fields []string
+	header bool
+}
+
+func newCSVEncoder(w io.Writer, fields []string) *csvEncoder {
+	return &csvEncoder{w: w, csv: csv.NewWriter(w), fields: fields}
+}
+
+// Encode writes a coupon CSV record
+func (e *csvEncoder) Encode(c domain.Coupon) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	record := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		switch v := domain.CouponFieldValue(c, f).(type) {
+		case time.Time:
+			record[i] = v.Format(time.RFC3339)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return e.csv.Write(record)
+}
+
+// Flush sends the buffered records to the client
+// The header record is written even if no coupon was encoded
+func (e *csvEncoder) Flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.csv.Flush()
+	if err := e.csv.Error(); err != nil {
+		return err
+	}
+	flush(e.w)
+	return nil
+}
+
+// writeHeader writes the field names record once
+func (e *csvEncoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.csv.Write(e.fields)
+}
+
+// ndjsonEncoder writes coupons as one JSON object per line
+type ndjsonEncoder struct {
+	w      io.Writer
+	enc    *json.Encoder
+	fields []string
+}
+
+// Encode writes a coupon JSON line
+func (e *ndjsonEncoder) Encode(c domain.Coupon) error {
+	return e.enc.Encode(domain.SelectCouponFields(c, e.fields))
+}
+
+// Flush sends the written lines to the client
+func (e *ndjsonEncoder) Flush() error {
+	flush(e.w)
+	return nil
+}
+
+// flush sends the buffered response to the client if the writer supports it
+func flush(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportCouponsHandler(t *testing.T) {
+	t.Run("ndjson", testExportNDJSON)
+	t.Run("csvAccept", testExportCSVAccept)
+	t.Run("csvEmpty", testExportCSVEmpty)
+	t.Run("invalidFormat", testExportInvalidFormat)
+	t.Run("invalidArgs", testExportInvalidArgs)
+	t.Run("serviceError", testExportServiceError)
+}
+
+func exportRouter(h *TestHandlers) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc(h.ExportCouponsPath(), h.ExportCouponsHandler).Methods("GET")
+	return router
+}
+
+func exportCoupons(ctx context.Context, args map[string][]string, fn func(c domain.Coupon) error) error {
+	expiry := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := uint(1); i <= 2; i++ {
+		if err := fn(domain.Coupon{Name: name, Brand: brand, Value: value * i, Expiry: expiry}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testExportNDJSON(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/export?format=ndjson&fields=name,value&brand=brand", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return([]string{"name", "value"}, nil)
+	h.mock.EXPECT().ExportCoupons(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(ctx context.Context, args map[string][]string, fn func(c domain.Coupon) error) {
+			assert.NotContains(t, args, formatQuery)
+			assert.Equal(t, []string{brand}, args["brand"])
+		}).
+		DoAndReturn(exportCoupons)
+
+	exportRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+	assert.Equal(t, ndjsonContentType, h.w.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"name\":\"name\",\"value\":10}\n{\"name\":\"name\",\"value\":20}\n", h.w.Body.String())
+}
+
+func testExportCSVAccept(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/export?fields=brand,expiry", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+	r.Header.Set("Accept", "text/csv;q=0.9, */*")
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return([]string{"brand", "expiry"}, nil)
+	h.mock.EXPECT().ExportCoupons(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(exportCoupons)
+
+	exportRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+	assert.Equal(t, csvContentType, h.w.Header().Get("Content-Type"))
+	assert.Equal(t, "brand,expiry\nbrand,2030-01-01T00:00:00Z\nbrand,2030-01-01T00:00:00Z\n", h.w.Body.String())
+}
+
+func testExportCSVEmpty(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/export?format=csv", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().ExportCoupons(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+	exportRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+	assert.Equal(t, "id,created_at,updated_at,name,brand,value,expiry\n", h.w.Body.String())
+}
+
+func testExportInvalidFormat(t *testing.T) {
+	h := startHandlers(t)
+
+	r, err := http.NewRequest("GET", "/coupons/export?format=xml", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	exportRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
+
+func testExportInvalidArgs(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/export?lv=a", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().ExportCoupons(gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.NewInvalidArgsError(""))
+
+	exportRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
+
+func testExportServiceError(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/export", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().ExportCoupons(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New(""))
+
+	exportRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusInternalServerError)
+}
@@ -3,12 +3,16 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,15 +22,32 @@ const (
 	getCouponPath    = "/coupons/{id:[0-9]+}"
 	deleteCouponPath = "/coupons/{id:[0-9]+}"
 	updateCouponPath = "/coupons/{id:[0-9]+}"
+	batchCouponsPath = "/coupons/batch"
+	couponsStatsPath = "/coupons/stats"
+	// statusClientClosedRequest is the non standard status of a request whose client went away
+	statusClientClosedRequest = 499
+	// consistencyHeader set to consistencyStrong makes the reads of a request see every previous write
+	consistencyHeader = "X-Consistency"
+	consistencyStrong = "strong"
+	// UnmatchedRoute is the Route of the requests matching no route
+	UnmatchedRoute = "unmatched"
 )
 
 // Service is the interface used for the API service layer
 type Service interface {
-	CreateCoupon(APIc domain.APICoupon) error
-	GetCoupon(id uint, c *domain.Coupon) error
-	DeleteCoupon(id uint) error
-	UpdateCoupon(id uint, APIc domain.APICoupon) error
-	GetCoupons(coupons *[]domain.Coupon, args map[string][]string) error
+	CreateCoupon(ctx context.Context, APIc domain.APICoupon) error
+	GetCoupon(ctx context.Context, id uint, fields []string, c *domain.Coupon) error
+	DeleteCoupon(ctx context.Context, id uint) error
+	UpdateCoupon(ctx context.Context, id uint, APIc domain.APICoupon) error
+	GetCoupons(ctx context.Context, coupons *[]domain.Coupon, args map[string][]string) error
+	ParseFields(args map[string][]string) ([]string, error)
+	GetCouponsStats(ctx context.Context, stats *[]domain.CouponStats, args map[string][]string) error
+	ExportCoupons(ctx context.Context, args map[string][]string, fn func(c domain.Coupon) error) error
+	ImportCoupons(ctx context.Context, rows []domain.ImportRow, dryRun bool) (domain.ImportReport, error)
+	BatchCoupons(ctx context.Context, batch domain.BatchRequest) ([]domain.BatchResult, error)
+	CouponHistory(ctx context.Context, id uint, entries *[]domain.AuditEntry) error
+	GetAudit(ctx context.Context, entries *[]domain.AuditEntry, args map[string][]string) error
+	Ping(ctx context.Context) error
 }
 
 // Handlers is the structure that holds the API handler functions
@@ -45,18 +66,20 @@ func NewHandlers(service Service, logger *logrus.Logger) *Handlers {
 func (h *Handlers) CreateCouponHandler(w http.ResponseWriter, r *http.Request) {
 	var APIc domain.APICoupon
 	if err := json.NewDecoder(r.Body).Decode(&APIc); err != nil {
-		h.logger.WithError(err).Debug("failed to decode jason")
+		h.log(r).WithError(err).Debug("failed to decode jason")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if err := h.service.CreateCoupon(APIc); err != nil {
-		if _, ok := err.(domain.InvalidArgsError); ok {
+	if err := h.service.CreateCoupon(r.Context(), APIc); err != nil {
+		switch err.(type) {
+		case domain.InvalidArgsError:
 			w.WriteHeader(http.StatusBadRequest)
-			return
+		case domain.ForbiddenError:
+			h.forbidden(w, h.log(r), err)
+		default:
+			h.serverError(w, h.log(r), err, "failed to create coupon")
 		}
-		h.logger.WithError(err).Error("failed to create coupon")
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -69,27 +92,33 @@ func (h *Handlers) CreateCouponPath() string {
 }
 
 // GetCouponHandler returns the coupon associated with an id
+// The fields query parameter restricts the returned coupon fields
 func (h *Handlers) GetCouponHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := h.getID(w, r)
 	if err != nil {
 		return
 	}
 
+	fields, err := h.service.ParseFields(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	var c domain.Coupon
-	if err = h.service.GetCoupon(id, &c); err != nil {
+	if err = h.service.GetCoupon(r.Context(), id, fields, &c); err != nil {
 		if _, ok := err.(domain.CouponNotFoundError); ok {
-			h.logger.WithError(err).WithField("id", id).Debug("coupon not found")
+			h.log(r).WithError(err).WithField("id", id).Debug("coupon not found")
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		h.logger.WithError(err).WithField("id", id).Error("failed to get coupon")
-		w.WriteHeader(http.StatusInternalServerError)
+		h.serverError(w, h.log(r).WithField("id", id), err, "failed to get coupon")
 		return
 	}
 
-	data, err := json.Marshal(c)
+	data, err := json.Marshal(domain.SelectCouponFields(c, fields))
 	if err != nil {
-		h.logger.WithError(err).WithField("id", id).Error("failed to Marshal coupon")
+		h.log(r).WithError(err).WithField("id", id).Error("failed to Marshal coupon")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -110,14 +139,16 @@ func (h *Handlers) DeleteCouponHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = h.service.DeleteCoupon(id); err != nil {
-		if _, ok := err.(domain.CouponNotFoundError); ok {
-			h.logger.WithError(err).WithField("id", id).Debug("coupon not found")
+	if err = h.service.DeleteCoupon(r.Context(), id); err != nil {
+		switch err.(type) {
+		case domain.CouponNotFoundError:
+			h.log(r).WithError(err).WithField("id", id).Debug("coupon not found")
 			w.WriteHeader(http.StatusNotFound)
-			return
+		case domain.ForbiddenError:
+			h.forbidden(w, h.log(r).WithField("id", id), err)
+		default:
+			h.serverError(w, h.log(r).WithField("id", id), err, "failed to delete coupon")
 		}
-		h.logger.WithError(err).WithField("id", id).Error("failed to delete coupon")
-		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -138,23 +169,25 @@ func (h *Handlers) UpdateCouponHandler(w http.ResponseWriter, r *http.Request) {
 
 	var APIc domain.APICoupon
 	if err := json.NewDecoder(r.Body).Decode(&APIc); err != nil {
-		h.logger.WithError(err).Debug("failed to decode jason")
+		h.log(r).WithError(err).Debug("failed to decode jason")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if err = h.service.UpdateCoupon(id, APIc); err != nil {
+	if err = h.service.UpdateCoupon(r.Context(), id, APIc); err != nil {
 		switch err.(type) {
 		case domain.CouponNotFoundError:
-			h.logger.WithError(err).WithField("id", id).Debug("coupon not found")
+			h.log(r).WithError(err).WithField("id", id).Debug("coupon not found")
 			w.WriteHeader(http.StatusNotFound)
 			return
 		case domain.InvalidArgsError:
 			w.WriteHeader(http.StatusBadRequest)
 			return
+		case domain.ForbiddenError:
+			h.forbidden(w, h.log(r).WithField("id", id), err)
+			return
 		default:
-			h.logger.WithError(err).WithField("id", id).Error("failed to update coupon")
-			w.WriteHeader(http.StatusInternalServerError)
+			h.serverError(w, h.log(r).WithField("id", id), err, "failed to update coupon")
 			return
 		}
 	}
@@ -168,21 +201,32 @@ func (h *Handlers) UpdateCouponPath() string {
 }
 
 // GetCouponsHandler queries all coupons and filter them accordingly
+// The fields query parameter restricts the returned coupon fields
 func (h *Handlers) GetCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	fields, err := h.service.ParseFields(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	var coupons []domain.Coupon
-	if err := h.service.GetCoupons(&coupons, r.URL.Query()); err != nil {
+	if err := h.service.GetCoupons(r.Context(), &coupons, r.URL.Query()); err != nil {
 		if _, ok := err.(domain.InvalidArgsError); ok {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		h.logger.WithError(err).WithField("query", r.URL.Query()).Error("failed to get coupons")
-		w.WriteHeader(http.StatusInternalServerError)
+		h.serverError(w, h.log(r).WithField("query", r.URL.Query()), err, "failed to get coupons")
 		return
 	}
 
-	data, err := json.Marshal(coupons)
+	selected := make([]interface{}, len(coupons))
+	for i, c := range coupons {
+		selected[i] = domain.SelectCouponFields(c, fields)
+	}
+
+	data, err := json.Marshal(selected)
 	if err != nil {
-		h.logger.WithError(err).WithField("query", r.URL.Query()).Error("failed to Marshal coupons")
+		h.log(r).WithError(err).WithField("query", r.URL.Query()).Error("failed to Marshal coupons")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -195,13 +239,148 @@ func (h *Handlers) GetCouponsPath() string {
 	return getCouponsPath
 }
 
+// BatchCouponsHandler executes a batch of create, update and delete operations
+// It responds with the result of every operation
+func (h *Handlers) BatchCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	var batch domain.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		h.log(r).WithError(err).Debug("failed to decode jason")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results, err := h.service.BatchCoupons(r.Context(), batch)
+	if err != nil {
+		switch err.(type) {
+		case domain.InvalidArgsError:
+			w.WriteHeader(http.StatusBadRequest)
+		case domain.ForbiddenError:
+			h.forbidden(w, h.log(r), err)
+		default:
+			h.serverError(w, h.log(r), err, "failed to execute coupons batch")
+		}
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		Results []domain.BatchResult `json:"results"`
+	}{results})
+	if err != nil {
+		h.log(r).WithError(err).Error("failed to Marshal batch results")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// BatchCouponsPath returns the url path associated with the BatchCouponsHandler
+func (h *Handlers) BatchCouponsPath() string {
+	return batchCouponsPath
+}
+
+// CouponsStatsHandler aggregates the coupons matching the query filters
+func (h *Handlers) CouponsStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := []domain.CouponStats{}
+	if err := h.service.GetCouponsStats(r.Context(), &stats, r.URL.Query()); err != nil {
+		if _, ok := err.(domain.InvalidArgsError); ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		h.serverError(w, h.log(r).WithField("query", r.URL.Query()), err, "failed to get coupons stats")
+		return
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		h.log(r).WithError(err).WithField("query", r.URL.Query()).Error("failed to Marshal coupons stats")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// CouponsStatsPath returns the url path associated with the CouponsStatsHandler
+func (h *Handlers) CouponsStatsPath() string {
+	return couponsStatsPath
+}
+
+// WithTimeout bounds the context of the requests handled by next to d, a zero d leaves it unbounded
+// The server write timeout only cuts the response, this cancels the work of the request as well
+func WithTimeout(d time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	if d <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// Consistency serves the reads of the requests with a "X-Consistency: strong" header from the primary db
+// The read replicas may lag behind, this lets a client read back the coupons it just wrote
+func Consistency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(consistencyHeader) == consistencyStrong {
+			r = r.WithContext(domain.WithPrimaryReads(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Route returns the path template of the mux route matched by r, or UnmatchedRoute
+// Unlike the path, the template identifies the route without the ids of the requested coupons
+func Route(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return UnmatchedRoute
+}
+
+// serverError responds to an unexpected service error
+// Errors caused by the request context are not failures of the API: a timeout responds with a 504
+// and a client going away with a 499, the nginx status for a request closed by the client
+func (h *Handlers) serverError(w http.ResponseWriter, log *logrus.Entry, err error, msg string) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.WithError(err).Warn(msg)
+		w.WriteHeader(http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		log.WithError(err).Debug(msg)
+		w.WriteHeader(statusClientClosedRequest)
+	default:
+		log.WithError(err).Error(msg)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// forbidden responds to a caller lacking the role of the operation
+func (h *Handlers) forbidden(w http.ResponseWriter, log *logrus.Entry, err error) {
+	log.WithError(err).Debug("forbidden operation")
+	w.WriteHeader(http.StatusForbidden)
+}
+
 func (h *Handlers) getID(w http.ResponseWriter, r *http.Request) (uint, error) {
+	return pathID(w, r, h.log(r))
+}
+
+// pathID returns the id of the request path, it responds with a 400 if the id is not an integer
+func pathID(w http.ResponseWriter, r *http.Request, log *logrus.Entry) (uint, error) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseUint(vars["id"], 10, 32)
 	if err != nil {
-		h.logger.WithError(err).WithField("id", vars["id"]).Debug("failed to convert id to integer")
+		log.WithError(err).WithField("id", vars["id"]).Debug("failed to convert id to integer")
 		w.WriteHeader(http.StatusBadRequest)
 		return 0, err
 	}
 	return uint(id), nil
 }
+
+// log returns the logger of the request, it carries the request id when WithRequestID assigned one
+func (h *Handlers) log(r *http.Request) *logrus.Entry {
+	return ctxlog.Entry(r.Context(), h.logger)
+}
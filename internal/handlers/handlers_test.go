@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -54,6 +55,7 @@ func TestCreateCouponHandler(t *testing.T) {
 	t.Run("success", testCreateCouponSuccess)
 	t.Run("failedDecoding", testCreateCouponFailedDecoding)
 	t.Run("invalidArgs", testCreateCouponInvalidArgs)
+	t.Run("forbidden", testCreateCouponForbidden)
 	t.Run("error", testCreateCouponError)
 }
 
@@ -67,7 +69,7 @@ func testCreateCouponSuccess(t *testing.T) {
 		t.Fatal("failed to create http request")
 	}
 
-	h.mock.EXPECT().CreateCoupon(gomock.Any()).Return(nil)
+	h.mock.EXPECT().CreateCoupon(gomock.Any(), gomock.Any()).Return(nil)
 
 	h.CreateCouponHandler(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusCreated)
@@ -97,12 +99,27 @@ func testCreateCouponInvalidArgs(t *testing.T) {
 		t.Fatal("failed to create http request")
 	}
 
-	h.mock.EXPECT().CreateCoupon(gomock.Any()).Return(domain.NewInvalidArgsError(""))
+	h.mock.EXPECT().CreateCoupon(gomock.Any(), gomock.Any()).Return(domain.NewInvalidArgsError(""))
 
 	h.CreateCouponHandler(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusBadRequest)
 }
 
+func testCreateCouponForbidden(t *testing.T) {
+	h := startHandlers(t)
+
+	body := marshalAPICoupon(t)
+	r, err := http.NewRequest("POST", "/coupons", body)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().CreateCoupon(gomock.Any(), gomock.Any()).Return(domain.NewForbiddenError("create requires the coupon-admin role"))
+
+	h.CreateCouponHandler(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusForbidden)
+}
+
 func testCreateCouponError(t *testing.T) {
 	h := startHandlers(t)
 
@@ -112,7 +129,7 @@ func testCreateCouponError(t *testing.T) {
 		t.Fatal("failed to create http request")
 	}
 
-	h.mock.EXPECT().CreateCoupon(gomock.Any()).Return(errors.New(""))
+	h.mock.EXPECT().CreateCoupon(gomock.Any(), gomock.Any()).Return(errors.New(""))
 
 	h.CreateCouponHandler(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusInternalServerError)
@@ -123,6 +140,11 @@ func TestGetCouponHandler(t *testing.T) {
 	t.Run("success", testGetCouponSuccess)
 	t.Run("notFound", testGetCouponNotFound)
 	t.Run("serviceError", testGetCouponServiceError)
+	t.Run("timeout", testGetCouponTimeout)
+	t.Run("canceled", testGetCouponCanceled)
+	t.Run("consistency", testGetCouponConsistency)
+	t.Run("fields", testGetCouponFields)
+	t.Run("invalidFields", testGetCouponInvalidFields)
 }
 
 func testGetCouponSuccess(t *testing.T) {
@@ -137,10 +159,17 @@ func testGetCouponSuccess(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(getCouponPath, h.GetCouponHandler).Methods("POST")
 
-	h.mock.EXPECT().GetCoupon(uint(4), gomock.Any()).Return(nil)
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().GetCoupon(gomock.Any(), uint(4), gomock.Any(), gomock.Any()).Return(nil)
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusOK)
+	// the deletion time and tenant are never marshalled
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(h.w.Body.Bytes(), &body))
+	assert.Contains(t, body, "ID")
+	assert.NotContains(t, body, "DeletedAt")
+	assert.NotContains(t, body, "TenantID")
 }
 
 func testGetCouponBadID(t *testing.T) {
@@ -167,7 +196,8 @@ func testGetCouponNotFound(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(getCouponPath, h.GetCouponHandler).Methods("POST")
 
-	h.mock.EXPECT().GetCoupon(uint(4), gomock.Any()).Return(domain.NewCouponNotFoundError())
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().GetCoupon(gomock.Any(), uint(4), gomock.Any(), gomock.Any()).Return(domain.NewCouponNotFoundError())
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusNotFound)
@@ -185,16 +215,134 @@ func testGetCouponServiceError(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(getCouponPath, h.GetCouponHandler).Methods("POST")
 
-	h.mock.EXPECT().GetCoupon(uint(4), gomock.Any()).Return(domain.NewInvalidArgsError(""))
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().GetCoupon(gomock.Any(), uint(4), gomock.Any(), gomock.Any()).Return(domain.NewInvalidArgsError(""))
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusInternalServerError)
 }
 
+func testGetCouponTimeout(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/4", nil)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(getCouponPath, WithTimeout(time.Millisecond, h.GetCouponHandler)).Methods("GET")
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().GetCoupon(gomock.Any(), uint(4), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, id uint, fields []string, c *domain.Coupon) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+	router.ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusGatewayTimeout)
+}
+
+func testGetCouponCanceled(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, err := http.NewRequest("GET", "/coupons/4", nil)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(getCouponPath, h.GetCouponHandler).Methods("GET")
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().GetCoupon(gomock.Any(), uint(4), gomock.Any(), gomock.Any()).Return(context.Canceled)
+
+	router.ServeHTTP(h.w, r.WithContext(ctx))
+	assert.Equal(t, h.w.Code, statusClientClosedRequest)
+}
+
+func testGetCouponConsistency(t *testing.T) {
+	for header, primary := range map[string]bool{"": false, "eventual": false, consistencyStrong: true} {
+		h := startHandlers(t)
+
+		r, err := http.NewRequest("GET", "/coupons/4", nil)
+		if err != nil {
+			t.Fatal("failed to create http request")
+		}
+		r.Header.Set(consistencyHeader, header)
+
+		router := mux.NewRouter()
+		router.Use(Consistency)
+		router.HandleFunc(getCouponPath, h.GetCouponHandler).Methods("GET")
+
+		h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+		h.mock.EXPECT().GetCoupon(gomock.Any(), uint(4), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, id uint, fields []string, c *domain.Coupon) error {
+				assert.Equal(t, primary, domain.PrimaryReads(ctx), header)
+				return nil
+			})
+
+		router.ServeHTTP(h.w, r)
+		assert.Equal(t, h.w.Code, http.StatusOK)
+		h.ctrl.Finish()
+	}
+}
+
+func testGetCouponFields(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/4?fields=name,value", nil)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(getCouponPath, h.GetCouponHandler).Methods("GET")
+
+	fields := []string{"name", "value"}
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(fields, nil)
+	h.mock.EXPECT().GetCoupon(gomock.Any(), uint(4), fields, gomock.Any()).
+		Do(func(ctx context.Context, id uint, fields []string, c *domain.Coupon) {
+			c.ID = id
+			c.Name = name
+			c.Value = value
+		}).
+		Return(nil)
+
+	router.ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+	assert.JSONEq(t, `{"name":"name","value":10}`, h.w.Body.String())
+}
+
+func testGetCouponInvalidFields(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/4?fields=DeletedAt", nil)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(getCouponPath, h.GetCouponHandler).Methods("GET")
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, domain.NewInvalidArgsError(""))
+
+	router.ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
+
 func TestDeleteCouponHandler(t *testing.T) {
 	t.Run("success", testDeleteCouponSuccess)
 	t.Run("badID", testDeleteCouponBadID)
 	t.Run("notFound", testDeleteCouponNotFound)
+	t.Run("forbidden", testDeleteCouponForbidden)
 	t.Run("serviceError", testDeleteCouponServiceError)
 }
 
@@ -210,12 +358,30 @@ func testDeleteCouponSuccess(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.DeleteCouponPath(), h.DeleteCouponHandler).Methods("DELETE")
 
-	h.mock.EXPECT().DeleteCoupon(uint(4)).Return(nil)
+	h.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(4)).Return(nil)
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusOK)
 }
 
+func testDeleteCouponForbidden(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("DELETE", "/coupons/4", nil)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(h.DeleteCouponPath(), h.DeleteCouponHandler).Methods("DELETE")
+
+	h.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(4)).Return(domain.NewForbiddenError("delete requires the coupon-admin role"))
+
+	router.ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusForbidden)
+}
+
 func testDeleteCouponBadID(t *testing.T) {
 	h := startHandlers(t)
 
@@ -240,7 +406,7 @@ func testDeleteCouponNotFound(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.DeleteCouponPath(), h.DeleteCouponHandler).Methods("DELETE")
 
-	h.mock.EXPECT().DeleteCoupon(uint(4)).Return(domain.NewCouponNotFoundError())
+	h.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(4)).Return(domain.NewCouponNotFoundError())
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusNotFound)
@@ -258,7 +424,7 @@ func testDeleteCouponServiceError(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.DeleteCouponPath(), h.DeleteCouponHandler).Methods("DELETE")
 
-	h.mock.EXPECT().DeleteCoupon(uint(4)).Return(errors.New(""))
+	h.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(4)).Return(errors.New(""))
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusInternalServerError)
@@ -299,7 +465,7 @@ func testUpdateCouponSuccess(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.UpdateCouponPath(), h.UpdateCouponHandler).Methods("PUT")
 
-	h.mock.EXPECT().UpdateCoupon(uint(4), gomock.Any()).Return(nil)
+	h.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(4), gomock.Any()).Return(nil)
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusOK)
@@ -332,7 +498,7 @@ func testUpdateCouponNotFound(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.UpdateCouponPath(), h.UpdateCouponHandler).Methods("PUT")
 
-	h.mock.EXPECT().UpdateCoupon(uint(4), gomock.Any()).Return(domain.NewCouponNotFoundError())
+	h.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(4), gomock.Any()).Return(domain.NewCouponNotFoundError())
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusNotFound)
@@ -350,7 +516,7 @@ func testUpdateCouponBadRequest(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.UpdateCouponPath(), h.UpdateCouponHandler).Methods("PUT")
 
-	h.mock.EXPECT().UpdateCoupon(uint(4), gomock.Any()).Return(domain.NewInvalidArgsError(""))
+	h.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(4), gomock.Any()).Return(domain.NewInvalidArgsError(""))
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusBadRequest)
@@ -368,7 +534,7 @@ func testUpdateCouponServiceError(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.UpdateCouponPath(), h.UpdateCouponHandler).Methods("PUT")
 
-	h.mock.EXPECT().UpdateCoupon(uint(4), gomock.Any()).Return(errors.New(""))
+	h.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(4), gomock.Any()).Return(errors.New(""))
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusInternalServerError)
@@ -378,6 +544,7 @@ func TestGetCouponsHandler(t *testing.T) {
 	t.Run("success", testGetCouponsSuccess)
 	t.Run("invalidArgs", testGetCouponsInvalidArgs)
 	t.Run("serviceError", testGetCouponsServiceError)
+	t.Run("fields", testGetCouponsFields)
 }
 
 func testGetCouponsSuccess(t *testing.T) {
@@ -392,7 +559,8 @@ func testGetCouponsSuccess(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.GetCouponsPath(), h.GetCouponsHandler).Methods("GET")
 
-	h.mock.EXPECT().GetCoupons(gomock.Any(), gomock.Any()).Return(nil)
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().GetCoupons(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusOK)
@@ -410,7 +578,8 @@ func testGetCouponsInvalidArgs(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.GetCouponsPath(), h.GetCouponsHandler).Methods("GET")
 
-	h.mock.EXPECT().GetCoupons(gomock.Any(), gomock.Any()).Return(domain.NewInvalidArgsError(""))
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().GetCoupons(gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.NewInvalidArgsError(""))
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusBadRequest)
@@ -428,8 +597,171 @@ func testGetCouponsServiceError(t *testing.T) {
 	router := mux.NewRouter()
 	router.HandleFunc(h.GetCouponsPath(), h.GetCouponsHandler).Methods("GET")
 
-	h.mock.EXPECT().GetCoupons(gomock.Any(), gomock.Any()).Return(errors.New(""))
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return(nil, nil)
+	h.mock.EXPECT().GetCoupons(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New(""))
 
 	router.ServeHTTP(h.w, r)
 	assert.Equal(t, h.w.Code, http.StatusInternalServerError)
 }
+
+func testGetCouponsFields(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons?fields=brand", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(h.GetCouponsPath(), h.GetCouponsHandler).Methods("GET")
+
+	h.mock.EXPECT().ParseFields(gomock.Any()).Return([]string{"brand"}, nil)
+	h.mock.EXPECT().GetCoupons(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(ctx context.Context, coupons *[]domain.Coupon, args map[string][]string) {
+			*coupons = []domain.Coupon{{Brand: brand}, {Brand: brand}}
+		}).
+		Return(nil)
+
+	router.ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+	assert.JSONEq(t, `[{"brand":"brand"},{"brand":"brand"}]`, h.w.Body.String())
+}
+
+func TestCouponsStatsHandler(t *testing.T) {
+	t.Run("success", testCouponsStatsSuccess)
+	t.Run("invalidArgs", testCouponsStatsInvalidArgs)
+	t.Run("serviceError", testCouponsStatsServiceError)
+}
+
+func testCouponsStatsSuccess(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/stats?group_by=brand", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(h.CouponsStatsPath(), h.CouponsStatsHandler).Methods("GET")
+
+	b := brand
+	h.mock.EXPECT().GetCouponsStats(gomock.Any(), gomock.Any(), gomock.Any()).
+		Do(func(ctx context.Context, stats *[]domain.CouponStats, args map[string][]string) {
+			*stats = []domain.CouponStats{{Brand: &b, Count: 2, Sum: 20, Min: 10, Max: 10}}
+		}).
+		Return(nil)
+
+	router.ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+	assert.JSONEq(t, `[{"brand":"brand","count":2,"sum":20,"min":10,"max":10}]`, h.w.Body.String())
+}
+
+func testCouponsStatsInvalidArgs(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/stats", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(h.CouponsStatsPath(), h.CouponsStatsHandler).Methods("GET")
+
+	h.mock.EXPECT().GetCouponsStats(gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.NewInvalidArgsError(""))
+
+	router.ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
+
+func testCouponsStatsServiceError(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("GET", "/coupons/stats", http.NoBody)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc(h.CouponsStatsPath(), h.CouponsStatsHandler).Methods("GET")
+
+	h.mock.EXPECT().GetCouponsStats(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New(""))
+
+	router.ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusInternalServerError)
+}
+
+func TestBatchCouponsHandler(t *testing.T) {
+	t.Run("success", testBatchCouponsSuccess)
+	t.Run("failedDecoding", testBatchCouponsFailedDecoding)
+	t.Run("invalidArgs", testBatchCouponsInvalidArgs)
+	t.Run("forbidden", testBatchCouponsForbidden)
+}
+
+func testBatchCouponsSuccess(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	body := bytes.NewReader([]byte(`{"mode":"best_effort","operations":[{"op":"delete","id":4}]}`))
+	r, err := http.NewRequest("POST", "/coupons/batch", body)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	batch := domain.BatchRequest{
+		Mode:       domain.BatchModeBestEffort,
+		Operations: []domain.BatchOperation{{Op: domain.BatchDelete, ID: 4}},
+	}
+	h.mock.EXPECT().BatchCoupons(gomock.Any(), batch).
+		Return([]domain.BatchResult{{Index: 0, Op: domain.BatchDelete, ID: 4, Status: domain.BatchStatusOK}}, nil)
+
+	h.BatchCouponsHandler(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+	assert.JSONEq(t, `{"results":[{"index":0,"op":"delete","id":4,"status":"ok"}]}`, h.w.Body.String())
+}
+
+func testBatchCouponsForbidden(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	body := bytes.NewReader([]byte(`{"operations":[{"op":"delete","id":4}]}`))
+	r, err := http.NewRequest("POST", "/coupons/batch", body)
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().BatchCoupons(gomock.Any(), gomock.Any()).Return(nil, domain.NewForbiddenError("batch requires the coupon-admin role"))
+
+	h.BatchCouponsHandler(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusForbidden)
+}
+
+func testBatchCouponsFailedDecoding(t *testing.T) {
+	h := startHandlers(t)
+
+	r, err := http.NewRequest("POST", "/coupons/batch", bytes.NewReader([]byte(`[`)))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.BatchCouponsHandler(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
+
+func testBatchCouponsInvalidArgs(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("POST", "/coupons/batch", bytes.NewReader([]byte(`{}`)))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().BatchCoupons(gomock.Any(), gomock.Any()).Return(nil, domain.NewInvalidArgsError(""))
+
+	h.BatchCouponsHandler(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
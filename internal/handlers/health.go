@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+)
+
+const (
+	livePath   = "/healthz"
+	readyPath  = "/readyz"
+	healthPath = "/health"
+
+	statusUp       = "up"
+	statusDown     = "down"
+	statusDegraded = "degraded"
+)
+
+// errShuttingDown fails the readiness of a server which began shutting down
+var errShuttingDown = errors.New("shutting down")
+
+// HealthCheck checks a dependency of the API
+type HealthCheck struct {
+	Name string
+	// Check returns an error if the dependency is not usable
+	Check func(ctx context.Context) error
+	// Optional checks are reported but never fail the readiness, the API works without the dependency
+	Optional bool
+}
+
+// CheckReport is the result of a HealthCheck
+type CheckReport struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Optional  bool    `json:"optional,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the detailed health of the API
+// Its status is up if every check passed, degraded if only optional checks failed and down otherwise
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []CheckReport `json:"checks"`
+}
+
+// Health handles the liveness, readiness and health report requests of the API
+type Health struct {
+	checks  []HealthCheck
+	timeout time.Duration
+	logger  *logrus.Logger
+	// shuttingDown is 1 once the shutdown began
+	shuttingDown int32
+}
+
+// NewHealth is the Health constructor, every check is given timeout to complete
+func NewHealth(logger *logrus.Logger, timeout time.Duration, checks ...HealthCheck) *Health {
+	return &Health{checks: checks, timeout: timeout, logger: logger}
+}
+
+// Shutdown makes the readiness fail from now on, so no new request is routed to a server shutting down
+func (h *Health) Shutdown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+// LiveHandler handles liveness requests, it responds 200 as long as the process serves requests
+func (h *Health) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, statusUp)
+}
+
+// LivePath returns the url path associated with the LiveHandler
+func (h *Health) LivePath() string {
+	return livePath
+}
+
+// ReadyHandler handles readiness requests
+// It responds 503 while the server is shutting down or a check which is not optional fails
+func (h *Health) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if report := h.Report(r.Context()); report.Status == statusDown {
+		writeStatus(w, http.StatusServiceUnavailable, statusDown)
+		return
+	}
+	writeStatus(w, http.StatusOK, statusUp)
+}
+
+// ReadyPath returns the url path associated with the ReadyHandler
+func (h *Health) ReadyPath() string {
+	return readyPath
+}
+
+// HealthHandler handles detailed health requests, it responds with the HealthReport
+// The status code is 503 if the report is down, like the ReadyHandler
+func (h *Health) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	report := h.Report(r.Context())
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		ctxlog.Entry(r.Context(), h.logger).WithError(err).Error("failed to Marshal health report")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == statusDown {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(data)
+}
+
+// HealthPath returns the url path associated with the HealthHandler
+func (h *Health) HealthPath() string {
+	return healthPath
+}
+
+// Report runs every check concurrently and reports their results in order
+// A server shutting down is reported down without running the checks
+func (h *Health) Report(ctx context.Context) HealthReport {
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		return HealthReport{
+			Status: statusDown,
+			Checks: []CheckReport{{Name: "server", Status: statusDown, Error: errShuttingDown.Error()}},
+		}
+	}
+
+	report := HealthReport{Status: statusUp, Checks: make([]CheckReport, len(h.checks))}
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func(i int, c HealthCheck) {
+			defer wg.Done()
+			report.Checks[i] = h.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, c := range report.Checks {
+		switch {
+		case c.Status == statusUp:
+		case c.Optional:
+			if report.Status == statusUp {
+				report.Status = statusDegraded
+			}
+		default:
+			report.Status = statusDown
+		}
+	}
+	return report
+}
+
+// run runs a check within the timeout and measures its latency
+func (h *Health) run(ctx context.Context, c HealthCheck) CheckReport {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.Check(ctx)
+	report := CheckReport{
+		Name:      c.Name,
+		Status:    statusUp,
+		Optional:  c.Optional,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		ctxlog.Entry(ctx, h.logger).WithError(err).WithField("check", c.Name).Warn("health check failed")
+		report.Status = statusDown
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// writeStatus responds with code and a {"status": status} body
+func writeStatus(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
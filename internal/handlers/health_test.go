@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var errDown = errors.New("connection refused")
+
+func up(ctx context.Context) error   { return nil }
+func down(ctx context.Context) error { return errDown }
+
+// serveHealth serves a request to path with the handlers of health
+func serveHealth(health *Health, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", path, http.NoBody)
+	switch path {
+	case livePath:
+		health.LiveHandler(w, r)
+	case readyPath:
+		health.ReadyHandler(w, r)
+	case healthPath:
+		health.HealthHandler(w, r)
+	}
+	return w
+}
+
+func newHealth(checks ...HealthCheck) *Health {
+	return NewHealth(logrus.New(), time.Second, checks...)
+}
+
+func TestHealthLive(t *testing.T) {
+	health := newHealth(HealthCheck{Name: "db", Check: down})
+
+	w := serveHealth(health, livePath)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"up"}`, w.Body.String())
+}
+
+func TestHealthReady(t *testing.T) {
+	cases := []struct {
+		name   string
+		checks []HealthCheck
+		code   int
+	}{
+		{"up", []HealthCheck{{Name: "db", Check: up}, {Name: "migrations", Check: up}}, http.StatusOK},
+		{"down", []HealthCheck{{Name: "db", Check: up}, {Name: "migrations", Check: down}}, http.StatusServiceUnavailable},
+		{"optionalDown", []HealthCheck{{Name: "db", Check: up}, {Name: "replica", Check: down, Optional: true}}, http.StatusOK},
+	}
+	for _, c := range cases {
+		w := serveHealth(newHealth(c.checks...), readyPath)
+		assert.Equal(t, c.code, w.Code, c.name)
+	}
+}
+
+func TestHealthShutdown(t *testing.T) {
+	health := newHealth(HealthCheck{Name: "db", Check: up})
+	assert.Equal(t, http.StatusOK, serveHealth(health, readyPath).Code)
+
+	health.Shutdown()
+
+	assert.Equal(t, http.StatusServiceUnavailable, serveHealth(health, readyPath).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serveHealth(health, healthPath).Code)
+	// the process is still alive while it drains its requests
+	assert.Equal(t, http.StatusOK, serveHealth(health, livePath).Code)
+}
+
+func TestHealthReport(t *testing.T) {
+	slow := func(ctx context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+	health := newHealth(
+		HealthCheck{Name: "db", Check: slow},
+		HealthCheck{Name: "replica", Check: down, Optional: true},
+	)
+
+	w := serveHealth(health, healthPath)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report HealthReport
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, statusDegraded, report.Status)
+	assert.Equal(t, 2, len(report.Checks))
+	assert.Equal(t, "db", report.Checks[0].Name)
+	assert.Equal(t, statusUp, report.Checks[0].Status)
+	assert.True(t, report.Checks[0].LatencyMS >= 10)
+	assert.Equal(t, CheckReport{Name: "replica", Status: statusDown, Optional: true, LatencyMS: report.Checks[1].LatencyMS, Error: errDown.Error()}, report.Checks[1])
+}
+
+func TestHealthTimeout(t *testing.T) {
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	health := NewHealth(logrus.New(), 10*time.Millisecond, HealthCheck{Name: "db", Check: hang})
+
+	report := health.Report(context.Background())
+
+	assert.Equal(t, statusDown, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[0].Error)
+}
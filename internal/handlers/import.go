@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+const (
+	importCouponsPath = "/coupons/import"
+	importDryRunQuery = "dry_run"
+	// maxImportSize is the maximum size in bytes of an import body
+	maxImportSize = 32 << 20
+	// maxImportLine is the maximum size in bytes of a NDJSON import line
+	maxImportLine = 1 << 20
+)
+
+// ImportCouponsHandler creates the coupons of a CSV or NDJSON body and returns a report with the result of every row
+// The format is chosen with the format query parameter or, if it is missing, with the Content-Type header.
+// With dry_run=true rows are only validated
+func (h *Handlers) ImportCouponsHandler(w http.ResponseWriter, r *http.Request) {
+	args := r.URL.Query()
+
+	format, err := couponsFormat(args.Get(formatQuery), r.Header.Get("Content-Type"))
+	if err != nil {
+		h.log(r).WithError(err).Debug("invalid import format")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var dryRun bool
+	if v := args.Get(importDryRunQuery); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			h.log(r).WithError(err).WithField("value", v).Debug("failed to parse dry_run")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	var rows []domain.ImportRow
+	if format == formatCSV {
+		rows, err = decodeCSVImport(body)
+	} else {
+		rows, err = decodeNDJSONImport(body)
+	}
+	if err != nil {
+		h.log(r).WithError(err).Debug("failed to decode import")
+		if _, ok := err.(*http.MaxBytesError); ok {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.service.ImportCoupons(r.Context(), rows, dryRun)
+	if err != nil {
+		if _, ok := err.(domain.ForbiddenError); ok {
+			h.forbidden(w, h.log(r), err)
+			return
+		}
+		h.serverError(w, h.log(r), err, "failed to import coupons")
+		return
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		h.log(r).WithError(err).Error("failed to Marshal import report")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// ImportCouponsPath returns the url path associated with the ImportCouponsHandler
+func (h *Handlers) ImportCouponsPath() string {
+	return importCouponsPath
+}
+
+// decodeNDJSONImport reads a coupon from every non empty line
+// Lines which are not valid coupons are returned as rows holding the decoding error
+func decodeNDJSONImport(r io.Reader) ([]domain.ImportRow, error) {
+	var rows []domain.ImportRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxImportLine)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		row := domain.ImportRow{Line: line}
+		if err := json.Unmarshal(scanner.Bytes(), &row.Coupon); err != nil {
+			row.Err = domain.NewInvalidArgsError("failed to decode coupon: " + err.Error())
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// decodeCSVImport reads a coupon from every record following the header record
+// The header must only hold coupon fields: name, brand, value and expiry, in any order.
+// Empty cells are left unset. Records whose cells can't be parsed are returned as rows holding the error
+func decodeCSVImport(r io.Reader) ([]domain.ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %v", err)
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		switch header[i] {
+		case "name", "brand", "value", "expiry":
+		default:
+			return nil, fmt.Errorf("unknown csv column: %s", column)
+		}
+	}
+
+	var rows []domain.ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			parseErr, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, err
+			}
+			rows = append(rows, domain.ImportRow{Line: parseErr.StartLine, Err: domain.NewInvalidArgsError(parseErr.Error())})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, csvImportRow(line, header, record))
+	}
+}
+
+// csvImportRow parses a CSV record according to the header columns
+func csvImportRow(line int, header, record []string) domain.ImportRow {
+	row := domain.ImportRow{Line: line}
+	if len(record) != len(header) {
+		row.Err = domain.NewInvalidArgsError(fmt.Sprintf("expected %d fields, got %d", len(header), len(record)))
+		return row
+	}
+
+	for i, cell := range record {
+		if cell == "" {
+			continue
+		}
+		switch header[i] {
+		case "name":
+			name := cell
+			row.Coupon.Name = &name
+		case "brand":
+			brand := cell
+			row.Coupon.Brand = &brand
+		case "value":
+			v64, err := strconv.ParseUint(cell, 10, 32)
+			if err != nil {
+				row.Err = domain.NewInvalidArgsError("failed to parse value:" + cell)
+				return row
+			}
+			value := uint(v64)
+			row.Coupon.Value = &value
+		case "expiry":
+			expiry, err := time.Parse(time.RFC3339, cell)
+			if err != nil {
+				row.Err = domain.NewInvalidArgsError("failed to parse expiry:" + cell)
+				return row
+			}
+			row.Coupon.Expiry = &expiry
+		}
+	}
+	return row
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportCouponsHandler(t *testing.T) {
+	t.Run("ndjson", testImportNDJSON)
+	t.Run("csv", testImportCSV)
+	t.Run("dryRun", testImportDryRun)
+	t.Run("forbidden", testImportForbidden)
+	t.Run("invalidDryRun", testImportInvalidDryRun)
+	t.Run("unknownColumn", testImportUnknownColumn)
+	t.Run("invalidFormat", testImportInvalidFormat)
+}
+
+func importRouter(h *TestHandlers) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc(h.ImportCouponsPath(), h.ImportCouponsHandler).Methods("POST")
+	return router
+}
+
+func testImportNDJSON(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	body := `{"name":"name","brand":"brand","value":10,"expiry":"2030-01-01T00:00:00Z"}
+
+{"name":
+{"name":"name2"}
+`
+	r, err := http.NewRequest("POST", "/coupons/import", strings.NewReader(body))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+	r.Header.Set("Content-Type", ndjsonContentType)
+
+	report := domain.ImportReport{Created: 1, Invalid: 2}
+	h.mock.EXPECT().ImportCoupons(gomock.Any(), gomock.Any(), false).
+		Do(func(ctx context.Context, rows []domain.ImportRow, dryRun bool) {
+			assert.Equal(t, len(rows), 3)
+			assert.Equal(t, rows[0].Line, 1)
+			assert.Nil(t, rows[0].Err)
+			assert.Equal(t, *rows[0].Coupon.Value, value)
+			assert.Equal(t, rows[1].Line, 3)
+			assert.Error(t, rows[1].Err)
+			assert.Equal(t, rows[2].Line, 4)
+			assert.Nil(t, rows[2].Err)
+			assert.Nil(t, rows[2].Coupon.Brand)
+		}).
+		Return(report, nil)
+
+	importRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+
+	var got domain.ImportReport
+	assert.Nil(t, json.Unmarshal(h.w.Body.Bytes(), &got))
+	assert.Equal(t, report.Created, got.Created)
+	assert.Equal(t, report.Invalid, got.Invalid)
+}
+
+func testImportCSV(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	body := "value, Name,brand,expiry\n" +
+		"10,name,brand,2030-01-01T00:00:00Z\n" +
+		"ten,name,brand,2030-01-01T00:00:00Z\n" +
+		"10,name,,\n" +
+		"10,name\n"
+	r, err := http.NewRequest("POST", "/coupons/import?format=csv", strings.NewReader(body))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().ImportCoupons(gomock.Any(), gomock.Any(), false).
+		Do(func(ctx context.Context, rows []domain.ImportRow, dryRun bool) {
+			assert.Equal(t, len(rows), 4)
+			assert.Equal(t, rows[0].Line, 2)
+			assert.Nil(t, rows[0].Err)
+			assert.Equal(t, *rows[0].Coupon.Name, name)
+			assert.Equal(t, *rows[0].Coupon.Value, value)
+			assert.Equal(t, rows[0].Coupon.Expiry.Year(), 2030)
+			assert.Error(t, rows[1].Err)
+			assert.Nil(t, rows[2].Err)
+			assert.Nil(t, rows[2].Coupon.Brand)
+			assert.Nil(t, rows[2].Coupon.Expiry)
+			assert.Equal(t, rows[3].Line, 5)
+			assert.Error(t, rows[3].Err)
+		}).
+		Return(domain.ImportReport{}, nil)
+
+	importRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+}
+
+func testImportDryRun(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("POST", "/coupons/import?dry_run=true", strings.NewReader(`{"name":"name"}`))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().ImportCoupons(gomock.Any(), gomock.Any(), true).Return(domain.ImportReport{DryRun: true}, nil)
+
+	importRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusOK)
+}
+
+func testImportForbidden(t *testing.T) {
+	h := startHandlers(t)
+	defer h.ctrl.Finish()
+
+	r, err := http.NewRequest("POST", "/coupons/import", strings.NewReader(`{"name":"name"}`))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	h.mock.EXPECT().ImportCoupons(gomock.Any(), gomock.Any(), false).Return(domain.ImportReport{}, domain.NewForbiddenError("import requires the coupon-admin role"))
+
+	importRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusForbidden)
+}
+
+func testImportInvalidDryRun(t *testing.T) {
+	h := startHandlers(t)
+
+	r, err := http.NewRequest("POST", "/coupons/import?dry_run=maybe", strings.NewReader(`{"name":"name"}`))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	importRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
+
+func testImportUnknownColumn(t *testing.T) {
+	h := startHandlers(t)
+
+	r, err := http.NewRequest("POST", "/coupons/import", strings.NewReader("name,id\nname,1\n"))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+	r.Header.Set("Content-Type", "text/csv; charset=utf-8")
+
+	importRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
+
+func testImportInvalidFormat(t *testing.T) {
+	h := startHandlers(t)
+
+	r, err := http.NewRequest("POST", "/coupons/import?format=xlsx", strings.NewReader(""))
+	if err != nil {
+		t.Fatal("failed to create http request")
+	}
+
+	importRouter(h).ServeHTTP(h.w, r)
+	assert.Equal(t, h.w.Code, http.StatusBadRequest)
+}
@@ -0,0 +1,174 @@
+//go:generate mockgen -package mocks -destination ../../mocks/key_service.go github.com/jcgfreitas/pb_api/internal/handlers KeyService
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+)
+
+const (
+	keysPath      = "/keys"
+	keyPath       = "/keys/{id:[0-9]+}"
+	rotateKeyPath = "/keys/{id:[0-9]+}/rotate"
+)
+
+// KeyService is the interface of the API keys management
+type KeyService interface {
+	CreateKey(ctx context.Context, name, tenant string, scopes []string) (domain.CreatedAPIKey, error)
+	ListKeys(ctx context.Context) ([]domain.APIKey, error)
+	RotateKey(ctx context.Context, id uint) (domain.CreatedAPIKey, error)
+	RevokeKey(ctx context.Context, id uint) error
+}
+
+// KeyRequest is the body of a key creation request
+type KeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Tenant is the only tenant reached with the key, the key reaches every tenant if it is empty
+	// A caller bound to a tenant only creates keys of its tenant, the default one
+	Tenant string `json:"tenant"`
+}
+
+// Keys handles the API keys management requests
+type Keys struct {
+	service KeyService
+	logger  *logrus.Logger
+}
+
+// NewKeys is the Keys constructor
+func NewKeys(service KeyService, logger *logrus.Logger) *Keys {
+	return &Keys{service: service, logger: logger}
+}
+
+// CreateKeyHandler handles key creation requests, it responds with the key which is never shown again
+// The tenant of a caller bound to one is authoritative, like in WithTenant: a request naming another tenant is
+// answered with a 403
+func (k *Keys) CreateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), k.logger)
+	var req KeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.WithError(err).Debug("failed to decode key request")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if bound := domain.BoundTenant(r.Context()); bound != "" {
+		if req.Tenant != "" && req.Tenant != bound {
+			log.WithField("tenant", req.Tenant).Debug("key of another tenant")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		req.Tenant = bound
+	}
+
+	created, err := k.service.CreateKey(r.Context(), req.Name, req.Tenant, req.Scopes)
+	if err != nil {
+		switch err.(type) {
+		case domain.InvalidArgsError:
+			log.WithError(err).Debug("invalid key request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case domain.ForbiddenError:
+			log.WithError(err).Debug("key of another tenant")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		log.WithError(err).Error("failed to create key")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.WithField("key_id", created.ID).Info("api key created")
+	writeJSON(w, log, http.StatusCreated, created)
+}
+
+// ListKeysHandler handles key listing requests, revoked keys included, only the keys of its tenant for a caller
+// bound to one
+func (k *Keys) ListKeysHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), k.logger)
+	keys, err := k.service.ListKeys(r.Context())
+	if err != nil {
+		log.WithError(err).Error("failed to list keys")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, log, http.StatusOK, keys)
+}
+
+// KeysPath returns the url path associated with the CreateKeyHandler and ListKeysHandler
+func (k *Keys) KeysPath() string {
+	return keysPath
+}
+
+// RotateKeyHandler handles key rotation requests, it responds with the key replacing the revoked one
+func (k *Keys) RotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), k.logger)
+	id, err := pathID(w, r, log)
+	if err != nil {
+		return
+	}
+
+	created, err := k.service.RotateKey(r.Context(), id)
+	if err != nil {
+		if _, ok := err.(domain.APIKeyNotFoundError); ok {
+			log.WithError(err).WithField("key_id", id).Debug("key not found")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.WithError(err).WithField("key_id", id).Error("failed to rotate key")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.WithField("key_id", id).WithField("new_key_id", created.ID).Info("api key rotated")
+	writeJSON(w, log, http.StatusCreated, created)
+}
+
+// RotateKeyPath returns the url path associated with the RotateKeyHandler
+func (k *Keys) RotateKeyPath() string {
+	return rotateKeyPath
+}
+
+// RevokeKeyHandler handles key revocation requests
+func (k *Keys) RevokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), k.logger)
+	id, err := pathID(w, r, log)
+	if err != nil {
+		return
+	}
+
+	if err := k.service.RevokeKey(r.Context(), id); err != nil {
+		if _, ok := err.(domain.APIKeyNotFoundError); ok {
+			log.WithError(err).WithField("key_id", id).Debug("key not found")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		log.WithError(err).WithField("key_id", id).Error("failed to revoke key")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.WithField("key_id", id).Info("api key revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeKeyPath returns the url path associated with the RevokeKeyHandler
+func (k *Keys) RevokeKeyPath() string {
+	return keyPath
+}
+
+// writeJSON responds with code and v marshalled in JSON
+func writeJSON(w http.ResponseWriter, log *logrus.Entry, code int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.WithError(err).Error("failed to Marshal response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestKeys struct {
+	*Keys
+	mock   *mocks.MockKeyService
+	ctrl   *gomock.Controller
+	w      *httptest.ResponseRecorder
+	router *mux.Router
+}
+
+func startKeys(t *testing.T) *TestKeys {
+	ctrl := gomock.NewController(t)
+	mock := mocks.NewMockKeyService(ctrl)
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+	k := &TestKeys{
+		Keys:   NewKeys(mock, logger),
+		mock:   mock,
+		ctrl:   ctrl,
+		w:      httptest.NewRecorder(),
+		router: mux.NewRouter(),
+	}
+	k.router.HandleFunc(k.KeysPath(), k.CreateKeyHandler).Methods("POST")
+	k.router.HandleFunc(k.KeysPath(), k.ListKeysHandler).Methods("GET")
+	k.router.HandleFunc(k.RotateKeyPath(), k.RotateKeyHandler).Methods("POST")
+	k.router.HandleFunc(k.RevokeKeyPath(), k.RevokeKeyHandler).Methods("DELETE")
+	return k
+}
+
+func TestCreateKeyHandler(t *testing.T) {
+	t.Run("success", testCreateKeySuccess)
+	t.Run("failedDecoding", testCreateKeyFailedDecoding)
+	t.Run("invalidArgs", testCreateKeyInvalidArgs)
+	t.Run("error", testCreateKeyError)
+	t.Run("boundTenant", testCreateKeyBoundTenant)
+	t.Run("otherTenant", testCreateKeyOtherTenant)
+	t.Run("forbidden", testCreateKeyForbidden)
+}
+
+func testCreateKeySuccess(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/keys", strings.NewReader(`{"name":"ci","scopes":["coupons:read"],"tenant":"acme"}`))
+	created := domain.CreatedAPIKey{APIKey: domain.APIKey{ID: 1, Name: "ci", Hash: "hash"}, Key: "key"}
+	k.mock.EXPECT().CreateKey(gomock.Any(), "ci", "acme", []string{domain.ScopeCouponsRead}).Return(created, nil)
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusCreated, k.w.Code)
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(k.w.Body.Bytes(), &body))
+	assert.Equal(t, "key", body["key"])
+	assert.NotContains(t, body, "hash")
+}
+
+func testCreateKeyFailedDecoding(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/keys", strings.NewReader(`{`))
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusBadRequest, k.w.Code)
+}
+
+func testCreateKeyInvalidArgs(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/keys", strings.NewReader(`{"name":"ci","scopes":["nope"]}`))
+	k.mock.EXPECT().CreateKey(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.CreatedAPIKey{}, domain.NewInvalidArgsError("unknown scope: nope"))
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusBadRequest, k.w.Code)
+}
+
+func testCreateKeyError(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/keys", strings.NewReader(`{"name":"ci","scopes":["admin"]}`))
+	k.mock.EXPECT().CreateKey(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.CreatedAPIKey{}, errors.New("error"))
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusInternalServerError, k.w.Code)
+}
+
+// boundAdmin returns r sent by an admin bound to the acme tenant
+func boundAdmin(r *http.Request) *http.Request {
+	p := domain.Principal{Subject: "apikey:9", Scopes: []string{domain.ScopeAdmin}, Tenant: "acme"}
+	return r.WithContext(domain.WithPrincipal(r.Context(), p))
+}
+
+func testCreateKeyBoundTenant(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	// the key of a bound admin naming no tenant is a key of its tenant, not of every tenant
+	r := boundAdmin(httptest.NewRequest("POST", "/keys", strings.NewReader(`{"name":"ci","scopes":["admin"]}`)))
+	created := domain.CreatedAPIKey{APIKey: domain.APIKey{ID: 1, Name: "ci", Tenant: "acme"}, Key: "key"}
+	k.mock.EXPECT().CreateKey(gomock.Any(), "ci", "acme", []string{domain.ScopeAdmin}).Return(created, nil)
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusCreated, k.w.Code)
+}
+
+func testCreateKeyOtherTenant(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := boundAdmin(httptest.NewRequest("POST", "/keys", strings.NewReader(`{"name":"ci","scopes":["admin"],"tenant":"globex"}`)))
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusForbidden, k.w.Code)
+}
+
+func testCreateKeyForbidden(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/keys", strings.NewReader(`{"name":"ci","scopes":["admin"],"tenant":"globex"}`))
+	k.mock.EXPECT().CreateKey(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.CreatedAPIKey{}, domain.NewForbiddenError("caller bound to another tenant: globex"))
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusForbidden, k.w.Code)
+}
+
+func TestListKeysHandler(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("GET", "/keys", nil)
+	k.mock.EXPECT().ListKeys(gomock.Any()).Return([]domain.APIKey{{ID: 1}, {ID: 2}}, nil)
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusOK, k.w.Code)
+	var keys []domain.APIKey
+	assert.NoError(t, json.Unmarshal(k.w.Body.Bytes(), &keys))
+	assert.Len(t, keys, 2)
+}
+
+func TestListKeysHandlerBoundTenant(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	// the service only lists the keys of the tenant the caller is bound to
+	r := boundAdmin(httptest.NewRequest("GET", "/keys", nil))
+	k.mock.EXPECT().ListKeys(gomock.Any()).DoAndReturn(func(ctx context.Context) ([]domain.APIKey, error) {
+		assert.Equal(t, "acme", domain.BoundTenant(ctx))
+		return []domain.APIKey{{ID: 1, Tenant: "acme"}}, nil
+	})
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusOK, k.w.Code)
+}
+
+func TestRotateKeyHandler(t *testing.T) {
+	t.Run("success", testRotateKeySuccess)
+	t.Run("notFound", testRotateKeyNotFound)
+	t.Run("otherTenant", testRotateKeyOtherTenant)
+}
+
+func testRotateKeyOtherTenant(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := boundAdmin(httptest.NewRequest("POST", "/keys/3/rotate", nil))
+	k.mock.EXPECT().RotateKey(gomock.Any(), uint(3)).Return(domain.CreatedAPIKey{}, domain.NewAPIKeyNotFoundError())
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusNotFound, k.w.Code)
+}
+
+func testRotateKeySuccess(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/keys/3/rotate", nil)
+	k.mock.EXPECT().RotateKey(gomock.Any(), uint(3)).Return(domain.CreatedAPIKey{APIKey: domain.APIKey{ID: 4}, Key: "key"}, nil)
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusCreated, k.w.Code)
+}
+
+func testRotateKeyNotFound(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/keys/3/rotate", nil)
+	k.mock.EXPECT().RotateKey(gomock.Any(), uint(3)).Return(domain.CreatedAPIKey{}, domain.NewAPIKeyNotFoundError())
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusNotFound, k.w.Code)
+}
+
+func TestRevokeKeyHandler(t *testing.T) {
+	t.Run("success", testRevokeKeySuccess)
+	t.Run("notFound", testRevokeKeyNotFound)
+	t.Run("error", testRevokeKeyError)
+	t.Run("otherTenant", testRevokeKeyOtherTenant)
+}
+
+func testRevokeKeyOtherTenant(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := boundAdmin(httptest.NewRequest("DELETE", "/keys/3", nil))
+	k.mock.EXPECT().RevokeKey(gomock.Any(), uint(3)).Return(domain.NewAPIKeyNotFoundError())
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusNotFound, k.w.Code)
+}
+
+func testRevokeKeySuccess(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("DELETE", "/keys/3", nil)
+	k.mock.EXPECT().RevokeKey(gomock.Any(), uint(3)).Return(nil)
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusNoContent, k.w.Code)
+}
+
+func testRevokeKeyNotFound(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("DELETE", "/keys/3", nil)
+	k.mock.EXPECT().RevokeKey(gomock.Any(), uint(3)).Return(domain.NewAPIKeyNotFoundError())
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusNotFound, k.w.Code)
+}
+
+func testRevokeKeyError(t *testing.T) {
+	k := startKeys(t)
+	defer k.ctrl.Finish()
+
+	r := httptest.NewRequest("DELETE", "/keys/3", nil)
+	k.mock.EXPECT().RevokeKey(gomock.Any(), uint(3)).Return(errors.New("error"))
+
+	k.router.ServeHTTP(k.w, r)
+	assert.Equal(t, http.StatusInternalServerError, k.w.Code)
+}
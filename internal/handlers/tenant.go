@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+)
+
+// TenantHeader names the tenant of a request, for the callers not bound to a tenant
+const TenantHeader = "X-Tenant-ID"
+
+// WithTenant returns a middleware setting the tenant of the requests on their context, see domain.WithTenant,
+// so the repository only reaches the coupons of that tenant
+// The tenant of the authenticated caller is authoritative: a request naming another tenant in its X-Tenant-ID header
+// is answered with a 403. Callers bound to no tenant, or unauthenticated ones while authentication is disabled,
+// pick their tenant with the header, and reach the domain.DefaultTenant without it. An invalid header is answered with a 400
+func WithTenant(logger *logrus.Logger) func(next http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log := ctxlog.Entry(r.Context(), logger)
+			header := r.Header.Get(TenantHeader)
+			if header != "" && !domain.IsTenantID(header) {
+				log.WithField("tenant", header).Debug("invalid tenant")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			tenant := header
+			if p, ok := domain.PrincipalFrom(r.Context()); ok && p.Tenant != "" {
+				if header != "" && header != p.Tenant {
+					log.WithField("tenant", header).Debug("tenant of another caller")
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				tenant = p.Tenant
+			}
+			if tenant == "" {
+				tenant = domain.DefaultTenant
+			}
+
+			log = log.WithField("tenant", tenant)
+			ctx := ctxlog.WithEntry(domain.WithTenant(r.Context(), tenant), log)
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
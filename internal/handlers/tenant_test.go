@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+func TestWithTenant(t *testing.T) {
+	cases := []struct {
+		name      string
+		principal *domain.Principal
+		header    string
+		status    int
+		tenant    string
+	}{
+		{"default", nil, "", http.StatusOK, domain.DefaultTenant},
+		{"header", nil, "acme", http.StatusOK, "acme"},
+		{"invalidHeader", nil, "Acme Inc", http.StatusBadRequest, ""},
+		{"unboundPrincipal", &domain.Principal{Subject: "apikey:1"}, "acme", http.StatusOK, "acme"},
+		{"boundPrincipal", &domain.Principal{Subject: "apikey:1", Tenant: "acme"}, "", http.StatusOK, "acme"},
+		{"sameTenant", &domain.Principal{Subject: "apikey:1", Tenant: "acme"}, "acme", http.StatusOK, "acme"},
+		{"otherTenant", &domain.Principal{Subject: "apikey:1", Tenant: "acme"}, "globex", http.StatusForbidden, ""},
+	}
+	for _, c := range cases {
+		var tenant string
+		h := WithTenant(logrus.New())(func(w http.ResponseWriter, r *http.Request) {
+			tenant = domain.TenantFrom(r.Context())
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/coupons", http.NoBody)
+		if c.principal != nil {
+			r = r.WithContext(domain.WithPrincipal(r.Context(), *c.principal))
+		}
+		if c.header != "" {
+			r.Header.Set(TenantHeader, c.header)
+		}
+		h(w, r)
+
+		assert.Equal(t, c.status, w.Code, c.name)
+		assert.Equal(t, c.tenant, tenant, c.name)
+	}
+}
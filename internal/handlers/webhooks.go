@@ -0,0 +1,195 @@
+//go:generate mockgen -package mocks -destination ../../mocks/webhook_service.go github.com/jcgfreitas/pb_api/internal/handlers WebhookService
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+)
+
+const (
+	webhooksPath   = "/webhooks"
+	webhookPath    = "/webhooks/{id:[0-9]+}"
+	deliveriesPath = "/webhooks/{id:[0-9]+}/deliveries"
+	redeliverPath  = "/webhooks/{id:[0-9]+}/deliveries/{delivery_id:[0-9]+}/redeliver"
+)
+
+// WebhookService is the interface of the webhooks management
+type WebhookService interface {
+	CreateWebhook(ctx context.Context, url string, events []string, secret string) (domain.CreatedWebhook, error)
+	ListWebhooks(ctx context.Context) ([]domain.Webhook, error)
+	GetWebhook(ctx context.Context, id uint) (domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, id uint) error
+	ListDeliveries(ctx context.Context, id uint, args map[string][]string) ([]domain.Delivery, error)
+	Redeliver(ctx context.Context, webhookID, id uint) error
+}
+
+// WebhookRequest is the body of a webhook creation request
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret signs the payloads delivered to the webhook, a random one is generated if it is empty
+	Secret string `json:"secret"`
+}
+
+// Webhooks handles the webhooks management requests
+type Webhooks struct {
+	service WebhookService
+	logger  *logrus.Logger
+}
+
+// NewWebhooks is the Webhooks constructor
+func NewWebhooks(service WebhookService, logger *logrus.Logger) *Webhooks {
+	return &Webhooks{service: service, logger: logger}
+}
+
+// CreateWebhookHandler handles webhook creation requests, it responds with the secret which is never shown again
+func (wh *Webhooks) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), wh.logger)
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.WithError(err).Debug("failed to decode webhook request")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	created, err := wh.service.CreateWebhook(r.Context(), req.URL, req.Events, req.Secret)
+	if err != nil {
+		if _, ok := err.(domain.InvalidArgsError); ok {
+			log.WithError(err).Debug("invalid webhook request")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		log.WithError(err).Error("failed to create webhook")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.WithField("webhook_id", created.ID).Info("webhook created")
+	writeJSON(w, log, http.StatusCreated, created)
+}
+
+// ListWebhooksHandler handles webhook listing requests
+func (wh *Webhooks) ListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), wh.logger)
+	webhooks, err := wh.service.ListWebhooks(r.Context())
+	if err != nil {
+		log.WithError(err).Error("failed to list webhooks")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, log, http.StatusOK, webhooks)
+}
+
+// WebhooksPath returns the url path associated with the CreateWebhookHandler and ListWebhooksHandler
+func (wh *Webhooks) WebhooksPath() string {
+	return webhooksPath
+}
+
+// GetWebhookHandler handles single webhook requests
+func (wh *Webhooks) GetWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), wh.logger)
+	id, err := pathID(w, r, log)
+	if err != nil {
+		return
+	}
+
+	webhook, err := wh.service.GetWebhook(r.Context(), id)
+	if err != nil {
+		wh.writeError(w, log.WithField("webhook_id", id), err, "failed to get webhook")
+		return
+	}
+	writeJSON(w, log, http.StatusOK, webhook)
+}
+
+// DeleteWebhookHandler handles webhook deletion requests
+func (wh *Webhooks) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), wh.logger)
+	id, err := pathID(w, r, log)
+	if err != nil {
+		return
+	}
+
+	if err := wh.service.DeleteWebhook(r.Context(), id); err != nil {
+		wh.writeError(w, log.WithField("webhook_id", id), err, "failed to delete webhook")
+		return
+	}
+	log.WithField("webhook_id", id).Info("webhook deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookPath returns the url path associated with the GetWebhookHandler and DeleteWebhookHandler
+func (wh *Webhooks) WebhookPath() string {
+	return webhookPath
+}
+
+// ListDeliveriesHandler handles the requests of the delivery log of a webhook, filtered by the url query
+func (wh *Webhooks) ListDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), wh.logger)
+	id, err := pathID(w, r, log)
+	if err != nil {
+		return
+	}
+
+	deliveries, err := wh.service.ListDeliveries(r.Context(), id, r.URL.Query())
+	if err != nil {
+		wh.writeError(w, log.WithField("webhook_id", id), err, "failed to list deliveries")
+		return
+	}
+	writeJSON(w, log, http.StatusOK, deliveries)
+}
+
+// DeliveriesPath returns the url path associated with the ListDeliveriesHandler
+func (wh *Webhooks) DeliveriesPath() string {
+	return deliveriesPath
+}
+
+// RedeliverHandler handles the requests to attempt a delivery again, a dead one in particular
+func (wh *Webhooks) RedeliverHandler(w http.ResponseWriter, r *http.Request) {
+	log := ctxlog.Entry(r.Context(), wh.logger)
+	id, err := pathID(w, r, log)
+	if err != nil {
+		return
+	}
+	deliveryID, err := strconv.ParseUint(mux.Vars(r)["delivery_id"], 10, 32)
+	if err != nil {
+		log.WithError(err).WithField("delivery_id", mux.Vars(r)["delivery_id"]).Debug("failed to convert delivery id to integer")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	log = log.WithField("webhook_id", id).WithField("delivery_id", deliveryID)
+	if err := wh.service.Redeliver(r.Context(), id, uint(deliveryID)); err != nil {
+		wh.writeError(w, log, err, "failed to redeliver")
+		return
+	}
+	log.Info("webhook delivery redelivered")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RedeliverPath returns the url path associated with the RedeliverHandler
+func (wh *Webhooks) RedeliverPath() string {
+	return redeliverPath
+}
+
+// writeError responds with the status of err, a 500 logged with msg for the unexpected errors
+func (wh *Webhooks) writeError(w http.ResponseWriter, log *logrus.Entry, err error, msg string) {
+	switch err.(type) {
+	case domain.WebhookNotFoundError, domain.DeliveryNotFoundError:
+		log.WithError(err).Debug("not found")
+		w.WriteHeader(http.StatusNotFound)
+	case domain.InvalidArgsError:
+		log.WithError(err).Debug("invalid request")
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		log.WithError(err).Error(msg)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/mocks"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type TestWebhooks struct {
+	*Webhooks
+	mock   *mocks.MockWebhookService
+	ctrl   *gomock.Controller
+	w      *httptest.ResponseRecorder
+	router *mux.Router
+}
+
+func startWebhooks(t *testing.T) *TestWebhooks {
+	ctrl := gomock.NewController(t)
+	mock := mocks.NewMockWebhookService(ctrl)
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+	wh := &TestWebhooks{
+		Webhooks: NewWebhooks(mock, logger),
+		mock:     mock,
+		ctrl:     ctrl,
+		w:        httptest.NewRecorder(),
+		router:   mux.NewRouter(),
+	}
+	wh.router.HandleFunc(wh.WebhooksPath(), wh.CreateWebhookHandler).Methods("POST")
+	wh.router.HandleFunc(wh.WebhooksPath(), wh.ListWebhooksHandler).Methods("GET")
+	wh.router.HandleFunc(wh.WebhookPath(), wh.GetWebhookHandler).Methods("GET")
+	wh.router.HandleFunc(wh.WebhookPath(), wh.DeleteWebhookHandler).Methods("DELETE")
+	wh.router.HandleFunc(wh.DeliveriesPath(), wh.ListDeliveriesHandler).Methods("GET")
+	wh.router.HandleFunc(wh.RedeliverPath(), wh.RedeliverHandler).Methods("POST")
+	return wh
+}
+
+func TestCreateWebhookHandler(t *testing.T) {
+	t.Run("success", testCreateWebhookSuccess)
+	t.Run("failedDecoding", testCreateWebhookFailedDecoding)
+	t.Run("invalidArgs", testCreateWebhookInvalidArgs)
+	t.Run("error", testCreateWebhookError)
+}
+
+func testCreateWebhookSuccess(t *testing.T) {
+	wh := startWebhooks(t)
+	defer wh.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/webhooks", strings.NewReader(`{"url":"https://example.com/hook","events":["coupon.created"]}`))
+	created := domain.CreatedWebhook{
+		Webhook: domain.Webhook{ID: 1, URL: "https://example.com/hook", Events: []string{domain.EventCouponCreated}, Secret: "s3cret"},
+		Secret:  "s3cret",
+	}
+	wh.mock.EXPECT().CreateWebhook(gomock.Any(), "https://example.com/hook", []string{domain.EventCouponCreated}, "").Return(created, nil)
+
+	wh.router.ServeHTTP(wh.w, r)
+	assert.Equal(t, http.StatusCreated, wh.w.Code)
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(wh.w.Body.Bytes(), &body))
+	assert.Equal(t, "s3cret", body["secret"])
+	assert.Equal(t, "https://example.com/hook", body["url"])
+}
+
+func testCreateWebhookFailedDecoding(t *testing.T) {
+	wh := startWebhooks(t)
+	defer wh.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/webhooks", strings.NewReader(`{`))
+
+	wh.router.ServeHTTP(wh.w, r)
+	assert.Equal(t, http.StatusBadRequest, wh.w.Code)
+}
+
+func testCreateWebhookInvalidArgs(t *testing.T) {
+	wh := startWebhooks(t)
+	defer wh.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/webhooks", strings.NewReader(`{"url":"/hook","events":["coupon.created"]}`))
+	wh.mock.EXPECT().CreateWebhook(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.CreatedWebhook{}, domain.NewInvalidArgsError("invalid url"))
+
+	wh.router.ServeHTTP(wh.w, r)
+	assert.Equal(t, http.StatusBadRequest, wh.w.Code)
+}
+
+func testCreateWebhookError(t *testing.T) {
+	wh := startWebhooks(t)
+	defer wh.ctrl.Finish()
+
+	r := httptest.NewRequest("POST", "/webhooks", strings.NewReader(`{"url":"https://example.com/hook","events":["coupon.created"]}`))
+	wh.mock.EXPECT().CreateWebhook(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(domain.CreatedWebhook{}, errors.New("error"))
+
+	wh.router.ServeHTTP(wh.w, r)
+	assert.Equal(t, http.StatusInternalServerError, wh.w.Code)
+}
+
+func TestListWebhooksHandler(t *testing.T) {
+	wh := startWebhooks(t)
+	defer wh.ctrl.Finish()
+
+	r := httptest.NewRequest("GET", "/webhooks", nil)
+	wh.mock.EXPECT().ListWebhooks(gomock.Any()).Return([]domain.Webhook{{ID: 1, Secret: "s3cret"}, {ID: 2}}, nil)
+
+	wh.router.ServeHTTP(wh.w, r)
+	assert.Equal(t, http.StatusOK, wh.w.Code)
+	assert.NotContains(t, wh.w.Body.String(), "s3cret")
+	var webhooks []domain.Webhook
+	assert.NoError(t, json.Unmarshal(wh.w.Body.Bytes(), &webhooks))
+	assert.Len(t, webhooks, 2)
+}
+
+func TestGetWebhookHandler(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("GET", "/webhooks/1", nil)
+		wh.mock.EXPECT().GetWebhook(gomock.Any(), uint(1)).Return(domain.Webhook{ID: 1}, nil)
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusOK, wh.w.Code)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("GET", "/webhooks/1", nil)
+		wh.mock.EXPECT().GetWebhook(gomock.Any(), uint(1)).Return(domain.Webhook{}, domain.NewWebhookNotFoundError())
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusNotFound, wh.w.Code)
+	})
+}
+
+func TestDeleteWebhookHandler(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("DELETE", "/webhooks/1", nil)
+		wh.mock.EXPECT().DeleteWebhook(gomock.Any(), uint(1)).Return(nil)
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusNoContent, wh.w.Code)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("DELETE", "/webhooks/1", nil)
+		wh.mock.EXPECT().DeleteWebhook(gomock.Any(), uint(1)).Return(domain.NewWebhookNotFoundError())
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusNotFound, wh.w.Code)
+	})
+	t.Run("error", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("DELETE", "/webhooks/1", nil)
+		wh.mock.EXPECT().DeleteWebhook(gomock.Any(), uint(1)).Return(errors.New("error"))
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusInternalServerError, wh.w.Code)
+	})
+}
+
+func TestListDeliveriesHandler(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("GET", "/webhooks/1/deliveries?status=dead", nil)
+		wh.mock.EXPECT().ListDeliveries(gomock.Any(), uint(1), map[string][]string{"status": {"dead"}}).
+			Return([]domain.Delivery{{ID: 2, Status: domain.DeliveryDead, Payload: json.RawMessage(`{"id":1}`)}}, nil)
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusOK, wh.w.Code)
+		var deliveries []domain.Delivery
+		assert.NoError(t, json.Unmarshal(wh.w.Body.Bytes(), &deliveries))
+		if assert.Len(t, deliveries, 1) {
+			assert.Equal(t, json.RawMessage(`{"id":1}`), deliveries[0].Payload)
+		}
+	})
+	t.Run("invalidArgs", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("GET", "/webhooks/1/deliveries?status=failed", nil)
+		wh.mock.EXPECT().ListDeliveries(gomock.Any(), uint(1), gomock.Any()).Return(nil, domain.NewInvalidArgsError("invalid status"))
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusBadRequest, wh.w.Code)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("GET", "/webhooks/1/deliveries", nil)
+		wh.mock.EXPECT().ListDeliveries(gomock.Any(), uint(1), gomock.Any()).Return(nil, domain.NewWebhookNotFoundError())
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusNotFound, wh.w.Code)
+	})
+}
+
+func TestRedeliverHandler(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("POST", "/webhooks/1/deliveries/2/redeliver", nil)
+		wh.mock.EXPECT().Redeliver(gomock.Any(), uint(1), uint(2)).Return(nil)
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusAccepted, wh.w.Code)
+	})
+	t.Run("notFound", func(t *testing.T) {
+		wh := startWebhooks(t)
+		defer wh.ctrl.Finish()
+
+		r := httptest.NewRequest("POST", "/webhooks/1/deliveries/2/redeliver", nil)
+		wh.mock.EXPECT().Redeliver(gomock.Any(), uint(1), uint(2)).Return(domain.NewDeliveryNotFoundError())
+
+		wh.router.ServeHTTP(wh.w, r)
+		assert.Equal(t, http.StatusNotFound, wh.w.Code)
+	})
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/handlers"
+	"github.com/jcgfreitas/pb_api/pkg/statuswriter"
+)
+
+const (
+	namespace   = "pb_api"
+	metricsPath = "/metrics"
+
+	resultOK       = "ok"
+	resultNotFound = "not_found"
+	resultInvalid  = "invalid"
+	resultError    = "error"
+)
+
+// Metrics holds the prometheus collectors of the API
+// It records the service events through the service.Metrics interface
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	couponsCreated     prometheus.Counter
+	validationFailures *prometheus.CounterVec
+	notFound           *prometheus.CounterVec
+
+	dbQueries       *prometheus.CounterVec
+	dbQueryDuration *prometheus.HistogramVec
+
+	rateLimited *prometheus.CounterVec
+	webhooks    *prometheus.CounterVec
+}
+
+// New is the Metrics constructor, its collectors and the go runtime and process collectors are registered
+// in a registry of their own
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the HTTP requests by method and route template.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		couponsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "service",
+			Name:      "coupons_created_total",
+			Help:      "Number of coupons created, imports and batches included.",
+		}),
+		validationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "service",
+			Name:      "validation_failures_total",
+			Help:      "Number of requests, import rows and batch operations failing the validation by reason.",
+		}, []string{"reason"}),
+		notFound: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "service",
+			Name:      "coupon_not_found_total",
+			Help:      "Number of operations on a coupon which does not exist by operation.",
+		}, []string{"op"}),
+		dbQueries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "queries_total",
+			Help:      "Number of repository calls by operation and result.",
+		}, []string{"op", "result"}),
+		dbQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "db",
+			Name:      "query_duration_seconds",
+			Help:      "Duration of the repository calls by operation, transactions include the calls they wrap.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"op"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "rate_limited_total",
+			Help:      "Number of requests rejected by the rate limits by route name and reason, limit or lockout.",
+		}, []string{"route", "reason"}),
+		webhooks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "webhook",
+			Name:      "attempts_total",
+			Help:      "Number of attempts of the webhook deliveries by result, delivered, failed or dead.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration,
+		m.couponsCreated, m.validationFailures, m.notFound,
+		m.dbQueries, m.dbQueryDuration,
+		m.rateLimited,
+		m.webhooks,
+	)
+	return m
+}
+
+// Handler serves the metrics in the prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Path returns the url path associated with the Handler
+func (m *Metrics) Path() string {
+	return metricsPath
+}
+
+// RegisterDB exports the connection pool stats of db labelled with its name
+// name must be unique, like the primary db and the address of each read replica
+func (m *Metrics) RegisterDB(name string, db *sql.DB) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// HTTP is a mux middleware counting and timing the requests by the template of their route
+// Unknown paths are counted as handlers.UnmatchedRoute so they don't create new series
+// Set it as the router NotFoundHandler and MethodNotAllowedHandler wrapper as well to count the unmatched requests,
+// mux skips its middlewares for them
+func (m *Metrics) HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := handlers.Route(r)
+		start := time.Now()
+		sw := statuswriter.Wrap(w)
+		next.ServeHTTP(sw, r)
+
+		m.httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		m.httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(sw.Status())).Inc()
+	})
+}
+
+// CouponsCreated counts n created coupons
+func (m *Metrics) CouponsCreated(n int) {
+	m.couponsCreated.Add(float64(n))
+}
+
+// ValidationFailed counts a validation failure
+func (m *Metrics) ValidationFailed(reason string) {
+	m.validationFailures.WithLabelValues(reason).Inc()
+}
+
+// CouponNotFound counts an op on a coupon which does not exist
+func (m *Metrics) CouponNotFound(op string) {
+	m.notFound.WithLabelValues(op).Inc()
+}
+
+// RateLimited counts a request of route rejected for reason
+func (m *Metrics) RateLimited(route, reason string) {
+	m.rateLimited.WithLabelValues(route, reason).Inc()
+}
+
+// WebhookAttempted counts an attempt of a webhook delivery with result
+func (m *Metrics) WebhookAttempted(result string) {
+	m.webhooks.WithLabelValues(result).Inc()
+}
+
+// ObserveQuery records a repository call of op which took d and returned err
+func (m *Metrics) ObserveQuery(op string, d time.Duration, err error) {
+	result := resultOK
+	switch {
+	case err == nil:
+	case errors.As(err, &domain.CouponNotFoundError{}):
+		result = resultNotFound
+	case errors.As(err, &domain.InvalidArgsError{}):
+		result = resultInvalid
+	default:
+		result = resultError
+	}
+	m.dbQueries.WithLabelValues(op, result).Inc()
+	m.dbQueryDuration.WithLabelValues(op).Observe(d.Seconds())
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/handlers"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRouter returns a router with a coupon route answering code, instrumented like the API router
+func newRouter(m *Metrics, code int) *mux.Router {
+	r := mux.NewRouter()
+	r.NotFoundHandler = m.HTTP(http.NotFoundHandler())
+	r.Use(m.HTTP)
+	r.HandleFunc("/coupons/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}).Methods("GET")
+	r.HandleFunc("/coupons", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}).Methods("GET")
+	return r
+}
+
+func serve(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, http.NoBody))
+	return w
+}
+
+func TestHTTP(t *testing.T) {
+	m := New()
+	r := newRouter(m, http.StatusNotFound)
+
+	serve(r, "/coupons/1")
+	serve(r, "/coupons/2")
+	serve(r, "/coupons")
+	serve(r, "/unknown/1")
+	serve(r, "/unknown/2")
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/coupons/{id:[0-9]+}", "404")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/coupons", "200")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", handlers.UnmatchedRoute, "404")))
+	// a series for every route template, never for every path
+	assert.Equal(t, 3, testutil.CollectAndCount(m.httpRequests))
+	assert.Equal(t, 3, testutil.CollectAndCount(m.httpDuration))
+}
+
+func TestHTTPFlush(t *testing.T) {
+	m := New()
+	h := m.HTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok)
+		w.Write([]byte("{}\n"))
+		w.(http.Flusher).Flush()
+	}))
+
+	w := serve(h, "/coupons/export")
+	assert.True(t, w.Flushed)
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", handlers.UnmatchedRoute, "200")))
+}
+
+func TestServiceMetrics(t *testing.T) {
+	m := New()
+
+	m.CouponsCreated(1)
+	m.CouponsCreated(3)
+	m.ValidationFailed("coupon_name")
+	m.ValidationFailed("coupon_name")
+	m.ValidationFailed("query")
+	m.CouponNotFound("get")
+
+	assert.Equal(t, float64(4), testutil.ToFloat64(m.couponsCreated))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.validationFailures.WithLabelValues("coupon_name")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.validationFailures.WithLabelValues("query")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.notFound.WithLabelValues("get")))
+}
+
+func TestObserveQuery(t *testing.T) {
+	m := New()
+
+	m.ObserveQuery("get_coupon", time.Millisecond, nil)
+	m.ObserveQuery("get_coupon", time.Millisecond, domain.NewCouponNotFoundError())
+	m.ObserveQuery("query_coupons", time.Millisecond, domain.NewInvalidArgsError("invalid column"))
+	m.ObserveQuery("query_coupons", time.Second, errors.New("connection refused"))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dbQueries.WithLabelValues("get_coupon", resultOK)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dbQueries.WithLabelValues("get_coupon", resultNotFound)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dbQueries.WithLabelValues("query_coupons", resultInvalid)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.dbQueries.WithLabelValues("query_coupons", resultError)))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.dbQueryDuration))
+}
+
+func TestRateLimited(t *testing.T) {
+	m := New()
+
+	m.RateLimited("get_coupon", "limit")
+	m.RateLimited("get_coupon", "limit")
+	m.RateLimited("get_coupon", "lockout")
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.rateLimited.WithLabelValues("get_coupon", "limit")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.rateLimited.WithLabelValues("get_coupon", "lockout")))
+}
+
+func TestWebhookAttempted(t *testing.T) {
+	m := New()
+
+	m.WebhookAttempted("delivered")
+	m.WebhookAttempted("failed")
+	m.WebhookAttempted("failed")
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.webhooks.WithLabelValues("delivered")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.webhooks.WithLabelValues("failed")))
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	db, err := sql.Open("sqlite3", ":memory:")
+	assert.Nil(t, err)
+	defer db.Close()
+	assert.Nil(t, m.RegisterDB("sqlite", db))
+	// every db is registered under a name of its own
+	assert.NotNil(t, m.RegisterDB("sqlite", db))
+	m.CouponsCreated(1)
+
+	w := serve(m.Handler(), m.Path())
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	for _, metric := range []string{
+		"pb_api_service_coupons_created_total 1",
+		`go_sql_open_connections{db_name="sqlite"}`,
+		"go_goroutines",
+	} {
+		assert.True(t, strings.Contains(body, metric), metric)
+	}
+}
@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is the minimum time between two sweeps of the expired entries of a MemoryStore
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store keeping its entries in memory, each API instance limits its clients on its own
+// The entries are swept once they expire, full buckets included, so the store does not grow with every client seen
+type MemoryStore struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	buckets   map[string]*bucket
+	failures  map[string]*failures
+	locks     map[string]time.Time
+	lastSweep time.Time
+}
+
+// bucket holds the tokens of a client, last is the time they were counted
+type bucket struct {
+	tokens  float64
+	last    time.Time
+	expires time.Time
+}
+
+// failures counts the failures of a client in the window ending at expires
+type failures struct {
+	count   int
+	expires time.Time
+}
+
+// NewMemoryStore is the MemoryStore constructor
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:       time.Now,
+		buckets:   make(map[string]*bucket),
+		failures:  make(map[string]*failures),
+		locks:     make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Take takes a token from the bucket of key, refilled with the tokens of the time elapsed since the last Take
+func (s *MemoryStore) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.sweep()
+
+	capacity := float64(l.Requests)
+	// tokens refilled per second
+	rate := capacity / l.Period.Seconds()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	var res Result
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = duration((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = duration((capacity - b.tokens) / rate)
+	b.expires = now.Add(res.Reset)
+	return res, nil
+}
+
+// Fail counts the failures of key in fixed windows starting at its first failure
+func (s *MemoryStore) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.sweep()
+
+	f, ok := s.failures[key]
+	if !ok || !now.Before(f.expires) {
+		f = &failures{expires: now.Add(window)}
+		s.failures[key] = f
+	}
+	f.count++
+	return f.count, nil
+}
+
+// Lock locks key out until d from now
+func (s *MemoryStore) Lock(ctx context.Context, key string, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[key] = s.sweep().Add(d)
+	return nil
+}
+
+// Locked returns the time left until the lockout of key expires
+func (s *MemoryStore) Locked(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.sweep()
+
+	until, ok := s.locks[key]
+	if !ok || !now.Before(until) {
+		return 0, nil
+	}
+	return until.Sub(now), nil
+}
+
+// sweep deletes the expired entries at most once per sweepInterval and returns the current time, s is locked
+func (s *MemoryStore) sweep() time.Time {
+	now := s.now()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return now
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if !now.Before(b.expires) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, f := range s.failures {
+		if !now.Before(f.expires) {
+			delete(s.failures, key)
+		}
+	}
+	for key, until := range s.locks {
+		if !now.Before(until) {
+			delete(s.locks, key)
+		}
+	}
+	return now
+}
+
+// duration converts seconds to a Duration
+func duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
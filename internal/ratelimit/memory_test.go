@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is a time moved forward by the tests
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time { return c.t }
+
+func (c *clock) add(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestStore returns a MemoryStore whose time is c
+func newTestStore(c *clock) *MemoryStore {
+	s := NewMemoryStore()
+	s.now = c.now
+	s.lastSweep = c.t
+	return s
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := newTestStore(c)
+	ctx := context.Background()
+	l := Limit{Requests: 2, Period: time.Second * 10, By: KeyByIP}
+
+	res, err := s.Take(ctx, "a", l)
+	assert.Nil(t, err)
+	assert.Equal(t, Result{Allowed: true, Remaining: 1, Reset: time.Second * 5}, res)
+	res, _ = s.Take(ctx, "a", l)
+	assert.Equal(t, Result{Allowed: true, Remaining: 0, Reset: time.Second * 10}, res)
+	res, _ = s.Take(ctx, "a", l)
+	assert.Equal(t, Result{Allowed: false, Remaining: 0, Reset: time.Second * 10, RetryAfter: time.Second * 5}, res)
+
+	// every key has its own bucket
+	res, _ = s.Take(ctx, "b", l)
+	assert.True(t, res.Allowed)
+
+	// a token is refilled every 5s
+	c.add(time.Second * 5)
+	res, _ = s.Take(ctx, "a", l)
+	assert.Equal(t, Result{Allowed: true, Remaining: 0, Reset: time.Second * 10}, res)
+	c.add(time.Minute)
+	res, _ = s.Take(ctx, "a", l)
+	assert.Equal(t, Result{Allowed: true, Remaining: 1, Reset: time.Second * 5}, res)
+}
+
+func TestMemoryStoreFail(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := newTestStore(c)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		n, err := s.Fail(ctx, "a", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, i, n)
+	}
+	n, _ := s.Fail(ctx, "b", time.Minute)
+	assert.Equal(t, 1, n)
+
+	// the count restarts once the window is over
+	c.add(time.Minute)
+	n, _ = s.Fail(ctx, "a", time.Minute)
+	assert.Equal(t, 1, n)
+}
+
+func TestMemoryStoreLock(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := newTestStore(c)
+	ctx := context.Background()
+
+	left, err := s.Locked(ctx, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), left)
+
+	assert.Nil(t, s.Lock(ctx, "a", time.Minute))
+	c.add(time.Second * 20)
+	left, _ = s.Locked(ctx, "a")
+	assert.Equal(t, time.Second*40, left)
+	left, _ = s.Locked(ctx, "b")
+	assert.Equal(t, time.Duration(0), left)
+
+	c.add(time.Second * 40)
+	left, _ = s.Locked(ctx, "a")
+	assert.Equal(t, time.Duration(0), left)
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	c := &clock{t: time.Unix(0, 0)}
+	s := newTestStore(c)
+	ctx := context.Background()
+	l := Limit{Requests: 10, Period: time.Second, By: KeyByIP}
+
+	s.Take(ctx, "a", l)
+	s.Take(ctx, "b", Limit{Requests: 10, Period: time.Hour, By: KeyByIP})
+	s.Fail(ctx, "a", time.Second)
+	s.Lock(ctx, "a", time.Second)
+	assert.Len(t, s.buckets, 2)
+
+	// the full buckets, counts and lockouts are swept, the bucket still refilling is kept
+	c.add(sweepInterval)
+	s.Take(ctx, "c", l)
+	assert.Len(t, s.buckets, 2)
+	assert.Contains(t, s.buckets, "b")
+	assert.Contains(t, s.buckets, "c")
+	assert.Empty(t, s.failures)
+	assert.Empty(t, s.locks)
+}
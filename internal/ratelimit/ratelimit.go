@@ -0,0 +1,340 @@
+// Package ratelimit limits the rate of the requests of each client with token buckets
+//
+// Every route has a Limit, a bucket of Requests tokens refilled over Period, and every client its own bucket per route:
+// a request takes a token and is answered with a 429 once the bucket is empty. The clients are told apart by their
+// API key, their IP or their customer, see the KeyBy values. The buckets live in a Store, in memory by default, or in
+// a store shared by the API instances.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+	"github.com/jcgfreitas/pb_api/pkg/statuswriter"
+)
+
+// KeyBy values, the clients sharing a bucket
+const (
+	// KeyByAPIKey shares a bucket between the requests of an API key or JWT subject, the unauthenticated requests
+	// are keyed by their IP
+	KeyByAPIKey = "api_key"
+	// KeyByIP shares a bucket between the requests of a client IP
+	KeyByIP = "ip"
+	// KeyByCustomer shares a bucket between the requests of the callers bound to a tenant, see domain.Principal,
+	// the requests of the other callers are keyed by their IP
+	KeyByCustomer = "customer"
+)
+
+// reasons of the rejected requests reported to the Metrics
+const (
+	ReasonLimit   = "limit"
+	ReasonLockout = "lockout"
+)
+
+// Off disables the limit of a route
+const Off = "off"
+
+// forwardedHeader holds the client IP added by a proxy
+const forwardedHeader = "X-Forwarded-For"
+
+// Limit is a token bucket of Requests tokens, refilled at Requests per Period, whose clients are keyed by By
+// The zero Limit lets every request through
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	By       string
+}
+
+// ParseLimit parses the limits written like 100/1m, a Requests/Period pair, optionally followed by "by" and
+// a KeyBy value like 100/1m by ip. The limits are keyed by KeyByAPIKey otherwise. Off is the zero Limit
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == Off {
+		return Limit{}, nil
+	}
+	l := Limit{By: KeyByAPIKey}
+	fields := strings.Fields(s)
+	switch {
+	case len(fields) == 3 && fields[1] == "by":
+		l.By = fields[2]
+	case len(fields) != 1:
+		return Limit{}, fmt.Errorf("invalid limit %q: expected <requests>/<period> [by <key>]", s)
+	}
+	if !IsKeyBy(l.By) {
+		return Limit{}, fmt.Errorf("invalid limit %q: unknown key %s", s, l.By)
+	}
+
+	rate := strings.SplitN(fields[0], "/", 2)
+	if len(rate) != 2 {
+		return Limit{}, fmt.Errorf("invalid limit %q: expected <requests>/<period> [by <key>]", s)
+	}
+	var err error
+	if l.Requests, err = strconv.Atoi(rate[0]); err != nil || l.Requests < 1 {
+		return Limit{}, fmt.Errorf("invalid limit %q: requests must be a positive integer", s)
+	}
+	if l.Period, err = time.ParseDuration(rate[1]); err != nil || l.Period <= 0 {
+		return Limit{}, fmt.Errorf("invalid limit %q: period must be a positive duration like 1m", s)
+	}
+	return l, nil
+}
+
+// IsKeyBy reports whether by is a KeyBy value
+func IsKeyBy(by string) bool {
+	switch by {
+	case KeyByAPIKey, KeyByIP, KeyByCustomer:
+		return true
+	}
+	return false
+}
+
+// Off reports whether l lets every request through
+func (l Limit) Off() bool {
+	return l.Requests == 0
+}
+
+// String returns l written like ParseLimit parses it
+func (l Limit) String() string {
+	if l.Off() {
+		return Off
+	}
+	return fmt.Sprintf("%d/%s by %s", l.Requests, l.Period, l.By)
+}
+
+// Lockout locks a client out of the lookup routes once Failures of its lookups failed within Window, for Duration
+// A lookup fails when the coupon looked up is not found, so guessing the coupons is soon stopped
+// The zero Lockout locks no client out
+type Lockout struct {
+	Failures int
+	Window   time.Duration
+	Duration time.Duration
+}
+
+// Result is the state of a bucket after a token was taken from it
+type Result struct {
+	// Allowed reports whether a token was taken, the request is rejected otherwise
+	Allowed bool
+	// Remaining is the number of tokens left in the bucket
+	Remaining int
+	// Reset is the time left until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time left until a token is available, 0 if the request is allowed
+	RetryAfter time.Duration
+}
+
+// Store holds the buckets, the failure counts and the lockouts of the clients
+// Its methods are safe for concurrent use. A store shared by the API instances, like a Redis one,
+// limits the clients across the instances
+type Store interface {
+	// Take takes a token from the bucket of key, created full if it does not exist
+	Take(ctx context.Context, key string, l Limit) (Result, error)
+	// Fail counts a failure of key and returns the number of failures of key within window, this one included
+	Fail(ctx context.Context, key string, window time.Duration) (int, error)
+	// Lock locks key out for d
+	Lock(ctx context.Context, key string, d time.Duration) error
+	// Locked returns the time left until key is no longer locked out, 0 if it is not locked out
+	Locked(ctx context.Context, key string) (time.Duration, error)
+}
+
+// Metrics records the rejected requests
+type Metrics interface {
+	// RateLimited records a request of route rejected for reason, ReasonLimit or ReasonLockout
+	RateLimited(route, reason string)
+}
+
+// nopMetrics is the Metrics of a Limiter recording nothing
+type nopMetrics struct{}
+
+func (nopMetrics) RateLimited(route, reason string) {}
+
+// Config holds the limits of the routes
+type Config struct {
+	// Default is the limit of the routes missing from Routes
+	Default Limit
+	// Routes maps the names of the routes to their limit
+	Routes  map[string]Limit
+	Lockout Lockout
+	// TrustForwarded keys the clients by the last address of the X-Forwarded-For header rather than the address
+	// of the connection, only the API behind a proxy setting the header trusts it
+	TrustForwarded bool
+}
+
+// Limiter limits the rate of the requests of the routes
+type Limiter struct {
+	store   Store
+	cfg     Config
+	logger  *logrus.Logger
+	metrics Metrics
+	// limited holds the names of the routes given to the middlewares
+	limited map[string]bool
+}
+
+// NewLimiter is the Limiter constructor
+func NewLimiter(store Store, cfg Config, logger *logrus.Logger) *Limiter {
+	return &Limiter{store: store, cfg: cfg, logger: logger, metrics: nopMetrics{}, limited: make(map[string]bool)}
+}
+
+// WithMetrics returns a copy of l recording the rejected requests to m
+func (l *Limiter) WithMetrics(m Metrics) *Limiter {
+	c := *l
+	c.metrics = m
+	return &c
+}
+
+// Unknown returns the routes of the config which no middleware of l limits, the misspelt ones
+func (l *Limiter) Unknown() []string {
+	var unknown []string
+	for route := range l.cfg.Routes {
+		if !l.limited[route] {
+			unknown = append(unknown, route)
+		}
+	}
+	return unknown
+}
+
+// Limit returns a middleware limiting the requests of route, see Config.Routes
+// Every response holds the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers of the bucket of its client,
+// the rejected requests are answered with a 429 and a Retry-After header.
+// It keys the clients by their caller and tenant, so it runs after the authentication and the tenant middlewares
+func (l *Limiter) Limit(route string) func(next http.HandlerFunc) http.HandlerFunc {
+	return l.middleware(route, false)
+}
+
+// Lookup returns the middleware of Limit which also locks the clients out of the lookup routes, see Lockout,
+// once too many of their lookups were answered with a 404
+func (l *Limiter) Lookup(route string) func(next http.HandlerFunc) http.HandlerFunc {
+	return l.middleware(route, l.cfg.Lockout.Failures > 0)
+}
+
+func (l *Limiter) middleware(route string, lockout bool) func(next http.HandlerFunc) http.HandlerFunc {
+	l.limited[route] = true
+	limit, ok := l.cfg.Routes[route]
+	if !ok {
+		limit = l.cfg.Default
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if limit.Off() && !lockout {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			log := ctxlog.Entry(r.Context(), l.logger)
+			by := limit.By
+			if limit.Off() {
+				by = KeyByAPIKey
+			}
+			client := l.client(r, by)
+
+			if lockout {
+				left, err := l.store.Locked(r.Context(), "lock:"+client)
+				if err != nil {
+					log.WithError(err).Error("failed to read the lockout")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if left > 0 {
+					log.WithField("client", client).Debug("client locked out")
+					l.metrics.RateLimited(route, ReasonLockout)
+					w.Header().Set("Retry-After", seconds(left))
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			if !limit.Off() {
+				res, err := l.store.Take(r.Context(), "bucket:"+route+":"+client, limit)
+				if err != nil {
+					log.WithError(err).Error("failed to take a token")
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				h := w.Header()
+				h.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+				h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+				h.Set("RateLimit-Reset", seconds(res.Reset))
+				if !res.Allowed {
+					log.WithField("client", client).Debug("rate limited")
+					l.metrics.RateLimited(route, ReasonLimit)
+					h.Set("Retry-After", seconds(res.RetryAfter))
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			if !lockout {
+				next(w, r)
+				return
+			}
+			sw := statuswriter.Wrap(w)
+			next(sw, r)
+			if sw.Status() == http.StatusNotFound {
+				l.fail(r.Context(), log, client)
+			}
+		}
+	}
+}
+
+// fail counts a failed lookup of client and locks it out once it failed too often, the response being written
+// the errors are only logged
+func (l *Limiter) fail(ctx context.Context, log *logrus.Entry, client string) {
+	failures, err := l.store.Fail(ctx, "fail:"+client, l.cfg.Lockout.Window)
+	if err != nil {
+		log.WithError(err).Error("failed to count the failed lookup")
+		return
+	}
+	if failures < l.cfg.Lockout.Failures {
+		return
+	}
+	if err := l.store.Lock(ctx, "lock:"+client, l.cfg.Lockout.Duration); err != nil {
+		log.WithError(err).Error("failed to lock the client out")
+		return
+	}
+	log.WithField("client", client).WithField("failures", failures).Warn("client locked out")
+}
+
+// client returns the key of the client of r, prefixed by its kind so the kinds never share a bucket
+func (l *Limiter) client(r *http.Request, by string) string {
+	switch by {
+	case KeyByAPIKey:
+		if p, ok := domain.PrincipalFrom(r.Context()); ok {
+			return "api_key:" + p.Subject
+		}
+	case KeyByCustomer:
+		// only the tenant bound to the caller keys it, the callers picking their tenant with the X-Tenant-ID header
+		// would otherwise get a bucket per header value, or drain the bucket of another tenant
+		if p, ok := domain.PrincipalFrom(r.Context()); ok && p.Tenant != "" {
+			return "customer:" + p.Tenant
+		}
+	}
+	return "ip:" + l.ip(r)
+}
+
+// ip returns the IP of the client of r
+func (l *Limiter) ip(r *http.Request) string {
+	if l.cfg.TrustForwarded {
+		if forwarded := r.Header.Get(forwardedHeader); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			if ip := net.ParseIP(strings.TrimSpace(addrs[len(addrs)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// seconds returns d in whole seconds, rounded up so the clients never retry too early
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// testMetrics counts the rejected requests by reason
+type testMetrics map[string]int
+
+func (m testMetrics) RateLimited(route, reason string) { m[reason]++ }
+
+func TestParseLimit(t *testing.T) {
+	cases := []struct {
+		s     string
+		limit Limit
+		err   bool
+	}{
+		{"100/1m", Limit{Requests: 100, Period: time.Minute, By: KeyByAPIKey}, false},
+		{" 5/1s by ip ", Limit{Requests: 5, Period: time.Second, By: KeyByIP}, false},
+		{"5/1h30m by customer", Limit{Requests: 5, Period: time.Hour + time.Minute*30, By: KeyByCustomer}, false},
+		{"off", Limit{}, false},
+		{"", Limit{}, true},
+		{"100", Limit{}, true},
+		{"0/1m", Limit{}, true},
+		{"10/0s", Limit{}, true},
+		{"10/minute", Limit{}, true},
+		{"10/1m by user", Limit{}, true},
+		{"10/1m for ip", Limit{}, true},
+	}
+	for _, c := range cases {
+		l, err := ParseLimit(c.s)
+		assert.Equal(t, c.err, err != nil, c.s)
+		assert.Equal(t, c.limit, l, c.s)
+	}
+
+	// String is parsed back
+	l := Limit{Requests: 5, Period: time.Second, By: KeyByIP}
+	parsed, err := ParseLimit(l.String())
+	assert.Nil(t, err)
+	assert.Equal(t, l, parsed)
+	assert.Equal(t, Off, Limit{}.String())
+}
+
+// serve serves a request of remoteAddr with h
+func serve(h http.HandlerFunc, r *http.Request, remoteAddr string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.RemoteAddr = remoteAddr
+	h(w, r)
+	return w
+}
+
+func TestLimit(t *testing.T) {
+	m := testMetrics{}
+	l := NewLimiter(NewMemoryStore(), Config{
+		Default: Limit{Requests: 2, Period: time.Minute, By: KeyByIP},
+		Routes:  map[string]Limit{"unlimited": {}},
+	}, logrus.New()).WithMetrics(m)
+	h := l.Limit("get_coupons")(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := serve(h, httptest.NewRequest("GET", "/coupons", http.NoBody), "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+	serve(h, httptest.NewRequest("GET", "/coupons", http.NoBody), "10.0.0.1:1234")
+
+	w = serve(h, httptest.NewRequest("GET", "/coupons", http.NoBody), "10.0.0.1:4321")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, 1, m[ReasonLimit])
+
+	// another IP has a bucket of its own
+	w = serve(h, httptest.NewRequest("GET", "/coupons", http.NoBody), "10.0.0.2:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// a route turned off is not limited
+	h = l.Limit("unlimited")(func(w http.ResponseWriter, r *http.Request) {})
+	w = serve(h, httptest.NewRequest("GET", "/coupons", http.NoBody), "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("RateLimit-Limit"))
+}
+
+func TestLimitKeys(t *testing.T) {
+	cases := []struct {
+		name      string
+		by        string
+		forwarded bool
+		// second is the request following the first one, sharing its bucket if limited
+		first, second func(r *http.Request) *http.Request
+		limited       bool
+	}{
+		{"sameAPIKey", KeyByAPIKey, false, principal("apikey:1", "10.0.0.1:1"), principal("apikey:1", "10.0.0.2:1"), true},
+		{"otherAPIKey", KeyByAPIKey, false, principal("apikey:1", "10.0.0.1:1"), principal("apikey:2", "10.0.0.1:1"), false},
+		{"unauthenticated", KeyByAPIKey, false, remote("10.0.0.1:1"), remote("10.0.0.1:2"), true},
+		{"sameCustomer", KeyByCustomer, false, tenant("acme", "10.0.0.1:1"), tenant("acme", "10.0.0.2:1"), true},
+		{"otherCustomer", KeyByCustomer, false, tenant("acme", "10.0.0.1:1"), tenant("globex", "10.0.0.1:1"), false},
+		{"headerCustomer", KeyByCustomer, false, header("acme", "10.0.0.1:1"), header("globex", "10.0.0.1:2"), true},
+		{"headerCustomerIP", KeyByCustomer, false, header("acme", "10.0.0.1:1"), header("acme", "10.0.0.2:1"), false},
+		{"ignoredForwarded", KeyByIP, false, forwarded("10.0.0.1", "192.168.0.1:1"), forwarded("10.0.0.2", "192.168.0.1:1"), true},
+		{"trustedForwarded", KeyByIP, true, forwarded("10.0.0.1", "192.168.0.1:1"), forwarded("10.0.0.2", "192.168.0.1:1"), false},
+		{"lastForwarded", KeyByIP, true, forwarded("1.1.1.1, 10.0.0.1", "192.168.0.1:1"), forwarded("2.2.2.2, 10.0.0.1", "192.168.0.1:1"), true},
+	}
+	for _, c := range cases {
+		l := NewLimiter(NewMemoryStore(), Config{
+			Default:        Limit{Requests: 1, Period: time.Minute, By: c.by},
+			TrustForwarded: c.forwarded,
+		}, logrus.New())
+		h := l.Limit("get_coupons")(func(w http.ResponseWriter, r *http.Request) {})
+
+		w := httptest.NewRecorder()
+		h(w, c.first(httptest.NewRequest("GET", "/coupons", http.NoBody)))
+		assert.Equal(t, http.StatusOK, w.Code, c.name)
+		w = httptest.NewRecorder()
+		h(w, c.second(httptest.NewRequest("GET", "/coupons", http.NoBody)))
+		assert.Equal(t, c.limited, w.Code == http.StatusTooManyRequests, c.name)
+	}
+}
+
+func remote(addr string) func(r *http.Request) *http.Request {
+	return func(r *http.Request) *http.Request {
+		r.RemoteAddr = addr
+		return r
+	}
+}
+
+func principal(subject, addr string) func(r *http.Request) *http.Request {
+	return func(r *http.Request) *http.Request {
+		r.RemoteAddr = addr
+		return r.WithContext(domain.WithPrincipal(r.Context(), domain.Principal{Subject: subject}))
+	}
+}
+
+// tenant returns a request of a caller bound to the tenant id
+func tenant(id, addr string) func(r *http.Request) *http.Request {
+	return func(r *http.Request) *http.Request {
+		r.RemoteAddr = addr
+		ctx := domain.WithPrincipal(r.Context(), domain.Principal{Subject: "apikey:1", Tenant: id})
+		return r.WithContext(domain.WithTenant(ctx, id))
+	}
+}
+
+// header returns a request of a caller picking the tenant id with the X-Tenant-ID header
+func header(id, addr string) func(r *http.Request) *http.Request {
+	return func(r *http.Request) *http.Request {
+		r.RemoteAddr = addr
+		ctx := domain.WithPrincipal(r.Context(), domain.Principal{Subject: "apikey:1"})
+		return r.WithContext(domain.WithTenant(ctx, id))
+	}
+}
+
+func forwarded(header, addr string) func(r *http.Request) *http.Request {
+	return func(r *http.Request) *http.Request {
+		r.RemoteAddr = addr
+		r.Header.Set(forwardedHeader, header)
+		return r
+	}
+}
+
+func TestLookup(t *testing.T) {
+	m := testMetrics{}
+	l := NewLimiter(NewMemoryStore(), Config{
+		Lockout: Lockout{Failures: 2, Window: time.Minute, Duration: time.Minute * 15},
+	}, logrus.New()).WithMetrics(m)
+	status := http.StatusNotFound
+	h := l.Lookup("get_coupon")(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(status) })
+	lookup := func(addr string) *httptest.ResponseRecorder {
+		return serve(h, httptest.NewRequest("GET", "/coupons/1", http.NoBody), addr)
+	}
+
+	// the found coupons are not counted, without a limit no RateLimit header is set
+	status = http.StatusOK
+	w := lookup("10.0.0.1:1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("RateLimit-Limit"))
+
+	status = http.StatusNotFound
+	assert.Equal(t, http.StatusNotFound, lookup("10.0.0.1:1").Code)
+	assert.Equal(t, http.StatusNotFound, lookup("10.0.0.1:1").Code)
+
+	// locked out, even for the coupons found
+	status = http.StatusOK
+	w = lookup("10.0.0.1:1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "900", w.Header().Get("Retry-After"))
+	assert.Equal(t, 1, m[ReasonLockout])
+	assert.Equal(t, http.StatusOK, lookup("10.0.0.2:1").Code)
+
+	// Limit never locks out
+	h = l.Limit("get_coupon")(func(w http.ResponseWriter, r *http.Request) {})
+	assert.Equal(t, http.StatusOK, serve(h, httptest.NewRequest("GET", "/coupons/1", http.NoBody), "10.0.0.1:1").Code)
+}
+
+func TestUnknown(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), Config{
+		Routes: map[string]Limit{"get_coupon": {}, "get_cupon": {}},
+	}, logrus.New())
+	l.Lookup("get_coupon")
+	l.Limit("get_coupons")
+
+	assert.Equal(t, []string{"get_cupon"}, l.Unknown())
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jinzhu/gorm"
+)
+
+// auditEntry is the audit_entries record of a domain.AuditEntry, its changes are stored as a JSON object
+type auditEntry struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	TenantID  string
+	Actor     string
+	Action    string
+	CouponID  uint
+	Changes   string
+	RequestID string
+}
+
+// TableName is the table of the records
+func (auditEntry) TableName() string {
+	return "audit_entries"
+}
+
+func newAuditRecord(e domain.AuditEntry) (auditEntry, error) {
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return auditEntry{}, err
+	}
+	return auditEntry{
+		TenantID:  e.TenantID,
+		Actor:     e.Actor,
+		Action:    e.Action,
+		CouponID:  e.CouponID,
+		Changes:   string(changes),
+		RequestID: e.RequestID,
+	}, nil
+}
+
+func (r auditEntry) toDomain() (domain.AuditEntry, error) {
+	e := domain.AuditEntry{
+		ID:        r.ID,
+		CreatedAt: r.CreatedAt,
+		Tenanted:  domain.Tenanted{TenantID: r.TenantID},
+		Actor:     r.Actor,
+		Action:    r.Action,
+		CouponID:  r.CouponID,
+		RequestID: r.RequestID,
+	}
+	if err := json.Unmarshal([]byte(r.Changes), &e.Changes); err != nil {
+		return domain.AuditEntry{}, err
+	}
+	return e, nil
+}
+
+// audited runs the coupon change fn within a transaction, along with the creation of the audit entry it returns
+// and of its event in the webhooks outbox
+// Within a transaction a savepoint is used, so a failed change leaves neither the change nor its entry behind
+func (gr *GormRepository) audited(ctx context.Context, fn func(db *gorm.DB) (domain.AuditEntry, error)) error {
+	return gr.transaction(ctx, func(tx *GormRepository) error {
+		db := tx.conn(ctx)
+		e, err := fn(db)
+		if err != nil {
+			return err
+		}
+		r, err := newAuditRecord(e)
+		if err != nil {
+			return err
+		}
+		if err := db.Create(&r).Error; err != nil {
+			return err
+		}
+		event, err := newEventRecord(domain.NewEvent(e))
+		if err != nil {
+			return err
+		}
+		return db.Create(&event).Error
+	})
+}
+
+// CouponHistory reads the audit entries of the coupon with id in id order, those of a deleted coupon included
+// If the coupon has no entry a CouponNotFoundError is returned
+func (gr *GormRepository) CouponHistory(ctx context.Context, id uint, entries *[]domain.AuditEntry) error {
+	if err := gr.QueryAudit(ctx, entries, domain.AuditQuery{CouponID: id}); err != nil {
+		return err
+	}
+	if len(*entries) == 0 {
+		return domain.NewCouponNotFoundError()
+	}
+	return nil
+}
+
+// QueryAudit reads the audit entries matching q in id order
+func (gr *GormRepository) QueryAudit(ctx context.Context, entries *[]domain.AuditEntry, q domain.AuditQuery) error {
+	ctx, cancel := gr.withTimeout(ctx, gr.timeouts.Read)
+	defer cancel()
+
+	var records []auditEntry
+	err := gr.read(ctx, func(db *gorm.DB) error {
+		return ctxErr(ctx, auditScope(db, q).Order("id").Find(&records).Error)
+	})
+	if err != nil {
+		return err
+	}
+	*entries = make([]domain.AuditEntry, len(records))
+	for i, r := range records {
+		if (*entries)[i], err = r.toDomain(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditScope builds the conditions and page of the query on db
+func auditScope(db *gorm.DB, q domain.AuditQuery) *gorm.DB {
+	if q.Actor != "" {
+		db = db.Where("actor = ?", q.Actor)
+	}
+	if q.Action != "" {
+		db = db.Where("action = ?", q.Action)
+	}
+	if q.CouponID != 0 {
+		db = db.Where("coupon_id = ?", q.CouponID)
+	}
+	if q.RequestID != "" {
+		db = db.Where("request_id = ?", q.RequestID)
+	}
+	if !q.From.IsZero() {
+		db = db.Where("created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		db = db.Where("created_at <= ?", q.To)
+	}
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit).Offset(q.Offset)
+	}
+	return db
+}
@@ -0,0 +1,659 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	name  = "name"
+	brand = "brand"
+	value = uint(10)
+	secs  = int64(1000000000)
+)
+
+var Time = time.Unix(secs, 0)
+var Name = "new" + name
+var Brand = "new" + brand
+var Value = 2 * value
+
+func newAPICoupon() domain.APICoupon {
+	return domain.APICoupon{Name: &Name, Brand: &Brand, Value: &Value, Expiry: &Time}
+}
+
+// opener returns an empty repository for a conformance test
+type opener func(t *testing.T) domain.Repository
+
+// testConformance runs the behaviour every domain.Repository implementation must share
+// Each subtest opens its own empty repository, so implementations are checked in isolation
+func testConformance(t *testing.T, open opener) {
+	cases := map[string]func(t *testing.T, open opener){
+		"create":           conformCreate,
+		"selectedColumns":  conformSelectedColumns,
+		"notFound":         conformNotFound,
+		"softDelete":       conformSoftDelete,
+		"tenants":          conformTenants,
+		"txTenants":        conformTxTenants,
+		"audit":            conformAudit,
+		"auditQuery":       conformAuditQuery,
+		"txAudit":          conformTxAudit,
+		"update":           conformUpdate,
+		"filters":          conformFilters,
+		"pagination":       conformPagination,
+		"export":           conformExport,
+		"stats":            conformStats,
+		"txCommit":         conformTxCommit,
+		"txRollback":       conformTxRollback,
+		"txSavepoint":      conformTxSavepoint,
+		"canceledContext":  conformCanceledContext,
+		"invalidQueryArgs": conformInvalidQuery,
+		"ping":             conformPing,
+	}
+	for name, fn := range cases {
+		fn := fn
+		t.Run(name, func(t *testing.T) { fn(t, open) })
+	}
+}
+
+// conformSeed creates the coupons shared by the conformance tests and returns their ids
+// They are two names, two brands, two values and two expiry dates, every combination of a name and a brand once
+func conformSeed(t *testing.T, repo domain.Repository) []uint {
+	var ids []uint
+	for i := 0; i < 4; i++ {
+		n := fmt.Sprintf("%s%d", name, i%2+1)
+		b := fmt.Sprintf("%s%d", brand, i/2+1)
+		v := value * uint(i%2+1)
+		e := time.Unix(secs*int64(i%2+1), 0)
+		id, err := repo.NewCoupon(context.Background(), domain.APICoupon{Name: &n, Brand: &b, Value: &v, Expiry: &e})
+		if err != nil {
+			t.Fatal("failed to create coupon:", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func conformQuery(t *testing.T, repo domain.Repository, q domain.CouponQuery) []domain.Coupon {
+	var coupons []domain.Coupon
+	if err := repo.QueryCoupons(context.Background(), &coupons, q); err != nil {
+		t.Fatal(err)
+	}
+	return coupons
+}
+
+func conformCreate(t *testing.T, open opener) {
+	repo := open(t)
+	start := time.Now().Add(-time.Second)
+	ids := conformSeed(t, repo)
+
+	for i, id := range ids {
+		if i > 0 {
+			assert.True(t, id > ids[i-1], "ids must increase")
+		}
+	}
+
+	var c domain.Coupon
+	assert.Nil(t, repo.GetCouponByID(context.Background(), ids[1], nil, &c))
+	assert.Equal(t, ids[1], c.ID)
+	assert.Equal(t, name+"2", c.Name)
+	assert.Equal(t, brand+"1", c.Brand)
+	assert.Equal(t, value*2, c.Value)
+	assert.Equal(t, 2*secs, c.Expiry.Unix())
+	assert.True(t, c.CreatedAt.After(start))
+	assert.Nil(t, c.DeletedAt)
+}
+
+func conformSelectedColumns(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+
+	var c domain.Coupon
+	assert.Nil(t, repo.GetCouponByID(context.Background(), ids[0], []string{"id", "brand"}, &c))
+	assert.Equal(t, domain.Coupon{Model: c.Model, Brand: brand + "1"}, c)
+	assert.Equal(t, ids[0], c.ID)
+	assert.True(t, c.CreatedAt.IsZero())
+
+	coupons := conformQuery(t, repo, domain.CouponQuery{}.Select([]string{"id", "value"}))
+	assert.Equal(t, 4, len(coupons))
+	for _, c := range coupons {
+		assert.Empty(t, c.Name)
+		assert.NotZero(t, c.Value)
+	}
+}
+
+func conformNotFound(t *testing.T, open opener) {
+	repo := open(t)
+	conformSeed(t, repo)
+	ctx := context.Background()
+
+	var c domain.Coupon
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(ctx, 100, nil, &c))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.DeleteCoupon(ctx, 100))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.UpdateCoupon(ctx, 100, domain.APICoupon{Name: &Name}))
+}
+
+func conformSoftDelete(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	ctx := context.Background()
+
+	assert.Nil(t, repo.DeleteCoupon(ctx, ids[0]))
+
+	var c domain.Coupon
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(ctx, ids[0], nil, &c))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.DeleteCoupon(ctx, ids[0]))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.UpdateCoupon(ctx, ids[0], domain.APICoupon{Name: &Name}))
+
+	coupons := conformQuery(t, repo, domain.CouponQuery{})
+	assert.Equal(t, 3, len(coupons))
+	for _, c := range coupons {
+		assert.NotEqual(t, ids[0], c.ID)
+	}
+
+	var stats []domain.CouponStats
+	assert.Nil(t, repo.CouponStats(ctx, &stats, nil, domain.CouponQuery{}))
+	assert.Equal(t, uint(3), stats[0].Count)
+
+	// the ids of deleted coupons are not reused
+	id, err := repo.NewCoupon(ctx, newAPICoupon())
+	assert.Nil(t, err)
+	assert.True(t, id > ids[3])
+}
+
+// conformTenants checks that the coupons of a tenant are never read, listed, updated or deleted from another tenant
+func conformTenants(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	acme := domain.WithTenant(context.Background(), "acme")
+	globex := domain.WithTenant(context.Background(), "globex")
+
+	acmeID, err := repo.NewCoupon(acme, newAPICoupon())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get
+	var c domain.Coupon
+	assert.Nil(t, repo.GetCouponByID(acme, acmeID, nil, &c))
+	assert.Equal(t, "acme", c.TenantID)
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(globex, acmeID, nil, &c))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(context.Background(), acmeID, nil, &c))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(acme, ids[0], []string{"id", "name"}, &c))
+
+	// list
+	var coupons []domain.Coupon
+	assert.Nil(t, repo.QueryCoupons(acme, &coupons, domain.CouponQuery{}))
+	if assert.Equal(t, 1, len(coupons)) {
+		assert.Equal(t, acmeID, coupons[0].ID)
+	}
+	assert.Nil(t, repo.QueryCoupons(globex, &coupons, domain.CouponQuery{}))
+	assert.Empty(t, coupons)
+	assert.Nil(t, repo.QueryCoupons(context.Background(), &coupons, domain.CouponQuery{}.Where("name", domain.FilterEqual, Name)))
+	assert.Empty(t, coupons)
+	assert.Equal(t, 4, len(conformQuery(t, repo, domain.CouponQuery{})))
+
+	var exported []uint
+	assert.Nil(t, repo.ExportCoupons(globex, func(c domain.Coupon) error {
+		exported = append(exported, c.ID)
+		return nil
+	}, domain.CouponQuery{}))
+	assert.Empty(t, exported)
+
+	var stats []domain.CouponStats
+	assert.Nil(t, repo.CouponStats(acme, &stats, nil, domain.CouponQuery{}))
+	assert.Equal(t, uint(1), stats[0].Count)
+
+	// update
+	other := "other"
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.UpdateCoupon(globex, acmeID, domain.APICoupon{Name: &other}))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.UpdateCoupon(acme, ids[0], domain.APICoupon{Name: &other}))
+	assert.Nil(t, repo.GetCouponByID(acme, acmeID, nil, &c))
+	assert.Equal(t, Name, c.Name)
+	assert.Nil(t, repo.UpdateCoupon(acme, acmeID, domain.APICoupon{Name: &other}))
+	assert.Nil(t, repo.GetCouponByID(acme, acmeID, nil, &c))
+	assert.Equal(t, other, c.Name)
+	assert.Equal(t, "acme", c.TenantID)
+
+	// delete
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.DeleteCoupon(globex, acmeID))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.DeleteCoupon(acme, ids[0]))
+	assert.Nil(t, repo.GetCouponByID(acme, acmeID, nil, &c))
+	assert.Nil(t, repo.GetCouponByID(context.Background(), ids[0], nil, &c))
+	assert.Nil(t, repo.DeleteCoupon(acme, acmeID))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(acme, acmeID, nil, &c))
+}
+
+// conformTxTenants checks that the transactions are scoped to the tenant of their statements as well
+func conformTxTenants(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	acme := domain.WithTenant(context.Background(), "acme")
+
+	var created uint
+	err := repo.WithTx(acme, func(tx domain.Repository) error {
+		var c domain.Coupon
+		assert.IsType(t, domain.CouponNotFoundError{}, tx.GetCouponByID(acme, ids[0], nil, &c))
+		assert.IsType(t, domain.CouponNotFoundError{}, tx.DeleteCoupon(acme, ids[0]))
+		var err error
+		created, err = tx.NewCoupon(acme, newAPICoupon())
+		return err
+	})
+	assert.Nil(t, err)
+
+	var c domain.Coupon
+	assert.Nil(t, repo.GetCouponByID(context.Background(), ids[0], nil, &c))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(context.Background(), created, nil, &c))
+	assert.Nil(t, repo.GetCouponByID(acme, created, nil, &c))
+}
+
+// conformAudit checks that every coupon change records an entry of its actor, request and changed fields
+func conformAudit(t *testing.T, open opener) {
+	repo := open(t)
+	ctx := domain.WithRequestID(domain.WithPrincipal(context.Background(), domain.Principal{Subject: "apikey:7"}), "req-1")
+
+	id, err := repo.NewCoupon(ctx, newAPICoupon())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, newValue := "other", Value+1
+	assert.Nil(t, repo.UpdateCoupon(ctx, id, domain.APICoupon{Name: &other, Brand: &Brand, Value: &newValue}))
+	assert.Nil(t, repo.DeleteCoupon(ctx, id))
+	// failed changes record nothing
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.UpdateCoupon(ctx, id, domain.APICoupon{Name: &Name}))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.DeleteCoupon(ctx, id))
+
+	var history []domain.AuditEntry
+	assert.Nil(t, repo.CouponHistory(ctx, id, &history))
+	if !assert.Equal(t, 3, len(history)) {
+		return
+	}
+	for i, action := range []string{domain.AuditCreate, domain.AuditUpdate, domain.AuditDelete} {
+		e := history[i]
+		assert.Equal(t, action, e.Action)
+		assert.Equal(t, id, e.CouponID)
+		assert.Equal(t, "apikey:7", e.Actor)
+		assert.Equal(t, "req-1", e.RequestID)
+		assert.Equal(t, domain.DefaultTenant, e.TenantID)
+		assert.False(t, e.CreatedAt.IsZero())
+		if i > 0 {
+			assert.True(t, e.ID > history[i-1].ID)
+		}
+	}
+
+	assert.Equal(t, 4, len(history[0].Changes))
+	assert.Nil(t, history[0].Changes["name"].Before)
+	assert.Equal(t, `"`+Name+`"`, string(history[0].Changes["name"].After))
+	// the brand is unchanged
+	assert.Equal(t, map[string]domain.AuditChange{
+		"name":  {Before: []byte(`"` + Name + `"`), After: []byte(`"other"`)},
+		"value": {Before: []byte(fmt.Sprint(Value)), After: []byte(fmt.Sprint(newValue))},
+	}, history[1].Changes)
+	assert.Equal(t, 4, len(history[2].Changes))
+	assert.Equal(t, `"other"`, string(history[2].Changes["name"].Before))
+	assert.Nil(t, history[2].Changes["name"].After)
+
+	// without caller the changes are anonymous
+	id, err = repo.NewCoupon(context.Background(), newAPICoupon())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, repo.CouponHistory(context.Background(), id, &history))
+	if assert.Equal(t, 1, len(history)) {
+		assert.Equal(t, domain.AnonymousActor, history[0].Actor)
+		assert.Equal(t, "", history[0].RequestID)
+	}
+
+	// the history of a coupon never changed, or changed by another tenant, is not found
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.CouponHistory(ctx, id+1, &history))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.CouponHistory(domain.WithTenant(ctx, "acme"), id, &history))
+}
+
+// conformAuditQuery checks the filters and pages of the audit entries
+func conformAuditQuery(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	start := time.Now().Add(-time.Minute)
+	alice := domain.WithRequestID(domain.WithPrincipal(context.Background(), domain.Principal{Subject: "jwt:alice"}), "req-1")
+	assert.Nil(t, repo.UpdateCoupon(alice, ids[0], domain.APICoupon{Name: &Name}))
+	assert.Nil(t, repo.DeleteCoupon(alice, ids[1]))
+	acme := domain.WithTenant(context.Background(), "acme")
+	if _, err := repo.NewCoupon(acme, newAPICoupon()); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(q domain.AuditQuery) []domain.AuditEntry {
+		var entries []domain.AuditEntry
+		if err := repo.QueryAudit(context.Background(), &entries, q); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	assert.Equal(t, 6, len(query(domain.AuditQuery{})))
+	assert.Equal(t, 4, len(query(domain.AuditQuery{Action: domain.AuditCreate})))
+	assert.Equal(t, 2, len(query(domain.AuditQuery{Actor: "jwt:alice"})))
+	assert.Equal(t, 2, len(query(domain.AuditQuery{RequestID: "req-1"})))
+	assert.Equal(t, 0, len(query(domain.AuditQuery{Actor: "jwt:bob"})))
+	if entries := query(domain.AuditQuery{Actor: "jwt:alice", Action: domain.AuditDelete}); assert.Equal(t, 1, len(entries)) {
+		assert.Equal(t, ids[1], entries[0].CouponID)
+	}
+	assert.Equal(t, 2, len(query(domain.AuditQuery{CouponID: ids[0]})))
+	assert.Equal(t, 6, len(query(domain.AuditQuery{From: start, To: time.Now().Add(time.Minute)})))
+	assert.Equal(t, 0, len(query(domain.AuditQuery{From: time.Now().Add(time.Minute)})))
+	assert.Equal(t, 0, len(query(domain.AuditQuery{To: start})))
+
+	page := query(domain.AuditQuery{Limit: 4, Offset: 4})
+	if assert.Equal(t, 2, len(page)) {
+		assert.Equal(t, domain.AuditUpdate, page[0].Action)
+		assert.Equal(t, domain.AuditDelete, page[1].Action)
+	}
+
+	var entries []domain.AuditEntry
+	assert.Nil(t, repo.QueryAudit(acme, &entries, domain.AuditQuery{}))
+	assert.Equal(t, 1, len(entries))
+}
+
+// conformTxAudit checks that the audit entries of a transaction are only visible once it is committed
+func conformTxAudit(t *testing.T, open opener) {
+	repo := open(t)
+	ctx := context.Background()
+
+	var id uint
+	newValue := Value + 1
+	err := repo.WithTx(ctx, func(tx domain.Repository) error {
+		var err error
+		if id, err = tx.NewCoupon(ctx, newAPICoupon()); err != nil {
+			return err
+		}
+		// a failed savepoint rolls back its entries only
+		tx.WithTx(ctx, func(sp domain.Repository) error {
+			if err := sp.UpdateCoupon(ctx, id, domain.APICoupon{Name: &Brand}); err != nil {
+				return err
+			}
+			return errors.New("failure")
+		})
+		var entries []domain.AuditEntry
+		if err := tx.CouponHistory(ctx, id, &entries); err != nil {
+			return err
+		}
+		assert.Equal(t, 1, len(entries))
+		return tx.UpdateCoupon(ctx, id, domain.APICoupon{Value: &newValue})
+	})
+	assert.Nil(t, err)
+
+	var entries []domain.AuditEntry
+	assert.Nil(t, repo.CouponHistory(ctx, id, &entries))
+	if assert.Equal(t, 2, len(entries)) {
+		assert.Equal(t, domain.AuditUpdate, entries[1].Action)
+		assert.Equal(t, 1, len(entries[1].Changes))
+	}
+}
+
+func conformUpdate(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	ctx := context.Background()
+
+	var before domain.Coupon
+	assert.Nil(t, repo.GetCouponByID(ctx, ids[0], nil, &before))
+
+	assert.Nil(t, repo.UpdateCoupon(ctx, ids[0], domain.APICoupon{Brand: &Brand, Value: &Value}))
+
+	var c domain.Coupon
+	assert.Nil(t, repo.GetCouponByID(ctx, ids[0], nil, &c))
+	assert.Equal(t, before.Name, c.Name)
+	assert.Equal(t, Brand, c.Brand)
+	assert.Equal(t, Value, c.Value)
+	assert.Equal(t, before.Expiry.Unix(), c.Expiry.Unix())
+	assert.Equal(t, before.CreatedAt.Unix(), c.CreatedAt.Unix())
+	assert.False(t, c.UpdatedAt.Before(before.UpdatedAt))
+}
+
+func conformFilters(t *testing.T, open opener) {
+	repo := open(t)
+	conformSeed(t, repo)
+	q := domain.CouponQuery{}
+
+	cases := []struct {
+		name     string
+		query    domain.CouponQuery
+		expected int
+	}{
+		{"all", q, 4},
+		{"equal", q.Where("name", domain.FilterEqual, name+"1"), 2},
+		{"in", q.Where("value", domain.FilterEqual, value, value*3), 2},
+		{"and", q.Where("name", domain.FilterEqual, name+"1").Where("brand", domain.FilterEqual, brand+"2"), 1},
+		{"noMatch", q.Where("brand", domain.FilterEqual, brand), 0},
+		{"lesserValue", q.Where("value", domain.FilterLess, value+1), 2},
+		{"greaterValue", q.Where("value", domain.FilterGreater, value), 2},
+		{"lesserExpiry", q.Where("expiry", domain.FilterLess, time.Unix(secs+1, 0)), 2},
+		{"greaterExpiry", q.Where("expiry", domain.FilterGreater, time.Unix(secs, 0)), 2},
+		{"expiryRange", q.Where("expiry", domain.FilterGreater, time.Unix(secs-1, 0)).Where("expiry", domain.FilterLess, time.Unix(secs+1, 0)), 2},
+		{"createdRange", q.Where("created_at", domain.FilterGreater, time.Now().Add(-time.Minute)).Where("created_at", domain.FilterLess, time.Now().Add(time.Minute)), 4},
+		{"iLike", q.Where("brand", domain.FilterILike, "BRAND1", "Brand3"), 2},
+		{"iLikeIsNotPrefix", q.Where("brand", domain.FilterILike, "BRAND"), 0},
+		{"prefix", q.Where("name", domain.FilterPrefix, "NA"), 4},
+		{"prefixEscapesWildcards", q.Where("name", domain.FilterPrefix, "n_me", "%"), 0},
+	}
+	for _, c := range cases {
+		coupons := conformQuery(t, repo, c.query)
+		assert.Equal(t, c.expected, len(coupons), c.name)
+	}
+}
+
+func conformPagination(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	q := domain.CouponQuery{}
+
+	pages := [][]uint{ids[0:3], ids[3:4], nil}
+	for i, expected := range pages {
+		var got []uint
+		for _, c := range conformQuery(t, repo, q.Paginate(3, uint(i+1))) {
+			got = append(got, c.ID)
+		}
+		assert.Equal(t, expected, got, "page %d", i+1)
+	}
+
+	coupons := conformQuery(t, repo, q.Where("name", domain.FilterEqual, name+"2").Paginate(1, 2))
+	assert.Equal(t, 1, len(coupons))
+	assert.Equal(t, ids[3], coupons[0].ID)
+}
+
+func conformExport(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	ctx := context.Background()
+
+	var got []uint
+	err := repo.ExportCoupons(ctx, func(c domain.Coupon) error {
+		got = append(got, c.ID)
+		return nil
+	}, domain.CouponQuery{}.Where("value", domain.FilterEqual, value))
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{ids[0], ids[2]}, got)
+
+	stop := errors.New("stop")
+	count := 0
+	err = repo.ExportCoupons(ctx, func(c domain.Coupon) error {
+		count++
+		return stop
+	}, domain.CouponQuery{})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, count)
+}
+
+func conformStats(t *testing.T, open opener) {
+	repo := open(t)
+	conformSeed(t, repo)
+	ctx := context.Background()
+
+	var stats []domain.CouponStats
+	assert.Nil(t, repo.CouponStats(ctx, &stats, nil, domain.CouponQuery{}))
+	assert.Equal(t, []domain.CouponStats{{Count: 4, Sum: value * 6, Min: value, Max: value * 2}}, stats)
+
+	stats = nil
+	assert.Nil(t, repo.CouponStats(ctx, &stats, nil, domain.CouponQuery{}.Where("brand", domain.FilterEqual, brand)))
+	assert.Equal(t, []domain.CouponStats{{}}, stats)
+
+	stats = nil
+	assert.Nil(t, repo.CouponStats(ctx, &stats, []string{domain.StatsGroupBrand, domain.StatsGroupExpiryMonth}, domain.CouponQuery{}.Where("name", domain.FilterEqual, name+"2")))
+	assert.Equal(t, 2, len(stats))
+	for i, s := range stats {
+		assert.Equal(t, fmt.Sprintf("%s%d", brand, i+1), *s.Brand)
+		assert.Equal(t, time.Unix(2*secs, 0).UTC().Format("2006-01"), *s.ExpiryMonth)
+		assert.Nil(t, s.Status)
+		assert.Equal(t, uint(1), s.Count)
+		assert.Equal(t, value*2, s.Sum)
+	}
+
+	// the page is ignored, half of the coupons expire in 2001 and the other half in 2033
+	stats = nil
+	assert.Nil(t, repo.CouponStats(ctx, &stats, []string{domain.StatsGroupStatus}, domain.CouponQuery{}.Paginate(1, 1)))
+	assert.Equal(t, 2, len(stats))
+	assert.Equal(t, domain.CouponStatusActive, *stats[0].Status)
+	assert.Equal(t, uint(2), stats[0].Count)
+	assert.Equal(t, domain.CouponStatusExpired, *stats[1].Status)
+	assert.Equal(t, uint(2), stats[1].Count)
+
+	assert.NotNil(t, repo.CouponStats(ctx, &stats, []string{"unknown"}, domain.CouponQuery{}))
+}
+
+func conformTxCommit(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	ctx := context.Background()
+
+	var created uint
+	err := repo.WithTx(ctx, func(tx domain.Repository) error {
+		var err error
+		if created, err = tx.NewCoupon(ctx, newAPICoupon()); err != nil {
+			return err
+		}
+		if err := tx.DeleteCoupon(ctx, ids[0]); err != nil {
+			return err
+		}
+		// the transaction reads its own writes
+		var c domain.Coupon
+		if err := tx.GetCouponByID(ctx, created, nil, &c); err != nil {
+			return err
+		}
+		return tx.UpdateCoupon(ctx, ids[1], domain.APICoupon{Name: &Name})
+	})
+	assert.Nil(t, err)
+
+	var c domain.Coupon
+	assert.Nil(t, repo.GetCouponByID(ctx, created, nil, &c))
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(ctx, ids[0], nil, &c))
+	assert.Nil(t, repo.GetCouponByID(ctx, ids[1], nil, &c))
+	assert.Equal(t, Name, c.Name)
+}
+
+func conformTxRollback(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	ctx := context.Background()
+	failure := errors.New("failure")
+
+	err := repo.WithTx(ctx, func(tx domain.Repository) error {
+		if _, err := tx.NewCoupon(ctx, newAPICoupon()); err != nil {
+			return err
+		}
+		if err := tx.UpdateCoupon(ctx, ids[0], domain.APICoupon{Name: &Name}); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(t, failure, err)
+
+	assert.Panics(t, func() {
+		repo.WithTx(ctx, func(tx domain.Repository) error {
+			tx.DeleteCoupon(ctx, ids[1])
+			panic("failure")
+		})
+	})
+
+	coupons := conformQuery(t, repo, domain.CouponQuery{})
+	assert.Equal(t, 4, len(coupons))
+	assert.Equal(t, name+"1", coupons[0].Name)
+
+	// the audit entries of the rolled back changes are rolled back with them
+	var entries []domain.AuditEntry
+	assert.Nil(t, repo.QueryAudit(ctx, &entries, domain.AuditQuery{}))
+	assert.Equal(t, 4, len(entries))
+}
+
+func conformTxSavepoint(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	ctx := context.Background()
+	failure := errors.New("failure")
+
+	err := repo.WithTx(ctx, func(tx domain.Repository) error {
+		if err := tx.DeleteCoupon(ctx, ids[0]); err != nil {
+			return err
+		}
+		// the failed savepoint only rolls back its own work
+		nestedErr := tx.WithTx(ctx, func(nested domain.Repository) error {
+			if err := nested.DeleteCoupon(ctx, ids[1]); err != nil {
+				return err
+			}
+			return failure
+		})
+		assert.Equal(t, failure, nestedErr)
+		return tx.WithTx(ctx, func(nested domain.Repository) error {
+			return nested.DeleteCoupon(ctx, ids[2])
+		})
+	})
+	assert.Nil(t, err)
+
+	coupons := conformQuery(t, repo, domain.CouponQuery{})
+	var got []uint
+	for _, c := range coupons {
+		got = append(got, c.ID)
+	}
+	assert.Equal(t, []uint{ids[1], ids[3]}, got)
+}
+
+func conformCanceledContext(t *testing.T, open opener) {
+	repo := open(t)
+	ids := conformSeed(t, repo)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var c domain.Coupon
+	assert.Equal(t, context.Canceled, repo.GetCouponByID(ctx, ids[0], nil, &c))
+	assert.Equal(t, context.Canceled, repo.DeleteCoupon(ctx, ids[0]))
+	var coupons []domain.Coupon
+	assert.Equal(t, context.Canceled, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}))
+	assert.Equal(t, context.Canceled, repo.WithTx(ctx, func(tx domain.Repository) error { return nil }))
+
+	// nothing was deleted
+	assert.Equal(t, 4, len(conformQuery(t, repo, domain.CouponQuery{})))
+}
+
+func conformPing(t *testing.T, open opener) {
+	repo := open(t)
+
+	assert.Nil(t, repo.Ping(context.Background()))
+	assert.Nil(t, repo.WithTx(context.Background(), func(tx domain.Repository) error {
+		return tx.Ping(context.Background())
+	}))
+}
+
+func conformInvalidQuery(t *testing.T, open opener) {
+	repo := open(t)
+	conformSeed(t, repo)
+	ctx := context.Background()
+	var coupons []domain.Coupon
+
+	assert.NotNil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}.Where("password", domain.FilterEqual, "x")))
+	assert.NotNil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}.Where("name", "regexp", "x")))
+	assert.NotNil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}.Where("name", domain.FilterEqual)))
+}
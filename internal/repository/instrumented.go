@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// repository operations reported to the Observer
+const (
+	opTx            = "tx"
+	opNewCoupon     = "new_coupon"
+	opGetCoupon     = "get_coupon"
+	opDeleteCoupon  = "delete_coupon"
+	opUpdateCoupon  = "update_coupon"
+	opQueryCoupons  = "query_coupons"
+	opExportCoupons = "export_coupons"
+	opCouponStats   = "coupon_stats"
+	opCouponHistory = "coupon_history"
+	opQueryAudit    = "query_audit"
+	opPing          = "ping"
+)
+
+// Observer records the duration and error of the repository calls
+type Observer interface {
+	ObserveQuery(op string, d time.Duration, err error)
+}
+
+// Instrumented is a domain.Repository reporting every call of the repository it wraps to an Observer
+// The repositories passed to the WithTx callbacks are instrumented as well, so the calls within a transaction
+// are reported along with the transaction itself
+type Instrumented struct {
+	repo     domain.Repository
+	observer Observer
+}
+
+// NewInstrumented is the Instrumented constructor
+func NewInstrumented(repo domain.Repository, observer Observer) *Instrumented {
+	return &Instrumented{repo: repo, observer: observer}
+}
+
+// WithTx reports the whole transaction, fn included
+func (ir *Instrumented) WithTx(ctx context.Context, fn func(tx domain.Repository) error) error {
+	start := time.Now()
+	err := ir.repo.WithTx(ctx, func(tx domain.Repository) error {
+		return fn(NewInstrumented(tx, ir.observer))
+	})
+	ir.observe(opTx, start, err)
+	return err
+}
+
+// NewCoupon reports the coupon creation
+func (ir *Instrumented) NewCoupon(ctx context.Context, APIc domain.APICoupon) (uint, error) {
+	start := time.Now()
+	id, err := ir.repo.NewCoupon(ctx, APIc)
+	ir.observe(opNewCoupon, start, err)
+	return id, err
+}
+
+// GetCouponByID reports the coupon read
+func (ir *Instrumented) GetCouponByID(ctx context.Context, id uint, columns []string, c *domain.Coupon) error {
+	start := time.Now()
+	err := ir.repo.GetCouponByID(ctx, id, columns, c)
+	ir.observe(opGetCoupon, start, err)
+	return err
+}
+
+// DeleteCoupon reports the coupon deletion
+func (ir *Instrumented) DeleteCoupon(ctx context.Context, id uint) error {
+	start := time.Now()
+	err := ir.repo.DeleteCoupon(ctx, id)
+	ir.observe(opDeleteCoupon, start, err)
+	return err
+}
+
+// UpdateCoupon reports the coupon update
+func (ir *Instrumented) UpdateCoupon(ctx context.Context, id uint, APIc domain.APICoupon) error {
+	start := time.Now()
+	err := ir.repo.UpdateCoupon(ctx, id, APIc)
+	ir.observe(opUpdateCoupon, start, err)
+	return err
+}
+
+// QueryCoupons reports the coupons query
+func (ir *Instrumented) QueryCoupons(ctx context.Context, coupons *[]domain.Coupon, q domain.CouponQuery) error {
+	start := time.Now()
+	err := ir.repo.QueryCoupons(ctx, coupons, q)
+	ir.observe(opQueryCoupons, start, err)
+	return err
+}
+
+// ExportCoupons reports the whole export, the time spent by fn writing the coupons included
+func (ir *Instrumented) ExportCoupons(ctx context.Context, fn func(c domain.Coupon) error, q domain.CouponQuery) error {
+	start := time.Now()
+	err := ir.repo.ExportCoupons(ctx, fn, q)
+	ir.observe(opExportCoupons, start, err)
+	return err
+}
+
+// CouponStats reports the coupons aggregation
+func (ir *Instrumented) CouponStats(ctx context.Context, stats *[]domain.CouponStats, groupBy []string, q domain.CouponQuery) error {
+	start := time.Now()
+	err := ir.repo.CouponStats(ctx, stats, groupBy, q)
+	ir.observe(opCouponStats, start, err)
+	return err
+}
+
+// CouponHistory reports the coupon history read
+func (ir *Instrumented) CouponHistory(ctx context.Context, id uint, entries *[]domain.AuditEntry) error {
+	start := time.Now()
+	err := ir.repo.CouponHistory(ctx, id, entries)
+	ir.observe(opCouponHistory, start, err)
+	return err
+}
+
+// QueryAudit reports the audit entries query
+func (ir *Instrumented) QueryAudit(ctx context.Context, entries *[]domain.AuditEntry, q domain.AuditQuery) error {
+	start := time.Now()
+	err := ir.repo.QueryAudit(ctx, entries, q)
+	ir.observe(opQueryAudit, start, err)
+	return err
+}
+
+// Ping reports the store ping
+func (ir *Instrumented) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := ir.repo.Ping(ctx)
+	ir.observe(opPing, start, err)
+	return err
+}
+
+func (ir *Instrumented) observe(op string, start time.Time, err error) {
+	ir.observer.ObserveQuery(op, time.Since(start), err)
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// call is a repository call reported to an observer
+type call struct {
+	op  string
+	err error
+}
+
+// recordingObserver records the reported calls in order
+type recordingObserver struct {
+	calls []call
+}
+
+func (o *recordingObserver) ObserveQuery(op string, d time.Duration, err error) {
+	o.calls = append(o.calls, call{op, err})
+}
+
+func TestInstrumented(t *testing.T) {
+	obs := &recordingObserver{}
+	repo := NewInstrumented(NewMemory(), obs)
+	ctx := context.Background()
+
+	id, err := repo.NewCoupon(ctx, newAPICoupon())
+	assert.Nil(t, err)
+	var c domain.Coupon
+	assert.Nil(t, repo.GetCouponByID(ctx, id, nil, &c))
+	assert.Nil(t, repo.UpdateCoupon(ctx, id, domain.APICoupon{Name: &Name}))
+	var coupons []domain.Coupon
+	assert.Nil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}))
+	assert.Nil(t, repo.ExportCoupons(ctx, func(c domain.Coupon) error { return nil }, domain.CouponQuery{}))
+	var stats []domain.CouponStats
+	assert.Nil(t, repo.CouponStats(ctx, &stats, nil, domain.CouponQuery{}))
+	assert.Nil(t, repo.DeleteCoupon(ctx, id))
+	notFound := repo.DeleteCoupon(ctx, id)
+	assert.IsType(t, domain.CouponNotFoundError{}, notFound)
+	var entries []domain.AuditEntry
+	assert.Nil(t, repo.CouponHistory(ctx, id, &entries))
+	assert.Nil(t, repo.QueryAudit(ctx, &entries, domain.AuditQuery{}))
+	assert.Nil(t, repo.Ping(ctx))
+
+	assert.Equal(t, []call{
+		{opNewCoupon, nil},
+		{opGetCoupon, nil},
+		{opUpdateCoupon, nil},
+		{opQueryCoupons, nil},
+		{opExportCoupons, nil},
+		{opCouponStats, nil},
+		{opDeleteCoupon, nil},
+		{opDeleteCoupon, notFound},
+		{opCouponHistory, nil},
+		{opQueryAudit, nil},
+		{opPing, nil},
+	}, obs.calls)
+}
+
+func TestInstrumentedTx(t *testing.T) {
+	obs := &recordingObserver{}
+	repo := NewInstrumented(NewMemory(), obs)
+	ctx := context.Background()
+
+	rollback := errors.New("rollback")
+	err := repo.WithTx(ctx, func(tx domain.Repository) error {
+		_, err := tx.NewCoupon(ctx, newAPICoupon())
+		assert.Nil(t, err)
+		return rollback
+	})
+	assert.Equal(t, rollback, err)
+
+	// the calls within the transaction are reported before the transaction itself
+	assert.Equal(t, []call{{opNewCoupon, nil}, {opTx, rollback}}, obs.calls)
+}
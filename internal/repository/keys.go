@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/gormtrace"
+	"github.com/jinzhu/gorm"
+)
+
+// apiKey is the api_keys record of a domain.APIKey, its scopes are stored space separated
+type apiKey struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	Name      string
+	Prefix    string
+	Hash      string
+	Scopes    string
+	TenantID  string
+	RevokedAt *time.Time
+}
+
+// TableName is the table of the records
+func (apiKey) TableName() string {
+	return "api_keys"
+}
+
+func newAPIKeyRecord(k domain.APIKey) apiKey {
+	return apiKey{Name: k.Name, Prefix: k.Prefix, Hash: k.Hash, Scopes: strings.Join(k.Scopes, " "), TenantID: k.Tenant}
+}
+
+func (r apiKey) toDomain() domain.APIKey {
+	return domain.APIKey{
+		ID:        r.ID,
+		CreatedAt: r.CreatedAt,
+		Name:      r.Name,
+		Prefix:    r.Prefix,
+		Hash:      r.Hash,
+		Scopes:    strings.Fields(r.Scopes),
+		Tenant:    r.TenantID,
+		RevokedAt: r.RevokedAt,
+	}
+}
+
+// GormKeyRepository is the domain.KeyRepository of the db
+// Keys are always read from the primary db, so a revoked key is refused right away
+type GormKeyRepository struct {
+	db       *gorm.DB
+	timeouts Timeouts
+}
+
+// NewKeys is the GormKeyRepository constructor, db is not closed by the repository
+func NewKeys(db *gorm.DB, timeouts Timeouts) *GormKeyRepository {
+	return &GormKeyRepository{db: db, timeouts: timeouts}
+}
+
+// NewAPIKey creates the key record and sets the ID and CreatedAt of k
+func (kr *GormKeyRepository) NewAPIKey(ctx context.Context, k *domain.APIKey) error {
+	ctx, cancel := withTimeout(ctx, kr.timeouts.Write)
+	defer cancel()
+
+	return ctxErr(ctx, createAPIKey(bind(ctx, kr.db), k))
+}
+
+// GetAPIKeyByID reads the key record with id
+// If there is no such record an APIKeyNotFoundError is returned
+func (kr *GormKeyRepository) GetAPIKeyByID(ctx context.Context, id uint, k *domain.APIKey) error {
+	ctx, cancel := withTimeout(ctx, kr.timeouts.Read)
+	defer cancel()
+
+	return ctxErr(ctx, firstAPIKey(keyScope(ctx, bind(ctx, kr.db)).Where("id = ?", id), k))
+}
+
+// GetAPIKeyByPrefix reads the key record with prefix
+// If there is no such record an APIKeyNotFoundError is returned
+func (kr *GormKeyRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string, k *domain.APIKey) error {
+	ctx, cancel := withTimeout(ctx, kr.timeouts.Read)
+	defer cancel()
+
+	return ctxErr(ctx, firstAPIKey(bind(ctx, kr.db).Where("prefix = ?", prefix), k))
+}
+
+// ListAPIKeys reads every key record of the tenant bound to the caller in id order
+func (kr *GormKeyRepository) ListAPIKeys(ctx context.Context, keys *[]domain.APIKey) error {
+	ctx, cancel := withTimeout(ctx, kr.timeouts.Read)
+	defer cancel()
+
+	var records []apiKey
+	if err := keyScope(ctx, bind(ctx, kr.db)).Order("id").Find(&records).Error; err != nil {
+		return ctxErr(ctx, err)
+	}
+	*keys = make([]domain.APIKey, len(records))
+	for i, r := range records {
+		(*keys)[i] = r.toDomain()
+	}
+	return nil
+}
+
+// RevokeAPIKey sets the revocation time of the key record with id
+// If there is no such record an APIKeyNotFoundError is returned
+func (kr *GormKeyRepository) RevokeAPIKey(ctx context.Context, id uint) error {
+	ctx, cancel := withTimeout(ctx, kr.timeouts.Write)
+	defer cancel()
+
+	db := keyScope(ctx, bind(ctx, kr.db))
+	if err := firstAPIKey(db.Where("id = ?", id), &domain.APIKey{}); err != nil {
+		return ctxErr(ctx, err)
+	}
+	return ctxErr(ctx, revokeAPIKey(db, id).Error)
+}
+
+// RotateAPIKey revokes the key record with id and creates k within a transaction
+// If there is no such record, or it is revoked already, an APIKeyNotFoundError is returned
+func (kr *GormKeyRepository) RotateAPIKey(ctx context.Context, id uint, k *domain.APIKey) error {
+	ctx, cancel := withTimeout(ctx, kr.timeouts.Write)
+	defer cancel()
+
+	tx := bind(ctx, kr.db).BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return ctxErr(ctx, tx.Error)
+	}
+	db := gormtrace.WithContext(ctx, tx)
+
+	revoked := revokeAPIKey(keyScope(ctx, db), id)
+	if revoked.Error != nil {
+		tx.Rollback()
+		return ctxErr(ctx, revoked.Error)
+	}
+	if revoked.RowsAffected == 0 {
+		tx.Rollback()
+		return domain.NewAPIKeyNotFoundError()
+	}
+	if err := createAPIKey(db, k); err != nil {
+		tx.Rollback()
+		return ctxErr(ctx, err)
+	}
+	return ctxErr(ctx, tx.Commit().Error)
+}
+
+// keyScope restricts db to the key records of the tenant bound to the caller of ctx, if any
+func keyScope(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tenant := domain.BoundTenant(ctx); tenant != "" {
+		return db.Where("tenant_id = ?", tenant)
+	}
+	return db
+}
+
+// firstAPIKey reads the first key record of db into k
+// If there is no such record an APIKeyNotFoundError is returned
+func firstAPIKey(db *gorm.DB, k *domain.APIKey) error {
+	var r apiKey
+	err := db.First(&r).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return domain.NewAPIKeyNotFoundError()
+	}
+	if err != nil {
+		return err
+	}
+	*k = r.toDomain()
+	return nil
+}
+
+// createAPIKey creates the record of k and sets its ID and CreatedAt
+func createAPIKey(db *gorm.DB, k *domain.APIKey) error {
+	r := newAPIKeyRecord(*k)
+	if err := db.Create(&r).Error; err != nil {
+		return err
+	}
+	k.ID, k.CreatedAt = r.ID, r.CreatedAt
+	return nil
+}
+
+// revokeAPIKey revokes the key record with id unless it is revoked already
+func revokeAPIKey(db *gorm.DB, id uint) *gorm.DB {
+	return db.Model(&apiKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryKeys runs the key repository conformance suite against MemoryKeyRepository
+func TestMemoryKeys(t *testing.T) {
+	testKeyConformance(t, func(t *testing.T) domain.KeyRepository { return NewMemoryKeys() })
+}
+
+// TestSQLiteKeys runs the key repository conformance suite against GormKeyRepository on sqlite
+func TestSQLiteKeys(t *testing.T) {
+	testKeyConformance(t, func(t *testing.T) domain.KeyRepository {
+		repo := startSQLite(t)
+		t.Cleanup(repo.Close)
+		return NewKeys(repo.db, Timeouts{})
+	})
+}
+
+// testKeyConformance runs the behaviour every domain.KeyRepository implementation must share
+func testKeyConformance(t *testing.T, open func(t *testing.T) domain.KeyRepository) {
+	ctx := context.Background()
+	newKey := func(prefix string) domain.APIKey {
+		return domain.APIKey{Name: "ci", Prefix: prefix, Hash: "hash-" + prefix, Scopes: []string{domain.ScopeCouponsRead, domain.ScopeCouponsWrite}}
+	}
+
+	t.Run("create", func(t *testing.T) {
+		repo := open(t)
+		k := newKey("aaaa")
+		k.Tenant = "acme"
+		assert.Nil(t, repo.NewAPIKey(ctx, &k))
+		assert.NotZero(t, k.ID)
+		assert.False(t, k.CreatedAt.IsZero())
+
+		var read domain.APIKey
+		assert.Nil(t, repo.GetAPIKeyByPrefix(ctx, "aaaa", &read))
+		assert.Equal(t, k.ID, read.ID)
+		assert.Equal(t, "hash-aaaa", read.Hash)
+		assert.Equal(t, k.Scopes, read.Scopes)
+		assert.Equal(t, "acme", read.Tenant)
+		assert.Nil(t, read.RevokedAt)
+
+		assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.GetAPIKeyByPrefix(ctx, "bbbb", &read))
+
+		var byID domain.APIKey
+		assert.Nil(t, repo.GetAPIKeyByID(ctx, k.ID, &byID))
+		assert.Equal(t, read, byID)
+		assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.GetAPIKeyByID(ctx, k.ID+1, &byID))
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		repo := open(t)
+		k := newKey("aaaa")
+		assert.Nil(t, repo.NewAPIKey(ctx, &k))
+
+		assert.Nil(t, repo.RevokeAPIKey(ctx, k.ID))
+		// revoking twice is a no-op
+		assert.Nil(t, repo.RevokeAPIKey(ctx, k.ID))
+		assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.RevokeAPIKey(ctx, k.ID+1))
+
+		var read domain.APIKey
+		assert.Nil(t, repo.GetAPIKeyByPrefix(ctx, "aaaa", &read))
+		assert.NotNil(t, read.RevokedAt)
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		repo := open(t)
+		old := newKey("aaaa")
+		assert.Nil(t, repo.NewAPIKey(ctx, &old))
+
+		rotated := newKey("bbbb")
+		assert.Nil(t, repo.RotateAPIKey(ctx, old.ID, &rotated))
+		assert.NotEqual(t, old.ID, rotated.ID)
+
+		var keys []domain.APIKey
+		assert.Nil(t, repo.ListAPIKeys(ctx, &keys))
+		if assert.Equal(t, 2, len(keys)) {
+			assert.Equal(t, old.ID, keys[0].ID)
+			assert.NotNil(t, keys[0].RevokedAt)
+			assert.Equal(t, rotated.ID, keys[1].ID)
+			assert.Nil(t, keys[1].RevokedAt)
+		}
+
+		// a revoked key is not rotated again, nothing is created
+		again := newKey("cccc")
+		assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.RotateAPIKey(ctx, old.ID, &again))
+		assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.GetAPIKeyByPrefix(ctx, "cccc", &domain.APIKey{}))
+	})
+
+	t.Run("boundTenant", func(t *testing.T) {
+		repo := open(t)
+		acme := domain.WithPrincipal(ctx, domain.Principal{Tenant: "acme"})
+		own, other, global := newKey("aaaa"), newKey("bbbb"), newKey("cccc")
+		own.Tenant, other.Tenant = "acme", "globex"
+		for _, k := range []*domain.APIKey{&own, &other, &global} {
+			assert.Nil(t, repo.NewAPIKey(ctx, k))
+		}
+
+		var keys []domain.APIKey
+		assert.Nil(t, repo.ListAPIKeys(acme, &keys))
+		if assert.Equal(t, 1, len(keys)) {
+			assert.Equal(t, own.ID, keys[0].ID)
+		}
+		assert.Nil(t, repo.ListAPIKeys(ctx, &keys))
+		assert.Equal(t, 3, len(keys))
+
+		// the keys of the other tenants, or of every tenant, are not found
+		for _, id := range []uint{other.ID, global.ID} {
+			assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.GetAPIKeyByID(acme, id, &domain.APIKey{}))
+			assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.RevokeAPIKey(acme, id))
+			rotated := newKey("dddd")
+			assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.RotateAPIKey(acme, id, &rotated))
+		}
+		var read domain.APIKey
+		assert.Nil(t, repo.GetAPIKeyByPrefix(acme, "bbbb", &read))
+		assert.Nil(t, read.RevokedAt)
+		assert.Equal(t, domain.NewAPIKeyNotFoundError(), repo.GetAPIKeyByPrefix(ctx, "dddd", &read))
+
+		assert.Nil(t, repo.RevokeAPIKey(acme, own.ID))
+	})
+
+	t.Run("canceledContext", func(t *testing.T) {
+		repo := open(t)
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		k := newKey("aaaa")
+		assert.Equal(t, context.Canceled, repo.NewAPIKey(canceled, &k))
+		assert.Equal(t, context.Canceled, repo.ListAPIKeys(canceled, &[]domain.APIKey{}))
+	})
+}
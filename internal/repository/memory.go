@@ -0,0 +1,594 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// MemoryRepository is a repository keeping the coupons in memory, for tests and local development
+// It behaves like GormRepository: coupons are soft deleted, queries support the same filters and pages,
+// transactions and their savepoints are isolated until they are committed, every coupon belongs to the tenant of the
+// context which created it, see domain.TenantFrom, and every change is recorded by an audit entry and by an event
+// of the webhooks outbox.
+// It is safe for concurrent use
+type MemoryRepository struct {
+	mu      sync.RWMutex
+	coupons map[uint]domain.Coupon
+	// lastID is shared with the transactions, ids are never reused even if a transaction is rolled back
+	lastID *uint64
+	// dirty holds the ids written by a transaction, nil if the repository is not a transaction
+	dirty map[uint]bool
+	// audit holds the audit entries in the order they were recorded, their ids are shared with the transactions
+	audit       []domain.AuditEntry
+	lastAuditID *uint64
+	// events is the outbox of the webhook events, taken by MemoryWebhookRepository.FanOut
+	events      []domain.Event
+	lastEventID *uint64
+	// notified holds the expiry of the coupons whose expired event was written, see expireCoupons
+	notified map[uint]time.Time
+}
+
+// NewMemory is the MemoryRepository constructor
+func NewMemory() *MemoryRepository {
+	return &MemoryRepository{
+		coupons:     make(map[uint]domain.Coupon),
+		lastID:      new(uint64),
+		lastAuditID: new(uint64),
+		lastEventID: new(uint64),
+		notified:    make(map[uint]time.Time),
+	}
+}
+
+// Close is a no-op, it lets MemoryRepository be used in place of GormRepository
+func (mr *MemoryRepository) Close() {}
+
+// WithTx runs fn with a MemoryRepository bound to a transaction
+// fn works on a copy of the coupons, its writes are applied if it returns nil and discarded if it returns an error or panics.
+// Calling WithTx on the repository passed to fn nests another copy, which behaves like a savepoint
+func (mr *MemoryRepository) WithTx(ctx context.Context, fn func(tx domain.Repository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mr.mu.RLock()
+	tx := &MemoryRepository{
+		coupons:     make(map[uint]domain.Coupon, len(mr.coupons)),
+		lastID:      mr.lastID,
+		dirty:       make(map[uint]bool),
+		audit:       append([]domain.AuditEntry(nil), mr.audit...),
+		lastAuditID: mr.lastAuditID,
+		events:      append([]domain.Event(nil), mr.events...),
+		lastEventID: mr.lastEventID,
+	}
+	for id, c := range mr.coupons {
+		tx.coupons[id] = c
+	}
+	// the entries and events recorded by fn are those past the copied ones
+	recorded, recordedEvents := len(tx.audit), len(tx.events)
+	mr.mu.RUnlock()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	for id := range tx.dirty {
+		mr.write(tx.coupons[id])
+	}
+	mr.audit = append(mr.audit, tx.audit[recorded:]...)
+	mr.events = append(mr.events, tx.events[recordedEvents:]...)
+	return nil
+}
+
+// NewCoupon creates a new coupon record and returns its ID
+func (mr *MemoryRepository) NewCoupon(ctx context.Context, APIc domain.APICoupon) (uint, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	c := domain.NewCoupon(APIc)
+	c.TenantID = domain.TenantFrom(ctx)
+	c.ID = uint(atomic.AddUint64(mr.lastID, 1))
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	mr.write(c)
+	mr.record(domain.NewAuditEntry(ctx, domain.AuditCreate, nil, &c))
+	return c.ID, nil
+}
+
+// GetCouponByID gets a coupon according to the ID
+// If columns is not empty only those columns are read, it must include the id column
+// If there is no record with the given ID a CouponNotFoundError is returned
+func (mr *MemoryRepository) GetCouponByID(ctx context.Context, id uint, columns []string, c *domain.Coupon) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+	found, err := mr.first(domain.TenantFrom(ctx), id)
+	if err != nil {
+		return err
+	}
+	*c = selectColumns(found, columns)
+	return nil
+}
+
+// DeleteCoupon soft deletes the coupon record with the given ID
+// If there is no record with the given ID a CouponNotFoundError is returned
+func (mr *MemoryRepository) DeleteCoupon(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	c, err := mr.first(domain.TenantFrom(ctx), id)
+	if err != nil {
+		return err
+	}
+	mr.record(domain.NewAuditEntry(ctx, domain.AuditDelete, &c, nil))
+	now := time.Now()
+	c.DeletedAt = &now
+	mr.write(c)
+	return nil
+}
+
+// UpdateCoupon updates a coupon record with a given ID
+// Only the name, brand, value and expiry can be changed
+// If there is no record with the given ID a CouponNotFoundError is returned
+func (mr *MemoryRepository) UpdateCoupon(ctx context.Context, id uint, APIc domain.APICoupon) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+	before, err := mr.first(domain.TenantFrom(ctx), id)
+	if err != nil {
+		return err
+	}
+	c := domain.UpdateCoupon(before, APIc)
+	c.UpdatedAt = time.Now()
+	mr.write(c)
+	mr.record(domain.NewAuditEntry(ctx, domain.AuditUpdate, &before, &c))
+	return nil
+}
+
+// QueryCoupons reads the coupon records matching the query in id order
+func (mr *MemoryRepository) QueryCoupons(ctx context.Context, coupons *[]domain.Coupon, q domain.CouponQuery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	matches, err := mr.find(domain.TenantFrom(ctx), q)
+	if err != nil {
+		return err
+	}
+	*coupons = matches
+	return nil
+}
+
+// ExportCoupons calls fn for every coupon record matching the query in id order
+// The matching coupons are read before fn is first called, so fn may use the repository.
+// If fn returns an error the export stops and the error is returned
+func (mr *MemoryRepository) ExportCoupons(ctx context.Context, fn func(c domain.Coupon) error, q domain.CouponQuery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	matches, err := mr.find(domain.TenantFrom(ctx), q)
+	if err != nil {
+		return err
+	}
+	for _, c := range matches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CouponStats aggregates the coupon records matching the query
+// The count, sum, min and max of the coupons value are computed for every group in groupBy,
+// which holds domain.StatsGroup... values. Without groups a single row is returned.
+// The selected columns and page of the query are ignored
+func (mr *MemoryRepository) CouponStats(ctx context.Context, stats *[]domain.CouponStats, groupBy []string, q domain.CouponQuery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, g := range groupBy {
+		if !domain.IsStatsGroup(g) {
+			return fmt.Errorf("unknown stats group: %s", g)
+		}
+	}
+
+	matches, err := mr.find(domain.TenantFrom(ctx), q.Select(nil).Paginate(0, 0))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	groups := make(map[string]*domain.CouponStats)
+	var keys []string
+	for _, c := range matches {
+		var s domain.CouponStats
+		for _, g := range groupBy {
+			switch g {
+			case domain.StatsGroupBrand:
+				brand := c.Brand
+				s.Brand = &brand
+			case domain.StatsGroupStatus:
+				status := domain.CouponStatusActive
+				if c.Expiry.Before(now) {
+					status = domain.CouponStatusExpired
+				}
+				s.Status = &status
+			case domain.StatsGroupExpiryMonth:
+				month := c.Expiry.UTC().Format("2006-01")
+				s.ExpiryMonth = &month
+			}
+		}
+
+		key := statsKey(s, groupBy)
+		group, ok := groups[key]
+		if !ok {
+			group = &s
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		if group.Count == 0 || c.Value < group.Min {
+			group.Min = c.Value
+		}
+		if c.Value > group.Max {
+			group.Max = c.Value
+		}
+		group.Count++
+		group.Sum += c.Value
+	}
+
+	if len(groupBy) == 0 && len(keys) == 0 {
+		*stats = []domain.CouponStats{{}}
+		return nil
+	}
+
+	sort.Strings(keys)
+	result := make([]domain.CouponStats, len(keys))
+	for i, k := range keys {
+		result[i] = *groups[k]
+	}
+	*stats = result
+	return nil
+}
+
+// CouponHistory reads the audit entries of the coupon with id in id order, those of a deleted coupon included
+// If the coupon has no entry a CouponNotFoundError is returned
+func (mr *MemoryRepository) CouponHistory(ctx context.Context, id uint, entries *[]domain.AuditEntry) error {
+	if err := mr.QueryAudit(ctx, entries, domain.AuditQuery{CouponID: id}); err != nil {
+		return err
+	}
+	if len(*entries) == 0 {
+		return domain.NewCouponNotFoundError()
+	}
+	return nil
+}
+
+// QueryAudit reads the audit entries matching q in id order
+func (mr *MemoryRepository) QueryAudit(ctx context.Context, entries *[]domain.AuditEntry, q domain.AuditQuery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tenant := domain.TenantFrom(ctx)
+
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	var matches []domain.AuditEntry
+	for _, e := range mr.audit {
+		if e.TenantID == tenant && matchAudit(e, q) {
+			matches = append(matches, copyEntry(e))
+		}
+	}
+	// the entries of a transaction are appended on commit, after those recorded meanwhile
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+
+	if q.Limit > 0 {
+		offset := q.Offset
+		if offset > uint(len(matches)) {
+			offset = uint(len(matches))
+		}
+		end := offset + q.Limit
+		if end > uint(len(matches)) {
+			end = uint(len(matches))
+		}
+		matches = matches[offset:end]
+	}
+	*entries = append(make([]domain.AuditEntry, 0, len(matches)), matches...)
+	return nil
+}
+
+// Ping always succeeds unless ctx is done, the coupons are in memory
+func (mr *MemoryRepository) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// find returns the coupon records of tenant matching the query in id order
+func (mr *MemoryRepository) find(tenant string, q domain.CouponQuery) ([]domain.Coupon, error) {
+	if err := checkQuery(q); err != nil {
+		return nil, err
+	}
+	filters := q.Filters()
+
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	ids := make([]uint, 0, len(mr.coupons))
+	for id, c := range mr.coupons {
+		if c.DeletedAt != nil || c.TenantID != tenant {
+			continue
+		}
+		ok, err := matchFilters(c, filters)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	if limit := q.Limit(); limit > 0 {
+		offset := q.Offset()
+		if offset > uint(len(ids)) {
+			offset = uint(len(ids))
+		}
+		end := offset + limit
+		if end > uint(len(ids)) {
+			end = uint(len(ids))
+		}
+		ids = ids[offset:end]
+	}
+
+	columns := q.Columns()
+	coupons := make([]domain.Coupon, len(ids))
+	for i, id := range ids {
+		coupons[i] = selectColumns(mr.coupons[id], columns)
+	}
+	return coupons, nil
+}
+
+// first returns the coupon record of tenant with the given ID, mr.mu must be held
+// If there is no such record, or it was deleted, a CouponNotFoundError is returned
+func (mr *MemoryRepository) first(tenant string, id uint) (domain.Coupon, error) {
+	c, ok := mr.coupons[id]
+	if !ok || c.DeletedAt != nil || c.TenantID != tenant {
+		return domain.Coupon{}, domain.NewCouponNotFoundError()
+	}
+	return c, nil
+}
+
+// record stores the audit entry e with the next id and its event in the outbox, mr.mu must be held for writing
+func (mr *MemoryRepository) record(e domain.AuditEntry) {
+	e.ID = uint(atomic.AddUint64(mr.lastAuditID, 1))
+	e.CreatedAt = time.Now()
+	mr.audit = append(mr.audit, e)
+
+	event := domain.NewEvent(copyEntry(e))
+	event.ID = uint(atomic.AddUint64(mr.lastEventID, 1))
+	event.CreatedAt = e.CreatedAt
+	mr.events = append(mr.events, event)
+}
+
+// expireCoupons writes the expired event of up to limit coupons whose expiry passed at now to the outbox, the
+// earliest expiry first, unless their current expiry was notified already. It returns the number of coupons expired
+func (mr *MemoryRepository) expireCoupons(now time.Time, limit int) int {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	var expired []domain.Coupon
+	for _, c := range mr.coupons {
+		notified, ok := mr.notified[c.ID]
+		if c.DeletedAt == nil && !c.Expiry.IsZero() && !c.Expiry.After(now) && !(ok && notified.Equal(c.Expiry)) {
+			expired = append(expired, c)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].Expiry.Equal(expired[j].Expiry) {
+			return expired[i].Expiry.Before(expired[j].Expiry)
+		}
+		return expired[i].ID < expired[j].ID
+	})
+	if limit < len(expired) {
+		expired = expired[:limit]
+	}
+
+	for _, c := range expired {
+		mr.notified[c.ID] = c.Expiry
+		event := domain.NewExpiredEvent(c)
+		event.ID = uint(atomic.AddUint64(mr.lastEventID, 1))
+		event.CreatedAt = now
+		mr.events = append(mr.events, event)
+	}
+	return len(expired)
+}
+
+// takeEvents removes up to limit events from the outbox, oldest first, and returns them
+func (mr *MemoryRepository) takeEvents(limit int) []domain.Event {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if limit > len(mr.events) {
+		limit = len(mr.events)
+	}
+	taken := append([]domain.Event(nil), mr.events[:limit]...)
+	mr.events = mr.events[limit:]
+	return taken
+}
+
+// matchAudit reports whether e matches q, as the sql conditions of GormRepository would
+func matchAudit(e domain.AuditEntry, q domain.AuditQuery) bool {
+	return (q.Actor == "" || e.Actor == q.Actor) &&
+		(q.Action == "" || e.Action == q.Action) &&
+		(q.CouponID == 0 || e.CouponID == q.CouponID) &&
+		(q.RequestID == "" || e.RequestID == q.RequestID) &&
+		(q.From.IsZero() || !e.CreatedAt.Before(q.From)) &&
+		(q.To.IsZero() || !e.CreatedAt.After(q.To))
+}
+
+// copyEntry returns a copy of e sharing none of its changes
+func copyEntry(e domain.AuditEntry) domain.AuditEntry {
+	changes := make(map[string]domain.AuditChange, len(e.Changes))
+	for f, c := range e.Changes {
+		changes[f] = c
+	}
+	e.Changes = changes
+	return e
+}
+
+// write stores c, mr.mu must be held for writing
+func (mr *MemoryRepository) write(c domain.Coupon) {
+	mr.coupons[c.ID] = c
+	if mr.dirty != nil {
+		mr.dirty[c.ID] = true
+	}
+}
+
+// matchFilters reports whether c matches every filter
+func matchFilters(c domain.Coupon, filters []domain.CouponFilter) (bool, error) {
+	for _, f := range filters {
+		ok, err := matchFilter(c, f)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchFilter reports whether c matches the filter, as the sql conditions of GormRepository would
+func matchFilter(c domain.Coupon, f domain.CouponFilter) (bool, error) {
+	value := domain.CouponFieldValue(c, f.Column)
+	switch f.Op {
+	case domain.FilterEqual:
+		for _, v := range f.Values {
+			cmp, err := compare(value, v)
+			if err != nil {
+				return false, err
+			}
+			if cmp == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case domain.FilterLess, domain.FilterGreater:
+		cmp, err := compare(value, f.Values[0])
+		if err != nil {
+			return false, err
+		}
+		if f.Op == domain.FilterLess {
+			return cmp < 0, nil
+		}
+		return cmp > 0, nil
+	case domain.FilterILike, domain.FilterPrefix:
+		s := strings.ToLower(fmt.Sprint(value))
+		for _, v := range f.Values {
+			pattern := strings.ToLower(fmt.Sprint(v))
+			if s == pattern || f.Op == domain.FilterPrefix && strings.HasPrefix(s, pattern) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown filter operator: %s", f.Op)
+}
+
+// compare returns -1, 0 or 1 if the column value a is lesser than, equal to or greater than the filter value b
+func compare(a, b interface{}) (int, error) {
+	switch av := a.(type) {
+	case time.Time:
+		bv, ok := b.(time.Time)
+		if !ok {
+			return 0, fmt.Errorf("invalid time value: %v", b)
+		}
+		switch {
+		case av.Before(bv):
+			return -1, nil
+		case av.After(bv):
+			return 1, nil
+		}
+		return 0, nil
+	case uint:
+		bv, err := strconv.ParseUint(fmt.Sprint(b), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer value: %v", b)
+		}
+		switch {
+		case uint64(av) < bv:
+			return -1, nil
+		case uint64(av) > bv:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)), nil
+}
+
+// selectColumns returns a copy of c only holding the given columns, or c itself if columns is empty
+func selectColumns(c domain.Coupon, columns []string) domain.Coupon {
+	if len(columns) == 0 {
+		return c
+	}
+	var selected domain.Coupon
+	for _, column := range columns {
+		switch column {
+		case "id":
+			selected.ID = c.ID
+		case "created_at":
+			selected.CreatedAt = c.CreatedAt
+		case "updated_at":
+			selected.UpdatedAt = c.UpdatedAt
+		case "name":
+			selected.Name = c.Name
+		case "brand":
+			selected.Brand = c.Brand
+		case "value":
+			selected.Value = c.Value
+		case "expiry":
+			selected.Expiry = c.Expiry
+		}
+	}
+	return selected
+}
+
+// statsKey returns the sort key of a stats group, ordered by the groups in groupBy
+func statsKey(s domain.CouponStats, groupBy []string) string {
+	parts := make([]string, len(groupBy))
+	for i, g := range groupBy {
+		switch g {
+		case domain.StatsGroupBrand:
+			parts[i] = *s.Brand
+		case domain.StatsGroupStatus:
+			parts[i] = *s.Status
+		case domain.StatsGroupExpiryMonth:
+			parts[i] = *s.ExpiryMonth
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
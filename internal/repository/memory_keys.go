@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// MemoryKeyRepository is a domain.KeyRepository keeping the keys in memory, for tests and local development
+// It is safe for concurrent use
+type MemoryKeyRepository struct {
+	mu     sync.RWMutex
+	keys   []domain.APIKey
+	lastID uint
+}
+
+// NewMemoryKeys is the MemoryKeyRepository constructor
+func NewMemoryKeys() *MemoryKeyRepository {
+	return &MemoryKeyRepository{}
+}
+
+// NewAPIKey creates the key and sets the ID and CreatedAt of k
+func (mk *MemoryKeyRepository) NewAPIKey(ctx context.Context, k *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	mk.create(k)
+	return nil
+}
+
+// GetAPIKeyByID reads the key with id
+// If there is no such key an APIKeyNotFoundError is returned
+func (mk *MemoryKeyRepository) GetAPIKeyByID(ctx context.Context, id uint, k *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mk.mu.RLock()
+	defer mk.mu.RUnlock()
+	i := mk.index(ctx, id)
+	if i < 0 {
+		return domain.NewAPIKeyNotFoundError()
+	}
+	*k = copyKey(mk.keys[i])
+	return nil
+}
+
+// GetAPIKeyByPrefix reads the key with prefix
+// If there is no such key an APIKeyNotFoundError is returned
+func (mk *MemoryKeyRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string, k *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mk.mu.RLock()
+	defer mk.mu.RUnlock()
+	for _, key := range mk.keys {
+		if key.Prefix == prefix {
+			*k = copyKey(key)
+			return nil
+		}
+	}
+	return domain.NewAPIKeyNotFoundError()
+}
+
+// ListAPIKeys reads every key of the tenant bound to the caller in id order
+func (mk *MemoryKeyRepository) ListAPIKeys(ctx context.Context, keys *[]domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mk.mu.RLock()
+	defer mk.mu.RUnlock()
+	*keys = []domain.APIKey{}
+	for _, k := range mk.keys {
+		if inScope(ctx, k) {
+			*keys = append(*keys, copyKey(k))
+		}
+	}
+	return nil
+}
+
+// RevokeAPIKey sets the revocation time of the key with id
+// If there is no such key an APIKeyNotFoundError is returned
+func (mk *MemoryKeyRepository) RevokeAPIKey(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	i := mk.index(ctx, id)
+	if i < 0 {
+		return domain.NewAPIKeyNotFoundError()
+	}
+	mk.revoke(i)
+	return nil
+}
+
+// RotateAPIKey revokes the key with id and creates k
+// If there is no such key, or it is revoked already, an APIKeyNotFoundError is returned
+func (mk *MemoryKeyRepository) RotateAPIKey(ctx context.Context, id uint, k *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	i := mk.index(ctx, id)
+	if i < 0 || mk.keys[i].RevokedAt != nil {
+		return domain.NewAPIKeyNotFoundError()
+	}
+	mk.revoke(i)
+	mk.create(k)
+	return nil
+}
+
+// create stores k with the next id, mk must be locked
+func (mk *MemoryKeyRepository) create(k *domain.APIKey) {
+	mk.lastID++
+	k.ID, k.CreatedAt, k.RevokedAt = mk.lastID, time.Now(), nil
+	mk.keys = append(mk.keys, copyKey(*k))
+}
+
+// revoke revokes the key at index i unless it is revoked already, mk must be locked
+func (mk *MemoryKeyRepository) revoke(i int) {
+	if mk.keys[i].RevokedAt == nil {
+		now := time.Now()
+		mk.keys[i].RevokedAt = &now
+	}
+}
+
+// index returns the index of the key with id in the scope of ctx, -1 if there is none
+func (mk *MemoryKeyRepository) index(ctx context.Context, id uint) int {
+	for i, k := range mk.keys {
+		if k.ID == id && inScope(ctx, k) {
+			return i
+		}
+	}
+	return -1
+}
+
+// inScope reports whether k is a key of the tenant bound to the caller of ctx, every key is if it is bound to none
+func inScope(ctx context.Context, k domain.APIKey) bool {
+	tenant := domain.BoundTenant(ctx)
+	return tenant == "" || k.Tenant == tenant
+}
+
+// copyKey returns a copy of k sharing none of its scopes and revocation time
+func copyKey(k domain.APIKey) domain.APIKey {
+	k.Scopes = append([]string(nil), k.Scopes...)
+	if k.RevokedAt != nil {
+		revokedAt := *k.RevokedAt
+		k.RevokedAt = &revokedAt
+	}
+	return k
+}
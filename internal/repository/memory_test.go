@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryConformance runs the shared repository conformance suite against MemoryRepository
+func TestMemoryConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) domain.Repository { return NewMemory() })
+}
+
+// TestMemoryConcurrent creates, updates and reads coupons from concurrent goroutines and transactions. Run it with -race
+func TestMemoryConcurrent(t *testing.T) {
+	repo := NewMemory()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			create := func(r domain.Repository) error {
+				id, err := r.NewCoupon(ctx, newAPICoupon())
+				if err != nil {
+					return err
+				}
+				return r.UpdateCoupon(ctx, id, domain.APICoupon{Name: &Name})
+			}
+			var err error
+			if i%2 == 0 {
+				err = repo.WithTx(ctx, create)
+			} else {
+				err = create(repo)
+			}
+			assert.Nil(t, err)
+
+			var coupons []domain.Coupon
+			assert.Nil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}.Where("name", domain.FilterEqual, Name)))
+		}(i)
+	}
+	wg.Wait()
+
+	var coupons []domain.Coupon
+	assert.Nil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}))
+	assert.Equal(t, 50, len(coupons))
+	for i, c := range coupons {
+		assert.Equal(t, uint(i+1), c.ID)
+	}
+}
@@ -0,0 +1,291 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// MemoryWebhookRepository is a domain.WebhookRepository keeping the webhooks and their deliveries in memory,
+// for tests and local development. Its outbox is the one of the MemoryRepository of the coupons
+// It is safe for concurrent use
+type MemoryWebhookRepository struct {
+	mu             sync.RWMutex
+	coupons        *MemoryRepository
+	webhooks       []domain.Webhook
+	deliveries     []domain.Delivery
+	lastID         uint
+	lastDeliveryID uint
+}
+
+// NewMemoryWebhooks is the MemoryWebhookRepository constructor, it delivers the events of coupons
+func NewMemoryWebhooks(coupons *MemoryRepository) *MemoryWebhookRepository {
+	return &MemoryWebhookRepository{coupons: coupons}
+}
+
+// NewWebhook creates the webhook of the tenant of ctx and sets the ID, CreatedAt and tenant of w
+func (mw *MemoryWebhookRepository) NewWebhook(ctx context.Context, w *domain.Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.lastID++
+	w.ID, w.CreatedAt, w.TenantID = mw.lastID, time.Now(), domain.TenantFrom(ctx)
+	mw.webhooks = append(mw.webhooks, copyWebhook(*w))
+	return nil
+}
+
+// GetWebhookByID reads the webhook with id
+// If there is no such webhook a WebhookNotFoundError is returned
+func (mw *MemoryWebhookRepository) GetWebhookByID(ctx context.Context, id uint, w *domain.Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mw.mu.RLock()
+	defer mw.mu.RUnlock()
+	i := mw.index(domain.TenantFrom(ctx), id)
+	if i < 0 {
+		return domain.NewWebhookNotFoundError()
+	}
+	*w = copyWebhook(mw.webhooks[i])
+	return nil
+}
+
+// ListWebhooks reads every webhook in id order
+func (mw *MemoryWebhookRepository) ListWebhooks(ctx context.Context, webhooks *[]domain.Webhook) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tenant := domain.TenantFrom(ctx)
+
+	mw.mu.RLock()
+	defer mw.mu.RUnlock()
+	*webhooks = []domain.Webhook{}
+	for _, w := range mw.webhooks {
+		if w.TenantID == tenant {
+			*webhooks = append(*webhooks, copyWebhook(w))
+		}
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook with id and its deliveries
+// If there is no such webhook a WebhookNotFoundError is returned
+func (mw *MemoryWebhookRepository) DeleteWebhook(ctx context.Context, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	i := mw.index(domain.TenantFrom(ctx), id)
+	if i < 0 {
+		return domain.NewWebhookNotFoundError()
+	}
+	mw.webhooks = append(mw.webhooks[:i:i], mw.webhooks[i+1:]...)
+	kept := mw.deliveries[:0:0]
+	for _, d := range mw.deliveries {
+		if d.WebhookID != id {
+			kept = append(kept, d)
+		}
+	}
+	mw.deliveries = kept
+	return nil
+}
+
+// QueryDeliveries reads the deliveries of the webhook with webhookID matching q in reverse id order
+// If there is no such webhook a WebhookNotFoundError is returned
+func (mw *MemoryWebhookRepository) QueryDeliveries(ctx context.Context, webhookID uint, deliveries *[]domain.Delivery, q domain.DeliveryQuery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mw.mu.RLock()
+	defer mw.mu.RUnlock()
+	if mw.index(domain.TenantFrom(ctx), webhookID) < 0 {
+		return domain.NewWebhookNotFoundError()
+	}
+	var matches []domain.Delivery
+	for i := len(mw.deliveries) - 1; i >= 0; i-- {
+		d := mw.deliveries[i]
+		if d.WebhookID == webhookID && (q.Status == "" || d.Status == q.Status) {
+			matches = append(matches, copyDelivery(d))
+		}
+	}
+
+	if q.Limit > 0 {
+		offset := q.Offset
+		if offset > uint(len(matches)) {
+			offset = uint(len(matches))
+		}
+		end := offset + q.Limit
+		if end > uint(len(matches)) {
+			end = uint(len(matches))
+		}
+		matches = matches[offset:end]
+	}
+	*deliveries = append(make([]domain.Delivery, 0, len(matches)), matches...)
+	return nil
+}
+
+// Redeliver sets the delivery with id of the webhook with webhookID pending, without attempts and due now
+// If there is no such webhook a WebhookNotFoundError is returned, and a DeliveryNotFoundError if there is no such delivery
+func (mw *MemoryWebhookRepository) Redeliver(ctx context.Context, webhookID, id uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if mw.index(domain.TenantFrom(ctx), webhookID) < 0 {
+		return domain.NewWebhookNotFoundError()
+	}
+	i := mw.deliveryIndex(id)
+	if i < 0 || mw.deliveries[i].WebhookID != webhookID {
+		return domain.NewDeliveryNotFoundError()
+	}
+	d := &mw.deliveries[i]
+	d.Status, d.Attempts, d.NextAttemptAt, d.UpdatedAt = domain.DeliveryPending, 0, time.Now(), time.Now()
+	return nil
+}
+
+// ExpireCoupons writes the expired events of up to limit coupons whose expiry passed to the outbox of the coupons,
+// the earliest expiry first
+func (mw *MemoryWebhookRepository) ExpireCoupons(ctx context.Context, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return mw.coupons.expireCoupons(time.Now(), limit), nil
+}
+
+// FanOut creates the deliveries of up to limit events taken from the outbox of the coupons, oldest first
+func (mw *MemoryWebhookRepository) FanOut(ctx context.Context, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	events := mw.coupons.takeEvents(limit)
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	now := time.Now()
+	for _, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+		for _, w := range mw.webhooks {
+			if w.TenantID != e.TenantID || !w.Subscribes(e.Type) {
+				continue
+			}
+			mw.lastDeliveryID++
+			mw.deliveries = append(mw.deliveries, domain.Delivery{
+				ID:            mw.lastDeliveryID,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+				Tenanted:      e.Tenanted,
+				WebhookID:     w.ID,
+				EventID:       e.ID,
+				EventType:     e.Type,
+				Payload:       payload,
+				Status:        domain.DeliveryPending,
+				NextAttemptAt: now,
+			})
+		}
+	}
+	return len(events), nil
+}
+
+// ClaimDeliveries reads up to limit pending deliveries due now, the longest due first, and postpones the next
+// attempt of each by lease
+func (mw *MemoryWebhookRepository) ClaimDeliveries(ctx context.Context, lease time.Duration, limit int, deliveries *[]domain.Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	now := time.Now()
+	var due []int
+	for i, d := range mw.deliveries {
+		if d.Status == domain.DeliveryPending && !d.NextAttemptAt.After(now) {
+			due = append(due, i)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return mw.deliveries[due[i]].NextAttemptAt.Before(mw.deliveries[due[j]].NextAttemptAt)
+	})
+	if limit < len(due) {
+		due = due[:limit]
+	}
+
+	*deliveries = make([]domain.Delivery, len(due))
+	for i, di := range due {
+		mw.deliveries[di].NextAttemptAt = now.Add(lease)
+		(*deliveries)[i] = copyDelivery(mw.deliveries[di])
+	}
+	return nil
+}
+
+// UpdateDelivery writes the outcome of the attempt of d to its delivery, a delivery deleted meanwhile along with
+// its webhook is left deleted
+func (mw *MemoryWebhookRepository) UpdateDelivery(ctx context.Context, d *domain.Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	i := mw.deliveryIndex(d.ID)
+	if i < 0 {
+		return nil
+	}
+	stored := &mw.deliveries[i]
+	stored.UpdatedAt = time.Now()
+	stored.Status, stored.Attempts, stored.NextAttemptAt = d.Status, d.Attempts, d.NextAttemptAt
+	stored.LastStatusCode, stored.LastError = d.LastStatusCode, d.LastError
+	stored.DeliveredAt = copyDelivery(*d).DeliveredAt
+	return nil
+}
+
+// index returns the index of the webhook of tenant with id, -1 if there is none
+func (mw *MemoryWebhookRepository) index(tenant string, id uint) int {
+	for i, w := range mw.webhooks {
+		if w.ID == id && w.TenantID == tenant {
+			return i
+		}
+	}
+	return -1
+}
+
+// deliveryIndex returns the index of the delivery with id, -1 if there is none
+func (mw *MemoryWebhookRepository) deliveryIndex(id uint) int {
+	for i, d := range mw.deliveries {
+		if d.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// copyWebhook returns a copy of w sharing none of its events
+func copyWebhook(w domain.Webhook) domain.Webhook {
+	w.Events = append([]string(nil), w.Events...)
+	return w
+}
+
+// copyDelivery returns a copy of d sharing none of its payload and delivery time
+func copyDelivery(d domain.Delivery) domain.Delivery {
+	d.Payload = append(json.RawMessage(nil), d.Payload...)
+	if d.DeliveredAt != nil {
+		deliveredAt := *d.DeliveredAt
+		d.DeliveredAt = &deliveredAt
+	}
+	return d
+}
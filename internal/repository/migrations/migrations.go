@@ -0,0 +1,26 @@
+// Package migrations holds the versioned SQL migrations of the coupons database
+//
+// Every migration is made of a {version}_{name}.up.sql file and its {version}_{name}.down.sql counterpart,
+// written once per supported gorm dialect in the directory named after it. Both directories hold the same versions.
+// Migrations are applied in version order with the pkg/gormdb/migrate package.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+// files holds the embedded migration files of every dialect
+//
+//go:embed postgres/*.sql sqlite3/*.sql
+var files embed.FS
+
+// FS returns the migration files of a gorm dialect: postgres or sqlite3
+func FS(dialect string) (fs.FS, error) {
+	switch dialect {
+	case "postgres", "sqlite3":
+		return fs.Sub(files, dialect)
+	}
+	return nil, fmt.Errorf("no migrations for dialect: %s", dialect)
+}
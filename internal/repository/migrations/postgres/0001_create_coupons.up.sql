@@ -0,0 +1,13 @@
+-- matches the table previously created by gorm's AutoMigrate, so existing databases are adopted as is
+CREATE TABLE IF NOT EXISTS coupons (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    name text,
+    brand text,
+    value integer,
+    expiry timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS idx_coupons_deleted_at ON coupons (deleted_at);
@@ -0,0 +1,12 @@
+-- the API keys authenticating the callers, only the hash of each key is stored
+CREATE TABLE api_keys (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    hash text NOT NULL,
+    scopes text NOT NULL,
+    revoked_at timestamp with time zone
+);
+
+CREATE UNIQUE INDEX idx_api_keys_prefix ON api_keys (prefix);
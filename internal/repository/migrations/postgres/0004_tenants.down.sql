@@ -0,0 +1,3 @@
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+DROP INDEX IF EXISTS idx_coupons_tenant_id;
+ALTER TABLE coupons DROP COLUMN IF EXISTS tenant_id;
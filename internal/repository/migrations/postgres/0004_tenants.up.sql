@@ -0,0 +1,6 @@
+-- the existing coupons belong to the default tenant, every statement of the repository is scoped to a tenant
+ALTER TABLE coupons ADD COLUMN tenant_id text NOT NULL DEFAULT 'default';
+CREATE INDEX idx_coupons_tenant_id ON coupons (tenant_id, id);
+
+-- an API key bound to a tenant only reaches its coupons, an empty tenant lets the caller pick one
+ALTER TABLE api_keys ADD COLUMN tenant_id text NOT NULL DEFAULT '';
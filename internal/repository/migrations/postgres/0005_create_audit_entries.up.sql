@@ -0,0 +1,23 @@
+-- the audit entries of the coupon changes, written in the transaction of each change and never changed afterwards
+CREATE TABLE audit_entries (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL,
+    tenant_id text NOT NULL,
+    actor text NOT NULL,
+    action text NOT NULL,
+    coupon_id integer NOT NULL,
+    changes text NOT NULL,
+    request_id text NOT NULL
+);
+
+CREATE INDEX idx_audit_entries_coupon_id ON audit_entries (tenant_id, coupon_id);
+CREATE INDEX idx_audit_entries_created_at ON audit_entries (tenant_id, created_at);
+
+CREATE FUNCTION audit_entries_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_entries_immutable BEFORE UPDATE OR DELETE ON audit_entries
+    FOR EACH ROW EXECUTE PROCEDURE audit_entries_immutable();
@@ -0,0 +1,42 @@
+-- the webhook subscriptions of the tenants, their secret signs the payloads so it is stored as is
+CREATE TABLE webhooks (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL,
+    tenant_id text NOT NULL,
+    url text NOT NULL,
+    events text NOT NULL,
+    secret text NOT NULL
+);
+
+CREATE INDEX idx_webhooks_tenant_id ON webhooks (tenant_id);
+
+-- the outbox of the coupon events, written in the transaction of each change and deleted once fanned out
+-- to the deliveries of the webhooks subscribed to them
+CREATE TABLE webhook_events (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL,
+    tenant_id text NOT NULL,
+    type text NOT NULL,
+    payload text NOT NULL
+);
+
+-- the deliveries of the events to the webhooks, pending until delivered or dead once every attempt failed
+CREATE TABLE webhook_deliveries (
+    id serial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL,
+    updated_at timestamp with time zone NOT NULL,
+    tenant_id text NOT NULL,
+    webhook_id integer NOT NULL,
+    event_id integer NOT NULL,
+    event_type text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL,
+    attempts integer NOT NULL,
+    next_attempt_at timestamp with time zone NOT NULL,
+    last_status_code integer NOT NULL,
+    last_error text NOT NULL,
+    delivered_at timestamp with time zone
+);
+
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (tenant_id, webhook_id);
+CREATE INDEX idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (status, next_attempt_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_coupons_expiry_not_notified;
+ALTER TABLE coupons DROP COLUMN IF EXISTS notified_expiry;
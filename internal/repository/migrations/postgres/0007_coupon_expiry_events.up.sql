@@ -0,0 +1,10 @@
+-- the expiry of each coupon whose coupon.expired event was written to the outbox, a coupon whose expiry is changed
+-- afterwards is notified again once its new expiry passes
+ALTER TABLE coupons ADD COLUMN notified_expiry timestamp with time zone;
+
+-- the coupons expired before the events existed are not notified now
+UPDATE coupons SET notified_expiry = expiry WHERE expiry IS NOT NULL AND expiry <= CURRENT_TIMESTAMP;
+
+-- the dispatchers look up the coupons to notify every round, the index only holds those not notified yet so the
+-- lookups do not walk the coupons notified already. Its condition is the one of the lookups, see ExpireCoupons
+CREATE INDEX idx_coupons_expiry_not_notified ON coupons (expiry, id) WHERE notified_expiry IS DISTINCT FROM expiry;
@@ -0,0 +1,14 @@
+-- sqlite counterpart of postgres/0001_create_coupons.up.sql
+-- time columns are datetime so the driver reads them back as time values
+CREATE TABLE IF NOT EXISTS coupons (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    name text,
+    brand text,
+    value integer,
+    expiry datetime
+);
+
+CREATE INDEX IF NOT EXISTS idx_coupons_deleted_at ON coupons (deleted_at);
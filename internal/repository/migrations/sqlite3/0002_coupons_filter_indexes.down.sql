@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_coupons_expiry;
+DROP INDEX IF EXISTS idx_coupons_brand;
@@ -0,0 +1,2 @@
+CREATE INDEX idx_coupons_brand ON coupons (brand);
+CREATE INDEX idx_coupons_expiry ON coupons (expiry);
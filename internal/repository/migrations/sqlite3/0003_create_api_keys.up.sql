@@ -0,0 +1,12 @@
+-- sqlite counterpart of postgres/0003_create_api_keys.up.sql
+CREATE TABLE api_keys (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    hash text NOT NULL,
+    scopes text NOT NULL,
+    revoked_at datetime
+);
+
+CREATE UNIQUE INDEX idx_api_keys_prefix ON api_keys (prefix);
@@ -0,0 +1,33 @@
+-- the bundled sqlite cannot drop columns, the tables are rebuilt without them
+CREATE TABLE api_keys_old (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    hash text NOT NULL,
+    scopes text NOT NULL,
+    revoked_at datetime
+);
+INSERT INTO api_keys_old (id, created_at, name, prefix, hash, scopes, revoked_at)
+    SELECT id, created_at, name, prefix, hash, scopes, revoked_at FROM api_keys;
+DROP TABLE api_keys;
+ALTER TABLE api_keys_old RENAME TO api_keys;
+CREATE UNIQUE INDEX idx_api_keys_prefix ON api_keys (prefix);
+
+CREATE TABLE coupons_old (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    name text,
+    brand text,
+    value integer,
+    expiry datetime
+);
+INSERT INTO coupons_old (id, created_at, updated_at, deleted_at, name, brand, value, expiry)
+    SELECT id, created_at, updated_at, deleted_at, name, brand, value, expiry FROM coupons;
+DROP TABLE coupons;
+ALTER TABLE coupons_old RENAME TO coupons;
+CREATE INDEX idx_coupons_deleted_at ON coupons (deleted_at);
+CREATE INDEX idx_coupons_brand ON coupons (brand);
+CREATE INDEX idx_coupons_expiry ON coupons (expiry);
@@ -0,0 +1,5 @@
+-- sqlite counterpart of postgres/0004_tenants.up.sql
+ALTER TABLE coupons ADD COLUMN tenant_id text NOT NULL DEFAULT 'default';
+CREATE INDEX idx_coupons_tenant_id ON coupons (tenant_id, id);
+
+ALTER TABLE api_keys ADD COLUMN tenant_id text NOT NULL DEFAULT '';
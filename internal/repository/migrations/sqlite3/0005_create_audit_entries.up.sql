@@ -0,0 +1,24 @@
+-- sqlite counterpart of postgres/0005_create_audit_entries.up.sql
+CREATE TABLE audit_entries (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL,
+    tenant_id text NOT NULL,
+    actor text NOT NULL,
+    action text NOT NULL,
+    coupon_id integer NOT NULL,
+    changes text NOT NULL,
+    request_id text NOT NULL
+);
+
+CREATE INDEX idx_audit_entries_coupon_id ON audit_entries (tenant_id, coupon_id);
+CREATE INDEX idx_audit_entries_created_at ON audit_entries (tenant_id, created_at);
+
+CREATE TRIGGER audit_entries_no_update BEFORE UPDATE ON audit_entries
+BEGIN
+    SELECT RAISE(ABORT, 'audit entries are immutable');
+END;
+
+CREATE TRIGGER audit_entries_no_delete BEFORE DELETE ON audit_entries
+BEGIN
+    SELECT RAISE(ABORT, 'audit entries are immutable');
+END;
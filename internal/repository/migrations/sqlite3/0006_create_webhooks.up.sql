@@ -0,0 +1,39 @@
+-- sqlite counterpart of postgres/0006_create_webhooks.up.sql
+CREATE TABLE webhooks (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL,
+    tenant_id text NOT NULL,
+    url text NOT NULL,
+    events text NOT NULL,
+    secret text NOT NULL
+);
+
+CREATE INDEX idx_webhooks_tenant_id ON webhooks (tenant_id);
+
+CREATE TABLE webhook_events (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL,
+    tenant_id text NOT NULL,
+    type text NOT NULL,
+    payload text NOT NULL
+);
+
+CREATE TABLE webhook_deliveries (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime NOT NULL,
+    updated_at datetime NOT NULL,
+    tenant_id text NOT NULL,
+    webhook_id integer NOT NULL,
+    event_id integer NOT NULL,
+    event_type text NOT NULL,
+    payload text NOT NULL,
+    status text NOT NULL,
+    attempts integer NOT NULL,
+    next_attempt_at datetime NOT NULL,
+    last_status_code integer NOT NULL,
+    last_error text NOT NULL,
+    delivered_at datetime
+);
+
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (tenant_id, webhook_id);
+CREATE INDEX idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (status, next_attempt_at);
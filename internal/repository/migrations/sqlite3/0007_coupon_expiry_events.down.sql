@@ -0,0 +1,20 @@
+-- the bundled sqlite cannot drop columns, the table is rebuilt without it
+CREATE TABLE coupons_old (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    name text,
+    brand text,
+    value integer,
+    expiry datetime,
+    tenant_id text NOT NULL DEFAULT 'default'
+);
+INSERT INTO coupons_old (id, created_at, updated_at, deleted_at, name, brand, value, expiry, tenant_id)
+    SELECT id, created_at, updated_at, deleted_at, name, brand, value, expiry, tenant_id FROM coupons;
+DROP TABLE coupons;
+ALTER TABLE coupons_old RENAME TO coupons;
+CREATE INDEX idx_coupons_deleted_at ON coupons (deleted_at);
+CREATE INDEX idx_coupons_brand ON coupons (brand);
+CREATE INDEX idx_coupons_expiry ON coupons (expiry);
+CREATE INDEX idx_coupons_tenant_id ON coupons (tenant_id, id);
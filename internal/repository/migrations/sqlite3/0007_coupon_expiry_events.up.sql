@@ -0,0 +1,7 @@
+-- sqlite counterpart of postgres/0007_coupon_expiry_events.up.sql, IS NOT compares the NULL values like
+-- IS DISTINCT FROM
+ALTER TABLE coupons ADD COLUMN notified_expiry datetime;
+
+UPDATE coupons SET notified_expiry = expiry WHERE expiry IS NOT NULL AND expiry <= CURRENT_TIMESTAMP;
+
+CREATE INDEX idx_coupons_expiry_not_notified ON coupons (expiry, id) WHERE notified_expiry IS NOT expiry;
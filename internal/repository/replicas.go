@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// ReplicaOptions configures how reads are routed to the read replicas
+type ReplicaOptions struct {
+	// Stickiness is how long every read goes to the primary after a write, so recent writes are read back
+	// even if the replicas lag behind. A zero duration routes reads to the replicas right after a write
+	Stickiness time.Duration
+	// RetryAfter is how long a replica which failed a read is left out before it is tried again
+	RetryAfter time.Duration
+}
+
+// replica is a read replica of the primary db
+type replica struct {
+	db *gorm.DB
+	// downUntil is the unix nano time until which the replica is left out, 0 if it is healthy
+	downUntil int64
+}
+
+// replicaSet routes reads to read replicas in round-robin
+// It is shared by the copies of a GormRepository and safe for concurrent use
+type replicaSet struct {
+	replicas []*replica
+	opts     ReplicaOptions
+	// next is the index of the replica serving the next read
+	next uint32
+	// lastWrite is the unix nano time of the last write to the primary
+	lastWrite int64
+}
+
+// WithReplicas returns a copy of gr reading coupons from the read replicas
+// GetCouponByID and QueryCoupons are served by the replicas in round-robin. A replica failing a read is left out
+// for opts.RetryAfter and the read is served by the primary, which also serves every read while no replica is healthy.
+// Transactions, reads within opts.Stickiness of a write and reads of a domain.WithPrimaryReads context use the primary
+func (gr *GormRepository) WithReplicas(replicas []*gorm.DB, opts ReplicaOptions) *GormRepository {
+	set := &replicaSet{opts: opts}
+	for _, db := range replicas {
+		set.replicas = append(set.replicas, &replica{db: db})
+	}
+	return &GormRepository{db: gr.db, timeouts: gr.timeouts, depth: gr.depth, replicas: set}
+}
+
+// read runs fn on the db serving the reads of ctx
+// If fn fails on a replica for any other reason than ctx or a missing coupon, the replica is left out
+// and fn runs again on the primary
+func (gr *GormRepository) read(ctx context.Context, fn func(db *gorm.DB) error) error {
+	if r := gr.replicas.pick(ctx); r != nil {
+		err := fn(tenantScope(ctx, bind(ctx, r.db)))
+		if !gr.replicas.failed(ctx, r, err) {
+			return err
+		}
+	}
+	return fn(gr.conn(ctx))
+}
+
+// wrote records a write to the primary, starting the stickiness period
+func (rs *replicaSet) wrote() {
+	if rs != nil {
+		atomic.StoreInt64(&rs.lastWrite, time.Now().UnixNano())
+	}
+}
+
+// pick returns the replica serving the next read of ctx, nil if the primary must serve it
+func (rs *replicaSet) pick(ctx context.Context) *replica {
+	if rs == nil || len(rs.replicas) == 0 || domain.PrimaryReads(ctx) {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&rs.lastWrite) < int64(rs.opts.Stickiness) {
+		return nil
+	}
+
+	start := atomic.AddUint32(&rs.next, 1) - 1
+	for i := range rs.replicas {
+		r := rs.replicas[(int(start)+i)%len(rs.replicas)]
+		if atomic.LoadInt64(&r.downUntil) <= now {
+			return r
+		}
+	}
+	return nil
+}
+
+// failed reports whether err is a failure of the replica r, which is then left out for a while
+// The failure is logged with the logger of the request of ctx, the standard logger outside of a request
+func (rs *replicaSet) failed(ctx context.Context, r *replica, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	if _, ok := err.(domain.CouponNotFoundError); ok {
+		return false
+	}
+	atomic.StoreInt64(&r.downUntil, time.Now().Add(rs.opts.RetryAfter).UnixNano())
+	ctxlog.Entry(ctx, logrus.StandardLogger()).WithError(err).WithField("retry_after", rs.opts.RetryAfter).
+		Warn("read replica failed, reading from the primary")
+	return true
+}
+
+// close closes the replicas
+func (rs *replicaSet) close() {
+	if rs == nil {
+		return
+	}
+	for _, r := range rs.replicas {
+		r.db.Close()
+	}
+}
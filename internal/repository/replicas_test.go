@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestReplicas tests the routing of reads to read replicas
+// The primary and the replicas are distinct sqlite databases holding a coupon named after them
+func TestReplicas(t *testing.T) {
+	t.Run("roundRobin", testReplicasRoundRobin)
+	t.Run("fallback", testReplicasFallback)
+	t.Run("notFound", testReplicasNotFound)
+	t.Run("stickiness", testReplicasStickiness)
+	t.Run("primaryReads", testReplicasPrimaryReads)
+	t.Run("transaction", testReplicasTransaction)
+}
+
+// startReplicas returns a repository with the given replicas, every db holds a single coupon named after it
+func startReplicas(t *testing.T, opts ReplicaOptions, names ...string) *GormRepository {
+	primary := startSQLite(t)
+	primary.NewCoupon(context.Background(), domain.APICoupon{Name: stringPtr("primary")})
+
+	var replicas []*gorm.DB
+	for _, n := range names {
+		r := startSQLite(t)
+		r.NewCoupon(context.Background(), domain.APICoupon{Name: stringPtr(n)})
+		replicas = append(replicas, r.db)
+	}
+
+	repo := primary.WithReplicas(replicas, opts)
+	t.Cleanup(repo.Close)
+	return repo
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// readName returns the name of the coupon 1 of the db serving the read
+func readName(t *testing.T, ctx context.Context, repo domain.Repository) string {
+	var c domain.Coupon
+	if err := repo.GetCouponByID(ctx, 1, nil, &c); err != nil {
+		t.Fatal(err)
+	}
+	return c.Name
+}
+
+func testReplicasRoundRobin(t *testing.T) {
+	repo := startReplicas(t, ReplicaOptions{}, "replica1", "replica2")
+	ctx := context.Background()
+
+	assert.Equal(t, "replica1", readName(t, ctx, repo))
+	assert.Equal(t, "replica2", readName(t, ctx, repo))
+
+	var coupons []domain.Coupon
+	assert.Nil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}))
+	assert.Equal(t, "replica1", coupons[0].Name)
+
+	// stats and exports are served by the primary
+	var stats []domain.CouponStats
+	assert.Nil(t, repo.CouponStats(ctx, &stats, nil, domain.CouponQuery{}))
+	assert.Equal(t, uint(1), stats[0].Count)
+}
+
+func testReplicasFallback(t *testing.T) {
+	repo := startReplicas(t, ReplicaOptions{RetryAfter: time.Hour}, "replica1", "replica2")
+	ctx := context.Background()
+	repo.replicas.replicas[0].db.Close()
+
+	// the closed replica fails the read, which is served by the primary
+	assert.Equal(t, "primary", readName(t, ctx, repo))
+	// it is then left out
+	assert.Equal(t, "replica2", readName(t, ctx, repo))
+	assert.Equal(t, "replica2", readName(t, ctx, repo))
+
+	repo.replicas.replicas[1].db.Close()
+	var coupons []domain.Coupon
+	assert.Nil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}))
+	assert.Equal(t, "primary", coupons[0].Name)
+	// without healthy replica the primary serves every read
+	assert.Equal(t, "primary", readName(t, ctx, repo))
+}
+
+func testReplicasNotFound(t *testing.T) {
+	repo := startReplicas(t, ReplicaOptions{RetryAfter: time.Hour}, "replica1")
+	ctx := context.Background()
+
+	var c domain.Coupon
+	assert.IsType(t, domain.CouponNotFoundError{}, repo.GetCouponByID(ctx, 2, nil, &c))
+	var coupons []domain.Coupon
+	assert.NotNil(t, repo.QueryCoupons(ctx, &coupons, domain.CouponQuery{}.Where("password", domain.FilterEqual, "x")))
+
+	// neither error is a failure of the replica
+	assert.Equal(t, "replica1", readName(t, ctx, repo))
+}
+
+func testReplicasStickiness(t *testing.T) {
+	repo := startReplicas(t, ReplicaOptions{Stickiness: time.Hour}, "replica1")
+	ctx := context.Background()
+
+	assert.Equal(t, "replica1", readName(t, ctx, repo))
+	assert.Nil(t, repo.UpdateCoupon(ctx, 1, domain.APICoupon{Brand: &Brand}))
+	assert.Equal(t, "primary", readName(t, ctx, repo))
+
+	repo.replicas.lastWrite = 0
+	assert.Equal(t, "replica1", readName(t, ctx, repo))
+	assert.Nil(t, repo.WithTx(ctx, func(tx domain.Repository) error {
+		_, err := tx.NewCoupon(ctx, newAPICoupon())
+		return err
+	}))
+	assert.Equal(t, "primary", readName(t, ctx, repo))
+}
+
+func testReplicasPrimaryReads(t *testing.T) {
+	repo := startReplicas(t, ReplicaOptions{}, "replica1")
+
+	assert.Equal(t, "primary", readName(t, domain.WithPrimaryReads(context.Background()), repo))
+	assert.Equal(t, "replica1", readName(t, context.Background(), repo))
+}
+
+func testReplicasTransaction(t *testing.T) {
+	repo := startReplicas(t, ReplicaOptions{}, "replica1")
+	ctx := context.Background()
+
+	assert.Nil(t, repo.WithTx(ctx, func(tx domain.Repository) error {
+		assert.Equal(t, "primary", readName(t, ctx, tx))
+		return nil
+	}))
+}
@@ -1,152 +1,397 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/ctxdb"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/gormtrace"
 	"github.com/jinzhu/gorm"
 )
 
+// likeEscaper escapes the LIKE wildcards so user input is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Timeouts are the maximum durations of the repository operations, a zero duration means no timeout
+// Read applies to single reads and queries, Write to writes and transactions and Export to whole exports
+type Timeouts struct {
+	Read   time.Duration
+	Write  time.Duration
+	Export time.Duration
+}
+
 // GormRepository handles the flow of control from the service upper layer to the database
+// Every statement is scoped to the tenant of its context, see domain.TenantFrom, and every coupon change
+// writes its audit entry in the transaction of the change
 type GormRepository struct {
-	db *gorm.DB
-	tx *gorm.DB
+	db       *gorm.DB
+	timeouts Timeouts
+	// depth is the transaction nesting level of db, 0 if it is not a transaction
+	depth int
+	// replicas serve the reads, nil if every read is served by db
+	replicas *replicaSet
 }
 
-// Close closes the underlying db
+// Close closes the underlying db and its replicas
 func (gr *GormRepository) Close() {
 	gr.db.Close()
+	gr.replicas.close()
 }
 
-// Reset drops coupons table rows
-func (gr *GormRepository) Reset() {
-	gr.db.AutoMigrate(&domain.Coupon{})
+// New is the GormRepository constructor
+func New(db *gorm.DB, timeouts Timeouts) *GormRepository {
+	return &GormRepository{db: db, timeouts: timeouts}
 }
 
-// New is the GormRepository constructor
-func New(db *gorm.DB) *GormRepository {
-	return &GormRepository{db: db}
+// WithTx runs fn with a GormRepository bound to a transaction
+// The transaction is committed if fn returns nil and rolled back if it returns an error or panics.
+// If gr is already bound to a transaction a savepoint is used instead, so only the work of fn is rolled back.
+//
+// The write timeout applies to the whole transaction, the statements of fn run with its context
+func (gr *GormRepository) WithTx(ctx context.Context, fn func(tx domain.Repository) error) error {
+	return gr.transaction(ctx, func(tx *GormRepository) error { return fn(tx) })
+}
+
+// transaction is WithTx passing the GormRepository bound to the transaction to fn
+func (gr *GormRepository) transaction(ctx context.Context, fn func(tx *GormRepository) error) error {
+	if gr.depth > 0 {
+		return gr.withSavepoint(ctx, fn)
+	}
+
+	ctx, cancel := withTimeout(ctx, gr.timeouts.Write)
+	defer cancel()
+
+	tx := gr.unscoped(ctx).BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return ctxErr(ctx, tx.Error)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&GormRepository{db: tx, timeouts: gr.timeouts, depth: 1}); err != nil {
+		tx.Rollback()
+		return ctxErr(ctx, err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return ctxErr(ctx, err)
+	}
+	gr.replicas.wrote()
+	return nil
+}
+
+// withSavepoint runs fn within a savepoint of the current transaction
+func (gr *GormRepository) withSavepoint(ctx context.Context, fn func(tx *GormRepository) error) error {
+	name := fmt.Sprintf("sp_%d", gr.depth)
+	if err := gr.db.Exec("SAVEPOINT " + name).Error; err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			gr.db.Exec("ROLLBACK TO SAVEPOINT " + name)
+			panic(p)
+		}
+	}()
+
+	if err := fn(&GormRepository{db: gr.db, timeouts: gr.timeouts, depth: gr.depth + 1}); err != nil {
+		gr.db.Exec("ROLLBACK TO SAVEPOINT " + name)
+		return ctxErr(ctx, err)
+	}
+	return ctxErr(ctx, gr.db.Exec("RELEASE SAVEPOINT "+name).Error)
 }
 
-// NewCoupon creates a new coupon record in the db
-func (gr *GormRepository) NewCoupon(APIc domain.APICoupon) error {
+// NewCoupon creates a new coupon record in the db and returns its ID
+func (gr *GormRepository) NewCoupon(ctx context.Context, APIc domain.APICoupon) (uint, error) {
 	c := domain.NewCoupon(APIc)
-	return gr.db.Create(&c).Error
+	c.TenantID = domain.TenantFrom(ctx)
+	err := gr.audited(ctx, func(db *gorm.DB) (domain.AuditEntry, error) {
+		if err := db.Create(&c).Error; err != nil {
+			return domain.AuditEntry{}, err
+		}
+		return domain.NewAuditEntry(ctx, domain.AuditCreate, nil, &c), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return c.ID, nil
 }
 
 // GetCouponByID gets a coupon from the db according to the ID
+// If columns is not empty only those columns are read, it must include the id column
 // If there is no record with the given ID a CouponNotFoundError is returned
-func (gr *GormRepository) GetCouponByID(id uint, c *domain.Coupon) error {
-	gr.db.First(c, id)
-	if c.ID == 0 {
-		return domain.NewCouponNotFoundError()
-	}
-	return gr.db.Error
+func (gr *GormRepository) GetCouponByID(ctx context.Context, id uint, columns []string, c *domain.Coupon) error {
+	ctx, cancel := gr.withTimeout(ctx, gr.timeouts.Read)
+	defer cancel()
+
+	return gr.read(ctx, func(db *gorm.DB) error {
+		if len(columns) > 0 {
+			db = db.Select(columns)
+		}
+		return ctxErr(ctx, first(db, id, c))
+	})
 }
 
 // DeleteCoupon deletes the coupon record with the given ID
 // If there is no record with the given ID a CouponNotFoundError is returned
-func (gr *GormRepository) DeleteCoupon(id uint) error {
-	var c domain.Coupon
-	gr.db.First(&c, id)
-	if c.ID == 0 {
-		return domain.NewCouponNotFoundError()
-	}
-	return gr.db.Delete(c).Error
+func (gr *GormRepository) DeleteCoupon(ctx context.Context, id uint) error {
+	return gr.audited(ctx, func(db *gorm.DB) (domain.AuditEntry, error) {
+		var c domain.Coupon
+		if err := first(db, id, &c); err != nil {
+			return domain.AuditEntry{}, err
+		}
+		if err := db.Delete(c).Error; err != nil {
+			return domain.AuditEntry{}, err
+		}
+		return domain.NewAuditEntry(ctx, domain.AuditDelete, &c, nil), nil
+	})
 }
 
 // UpdateCoupon updates a coupon record with a given ID
 // Only the name, brand, value and expiry can be changed
 // If there is no record with the given ID a CouponNotFoundError is returned
-func (gr *GormRepository) UpdateCoupon(id uint, APIc domain.APICoupon) error {
-	var c domain.Coupon
-	gr.db.First(&c, id)
-	if c.ID == 0 {
-		return domain.NewCouponNotFoundError()
-	}
+func (gr *GormRepository) UpdateCoupon(ctx context.Context, id uint, APIc domain.APICoupon) error {
+	return gr.audited(ctx, func(db *gorm.DB) (domain.AuditEntry, error) {
+		var before domain.Coupon
+		if err := first(db, id, &before); err != nil {
+			return domain.AuditEntry{}, err
+		}
 
-	c = domain.UpdateCoupon(c, APIc)
+		c := domain.UpdateCoupon(before, APIc)
 
-	return gr.db.Save(&c).Error
+		if err := db.Save(&c).Error; err != nil {
+			return domain.AuditEntry{}, err
+		}
+		return domain.NewAuditEntry(ctx, domain.AuditUpdate, &before, &c), nil
+	})
 }
 
-// QueryCoupons queries the db for coupon records according to the query and the variadic functions
-//
-// Query can be used like a "WHERE {key} = {value}" in sql
-// The possible key-values are:
-// name: string
-// brand: string
-// value; uint
-//
-// the functions that can be used to limit our query are the ones generated through this package with a signature:
-// "Query...(...) func error"
-func (gr *GormRepository) QueryCoupons(coupons *[]domain.Coupon, query map[string]interface{}, functions ...func() error) error {
-	gr.tx = gr.db.Where(query)
+// QueryCoupons queries the db for the coupon records matching the query in id order
+// The query only lives for the call, so concurrent calls can't interfere with each other
+func (gr *GormRepository) QueryCoupons(ctx context.Context, coupons *[]domain.Coupon, q domain.CouponQuery) error {
+	ctx, cancel := gr.withTimeout(ctx, gr.timeouts.Read)
+	defer cancel()
+
+	if err := checkQuery(q); err != nil {
+		return err
+	}
+	return gr.read(ctx, func(db *gorm.DB) error {
+		return ctxErr(ctx, gr.scope(db, q).Order("id").Find(coupons).Error)
+	})
+}
+
+// ExportCoupons reads every coupon record matching the query through a row cursor
+// fn is called for every coupon in id order, so the whole result set is never held in memory.
+// If fn returns an error the export stops and the error is returned
+func (gr *GormRepository) ExportCoupons(ctx context.Context, fn func(c domain.Coupon) error, q domain.CouponQuery) error {
+	ctx, cancel := gr.withTimeout(ctx, gr.timeouts.Export)
+	defer cancel()
+
+	if err := checkQuery(q); err != nil {
+		return err
+	}
+	db := gr.scope(gr.conn(ctx), q)
 
-	for _, c := range functions {
-		if err := c(); err != nil {
+	rows, err := db.Model(&domain.Coupon{}).Order("id").Rows()
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c domain.Coupon
+		if err := db.ScanRows(rows, &c); err != nil {
+			return ctxErr(ctx, err)
+		}
+		if err := fn(c); err != nil {
 			return err
 		}
 	}
+	return ctxErr(ctx, rows.Err())
+}
+
+// CouponStats aggregates the coupon records matching the query
+// The count, sum, min and max of the coupons value are computed for every group in groupBy,
+// which holds domain.StatsGroup... values. Without groups a single row is returned.
+// The selected columns and page of the query are ignored
+func (gr *GormRepository) CouponStats(ctx context.Context, stats *[]domain.CouponStats, groupBy []string, q domain.CouponQuery) error {
+	ctx, cancel := gr.withTimeout(ctx, gr.timeouts.Read)
+	defer cancel()
 
-	if err := gr.tx.Find(coupons).Error; err != nil {
+	if err := checkQuery(q); err != nil {
 		return err
 	}
+	db := gr.scope(gr.conn(ctx), q.Select(nil).Paginate(0, 0))
+
+	var selects, groups []string
+	var args []interface{}
+	for _, g := range groupBy {
+		switch g {
+		case domain.StatsGroupBrand:
+			selects = append(selects, "brand")
+		case domain.StatsGroupStatus:
+			selects = append(selects, fmt.Sprintf("CASE WHEN expiry < ? THEN '%s' ELSE '%s' END AS status", domain.CouponStatusExpired, domain.CouponStatusActive))
+			args = append(args, time.Now())
+		case domain.StatsGroupExpiryMonth:
+			selects = append(selects, expiryMonth(db)+" AS expiry_month")
+		default:
+			return fmt.Errorf("unknown stats group: %s", g)
+		}
+		groups = append(groups, g)
+	}
+	selects = append(selects, "COUNT(*) AS count", "COALESCE(SUM(value), 0) AS sum", "COALESCE(MIN(value), 0) AS min", "COALESCE(MAX(value), 0) AS max")
+
+	db = db.Model(&domain.Coupon{}).Select(strings.Join(selects, ", "), args...)
+	if len(groups) > 0 {
+		db = db.Group(strings.Join(groups, ", ")).Order(strings.Join(groups, ", "))
+	}
+	return ctxErr(ctx, db.Scan(stats).Error)
+}
+
+// Ping checks that the db is reachable, the read replicas are not checked
+func (gr *GormRepository) Ping(ctx context.Context) error {
+	sqlDB, ok := gr.db.CommonDB().(*sql.DB)
+	if !ok {
+		// a transaction holds its connection until it ends
+		return ctx.Err()
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// checkQuery returns an error if the query has unknown columns or operators, or filters without value
+func checkQuery(q domain.CouponQuery) error {
+	for _, f := range q.Filters() {
+		if !domain.IsCouponColumn(f.Column) {
+			return fmt.Errorf("unknown coupon column: %s", f.Column)
+		}
+		if len(f.Values) == 0 {
+			return fmt.Errorf("filter on %s has no value", f.Column)
+		}
+		switch f.Op {
+		case domain.FilterEqual, domain.FilterLess, domain.FilterGreater, domain.FilterILike, domain.FilterPrefix:
+		default:
+			return fmt.Errorf("unknown filter operator: %s", f.Op)
+		}
+	}
 	return nil
 }
 
-// QueryBatchingFunction changes the amount of coupons and current page of the query
-func (gr *GormRepository) QueryBatchingFunction(limit, page uint) func() error {
-	return func() error {
-		gr.tx = gr.tx.Limit(limit).Offset(limit * (page - 1))
-		return gr.tx.Error
+// scope builds the conditions, selected columns and page of the query on db, q must have been checked by checkQuery
+// The repository itself is never modified
+func (gr *GormRepository) scope(db *gorm.DB, q domain.CouponQuery) *gorm.DB {
+	for _, f := range q.Filters() {
+		switch f.Op {
+		case domain.FilterEqual:
+			if len(f.Values) == 1 {
+				db = db.Where(fmt.Sprintf("%s = ?", f.Column), f.Values[0])
+			} else {
+				db = db.Where(fmt.Sprintf("%s IN (?)", f.Column), f.Values)
+			}
+		case domain.FilterLess:
+			db = db.Where(fmt.Sprintf("%s < ?", f.Column), f.Values[0])
+		case domain.FilterGreater:
+			db = db.Where(fmt.Sprintf("%s > ?", f.Column), f.Values[0])
+		case domain.FilterILike:
+			db = whereLike(db, f.Column, f.Values, "")
+		case domain.FilterPrefix:
+			db = whereLike(db, f.Column, f.Values, "%")
+		}
+	}
+
+	if columns := q.Columns(); len(columns) > 0 {
+		db = db.Select(columns)
+	}
+	if limit := q.Limit(); limit > 0 {
+		db = db.Limit(limit).Offset(q.Offset())
+	}
+	return db
+}
+
+// expiryMonth returns the sql expression of the YYYY-MM month of the coupon expiry in the dialect of db
+func expiryMonth(db *gorm.DB) string {
+	if db.Dialect().GetName() == "sqlite3" {
+		return "strftime('%Y-%m', expiry)"
 	}
+	return "to_char(expiry, 'YYYY-MM')"
 }
 
-// QueryLTExpiryFunction limits the query with a "WHERE expiry < ?"
-func (gr *GormRepository) QueryLTExpiryFunction(t time.Time) func() error {
-	return func() error {
-		gr.tx = gr.tx.Where("expiry < ?", t)
-		return gr.tx.Error
+// whereLike ORs a "LOWER({column}) LIKE ?" condition for every value
+// The LIKE wildcards of the values are escaped so they are matched literally
+func whereLike(db *gorm.DB, column string, values []interface{}, suffix string) *gorm.DB {
+	conditions := make([]string, len(values))
+	patterns := make([]interface{}, len(values))
+	for i, v := range values {
+		conditions[i] = fmt.Sprintf(`LOWER(%s) LIKE ? ESCAPE '\'`, column)
+		patterns[i] = likeEscaper.Replace(strings.ToLower(fmt.Sprint(v))) + suffix
 	}
+	return db.Where(strings.Join(conditions, " OR "), patterns...)
+}
+
+// conn returns the db of the repository bound to ctx and scoped to the tenant of ctx
+// Within a transaction the statements run with the context of the transaction, ctx only parents their spans
+func (gr *GormRepository) conn(ctx context.Context) *gorm.DB {
+	return tenantScope(ctx, gr.unscoped(ctx))
 }
 
-// QueryGTExpiryFunction limits the query with a "WHERE expiry > ?"
-func (gr *GormRepository) QueryGTExpiryFunction(t time.Time) func() error {
-	return func() error {
-		gr.tx = gr.tx.Where("expiry > ?", t)
-		return gr.tx.Error
+// unscoped returns the db of the repository bound to ctx without tenant condition, for the statements reading
+// and writing no entity like the transactions
+func (gr *GormRepository) unscoped(ctx context.Context) *gorm.DB {
+	if gr.depth > 0 {
+		return gormtrace.WithContext(ctx, gr.db)
 	}
+	return bind(ctx, gr.db)
 }
 
-// QueryLTCreatedFunction limits the query with a "WHERE created_at < ?"
-func (gr *GormRepository) QueryLTCreatedFunction(t time.Time) func() error {
-	return func() error {
-		gr.tx = gr.tx.Where("created_at < ?", t)
-		return gr.tx.Error
+// tenantScope restricts the statements of db to the entities of the tenant of ctx
+func tenantScope(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.Where("tenant_id = ?", domain.TenantFrom(ctx))
+}
+
+// bind binds the statements of db and their spans to ctx
+func bind(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return gormtrace.WithContext(ctx, ctxdb.WithContext(ctx, db))
+}
+
+// withTimeout applies the timeout of an operation to ctx
+// Within a transaction the timeout of the transaction applies instead
+func (gr *GormRepository) withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if gr.depth > 0 {
+		return context.WithCancel(ctx)
 	}
+	return withTimeout(ctx, d)
 }
 
-// QueryGTCreatedFunction limits the query with "WHERE created_at > ?"
-func (gr *GormRepository) QueryGTCreatedFunction(t time.Time) func() error {
-	return func() error {
-		gr.tx = gr.tx.Where("created_at > ?", t)
-		return gr.tx.Error
+// withTimeout returns a copy of ctx canceled after d, or only when ctx is if d is 0
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, d)
 }
 
-// QueryLTValueFunction limits the query with "WHERE value < ?"
-func (gr *GormRepository) QueryLTValueFunction(v uint) func() error {
-	return func() error {
-		gr.tx = gr.tx.Where("value < ?", v)
-		return gr.tx.Error
+// ctxErr returns the error of ctx if it is done and err is not nil
+// Drivers report canceled statements with their own errors, this lets the upper layers recognize them
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
 	}
+	return err
 }
 
-// QueryGTValueFunction limts the query with "WHERE value > ?"
-func (gr *GormRepository) QueryGTValueFunction(v uint) func() error {
-	return func() error {
-		gr.tx = gr.tx.Where("value > ?", v)
-		return gr.tx.Error
+// first reads the coupon record with the given ID into c
+// If there is no such record a CouponNotFoundError is returned
+func first(db *gorm.DB, id uint, c *domain.Coupon) error {
+	// gorm adds the primary key of a non zero c to the conditions, which would never match another id
+	*c = domain.Coupon{}
+	err := db.First(c, id).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return domain.NewCouponNotFoundError()
 	}
+	return err
 }
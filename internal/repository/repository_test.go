@@ -3,10 +3,16 @@
 package repository
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/repository/migrations"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/migrate"
 	"github.com/jcgfreitas/pb_api/pkg/gormdb/postgres"
 	"github.com/stretchr/testify/assert"
 )
@@ -17,16 +23,41 @@ const (
 	user     = "postgres"
 	dbName   = "postgres"
 	password = "password1"
-	name     = "name"
-	brand    = "brand"
-	value    = uint(10)
-	secs     = int64(1000000000)
 )
 
-var Time = time.Unix(secs, 0)
-var Name = "new" + name
-var Brand = "new" + brand
-var Value = 2 * value
+// TestConformance runs the shared repository conformance suite against postgres
+func TestConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) domain.Repository {
+		repo := startDB(t)
+		t.Cleanup(repo.Close)
+		return repo
+	})
+}
+
+// TestKeyConformance runs the key repository conformance suite against postgres
+func TestKeyConformance(t *testing.T) {
+	testKeyConformance(t, func(t *testing.T) domain.KeyRepository {
+		repo := startDB(t)
+		t.Cleanup(repo.Close)
+		return NewKeys(repo.db, Timeouts{})
+	})
+}
+
+// TestWebhookConformance runs the webhook repository conformance suite against postgres
+func TestWebhookConformance(t *testing.T) {
+	testWebhookConformance(t, func(t *testing.T) (domain.Repository, domain.WebhookRepository) {
+		repo := startDB(t)
+		t.Cleanup(repo.Close)
+		return repo, NewWebhooks(repo.db, Timeouts{})
+	})
+}
+
+// TestExpiryBackfill runs the expiry backfill test against postgres
+func TestExpiryBackfill(t *testing.T) {
+	repo := startDB(t)
+	t.Cleanup(repo.Close)
+	testExpiryBackfill(t, repo)
+}
 
 // TestNewCoupon tests default values insertion and insertion with non existing tables (tests the error)
 func TestNewCoupon(t *testing.T) {
@@ -72,7 +103,7 @@ func TestNewCoupon(t *testing.T) {
 
 	// insert coupons
 	for _, c := range testCase {
-		if err := repo.NewCoupon(c); err != nil {
+		if _, err := repo.NewCoupon(context.Background(), c); err != nil {
 			t.Error(err)
 		}
 	}
@@ -103,15 +134,119 @@ func TestNewCoupon(t *testing.T) {
 	}
 
 	repo.db.DropTableIfExists(&domain.Coupon{})
-	if err := repo.NewCoupon(testCase[0]); err == nil {
+	if _, err := repo.NewCoupon(context.Background(), testCase[0]); err == nil {
 		t.Error("should error when inserting into an nonexisting table")
 	}
 }
 
+// TestWithTx tests commits, rollbacks and nested savepoints
+func TestWithTx(t *testing.T) {
+	t.Run("commit", testWithTxCommit)
+	t.Run("rollback", testWithTxRollback)
+	t.Run("panic", testWithTxPanic)
+	t.Run("savepointRollback", testWithTxSavepointRollback)
+	t.Run("savepointCommit", testWithTxSavepointCommit)
+}
+
+func countCoupons(repo *GormRepository) int {
+	var count int
+	repo.db.Model(&domain.Coupon{}).Count(&count)
+	return count
+}
+
+func testWithTxCommit(t *testing.T) {
+	repo := singleRecordDB(t)
+	defer repo.Close()
+
+	err := repo.WithTx(context.Background(), func(tx domain.Repository) error {
+		if _, err := tx.NewCoupon(context.Background(), newAPICoupon()); err != nil {
+			return err
+		}
+		return tx.DeleteCoupon(context.Background(), 1)
+	})
+
+	assert.Nil(t, err)
+	var c domain.Coupon
+	repo.db.Find(&c, 2)
+	assert.Equal(t, Name, c.Name)
+	assert.Equal(t, 1, countCoupons(repo))
+}
+
+func testWithTxRollback(t *testing.T) {
+	repo := singleRecordDB(t)
+	defer repo.Close()
+
+	err := repo.WithTx(context.Background(), func(tx domain.Repository) error {
+		if _, err := tx.NewCoupon(context.Background(), newAPICoupon()); err != nil {
+			return err
+		}
+		return tx.DeleteCoupon(context.Background(), 3)
+	})
+
+	assert.IsType(t, domain.CouponNotFoundError{}, err)
+	assert.Equal(t, 1, countCoupons(repo))
+}
+
+func testWithTxPanic(t *testing.T) {
+	repo := singleRecordDB(t)
+	defer repo.Close()
+
+	assert.Panics(t, func() {
+		repo.WithTx(context.Background(), func(tx domain.Repository) error {
+			tx.NewCoupon(context.Background(), newAPICoupon())
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 1, countCoupons(repo))
+}
+
+func testWithTxSavepointRollback(t *testing.T) {
+	repo := singleRecordDB(t)
+	defer repo.Close()
+
+	var nestedErr error
+	err := repo.WithTx(context.Background(), func(tx domain.Repository) error {
+		if _, err := tx.NewCoupon(context.Background(), newAPICoupon()); err != nil {
+			return err
+		}
+		nestedErr = tx.WithTx(context.Background(), func(nested domain.Repository) error {
+			if err := nested.DeleteCoupon(context.Background(), 1); err != nil {
+				return err
+			}
+			return errors.New("nested failure")
+		})
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.EqualError(t, nestedErr, "nested failure")
+	// the outer coupon is committed while the nested deletion is rolled back
+	assert.Equal(t, 2, countCoupons(repo))
+}
+
+func testWithTxSavepointCommit(t *testing.T) {
+	repo := singleRecordDB(t)
+	defer repo.Close()
+
+	err := repo.WithTx(context.Background(), func(tx domain.Repository) error {
+		if err := tx.WithTx(context.Background(), func(nested domain.Repository) error {
+			return nested.DeleteCoupon(context.Background(), 1)
+		}); err != nil {
+			return err
+		}
+		return errors.New("outer failure")
+	})
+
+	assert.EqualError(t, err, "outer failure")
+	// rolling back the outer transaction discards the released savepoint too
+	assert.Equal(t, 1, countCoupons(repo))
+}
+
 // TestGetCouponByID test if it can get a record and if the record does not exist it returns a coupon.ID == 0
 func TestGetCouponByID(t *testing.T) {
 	t.Run("recordExist", testRecordExist)
 	t.Run("recordDoesNotExist", testNoRecord)
+	t.Run("selectedColumns", testSelectedColumns)
 }
 
 func testRecordExist(t *testing.T) {
@@ -119,7 +254,7 @@ func testRecordExist(t *testing.T) {
 	defer repo.Close()
 	var rCoupon domain.Coupon
 
-	repo.GetCouponByID(1, &rCoupon)
+	repo.GetCouponByID(context.Background(), 1, nil, &rCoupon)
 
 	assert.Equal(t, rCoupon.Name, name)
 	assert.Equal(t, rCoupon.Brand, brand)
@@ -127,12 +262,25 @@ func testRecordExist(t *testing.T) {
 	assert.Equal(t, rCoupon.Expiry.Unix(), secs)
 }
 
+func testSelectedColumns(t *testing.T) {
+	repo := singleRecordDB(t)
+	defer repo.Close()
+	var rCoupon domain.Coupon
+
+	assert.Nil(t, repo.GetCouponByID(context.Background(), 1, []string{"id", "name"}, &rCoupon))
+
+	assert.Equal(t, rCoupon.ID, uint(1))
+	assert.Equal(t, rCoupon.Name, name)
+	assert.Equal(t, rCoupon.Brand, "")
+	assert.Equal(t, rCoupon.Value, uint(0))
+}
+
 func testNoRecord(t *testing.T) {
 	repo := singleRecordDB(t)
 	defer repo.Close()
 	var rCoupon = domain.Coupon{}
 
-	assert.Error(t, repo.GetCouponByID(2, &rCoupon), domain.CouponNotFoundErrorMessage)
+	assert.Error(t, repo.GetCouponByID(context.Background(), 2, nil, &rCoupon), domain.CouponNotFoundErrorMessage)
 	assert.Equal(t, rCoupon.ID, uint(0))
 }
 
@@ -145,14 +293,14 @@ func testDelete(t *testing.T) {
 	repo := singleRecordDB(t)
 	defer repo.Close()
 
-	assert.Nil(t, repo.DeleteCoupon(1))
+	assert.Nil(t, repo.DeleteCoupon(context.Background(), 1))
 }
 
 func testDeleteDoesNotExist(t *testing.T) {
 	repo := startDB(t)
 	defer repo.Close()
 
-	assert.Equal(t, repo.DeleteCoupon(1), domain.NewCouponNotFoundError())
+	assert.Equal(t, repo.DeleteCoupon(context.Background(), 1), domain.NewCouponNotFoundError())
 }
 
 func TestUpdateCoupon(t *testing.T) {
@@ -170,7 +318,7 @@ func testUpdateEmptyCoupon(t *testing.T) {
 	defer repo.Close()
 	a := domain.APICoupon{}
 
-	assert.Nil(t, repo.UpdateCoupon(1, a))
+	assert.Nil(t, repo.UpdateCoupon(context.Background(), 1, a))
 }
 
 func testUpdateName(t *testing.T) {
@@ -180,7 +328,7 @@ func testUpdateName(t *testing.T) {
 		Name: &Name,
 	}
 
-	assert.Nil(t, repo.UpdateCoupon(1, a))
+	assert.Nil(t, repo.UpdateCoupon(context.Background(), 1, a))
 
 	var c domain.Coupon
 	repo.db.Find(&c, 1)
@@ -194,7 +342,7 @@ func testUpdateBrand(t *testing.T) {
 		Brand: &Brand,
 	}
 
-	assert.Nil(t, repo.UpdateCoupon(1, a))
+	assert.Nil(t, repo.UpdateCoupon(context.Background(), 1, a))
 
 	var c domain.Coupon
 	repo.db.Find(&c, 1)
@@ -208,7 +356,7 @@ func testUpdateValue(t *testing.T) {
 		Value: &Value,
 	}
 
-	assert.Nil(t, repo.UpdateCoupon(1, a))
+	assert.Nil(t, repo.UpdateCoupon(context.Background(), 1, a))
 
 	var c domain.Coupon
 	repo.db.Find(&c, 1)
@@ -222,7 +370,7 @@ func testUpdateExpiry(t *testing.T) {
 		Expiry: &Time,
 	}
 
-	assert.Nil(t, repo.UpdateCoupon(1, a))
+	assert.Nil(t, repo.UpdateCoupon(context.Background(), 1, a))
 
 	var c domain.Coupon
 	repo.db.Find(&c, 1)
@@ -239,7 +387,7 @@ func testFullUpdate(t *testing.T) {
 		Expiry: &Time,
 	}
 
-	assert.Nil(t, repo.UpdateCoupon(1, a))
+	assert.Nil(t, repo.UpdateCoupon(context.Background(), 1, a))
 
 	var c domain.Coupon
 	repo.db.Find(&c, 1)
@@ -254,7 +402,7 @@ func testUpdateCouponNotFound(t *testing.T) {
 	defer repo.Close()
 	a := domain.APICoupon{}
 
-	assert.Equal(t, repo.UpdateCoupon(1, a), domain.NewCouponNotFoundError())
+	assert.Equal(t, repo.UpdateCoupon(context.Background(), 1, a), domain.NewCouponNotFoundError())
 }
 
 // TestQueryCoupons tests the function QueryCoupons
@@ -279,6 +427,11 @@ func TestQueryCoupons(t *testing.T) {
 	t.Run("lesserThanCreated", testLTCreated)
 	t.Run("greaterThanCreated", testGTCreated)
 	t.Run("limitedCreated", testLimitedCreated)
+	t.Run("inName", testInName)
+	t.Run("inValue", testInValue)
+	t.Run("iLikeBrand", testILikeBrand)
+	t.Run("prefixName", testPrefixName)
+	t.Run("prefixEscapesWildcards", testPrefixEscapesWildcards)
 }
 
 func testNilQuery(t *testing.T) {
@@ -286,7 +439,7 @@ func testNilQuery(t *testing.T) {
 	defer repo.Close()
 	var Coupons []domain.Coupon
 
-	repo.QueryCoupons(&Coupons, nil)
+	repo.QueryCoupons(context.Background(), &Coupons, domain.CouponQuery{})
 
 	assert.Equal(t, len(Coupons), 4)
 }
@@ -297,7 +450,7 @@ func testEmptyQuery(t *testing.T) {
 	var Coupons []domain.Coupon
 	query := make(map[string]interface{})
 
-	repo.QueryCoupons(&Coupons, query)
+	repo.QueryCoupons(context.Background(), &Coupons, equalQuery(query))
 
 	assert.Equal(t, len(Coupons), 4)
 }
@@ -309,7 +462,7 @@ func testName1Query(t *testing.T) {
 	query := make(map[string]interface{})
 	query["name"] = name + "1"
 
-	repo.QueryCoupons(&Coupons, query)
+	repo.QueryCoupons(context.Background(), &Coupons, equalQuery(query))
 
 	assert.Equal(t, len(Coupons), 2)
 	for _, c := range Coupons {
@@ -324,7 +477,7 @@ func testName2Query(t *testing.T) {
 	query := make(map[string]interface{})
 	query["name"] = name + "2"
 
-	repo.QueryCoupons(&Coupons, query)
+	repo.QueryCoupons(context.Background(), &Coupons, equalQuery(query))
 
 	assert.Equal(t, len(Coupons), 2)
 	for _, c := range Coupons {
@@ -339,7 +492,7 @@ func testBrand1Query(t *testing.T) {
 	query := make(map[string]interface{})
 	query["brand"] = brand + "1"
 
-	repo.QueryCoupons(&Coupons, query)
+	repo.QueryCoupons(context.Background(), &Coupons, equalQuery(query))
 
 	assert.Equal(t, len(Coupons), 2)
 	for _, c := range Coupons {
@@ -354,7 +507,7 @@ func testBrand2Query(t *testing.T) {
 	query := make(map[string]interface{})
 	query["brand"] = brand + "2"
 
-	repo.QueryCoupons(&Coupons, query)
+	repo.QueryCoupons(context.Background(), &Coupons, equalQuery(query))
 
 	assert.Equal(t, len(Coupons), 2)
 	for _, c := range Coupons {
@@ -369,7 +522,7 @@ func testValue1Query(t *testing.T) {
 	query := make(map[string]interface{})
 	query["value"] = value
 
-	repo.QueryCoupons(&Coupons, query)
+	repo.QueryCoupons(context.Background(), &Coupons, equalQuery(query))
 
 	assert.Equal(t, len(Coupons), 2)
 	for _, c := range Coupons {
@@ -384,7 +537,7 @@ func testValue2Query(t *testing.T) {
 	query := make(map[string]interface{})
 	query["value"] = value * 2
 
-	repo.QueryCoupons(&Coupons, query)
+	repo.QueryCoupons(context.Background(), &Coupons, equalQuery(query))
 
 	assert.Equal(t, len(Coupons), 2)
 	for _, c := range Coupons {
@@ -396,9 +549,9 @@ func testBatching1(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	batch := repo.QueryBatchingFunction(1, 1)
+	query := domain.CouponQuery{}.Paginate(1, 1)
 
-	repo.QueryCoupons(&Coupons, nil, batch)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 1)
 	assert.Equal(t, Coupons[0].ID, uint(1))
@@ -409,9 +562,9 @@ func testBatching2(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	batch := repo.QueryBatchingFunction(2, 1)
+	query := domain.CouponQuery{}.Paginate(2, 1)
 
-	repo.QueryCoupons(&Coupons, nil, batch)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 2)
 	assert.Equal(t, Coupons[0].ID, uint(1))
@@ -422,9 +575,9 @@ func testBatching3(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	batch := repo.QueryBatchingFunction(1, 4)
+	query := domain.CouponQuery{}.Paginate(1, 4)
 
-	repo.QueryCoupons(&Coupons, nil, batch)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 1)
 	assert.Equal(t, Coupons[0].ID, uint(4))
@@ -434,9 +587,9 @@ func testLTExpiry(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	ltExpiry := repo.QueryLTExpiryFunction(time.Unix(secs+1, 0))
+	query := domain.CouponQuery{}.Where("expiry", domain.FilterLess, time.Unix(secs+1, 0))
 
-	repo.QueryCoupons(&Coupons, nil, ltExpiry)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 2)
 	assert.Equal(t, Coupons[0].Expiry.Unix(), int64(secs))
@@ -447,9 +600,9 @@ func testGTExpiry(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	gtExpiry := repo.QueryGTExpiryFunction(time.Unix(secs+1, 0))
+	query := domain.CouponQuery{}.Where("expiry", domain.FilterGreater, time.Unix(secs+1, 0))
 
-	repo.QueryCoupons(&Coupons, nil, gtExpiry)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 2)
 	assert.Equal(t, Coupons[0].Expiry.Unix(), int64(secs*2))
@@ -460,10 +613,9 @@ func testLimitedExpiry(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	ltExpiry := repo.QueryLTExpiryFunction(time.Unix(secs+1, 0))
-	gtExpiry := repo.QueryGTExpiryFunction(time.Unix(secs-1, 0))
+	query := domain.CouponQuery{}.Where("expiry", domain.FilterGreater, time.Unix(secs-1, 0)).Where("expiry", domain.FilterLess, time.Unix(secs+1, 0))
 
-	repo.QueryCoupons(&Coupons, nil, gtExpiry, ltExpiry)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 2)
 	assert.Equal(t, Coupons[0].Expiry.Unix(), int64(secs))
@@ -474,9 +626,9 @@ func testLTValue(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	ltValue := repo.QueryLTValueFunction(value + 1)
+	query := domain.CouponQuery{}.Where("value", domain.FilterLess, value+1)
 
-	repo.QueryCoupons(&Coupons, nil, ltValue)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 2)
 	assert.Equal(t, Coupons[0].Value, value)
@@ -487,9 +639,9 @@ func testGTValue(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	gtValue := repo.QueryGTValueFunction(value + 1)
+	query := domain.CouponQuery{}.Where("value", domain.FilterGreater, value+1)
 
-	repo.QueryCoupons(&Coupons, nil, gtValue)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 2)
 	assert.Equal(t, Coupons[0].Value, value*2)
@@ -500,10 +652,9 @@ func testLimitedValue(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	gtValue := repo.QueryGTValueFunction(value - 1)
-	ltValue := repo.QueryLTValueFunction(value + 1)
+	query := domain.CouponQuery{}.Where("value", domain.FilterGreater, value-1).Where("value", domain.FilterLess, value+1)
 
-	repo.QueryCoupons(&Coupons, nil, gtValue, ltValue)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 2)
 	assert.Equal(t, Coupons[0].Value, value)
@@ -514,9 +665,9 @@ func testLTCreated(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	ltCreated := repo.QueryLTCreatedFunction(time.Now().Add(time.Duration(10 * time.Second)))
+	query := domain.CouponQuery{}.Where("created_at", domain.FilterLess, time.Now().Add(time.Duration(10*time.Second)))
 
-	repo.QueryCoupons(&Coupons, nil, ltCreated)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 4)
 }
@@ -525,9 +676,9 @@ func testGTCreated(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	gtCreated := repo.QueryGTCreatedFunction(time.Now().Truncate(time.Duration(10 * time.Second)))
+	query := domain.CouponQuery{}.Where("created_at", domain.FilterGreater, time.Now().Truncate(time.Duration(10*time.Second)))
 
-	repo.QueryCoupons(&Coupons, nil, gtCreated)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 4)
 }
@@ -536,14 +687,292 @@ func testLimitedCreated(t *testing.T) {
 	repo := multipleRecordDB(t)
 	defer repo.Close()
 	var Coupons []domain.Coupon
-	gtCreated := repo.QueryGTCreatedFunction(time.Now().Truncate(time.Duration(10 * time.Second)))
-	ltCreated := repo.QueryLTCreatedFunction(time.Now().Add(time.Duration(10 * time.Second)))
+	query := domain.CouponQuery{}.Where("created_at", domain.FilterGreater, time.Now().Truncate(time.Duration(10*time.Second))).Where("created_at", domain.FilterLess, time.Now().Add(time.Duration(10*time.Second)))
+
+	repo.QueryCoupons(context.Background(), &Coupons, query)
+
+	assert.Equal(t, len(Coupons), 4)
+}
+
+func testInName(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var Coupons []domain.Coupon
+	query := domain.CouponQuery{}.Where("name", domain.FilterEqual, name+"1", name+"2")
+
+	repo.QueryCoupons(context.Background(), &Coupons, query)
+
+	assert.Equal(t, len(Coupons), 4)
+}
+
+func testInValue(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var Coupons []domain.Coupon
+	query := domain.CouponQuery{}.Where("value", domain.FilterEqual, value, value*3)
+
+	repo.QueryCoupons(context.Background(), &Coupons, query)
+
+	assert.Equal(t, len(Coupons), 2)
+	for _, c := range Coupons {
+		assert.Equal(t, c.Value, value)
+	}
+}
+
+func testILikeBrand(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var Coupons []domain.Coupon
+	query := domain.CouponQuery{}.Where("brand", domain.FilterILike, "BRAND1", "Brand3")
 
-	repo.QueryCoupons(&Coupons, nil, gtCreated, ltCreated)
+	repo.QueryCoupons(context.Background(), &Coupons, query)
+
+	assert.Equal(t, len(Coupons), 2)
+	for _, c := range Coupons {
+		assert.Equal(t, c.Brand, brand+"1")
+	}
+}
+
+func testPrefixName(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var Coupons []domain.Coupon
+	query := domain.CouponQuery{}.Where("name", domain.FilterPrefix, "NA")
+
+	repo.QueryCoupons(context.Background(), &Coupons, query)
 
 	assert.Equal(t, len(Coupons), 4)
 }
 
+func testPrefixEscapesWildcards(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var Coupons []domain.Coupon
+	query := domain.CouponQuery{}.Where("name", domain.FilterPrefix, "n_me", "%")
+
+	repo.QueryCoupons(context.Background(), &Coupons, query)
+
+	assert.Equal(t, len(Coupons), 0)
+}
+
+// TestQueryCouponsConcurrent issues concurrent filtered queries on the same repository,
+// every query must only return the coupons matching its own filters. Run it with -race
+func TestQueryCouponsConcurrent(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	// a base query shared by every goroutine, each one extends it with its own filters
+	base := domain.CouponQuery{}.Paginate(10, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := fmt.Sprintf("%s%d", name, i%2+1)
+			b := fmt.Sprintf("%s%d", brand, i%4/2+1)
+			query := base.Where("name", domain.FilterEqual, n)
+			if i%3 == 0 {
+				query = query.Where("brand", domain.FilterEqual, b)
+			}
+
+			var coupons []domain.Coupon
+			if err := repo.QueryCoupons(context.Background(), &coupons, query); err != nil {
+				t.Error(err)
+				return
+			}
+
+			expected := 2
+			if i%3 == 0 {
+				expected = 1
+			}
+			assert.Equal(t, expected, len(coupons))
+			for _, c := range coupons {
+				assert.Equal(t, n, c.Name)
+				if i%3 == 0 {
+					assert.Equal(t, b, c.Brand)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestContext tests that canceled and timed out operations report the context error
+func TestContext(t *testing.T) {
+	t.Run("canceled", testContextCanceled)
+	t.Run("readTimeout", testContextReadTimeout)
+	t.Run("writeTimeout", testContextWriteTimeout)
+}
+
+func testContextCanceled(t *testing.T) {
+	repo := singleRecordDB(t)
+	defer repo.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var c domain.Coupon
+	assert.Equal(t, context.Canceled, repo.GetCouponByID(ctx, 1, nil, &c))
+	assert.Equal(t, context.Canceled, repo.DeleteCoupon(ctx, 1))
+
+	// the coupon is still there
+	assert.Nil(t, repo.GetCouponByID(context.Background(), 1, nil, &c))
+}
+
+func testContextReadTimeout(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	repo.timeouts.Read = time.Nanosecond
+
+	var coupons []domain.Coupon
+	assert.Equal(t, context.DeadlineExceeded, repo.QueryCoupons(context.Background(), &coupons, domain.CouponQuery{}))
+	var stats []domain.CouponStats
+	assert.Equal(t, context.DeadlineExceeded, repo.CouponStats(context.Background(), &stats, nil, domain.CouponQuery{}))
+}
+
+func testContextWriteTimeout(t *testing.T) {
+	repo := singleRecordDB(t)
+	defer repo.Close()
+	repo.timeouts.Write = time.Nanosecond
+
+	_, err := repo.NewCoupon(context.Background(), newAPICoupon())
+	assert.Equal(t, context.DeadlineExceeded, err)
+	err = repo.WithTx(context.Background(), func(tx domain.Repository) error { return nil })
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+// TestExportCoupons tests the row cursor of ExportCoupons
+func TestExportCoupons(t *testing.T) {
+	t.Run("all", testExportAll)
+	t.Run("filtered", testExportFiltered)
+	t.Run("stopped", testExportStopped)
+}
+
+func testExportAll(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var ids []uint
+
+	err := repo.ExportCoupons(context.Background(), func(c domain.Coupon) error {
+		ids = append(ids, c.ID)
+		return nil
+	}, domain.CouponQuery{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{1, 2, 3, 4}, ids)
+}
+
+func testExportFiltered(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var Coupons []domain.Coupon
+	query := domain.CouponQuery{}.Where("name", domain.FilterEqual, name+"1").Where("value", domain.FilterLess, value+1)
+
+	err := repo.ExportCoupons(context.Background(), func(c domain.Coupon) error {
+		Coupons = append(Coupons, c)
+		return nil
+	}, query)
+
+	assert.Nil(t, err)
+	assert.Equal(t, len(Coupons), 2)
+	for _, c := range Coupons {
+		assert.Equal(t, c.Name, name+"1")
+		assert.Equal(t, c.Value, value)
+	}
+}
+
+func testExportStopped(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	count := 0
+	stop := errors.New("stop")
+
+	err := repo.ExportCoupons(context.Background(), func(c domain.Coupon) error {
+		count++
+		return stop
+	}, domain.CouponQuery{})
+
+	assert.Equal(t, stop, err)
+	assert.Equal(t, count, 1)
+}
+
+// TestCouponStats tests the aggregations of CouponStats
+func TestCouponStats(t *testing.T) {
+	t.Run("noGroups", testStatsNoGroups)
+	t.Run("groupByBrand", testStatsGroupByBrand)
+	t.Run("groupByStatusAndMonth", testStatsGroupByStatusAndMonth)
+	t.Run("filtered", testStatsFiltered)
+}
+
+func testStatsNoGroups(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var stats []domain.CouponStats
+
+	assert.Nil(t, repo.CouponStats(context.Background(), &stats, nil, domain.CouponQuery{}))
+
+	assert.Equal(t, len(stats), 1)
+	assert.Equal(t, stats[0].Count, uint(4))
+	assert.Equal(t, stats[0].Sum, value*6)
+	assert.Equal(t, stats[0].Min, value)
+	assert.Equal(t, stats[0].Max, value*2)
+}
+
+func testStatsGroupByBrand(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var stats []domain.CouponStats
+
+	assert.Nil(t, repo.CouponStats(context.Background(), &stats, []string{domain.StatsGroupBrand}, domain.CouponQuery{}))
+
+	assert.Equal(t, len(stats), 2)
+	for i, s := range stats {
+		assert.Equal(t, *s.Brand, fmt.Sprintf("%s%d", brand, i+1))
+		assert.Nil(t, s.Status)
+		assert.Equal(t, s.Count, uint(2))
+		assert.Equal(t, s.Sum, value*3)
+	}
+}
+
+func testStatsGroupByStatusAndMonth(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var stats []domain.CouponStats
+
+	assert.Nil(t, repo.CouponStats(context.Background(), &stats, []string{domain.StatsGroupStatus, domain.StatsGroupExpiryMonth}, domain.CouponQuery{}))
+
+	// half of the test coupons expired in 2001, the other half expires in 2033
+	assert.Equal(t, len(stats), 2)
+	for _, s := range stats {
+		assert.Equal(t, s.Count, uint(2))
+	}
+	assert.Equal(t, *stats[0].Status, domain.CouponStatusActive)
+	assert.Equal(t, *stats[0].ExpiryMonth, time.Unix(2*secs, 0).UTC().Format("2006-01"))
+	assert.Equal(t, *stats[1].Status, domain.CouponStatusExpired)
+	assert.Equal(t, *stats[1].ExpiryMonth, time.Unix(secs, 0).UTC().Format("2006-01"))
+}
+
+func testStatsFiltered(t *testing.T) {
+	repo := multipleRecordDB(t)
+	defer repo.Close()
+	var stats []domain.CouponStats
+	query := domain.CouponQuery{}.Where("brand", domain.FilterEqual, brand+"1").Where("value", domain.FilterGreater, value)
+
+	assert.Nil(t, repo.CouponStats(context.Background(), &stats, nil, query))
+
+	assert.Equal(t, len(stats), 1)
+	assert.Equal(t, stats[0].Count, uint(1))
+	assert.Equal(t, stats[0].Sum, value*2)
+}
+
+// equalQuery builds a CouponQuery matching the records whose columns equal the values of query
+func equalQuery(query map[string]interface{}) domain.CouponQuery {
+	var q domain.CouponQuery
+	for column, value := range query {
+		q = q.Where(column, domain.FilterEqual, value)
+	}
+	return q
+}
+
 func singleRecordDB(t *testing.T) *GormRepository {
 	var coupon = domain.Coupon{
 		Name:   name,
@@ -604,14 +1033,25 @@ func multipleRecordDB(t *testing.T) *GormRepository {
 
 func startDB(t *testing.T) *GormRepository {
 	// open db with GORM
-	db, err := postgres.Open(host, port, user, dbName, password)
+	db, err := postgres.Open(postgres.Config{Host: host, Port: port, User: user, Name: dbName, Password: password})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// drop and create table
-	db.DropTableIfExists(&domain.Coupon{})
-	db.AutoMigrate(&domain.Coupon{})
+	// drop and migrate tables
+	db.DropTableIfExists(&domain.Coupon{}, "api_keys", "audit_entries", "webhooks", "webhook_events", "webhook_deliveries", "schema_migrations")
+	db.Exec("DROP FUNCTION IF EXISTS audit_entries_immutable()")
+	fsys, err := migrations.FS(db.Dialect().GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
 
-	return New(db)
+	return New(db, Timeouts{})
 }
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/repository/migrations"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/migrate"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSQLiteConformance runs the shared repository conformance suite against GormRepository on sqlite
+func TestSQLiteConformance(t *testing.T) {
+	testConformance(t, func(t *testing.T) domain.Repository {
+		repo := startSQLite(t)
+		t.Cleanup(repo.Close)
+		return repo
+	})
+}
+
+// TestSQLiteAuditImmutable checks that the audit entries can be neither updated nor deleted
+func TestSQLiteAuditImmutable(t *testing.T) {
+	repo := startSQLite(t)
+	defer repo.Close()
+	if _, err := repo.NewCoupon(context.Background(), newAPICoupon()); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Error(t, repo.db.Exec("UPDATE audit_entries SET actor = ?", "someone").Error)
+	assert.Error(t, repo.db.Exec("DELETE FROM audit_entries").Error)
+
+	var entries []domain.AuditEntry
+	assert.Nil(t, repo.QueryAudit(context.Background(), &entries, domain.AuditQuery{}))
+	if assert.Equal(t, 1, len(entries)) {
+		assert.Equal(t, domain.AnonymousActor, entries[0].Actor)
+	}
+}
+
+// startSQLite returns a GormRepository on a migrated in memory sqlite database
+func startSQLite(t *testing.T) *GormRepository {
+	db, err := sqlite.Open(sqlite.Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := migrations.FS(db.Dialect().GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return New(db, Timeouts{})
+}
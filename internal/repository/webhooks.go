@@ -0,0 +1,421 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/gormtrace"
+	"github.com/jinzhu/gorm"
+)
+
+// webhook is the webhooks record of a domain.Webhook, its events are stored space separated
+type webhook struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	TenantID  string
+	URL       string
+	Events    string
+	Secret    string
+}
+
+// TableName is the table of the records
+func (webhook) TableName() string {
+	return "webhooks"
+}
+
+func newWebhookRecord(w domain.Webhook) webhook {
+	return webhook{TenantID: w.TenantID, URL: w.URL, Events: strings.Join(w.Events, " "), Secret: w.Secret}
+}
+
+func (r webhook) toDomain() domain.Webhook {
+	return domain.Webhook{
+		ID:        r.ID,
+		CreatedAt: r.CreatedAt,
+		Tenanted:  domain.Tenanted{TenantID: r.TenantID},
+		URL:       r.URL,
+		Events:    strings.Fields(r.Events),
+		Secret:    r.Secret,
+	}
+}
+
+// webhookEvent is the webhook_events record of a domain.Event, the outbox of the events, its data is stored
+// as a JSON object
+type webhookEvent struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	TenantID  string
+	Type      string
+	Payload   string
+}
+
+// TableName is the table of the records
+func (webhookEvent) TableName() string {
+	return "webhook_events"
+}
+
+func newEventRecord(e domain.Event) (webhookEvent, error) {
+	payload, err := json.Marshal(e.Data)
+	if err != nil {
+		return webhookEvent{}, err
+	}
+	return webhookEvent{TenantID: e.TenantID, Type: e.Type, Payload: string(payload)}, nil
+}
+
+func (r webhookEvent) toDomain() (domain.Event, error) {
+	e := domain.Event{ID: r.ID, CreatedAt: r.CreatedAt, Tenanted: domain.Tenanted{TenantID: r.TenantID}, Type: r.Type}
+	if err := json.Unmarshal([]byte(r.Payload), &e.Data); err != nil {
+		return domain.Event{}, err
+	}
+	return e, nil
+}
+
+// delivery is the webhook_deliveries record of a domain.Delivery
+type delivery struct {
+	ID             uint `gorm:"primary_key"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	TenantID       string
+	WebhookID      uint
+	EventID        uint
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode int
+	LastError      string
+	DeliveredAt    *time.Time
+}
+
+// TableName is the table of the records
+func (delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+func (r delivery) toDomain() domain.Delivery {
+	return domain.Delivery{
+		ID:             r.ID,
+		CreatedAt:      r.CreatedAt,
+		UpdatedAt:      r.UpdatedAt,
+		Tenanted:       domain.Tenanted{TenantID: r.TenantID},
+		WebhookID:      r.WebhookID,
+		EventID:        r.EventID,
+		EventType:      r.EventType,
+		Payload:        json.RawMessage(r.Payload),
+		Status:         r.Status,
+		Attempts:       r.Attempts,
+		NextAttemptAt:  r.NextAttemptAt,
+		LastStatusCode: r.LastStatusCode,
+		LastError:      r.LastError,
+		DeliveredAt:    r.DeliveredAt,
+	}
+}
+
+// GormWebhookRepository is the domain.WebhookRepository of the db
+// The webhooks and their deliveries are always read from the primary db, the dispatcher claims the deliveries it reads
+type GormWebhookRepository struct {
+	db       *gorm.DB
+	timeouts Timeouts
+}
+
+// NewWebhooks is the GormWebhookRepository constructor, db is not closed by the repository
+func NewWebhooks(db *gorm.DB, timeouts Timeouts) *GormWebhookRepository {
+	return &GormWebhookRepository{db: db, timeouts: timeouts}
+}
+
+// NewWebhook creates the webhook record of the tenant of ctx and sets the ID, CreatedAt and tenant of w
+func (wr *GormWebhookRepository) NewWebhook(ctx context.Context, w *domain.Webhook) error {
+	ctx, cancel := withTimeout(ctx, wr.timeouts.Write)
+	defer cancel()
+
+	w.TenantID = domain.TenantFrom(ctx)
+	r := newWebhookRecord(*w)
+	if err := bind(ctx, wr.db).Create(&r).Error; err != nil {
+		return ctxErr(ctx, err)
+	}
+	w.ID, w.CreatedAt = r.ID, r.CreatedAt
+	return nil
+}
+
+// GetWebhookByID reads the webhook record with id
+// If there is no such record a WebhookNotFoundError is returned
+func (wr *GormWebhookRepository) GetWebhookByID(ctx context.Context, id uint, w *domain.Webhook) error {
+	ctx, cancel := withTimeout(ctx, wr.timeouts.Read)
+	defer cancel()
+
+	return ctxErr(ctx, firstWebhook(tenantScope(ctx, bind(ctx, wr.db)), id, w))
+}
+
+// ListWebhooks reads every webhook record in id order
+func (wr *GormWebhookRepository) ListWebhooks(ctx context.Context, webhooks *[]domain.Webhook) error {
+	ctx, cancel := withTimeout(ctx, wr.timeouts.Read)
+	defer cancel()
+
+	var records []webhook
+	if err := tenantScope(ctx, bind(ctx, wr.db)).Order("id").Find(&records).Error; err != nil {
+		return ctxErr(ctx, err)
+	}
+	*webhooks = make([]domain.Webhook, len(records))
+	for i, r := range records {
+		(*webhooks)[i] = r.toDomain()
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook record with id and its delivery records within a transaction
+// If there is no such record a WebhookNotFoundError is returned
+func (wr *GormWebhookRepository) DeleteWebhook(ctx context.Context, id uint) error {
+	return wr.transaction(ctx, func(db *gorm.DB) error {
+		deleted := tenantScope(ctx, db).Where("id = ?", id).Delete(&webhook{})
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		if deleted.RowsAffected == 0 {
+			return domain.NewWebhookNotFoundError()
+		}
+		return tenantScope(ctx, db).Where("webhook_id = ?", id).Delete(&delivery{}).Error
+	})
+}
+
+// QueryDeliveries reads the delivery records of the webhook with webhookID matching q in reverse id order
+// If there is no such webhook a WebhookNotFoundError is returned
+func (wr *GormWebhookRepository) QueryDeliveries(ctx context.Context, webhookID uint, deliveries *[]domain.Delivery, q domain.DeliveryQuery) error {
+	ctx, cancel := withTimeout(ctx, wr.timeouts.Read)
+	defer cancel()
+
+	db := tenantScope(ctx, bind(ctx, wr.db))
+	if err := firstWebhook(db, webhookID, &domain.Webhook{}); err != nil {
+		return ctxErr(ctx, err)
+	}
+	db = db.Where("webhook_id = ?", webhookID)
+	if q.Status != "" {
+		db = db.Where("status = ?", q.Status)
+	}
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit).Offset(q.Offset)
+	}
+	var records []delivery
+	if err := db.Order("id DESC").Find(&records).Error; err != nil {
+		return ctxErr(ctx, err)
+	}
+	*deliveries = make([]domain.Delivery, len(records))
+	for i, r := range records {
+		(*deliveries)[i] = r.toDomain()
+	}
+	return nil
+}
+
+// Redeliver sets the delivery record with id of the webhook with webhookID pending, without attempts and due now
+// If there is no such webhook a WebhookNotFoundError is returned, and a DeliveryNotFoundError if there is no such delivery
+func (wr *GormWebhookRepository) Redeliver(ctx context.Context, webhookID, id uint) error {
+	ctx, cancel := withTimeout(ctx, wr.timeouts.Write)
+	defer cancel()
+
+	db := tenantScope(ctx, bind(ctx, wr.db))
+	if err := firstWebhook(db, webhookID, &domain.Webhook{}); err != nil {
+		return ctxErr(ctx, err)
+	}
+	updated := db.Model(&delivery{}).Where("id = ? AND webhook_id = ?", id, webhookID).Updates(map[string]interface{}{
+		"status":          domain.DeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	})
+	if updated.Error != nil {
+		return ctxErr(ctx, updated.Error)
+	}
+	if updated.RowsAffected == 0 {
+		return domain.NewDeliveryNotFoundError()
+	}
+	return nil
+}
+
+// ExpireCoupons creates the expired event records of up to limit coupon records whose expiry passed, the earliest
+// expiry first, and sets their notified expiry within a transaction. A coupon notified meanwhile by another
+// dispatcher is skipped, so its expiry is notified once
+// The coupon records are looked up on the index of those not notified yet, the lookup does not grow with the coupons
+// notified already
+func (wr *GormWebhookRepository) ExpireCoupons(ctx context.Context, limit int) (int, error) {
+	var expired int
+	err := wr.transaction(ctx, func(db *gorm.DB) error {
+		notified := notNotified(db)
+		// the zero expiry is that of the coupons without one
+		var coupons []domain.Coupon
+		err := db.Where("expiry > ? AND expiry <= ?", time.Time{}, time.Now()).Where(notified).
+			Order("expiry, id").Limit(limit).Find(&coupons).Error
+		if err != nil {
+			return err
+		}
+		for _, c := range coupons {
+			updated := db.Table("coupons").Where("id = ?", c.ID).Where(notified).
+				UpdateColumn("notified_expiry", gorm.Expr("expiry"))
+			if updated.Error != nil {
+				return updated.Error
+			}
+			if updated.RowsAffected == 0 {
+				continue
+			}
+			event, err := newEventRecord(domain.NewExpiredEvent(c))
+			if err != nil {
+				return err
+			}
+			if err := db.Create(&event).Error; err != nil {
+				return err
+			}
+			expired++
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// notNotified returns the sql condition of the coupon records whose current expiry is not notified yet in the dialect
+// of db, the condition of the idx_coupons_expiry_not_notified partial index so the lookups use it
+func notNotified(db *gorm.DB) string {
+	if db.Dialect().GetName() == "sqlite3" {
+		return "notified_expiry IS NOT expiry"
+	}
+	return "notified_expiry IS DISTINCT FROM expiry"
+}
+
+// FanOut creates the delivery records of up to limit event records of the outbox, oldest first, and deletes them
+// within a transaction. An event deleted meanwhile by another dispatcher is skipped, so it is fanned out once
+func (wr *GormWebhookRepository) FanOut(ctx context.Context, limit int) (int, error) {
+	var fannedOut int
+	err := wr.transaction(ctx, func(db *gorm.DB) error {
+		var events []webhookEvent
+		if err := db.Order("id").Limit(limit).Find(&events).Error; err != nil {
+			return err
+		}
+		// the webhooks of each tenant are read once
+		webhooks := make(map[string][]webhook)
+		now := time.Now()
+		for _, r := range events {
+			deleted := db.Where("id = ?", r.ID).Delete(&webhookEvent{})
+			if deleted.Error != nil {
+				return deleted.Error
+			}
+			if deleted.RowsAffected == 0 {
+				continue
+			}
+
+			subscribed, ok := webhooks[r.TenantID]
+			if !ok {
+				if err := db.Where("tenant_id = ?", r.TenantID).Order("id").Find(&subscribed).Error; err != nil {
+					return err
+				}
+				webhooks[r.TenantID] = subscribed
+			}
+			e, err := r.toDomain()
+			if err != nil {
+				return err
+			}
+			payload, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			for _, w := range subscribed {
+				if !w.toDomain().Subscribes(r.Type) {
+					continue
+				}
+				d := delivery{
+					TenantID:      r.TenantID,
+					WebhookID:     w.ID,
+					EventID:       r.ID,
+					EventType:     r.Type,
+					Payload:       string(payload),
+					Status:        domain.DeliveryPending,
+					NextAttemptAt: now,
+				}
+				if err := db.Create(&d).Error; err != nil {
+					return err
+				}
+			}
+			fannedOut++
+		}
+		return nil
+	})
+	return fannedOut, err
+}
+
+// ClaimDeliveries reads up to limit pending delivery records due now, the longest due first, and postpones
+// the next attempt of each by lease unless another dispatcher claimed it meanwhile
+func (wr *GormWebhookRepository) ClaimDeliveries(ctx context.Context, lease time.Duration, limit int, deliveries *[]domain.Delivery) error {
+	ctx, cancel := withTimeout(ctx, wr.timeouts.Write)
+	defer cancel()
+
+	db := bind(ctx, wr.db)
+	now := time.Now()
+	var records []delivery
+	err := db.Where("status = ? AND next_attempt_at <= ?", domain.DeliveryPending, now).
+		Order("next_attempt_at, id").Limit(limit).Find(&records).Error
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	claimed := make([]domain.Delivery, 0, len(records))
+	for _, r := range records {
+		// the claim fails if another dispatcher postponed the attempt already
+		updated := db.Model(&delivery{}).Where("id = ? AND status = ? AND next_attempt_at <= ?", r.ID, domain.DeliveryPending, now).
+			Update("next_attempt_at", now.Add(lease))
+		if updated.Error != nil {
+			return ctxErr(ctx, updated.Error)
+		}
+		if updated.RowsAffected == 1 {
+			r.NextAttemptAt = now.Add(lease)
+			claimed = append(claimed, r.toDomain())
+		}
+	}
+	*deliveries = claimed
+	return nil
+}
+
+// UpdateDelivery writes the outcome of the attempt of d to its delivery record, a record deleted meanwhile
+// along with its webhook is left deleted
+func (wr *GormWebhookRepository) UpdateDelivery(ctx context.Context, d *domain.Delivery) error {
+	ctx, cancel := withTimeout(ctx, wr.timeouts.Write)
+	defer cancel()
+
+	err := bind(ctx, wr.db).Model(&delivery{}).Where("id = ?", d.ID).Updates(map[string]interface{}{
+		"status":           d.Status,
+		"attempts":         d.Attempts,
+		"next_attempt_at":  d.NextAttemptAt,
+		"last_status_code": d.LastStatusCode,
+		"last_error":       d.LastError,
+		"delivered_at":     d.DeliveredAt,
+	}).Error
+	return ctxErr(ctx, err)
+}
+
+// transaction runs fn within a transaction bounded by the write timeout, committed if fn returns nil
+func (wr *GormWebhookRepository) transaction(ctx context.Context, fn func(db *gorm.DB) error) error {
+	ctx, cancel := withTimeout(ctx, wr.timeouts.Write)
+	defer cancel()
+
+	tx := bind(ctx, wr.db).BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return ctxErr(ctx, tx.Error)
+	}
+	if err := fn(gormtrace.WithContext(ctx, tx)); err != nil {
+		tx.Rollback()
+		return ctxErr(ctx, err)
+	}
+	return ctxErr(ctx, tx.Commit().Error)
+}
+
+// firstWebhook reads the webhook record of db with id into w
+// If there is no such record a WebhookNotFoundError is returned
+func firstWebhook(db *gorm.DB, id uint, w *domain.Webhook) error {
+	var r webhook
+	err := db.Where("id = ?", id).First(&r).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return domain.NewWebhookNotFoundError()
+	}
+	if err != nil {
+		return err
+	}
+	*w = r.toDomain()
+	return nil
+}
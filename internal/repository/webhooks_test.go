@@ -0,0 +1,293 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/repository/migrations"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryWebhooks runs the webhook repository conformance suite against MemoryWebhookRepository
+func TestMemoryWebhooks(t *testing.T) {
+	testWebhookConformance(t, func(t *testing.T) (domain.Repository, domain.WebhookRepository) {
+		coupons := NewMemory()
+		return coupons, NewMemoryWebhooks(coupons)
+	})
+}
+
+// TestSQLiteWebhooks runs the webhook repository conformance suite against GormWebhookRepository on sqlite
+func TestSQLiteWebhooks(t *testing.T) {
+	testWebhookConformance(t, func(t *testing.T) (domain.Repository, domain.WebhookRepository) {
+		repo := startSQLite(t)
+		t.Cleanup(repo.Close)
+		return repo, NewWebhooks(repo.db, Timeouts{})
+	})
+}
+
+// TestSQLiteExpiryBackfill runs the expiry backfill test against sqlite, and checks that the lookup of the coupons
+// to notify uses the index of those not notified yet
+func TestSQLiteExpiryBackfill(t *testing.T) {
+	repo := startSQLite(t)
+	t.Cleanup(repo.Close)
+	testExpiryBackfill(t, repo)
+
+	var plan []struct {
+		ID, Parent, NotUsed int
+		Detail              string
+	}
+	query := "EXPLAIN QUERY PLAN SELECT * FROM coupons WHERE expiry > ? AND expiry <= ? AND " + notNotified(repo.db) + " ORDER BY expiry, id"
+	assert.Nil(t, repo.db.Raw(query, time.Time{}, time.Now()).Scan(&plan).Error)
+	if assert.NotEmpty(t, plan) {
+		assert.Contains(t, plan[0].Detail, "idx_coupons_expiry_not_notified")
+	}
+}
+
+// testExpiryBackfill checks that the coupons expired before the expiry events existed are not notified once the
+// migration adding them is applied to repo
+func testExpiryBackfill(t *testing.T, repo *GormRepository) {
+	fsys, err := migrations.FS(repo.db.Dialect().GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(repo.db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// back to the schema without the expiry events
+	if _, err := m.Down(1); err != nil {
+		t.Fatal(err)
+	}
+	ctx := domain.WithTenant(context.Background(), "acme")
+	for i := 0; i < 3; i++ {
+		if _, err := repo.NewCoupon(ctx, newAPICoupon()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks := NewWebhooks(repo.db, Timeouts{})
+	n, err := webhooks.ExpireCoupons(context.Background(), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// the coupons expiring after the migration are notified
+	if _, err := repo.NewCoupon(ctx, newAPICoupon()); err != nil {
+		t.Fatal(err)
+	}
+	n, err = webhooks.ExpireCoupons(context.Background(), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+}
+
+// testWebhookConformance runs the behaviour every domain.WebhookRepository implementation must share, along with
+// the coupons repository writing its outbox
+func testWebhookConformance(t *testing.T, open func(t *testing.T) (domain.Repository, domain.WebhookRepository)) {
+	acme := domain.WithTenant(context.Background(), "acme")
+	globex := domain.WithTenant(context.Background(), "globex")
+	newWebhook := func(events ...string) domain.Webhook {
+		return domain.Webhook{URL: "https://example.com/hook", Events: events, Secret: "s3cret"}
+	}
+
+	t.Run("webhooks", func(t *testing.T) {
+		_, repo := open(t)
+		w := newWebhook(domain.EventCouponCreated, domain.EventCouponDeleted)
+		assert.Nil(t, repo.NewWebhook(acme, &w))
+		assert.NotZero(t, w.ID)
+		assert.False(t, w.CreatedAt.IsZero())
+		assert.Equal(t, "acme", w.TenantID)
+
+		var read domain.Webhook
+		assert.Nil(t, repo.GetWebhookByID(acme, w.ID, &read))
+		assert.Equal(t, w.URL, read.URL)
+		assert.Equal(t, w.Events, read.Events)
+		assert.Equal(t, "s3cret", read.Secret)
+		// the webhooks of another tenant are not found
+		assert.Equal(t, domain.NewWebhookNotFoundError(), repo.GetWebhookByID(globex, w.ID, &read))
+
+		var webhooks []domain.Webhook
+		assert.Nil(t, repo.ListWebhooks(acme, &webhooks))
+		assert.Equal(t, 1, len(webhooks))
+		assert.Nil(t, repo.ListWebhooks(globex, &webhooks))
+		assert.Equal(t, 0, len(webhooks))
+
+		assert.Equal(t, domain.NewWebhookNotFoundError(), repo.DeleteWebhook(globex, w.ID))
+		assert.Nil(t, repo.DeleteWebhook(acme, w.ID))
+		assert.Equal(t, domain.NewWebhookNotFoundError(), repo.DeleteWebhook(acme, w.ID))
+	})
+
+	t.Run("fanOut", func(t *testing.T) {
+		coupons, repo := open(t)
+		w := newWebhook(domain.EventCouponCreated, domain.EventCouponDeleted)
+		assert.Nil(t, repo.NewWebhook(acme, &w))
+		all := newWebhook(domain.EventTypes...)
+		assert.Nil(t, repo.NewWebhook(globex, &all))
+
+		id, err := coupons.NewCoupon(acme, newAPICoupon())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, coupons.UpdateCoupon(acme, id, newAPICoupon()))
+		assert.Nil(t, coupons.DeleteCoupon(acme, id))
+		// the changes rolled back have no event
+		err = coupons.WithTx(acme, func(tx domain.Repository) error {
+			if _, err := tx.NewCoupon(acme, newAPICoupon()); err != nil {
+				return err
+			}
+			return errors.New("rollback")
+		})
+		assert.NotNil(t, err)
+
+		n, err := repo.FanOut(context.Background(), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, n)
+		n, err = repo.FanOut(context.Background(), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		n, err = repo.FanOut(context.Background(), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		var deliveries []domain.Delivery
+		assert.Nil(t, repo.QueryDeliveries(acme, w.ID, &deliveries, domain.DeliveryQuery{}))
+		if assert.Equal(t, 2, len(deliveries)) {
+			// the latest first
+			assert.Equal(t, domain.EventCouponDeleted, deliveries[0].EventType)
+			assert.Equal(t, domain.EventCouponCreated, deliveries[1].EventType)
+			assert.Equal(t, domain.DeliveryPending, deliveries[1].Status)
+			assert.Equal(t, w.ID, deliveries[1].WebhookID)
+
+			var e domain.Event
+			assert.Nil(t, json.Unmarshal(deliveries[1].Payload, &e))
+			assert.Equal(t, deliveries[1].EventID, e.ID)
+			assert.Equal(t, domain.EventCouponCreated, e.Type)
+			assert.Equal(t, id, e.Data.CouponID)
+			assert.Equal(t, json.RawMessage(`"`+Name+`"`), e.Data.Changes["name"].After)
+		}
+		// the webhook of another tenant gets none of the events
+		assert.Nil(t, repo.QueryDeliveries(globex, all.ID, &deliveries, domain.DeliveryQuery{}))
+		assert.Equal(t, 0, len(deliveries))
+		assert.Equal(t, domain.NewWebhookNotFoundError(), repo.QueryDeliveries(globex, w.ID, &deliveries, domain.DeliveryQuery{}))
+	})
+
+	t.Run("expireCoupons", func(t *testing.T) {
+		coupons, repo := open(t)
+		w := newWebhook(domain.EventCouponExpired)
+		assert.Nil(t, repo.NewWebhook(acme, &w))
+		newCoupon := func(ctx context.Context, expiry *time.Time) uint {
+			id, err := coupons.NewCoupon(ctx, domain.APICoupon{Name: &Name, Expiry: expiry})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+		later, future := Time.Add(time.Hour), time.Now().Add(time.Hour)
+		expired := newCoupon(acme, &later)
+		first := newCoupon(globex, &Time)
+		newCoupon(acme, &future)
+		// a coupon without expiry never expires
+		newCoupon(acme, nil)
+		assert.Nil(t, coupons.DeleteCoupon(acme, newCoupon(acme, &Time)))
+
+		// the earliest expiry first
+		n, err := repo.ExpireCoupons(context.Background(), 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		n, err = repo.ExpireCoupons(context.Background(), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+		// each expiry is notified once
+		n, err = repo.ExpireCoupons(context.Background(), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		if _, err := repo.FanOut(context.Background(), 100); err != nil {
+			t.Fatal(err)
+		}
+		var deliveries []domain.Delivery
+		assert.Nil(t, repo.QueryDeliveries(acme, w.ID, &deliveries, domain.DeliveryQuery{}))
+		if assert.Equal(t, 1, len(deliveries)) {
+			assert.Equal(t, domain.EventCouponExpired, deliveries[0].EventType)
+			var e domain.Event
+			assert.Nil(t, json.Unmarshal(deliveries[0].Payload, &e))
+			assert.Equal(t, domain.EventCouponExpired, e.Type)
+			assert.Equal(t, expired, e.Data.CouponID)
+			assert.Empty(t, e.Data.Changes)
+		}
+
+		// a changed expiry is notified again once it passes
+		assert.Nil(t, coupons.UpdateCoupon(globex, first, domain.APICoupon{Expiry: &later}))
+		n, err = repo.ExpireCoupons(context.Background(), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("deliveries", func(t *testing.T) {
+		coupons, repo := open(t)
+		w := newWebhook(domain.EventTypes...)
+		assert.Nil(t, repo.NewWebhook(acme, &w))
+		for i := 0; i < 3; i++ {
+			if _, err := coupons.NewCoupon(acme, newAPICoupon()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := repo.FanOut(context.Background(), 10); err != nil {
+			t.Fatal(err)
+		}
+
+		var claimed []domain.Delivery
+		assert.Nil(t, repo.ClaimDeliveries(context.Background(), time.Minute, 2, &claimed))
+		assert.Equal(t, 2, len(claimed))
+		var rest []domain.Delivery
+		assert.Nil(t, repo.ClaimDeliveries(context.Background(), time.Minute, 10, &rest))
+		assert.Equal(t, 1, len(rest))
+		// the claimed deliveries are not due until their lease ends
+		assert.Nil(t, repo.ClaimDeliveries(context.Background(), time.Minute, 10, &rest))
+		assert.Equal(t, 0, len(rest))
+
+		delivered, dead := claimed[0], claimed[1]
+		now := time.Now()
+		delivered.Status, delivered.Attempts, delivered.LastStatusCode, delivered.DeliveredAt = domain.DeliveryDelivered, 1, 204, &now
+		assert.Nil(t, repo.UpdateDelivery(context.Background(), &delivered))
+		dead.Status, dead.Attempts, dead.LastStatusCode, dead.LastError = domain.DeliveryDead, 5, 500, "500 Internal Server Error"
+		assert.Nil(t, repo.UpdateDelivery(context.Background(), &dead))
+
+		var deliveries []domain.Delivery
+		assert.Nil(t, repo.QueryDeliveries(acme, w.ID, &deliveries, domain.DeliveryQuery{Status: domain.DeliveryDead}))
+		if assert.Equal(t, 1, len(deliveries)) {
+			assert.Equal(t, dead.ID, deliveries[0].ID)
+			assert.Equal(t, 5, deliveries[0].Attempts)
+			assert.Equal(t, "500 Internal Server Error", deliveries[0].LastError)
+		}
+		assert.Nil(t, repo.QueryDeliveries(acme, w.ID, &deliveries, domain.DeliveryQuery{Status: domain.DeliveryDelivered}))
+		if assert.Equal(t, 1, len(deliveries)) {
+			assert.NotNil(t, deliveries[0].DeliveredAt)
+			assert.Equal(t, 204, deliveries[0].LastStatusCode)
+		}
+		assert.Nil(t, repo.QueryDeliveries(acme, w.ID, &deliveries, domain.DeliveryQuery{Limit: 2, Offset: 2}))
+		assert.Equal(t, 1, len(deliveries))
+
+		// a dead delivery is attempted again once redelivered
+		assert.Nil(t, repo.Redeliver(acme, w.ID, dead.ID))
+		assert.Nil(t, repo.ClaimDeliveries(context.Background(), time.Minute, 10, &rest))
+		if assert.Equal(t, 1, len(rest)) {
+			assert.Equal(t, dead.ID, rest[0].ID)
+			assert.Equal(t, 0, rest[0].Attempts)
+		}
+		assert.Equal(t, domain.NewDeliveryNotFoundError(), repo.Redeliver(acme, w.ID, dead.ID+100))
+		assert.Equal(t, domain.NewWebhookNotFoundError(), repo.Redeliver(globex, w.ID, dead.ID))
+
+		// the deliveries are deleted along with their webhook
+		assert.Nil(t, repo.DeleteWebhook(acme, w.ID))
+		assert.Nil(t, repo.NewWebhook(acme, &w))
+		assert.Nil(t, repo.QueryDeliveries(acme, w.ID, &deliveries, domain.DeliveryQuery{}))
+		assert.Equal(t, 0, len(deliveries))
+	})
+}
@@ -1,12 +1,15 @@
-//go:generate mockgen -package mocks -destination ../../mocks/repository.go github.com/jcgfreitas/pb_api/internal/service Repository
-
 package service
 
 import (
+	"context"
+	"errors"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/pkg/ctxlog"
 	"github.com/sirupsen/logrus"
 )
 
@@ -25,64 +28,432 @@ const (
 	queryGreaterCreated = "gc"
 	queryLesserValue    = "lv"
 	queryGreaterValue   = "gv"
+	queryMatch          = "match"
+	matchExact          = "exact"
+	matchILike          = "ilike"
+	matchPrefix         = "prefix"
+	queryFields         = "fields"
+	queryGroupBy        = "group_by"
+	queryActor          = "actor"
+	queryAction         = "action"
+	queryCouponID       = "coupon_id"
+	queryRequestID      = "request_id"
+	queryFrom           = "from"
+	queryTo             = "to"
+	columnExpiry        = "expiry"
+	columnCreatedAt     = "created_at"
+	// importChunkSize is the number of coupons inserted by each import transaction
+	importChunkSize = 500
+	// maxBatchSize is the maximum number of operations of a batch
+	maxBatchSize = 1000
+
+	// validation failure reasons reported to the Metrics, besides the coupon ones of validationReasons
+	reasonCoupon    = "coupon"
+	reasonQuery     = "query"
+	reasonBatch     = "batch"
+	reasonImportRow = "import_row"
+
+	// operations reported to the Metrics when their coupon is not found, and named by the authorization errors
+	opGet     = "get"
+	opUpdate  = "update"
+	opDelete  = "delete"
+	opCreate  = "create"
+	opImport  = "import"
+	opBatch   = "batch"
+	opHistory = "history"
 )
 
+// coupon validation errors
+var (
+	errCouponNilFields   = domain.NewInvalidArgsError("coupon fields must not be nil")
+	errCouponEmptyFields = domain.NewInvalidArgsError("coupons fields must not be empty")
+	errCouponName        = domain.NewInvalidArgsError("coupon name cannot be empty")
+	errCouponBrand       = domain.NewInvalidArgsError("coupon brand cannot be empty")
+	errCouponValue       = domain.NewInvalidArgsError("coupon value must be bigger than 0")
+	errCouponExpiry      = domain.NewInvalidArgsError("coupon expiry must be after now")
+)
+
+// errors reported for the import rows and batch operations failing in the repository, the repository error is only
+// logged since it may hold the details of the db
+const (
+	errImportChunk    = "failed to insert the chunk"
+	errBatchOperation = "failed to apply the operation"
+)
+
+// validationReasons maps the coupon validation errors to the failure reason reported to the Metrics
+var validationReasons = map[error]string{
+	errCouponNilFields:   "coupon_fields",
+	errCouponEmptyFields: "coupon_fields",
+	errCouponName:        "coupon_name",
+	errCouponBrand:       "coupon_brand",
+	errCouponValue:       "coupon_value",
+	errCouponExpiry:      "coupon_expiry",
+}
+
 // Repository is the abstraction over the repository layer that handles db requests
-type Repository interface {
-	NewCoupon(APIc domain.APICoupon) error
-	GetCouponByID(id uint, c *domain.Coupon) error
-	DeleteCoupon(id uint) error
-	UpdateCoupon(id uint, APIc domain.APICoupon) error
-	QueryCoupons(coupons *[]domain.Coupon, query map[string]interface{}, functions ...func() error) error
-	QueryBatchingFunction(limit, page uint) func() error
-	QueryLTExpiryFunction(t time.Time) func() error
-	QueryGTExpiryFunction(t time.Time) func() error
-	QueryLTCreatedFunction(t time.Time) func() error
-	QueryGTCreatedFunction(t time.Time) func() error
-	QueryLTValueFunction(v uint) func() error
-	QueryGTValueFunction(v uint) func() error
+type Repository = domain.Repository
+
+// Metrics records the service events
+type Metrics interface {
+	// CouponsCreated records n created coupons
+	CouponsCreated(n int)
+	// ValidationFailed records a request, import row or batch operation failing the validation
+	ValidationFailed(reason string)
+	// CouponNotFound records an operation on a coupon which does not exist
+	CouponNotFound(op string)
 }
 
+// nopMetrics is the Metrics of a Service recording nothing
+type nopMetrics struct{}
+
+func (nopMetrics) CouponsCreated(n int)           {}
+func (nopMetrics) ValidationFailed(reason string) {}
+func (nopMetrics) CouponNotFound(op string)       {}
+
 // Service is the layer between the handlers and the repository. It mainly deals with validation and default values
 type Service struct {
-	repo   Repository
-	logger *logrus.Logger
+	repo    Repository
+	logger  *logrus.Logger
+	metrics Metrics
+	// authorization requires the coupon-admin role of the caller to change coupons
+	authorization bool
 }
 
 // NewService is the Service constructor
 func NewService(repository Repository, logger *logrus.Logger) *Service {
 	logger.SetReportCaller(true)
-	return &Service{repo: repository, logger: logger}
+	return &Service{repo: repository, logger: logger, metrics: nopMetrics{}}
+}
+
+// WithMetrics returns a copy of s recording its events to m
+func (s *Service) WithMetrics(m Metrics) *Service {
+	c := *s
+	c.metrics = m
+	return &c
+}
+
+// WithAuthorization returns a copy of s changing coupons only for the callers with the domain.RoleCouponAdmin role,
+// the caller is the domain.Principal of the context
+func (s *Service) WithAuthorization() *Service {
+	c := *s
+	c.authorization = true
+	return &c
+}
+
+// Ping checks that the repository is reachable
+func (s *Service) Ping(ctx context.Context) error {
+	return s.repo.Ping(ctx)
 }
 
 // CreateCoupon validates the coupon creation and requests the creation of the coupon record to the repository
-// It returns a InvalidArgsError if it fails the validation
-func (s *Service) CreateCoupon(APIc domain.APICoupon) error {
+// It returns a InvalidArgsError if it fails the validation and a ForbiddenError if the caller may not create coupons
+func (s *Service) CreateCoupon(ctx context.Context, APIc domain.APICoupon) error {
+	if err := s.authorize(ctx, opCreate); err != nil {
+		return err
+	}
 	if err := createCouponValidation(APIc); err != nil {
-		s.logger.WithError(err).Debug("failed to create Coupon")
+		s.log(ctx).WithError(err).Debug("failed to create Coupon")
+		s.validationFailed(err, reasonCoupon)
 		return err
 	}
-	return s.repo.NewCoupon(APIc)
+	if _, err := s.repo.NewCoupon(ctx, APIc); err != nil {
+		return err
+	}
+	s.metrics.CouponsCreated(1)
+	return nil
+}
+
+// ImportCoupons validates every row with the coupon creation rules and creates the valid ones
+// Valid rows are inserted in transactional chunks of importChunkSize, if a chunk fails every row in it is reported
+// as failed and the import goes on with the next chunk. With dryRun rows are only validated.
+//
+// The report holds a result for every row, in the same order.
+// It returns a ForbiddenError if the caller may not create coupons, a dry run is allowed to every caller
+func (s *Service) ImportCoupons(ctx context.Context, rows []domain.ImportRow, dryRun bool) (domain.ImportReport, error) {
+	if !dryRun {
+		if err := s.authorize(ctx, opImport); err != nil {
+			return domain.ImportReport{}, err
+		}
+	}
+	report := domain.ImportReport{DryRun: dryRun, Rows: make([]domain.ImportResult, len(rows))}
+
+	var chunk []domain.APICoupon
+	var chunkRows []int
+	insert := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		status := domain.ImportStatusCreated
+		var errs []string
+		if err := s.newCoupons(ctx, chunk); err != nil {
+			s.log(ctx).WithError(err).WithField("rows", len(chunk)).Error("failed to import coupons")
+			status = domain.ImportStatusFailed
+			errs = []string{errImportChunk}
+		}
+		for _, i := range chunkRows {
+			report.Rows[i].Status = status
+			report.Rows[i].Errors = errs
+		}
+		if status == domain.ImportStatusCreated {
+			report.Created += len(chunk)
+			s.metrics.CouponsCreated(len(chunk))
+		} else {
+			report.Failed += len(chunk)
+		}
+		chunk, chunkRows = nil, nil
+	}
+
+	for i, row := range rows {
+		report.Rows[i].Line = row.Line
+		err := row.Err
+		if err == nil {
+			err = createCouponValidation(row.Coupon)
+		}
+		switch {
+		case err != nil:
+			s.validationFailed(err, reasonImportRow)
+			report.Rows[i].Status = domain.ImportStatusInvalid
+			report.Rows[i].Errors = []string{err.Error()}
+			report.Invalid++
+		case dryRun:
+			report.Rows[i].Status = domain.ImportStatusValid
+			report.Valid++
+		default:
+			chunk = append(chunk, row.Coupon)
+			chunkRows = append(chunkRows, i)
+			if len(chunk) == importChunkSize {
+				insert()
+			}
+		}
+	}
+	insert()
+	return report, nil
+}
+
+// BatchCoupons validates and executes a batch of create, update and delete operations
+// It returns a result for every operation, in the same order. Operations failing the validation are invalid,
+// in an atomic batch that aborts every other operation without reaching the repository.
+// The mode defaults to domain.BatchModeAtomic
+//
+// It returns a InvalidArgsError if the batch itself is invalid: unknown mode, no operations or more than maxBatchSize,
+// and the context error if an atomic batch timed out or was canceled.
+// It returns a ForbiddenError if the caller may not change coupons
+func (s *Service) BatchCoupons(ctx context.Context, batch domain.BatchRequest) ([]domain.BatchResult, error) {
+	if err := s.authorize(ctx, opBatch); err != nil {
+		return nil, err
+	}
+	var atomic bool
+	switch batch.Mode {
+	case domain.BatchModeAtomic, "":
+		atomic = true
+	case domain.BatchModeBestEffort:
+	default:
+		s.log(ctx).WithField("mode", batch.Mode).Debug("invalid batch mode")
+		s.metrics.ValidationFailed(reasonBatch)
+		return nil, domain.NewInvalidArgsError("invalid batch mode:" + batch.Mode)
+	}
+	if len(batch.Operations) == 0 || len(batch.Operations) > maxBatchSize {
+		s.log(ctx).WithField("operations", len(batch.Operations)).Debug("invalid batch size")
+		s.metrics.ValidationFailed(reasonBatch)
+		return nil, domain.NewInvalidArgsError("batch must hold between 1 and " + strconv.Itoa(maxBatchSize) + " operations")
+	}
+
+	results := make([]domain.BatchResult, len(batch.Operations))
+	var valid []domain.BatchOperation
+	var validIndexes []int
+	for i, op := range batch.Operations {
+		results[i] = domain.BatchResult{Index: i, Op: op.Op, ID: op.ID}
+		if err := batchOperationValidation(op); err != nil {
+			s.log(ctx).WithError(err).WithField("index", i).Debug("invalid batch operation")
+			s.validationFailed(err, reasonBatch)
+			results[i].Status = domain.BatchStatusInvalid
+			results[i].Error = err.Error()
+			continue
+		}
+		valid = append(valid, op)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if atomic && len(valid) < len(batch.Operations) {
+		for _, i := range validIndexes {
+			results[i].Status = domain.BatchStatusAborted
+		}
+		return results, nil
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	if !atomic {
+		for _, i := range validIndexes {
+			s.batchOperation(ctx, s.repo, batch.Operations[i], &results[i])
+		}
+		s.metrics.CouponsCreated(batchCreated(results))
+		return results, nil
+	}
+
+	// the first failing operation rolls back the transaction and aborts every other one
+	failed := -1
+	err := s.repo.WithTx(ctx, func(tx Repository) error {
+		for _, i := range validIndexes {
+			if err := s.batchOperation(ctx, tx, batch.Operations[i], &results[i]); err != nil {
+				failed = i
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		s.metrics.CouponsCreated(batchCreated(results))
+		return results, nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// nothing was applied, the caller reports the timeout or cancellation itself
+		return nil, err
+	}
+
+	if failed < 0 {
+		s.log(ctx).WithError(err).Error("failed batch transaction")
+	} else {
+		s.log(ctx).WithError(err).WithField("index", failed).Debug("atomic batch rolled back")
+	}
+	for _, i := range validIndexes {
+		switch {
+		case failed < 0:
+			// the transaction itself failed, no operation was applied
+			results[i].Status = domain.BatchStatusFailed
+			results[i].Error = errBatchOperation
+		case i != failed:
+			results[i].Status = domain.BatchStatusAborted
+			results[i].Error = ""
+		}
+		if i != failed && batch.Operations[i].Op == domain.BatchCreate {
+			results[i].ID = 0
+		}
+	}
+	return results, nil
+}
+
+// batchOperation executes a validated batch operation through repo and sets its result status
+// The error of a failed operation is returned as well as recorded in the result
+func (s *Service) batchOperation(ctx context.Context, repo Repository, op domain.BatchOperation, result *domain.BatchResult) error {
+	var err error
+	switch op.Op {
+	case domain.BatchCreate:
+		result.ID, err = repo.NewCoupon(ctx, op.Coupon)
+	case domain.BatchUpdate:
+		err = repo.UpdateCoupon(ctx, op.ID, op.Coupon)
+	case domain.BatchDelete:
+		err = repo.DeleteCoupon(ctx, op.ID)
+	}
+
+	switch err.(type) {
+	case nil:
+		result.Status = domain.BatchStatusOK
+		return nil
+	case domain.CouponNotFoundError:
+		s.metrics.CouponNotFound(op.Op)
+		result.Status = domain.BatchStatusNotFound
+	default:
+		s.log(ctx).WithError(err).WithField("op", op.Op).Error("failed batch operation")
+		result.Status = domain.BatchStatusFailed
+		result.Error = errBatchOperation
+		return err
+	}
+	result.Error = err.Error()
+	return err
+}
+
+// batchCreated returns the number of coupons created by a batch
+func batchCreated(results []domain.BatchResult) int {
+	var n int
+	for _, r := range results {
+		if r.Op == domain.BatchCreate && r.Status == domain.BatchStatusOK {
+			n++
+		}
+	}
+	return n
+}
+
+// newCoupons creates a coupon for every APICoupon in a single transaction
+// Either every coupon is created or none of them is
+func (s *Service) newCoupons(ctx context.Context, APIcs []domain.APICoupon) error {
+	return s.repo.WithTx(ctx, func(tx Repository) error {
+		for _, APIc := range APIcs {
+			if _, err := tx.NewCoupon(ctx, APIc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // GetCoupon request the coupon with a given ID to the repository
-func (s *Service) GetCoupon(id uint, c *domain.Coupon) error {
-	return s.repo.GetCouponByID(id, c)
+// If fields is not empty only those fields are read, fields must be validated with ParseFields
+func (s *Service) GetCoupon(ctx context.Context, id uint, fields []string, c *domain.Coupon) error {
+	var columns []string
+	if len(fields) > 0 {
+		columns = domain.CouponColumns(fields)
+	}
+	err := s.repo.GetCouponByID(ctx, id, columns, c)
+	s.notFound(err, opGet)
+	return err
+}
+
+// ParseFields validates the fields arg against the selectable coupon fields
+// fields can be repeated or hold comma separated values
+// It returns the selected fields, which are empty if every field should be returned
+//
+// It returns a InvalidArgsError if it fails the validation
+func (s *Service) ParseFields(args map[string][]string) ([]string, error) {
+	fields, err := s.parseFields(logrus.NewEntry(s.logger), args)
+	s.validationFailed(err, reasonQuery)
+	return fields, err
+}
+
+// parseFields is ParseFields without recording the validation failures, for the service methods recording their own
+// The failures are logged to log, the logger of the request when it is known
+func (s *Service) parseFields(log *logrus.Entry, args map[string][]string) ([]string, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, f := range splitArgs(args[queryFields]) {
+		if !domain.IsCouponField(f) {
+			log.WithField("value", f).Debug("invalid field")
+			return nil, domain.NewInvalidArgsError("invalid field:" + f)
+		}
+		if !seen[f] {
+			seen[f] = true
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
 }
 
 // DeleteCoupon requests the deletion of a coupon with a given ID to the repository
-func (s *Service) DeleteCoupon(id uint) error {
-	return s.repo.DeleteCoupon(id)
+// It returns a ForbiddenError if the caller may not delete coupons
+func (s *Service) DeleteCoupon(ctx context.Context, id uint) error {
+	if err := s.authorize(ctx, opDelete); err != nil {
+		return err
+	}
+	err := s.repo.DeleteCoupon(ctx, id)
+	s.notFound(err, opDelete)
+	return err
 }
 
 // UpdateCoupon validates and updates a coupon with the giv4n Id to the repository
-// It returns a InvalidArgsError if it fails the validation
-func (s *Service) UpdateCoupon(id uint, APIc domain.APICoupon) error {
+// It returns a InvalidArgsError if it fails the validation and a ForbiddenError if the caller may not update coupons
+func (s *Service) UpdateCoupon(ctx context.Context, id uint, APIc domain.APICoupon) error {
+	if err := s.authorize(ctx, opUpdate); err != nil {
+		return err
+	}
 	if err := updateCouponValidation(APIc); err != nil {
-		s.logger.WithError(err).Debug("failed to update Coupon")
+		s.log(ctx).WithError(err).Debug("failed to update Coupon")
+		s.validationFailed(err, reasonCoupon)
 		return err
 	}
-	return s.repo.UpdateCoupon(id, APIc)
+	err := s.repo.UpdateCoupon(ctx, id, APIc)
+	s.notFound(err, opUpdate)
+	return err
 }
 
 // GetCoupons validates query arguments and executes the query in the repository
@@ -90,7 +461,7 @@ func (s *Service) UpdateCoupon(id uint, APIc domain.APICoupon) error {
 //
 // The accepted args keys are the following:
 //
-//  queryName           = "name"
+//	queryName           = "name"
 //	queryBrand          = "brand"
 //	queryValue          = "value"
 //	queryLimit          = "limit"
@@ -101,131 +472,428 @@ func (s *Service) UpdateCoupon(id uint, APIc domain.APICoupon) error {
 //	queryGreaterCreated = "gc"
 //	queryLesserValue    = "lv"
 //	queryGreaterValue   = "gv"
+//	queryMatch          = "match"
+//	queryFields         = "fields"
 //
-// It returns a InvalidArgsError if it fails the validation
-func (s *Service) GetCoupons(coupons *[]domain.Coupon, args map[string][]string) error {
-	var funcs []func() error
-	query := make(map[string]interface{})
+// name, brand and value can be repeated, in which case the coupon must match any of the given values.
+// match changes how name and brand are compared: "exact" (default), "ilike" for a case-insensitive
+// comparison or "prefix" for a case-insensitive prefix match.
+// fields is validated with ParseFields, fields restricts the columns read from the db.
+//
+// It returns a InvalidArgsError if it fails the validation or if args holds an unknown key
+func (s *Service) GetCoupons(ctx context.Context, coupons *[]domain.Coupon, args map[string][]string) (err error) {
+	defer func() { s.validationFailed(err, reasonQuery) }()
+	q, rest, err := s.parseFilters(s.log(ctx), args)
+	if err != nil {
+		return err
+	}
+
+	fields, err := s.parseFields(s.log(ctx), rest)
+	if err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		q = q.Select(domain.CouponColumns(fields))
+	}
+
 	limit := defaultLimit
 	page := defaultPage
-
-	for k, v := range args {
+	for k, v := range rest {
 		switch k {
+		case queryFields:
+			// already handled by ParseFields
 		case queryLimit:
 			l64, err := strconv.ParseUint(v[0], 10, 32)
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse limit")
+				s.log(ctx).WithError(err).WithField("value", v[0]).Debug("failed to parse limit")
 				return domain.NewInvalidArgsError("failed to parse limit value:" + v[0])
 			}
 			if l64 == 0 || l64 > uint64(maxLimit) {
-				s.logger.WithField("value", v[0]).Debug("invalid limit")
+				s.log(ctx).WithField("value", v[0]).Debug("invalid limit")
 				return domain.NewInvalidArgsError("invalid limit value:" + v[0])
 			}
 			limit = uint(l64)
 		case queryPage:
 			p64, err := strconv.ParseUint(v[0], 10, 32)
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse page")
+				s.log(ctx).WithError(err).WithField("value", v[0]).Debug("failed to parse page")
 				return domain.NewInvalidArgsError("failed to parse page value:" + v[0])
 			}
 			if p64 == 0 {
-				s.logger.WithField("value", v[0]).Debug("invalid page value")
+				s.log(ctx).WithField("value", v[0]).Debug("invalid page value")
 				return domain.NewInvalidArgsError("invalid page value" + v[0])
 			}
 			page = uint(p64)
-		case queryName:
-			query[k] = v[0]
-		case queryBrand:
-			query[k] = v[0]
-		case queryValue:
-			v64, err := strconv.ParseUint(v[0], 10, 32)
+		default:
+			s.log(ctx).WithField("key", k).Debug("unknown query key")
+			return domain.NewInvalidArgsError("unknown query parameter:" + k)
+		}
+	}
+	return s.repo.QueryCoupons(ctx, coupons, q.Paginate(limit, page))
+}
+
+// ExportCoupons validates query arguments and calls fn for every matching coupon, without any limit
+// args accepts the same filters as GetCoupons as well as fields
+// Coupons are read through a cursor so the result set is never loaded as a whole
+//
+// It returns a InvalidArgsError if it fails the validation or if args holds an unknown key
+func (s *Service) ExportCoupons(ctx context.Context, args map[string][]string, fn func(c domain.Coupon) error) (err error) {
+	defer func() { s.validationFailed(err, reasonQuery) }()
+	q, rest, err := s.parseFilters(s.log(ctx), args)
+	if err != nil {
+		return err
+	}
+
+	fields, err := s.parseFields(s.log(ctx), rest)
+	if err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		q = q.Select(domain.CouponColumns(fields))
+	}
+
+	for k := range rest {
+		if k != queryFields {
+			s.log(ctx).WithField("key", k).Debug("unknown query key")
+			return domain.NewInvalidArgsError("unknown query parameter:" + k)
+		}
+	}
+	return s.repo.ExportCoupons(ctx, fn, q)
+}
+
+// GetCouponsStats validates query arguments and aggregates the matching coupons in the repository
+// args accepts the same filters as GetCoupons and a queryGroupBy key.
+//
+// group_by can be repeated or hold comma separated values, the accepted groups are:
+//
+//	domain.StatsGroupBrand       = "brand"
+//	domain.StatsGroupStatus      = "status"
+//	domain.StatsGroupExpiryMonth = "expiry_month"
+//
+// Without group_by a single row aggregating every matching coupon is returned.
+//
+// It returns a InvalidArgsError if it fails the validation or if args holds an unknown key
+func (s *Service) GetCouponsStats(ctx context.Context, stats *[]domain.CouponStats, args map[string][]string) (err error) {
+	defer func() { s.validationFailed(err, reasonQuery) }()
+	q, rest, err := s.parseFilters(s.log(ctx), args)
+	if err != nil {
+		return err
+	}
+
+	var groupBy []string
+	seen := make(map[string]bool)
+	for k, v := range rest {
+		switch k {
+		case queryGroupBy:
+			for _, g := range splitArgs(v) {
+				if !domain.IsStatsGroup(g) {
+					s.log(ctx).WithField("value", g).Debug("invalid group_by")
+					return domain.NewInvalidArgsError("invalid group_by value:" + g)
+				}
+				if !seen[g] {
+					seen[g] = true
+					groupBy = append(groupBy, g)
+				}
+			}
+		default:
+			s.log(ctx).WithField("key", k).Debug("unknown query key")
+			return domain.NewInvalidArgsError("unknown query parameter:" + k)
+		}
+	}
+	return s.repo.CouponStats(ctx, stats, groupBy, q)
+}
+
+// CouponHistory requests the audit entries of the coupon with id to the repository, oldest first
+// The history of a deleted coupon is kept, it returns a CouponNotFoundError if the coupon was never created
+func (s *Service) CouponHistory(ctx context.Context, id uint, entries *[]domain.AuditEntry) error {
+	err := s.repo.CouponHistory(ctx, id, entries)
+	s.notFound(err, opHistory)
+	return err
+}
+
+// GetAudit validates query arguments and queries the audit entries in the repository, oldest first
+// args uses the same type as the url.Values from http.Request
+//
+// The accepted args keys are the following:
+//
+//	queryActor     = "actor"
+//	queryAction    = "action"
+//	queryCouponID  = "coupon_id"
+//	queryRequestID = "request_id"
+//	queryFrom      = "from"
+//	queryTo        = "to"
+//	queryLimit     = "limit"
+//	queryPage      = "page"
+//
+// action is one of the domain.Audit... actions, from and to are RFC 3339 times bounding the entries, both included.
+// limit and page default to the GetCoupons ones.
+//
+// It returns a InvalidArgsError if it fails the validation or if args holds an unknown key
+func (s *Service) GetAudit(ctx context.Context, entries *[]domain.AuditEntry, args map[string][]string) (err error) {
+	defer func() { s.validationFailed(err, reasonQuery) }()
+	log := s.log(ctx)
+	q := domain.AuditQuery{Limit: defaultLimit}
+	page := defaultPage
+	for k, v := range args {
+		switch k {
+		case queryActor:
+			q.Actor = v[0]
+		case queryAction:
+			if !domain.IsAuditAction(v[0]) {
+				log.WithField("value", v[0]).Debug("invalid audit action")
+				return domain.NewInvalidArgsError("invalid action value:" + v[0])
+			}
+			q.Action = v[0]
+		case queryCouponID:
+			id, err := strconv.ParseUint(v[0], 10, 32)
+			if err != nil || id == 0 {
+				log.WithField("value", v[0]).Debug("invalid coupon id")
+				return domain.NewInvalidArgsError("invalid coupon_id value:" + v[0])
+			}
+			q.CouponID = uint(id)
+		case queryRequestID:
+			q.RequestID = v[0]
+		case queryFrom, queryTo:
+			t, err := time.Parse(time.RFC3339, v[0])
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse value")
-				return domain.NewInvalidArgsError("failed to parse value:" + v[0])
+				log.WithError(err).WithField("value", v[0]).Debug("failed to parse " + k)
+				return domain.NewInvalidArgsError("failed to parse " + k + ":" + v[0])
+			}
+			if k == queryFrom {
+				q.From = t
+			} else {
+				q.To = t
+			}
+		case queryLimit:
+			l64, err := strconv.ParseUint(v[0], 10, 32)
+			if err != nil || l64 == 0 || l64 > uint64(maxLimit) {
+				log.WithField("value", v[0]).Debug("invalid limit")
+				return domain.NewInvalidArgsError("invalid limit value:" + v[0])
+			}
+			q.Limit = uint(l64)
+		case queryPage:
+			p64, err := strconv.ParseUint(v[0], 10, 32)
+			if err != nil || p64 == 0 {
+				log.WithField("value", v[0]).Debug("invalid page")
+				return domain.NewInvalidArgsError("invalid page value:" + v[0])
+			}
+			page = uint(p64)
+		default:
+			log.WithField("key", k).Debug("unknown query key")
+			return domain.NewInvalidArgsError("unknown query parameter:" + k)
+		}
+	}
+	q.Offset = (page - 1) * q.Limit
+	return s.repo.QueryAudit(ctx, entries, q)
+}
+
+// parseFilters parses the filters shared by the coupon queries into a CouponQuery
+// The args which are not filters are returned in rest, to be handled by the caller.
+// Keys are parsed in sorted order so the same args always build the same query
+func (s *Service) parseFilters(log *logrus.Entry, args map[string][]string) (q domain.CouponQuery, rest map[string][]string, err error) {
+	rest = make(map[string][]string)
+
+	match := matchExact
+	if v, ok := args[queryMatch]; ok {
+		switch v[0] {
+		case matchExact, matchILike, matchPrefix:
+			match = v[0]
+		default:
+			log.WithField("value", v[0]).Debug("invalid match mode")
+			return q, nil, domain.NewInvalidArgsError("invalid match value:" + v[0])
+		}
+	}
+
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := args[k]
+		switch k {
+		case queryMatch:
+			// already handled, it changes how name and brand are compared
+		case queryName, queryBrand:
+			values := make([]interface{}, len(v))
+			for i, value := range v {
+				values[i] = value
+			}
+			switch match {
+			case matchILike:
+				q = q.Where(k, domain.FilterILike, values...)
+			case matchPrefix:
+				q = q.Where(k, domain.FilterPrefix, values...)
+			default:
+				q = q.Where(k, domain.FilterEqual, values...)
+			}
+		case queryValue:
+			values := make([]interface{}, 0, len(v))
+			for _, value := range v {
+				v64, err := strconv.ParseUint(value, 10, 32)
+				if err != nil {
+					log.WithError(err).WithField("value", value).Debug("failed to parse value")
+					return q, nil, domain.NewInvalidArgsError("failed to parse value:" + value)
+				}
+				values = append(values, uint(v64))
 			}
-			query[k] = uint(v64)
+			q = q.Where(k, domain.FilterEqual, values...)
 		case queryLesserValue:
 			lv64, err := strconv.ParseUint(v[0], 10, 32)
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse LesserValueLimit")
-				return domain.NewInvalidArgsError("failed to parse LesserValueLimit:" + v[0])
+				log.WithError(err).WithField("value", v[0]).Debug("failed to parse LesserValueLimit")
+				return q, nil, domain.NewInvalidArgsError("failed to parse LesserValueLimit:" + v[0])
 			}
-			funcs = append(funcs, s.repo.QueryLTValueFunction(uint(lv64)))
+			q = q.Where(queryValue, domain.FilterLess, uint(lv64))
 		case queryGreaterValue:
 			gv64, err := strconv.ParseUint(v[0], 10, 32)
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse GreaterValueLimit")
-				return domain.NewInvalidArgsError("failed to parse GreaterValueLimit:" + v[0])
+				log.WithError(err).WithField("value", v[0]).Debug("failed to parse GreaterValueLimit")
+				return q, nil, domain.NewInvalidArgsError("failed to parse GreaterValueLimit:" + v[0])
 			}
-			funcs = append(funcs, s.repo.QueryGTValueFunction(uint(gv64)))
+			q = q.Where(queryValue, domain.FilterGreater, uint(gv64))
 		case queryLesserExpiry:
 			le, err := time.Parse(time.RFC3339, v[0])
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse LesserExpiryLimit")
-				return domain.NewInvalidArgsError("failed to parse LesserExpiryLimit:" + v[0])
+				log.WithError(err).WithField("value", v[0]).Debug("failed to parse LesserExpiryLimit")
+				return q, nil, domain.NewInvalidArgsError("failed to parse LesserExpiryLimit:" + v[0])
 			}
-			funcs = append(funcs, s.repo.QueryLTExpiryFunction(le))
+			q = q.Where(columnExpiry, domain.FilterLess, le)
 		case queryGreaterExpiry:
 			ge, err := time.Parse(time.RFC3339, v[0])
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse GreaterExpiryLimit")
-				return domain.NewInvalidArgsError("failed to parse GreaterExpiryLimit:" + v[0])
+				log.WithError(err).WithField("value", v[0]).Debug("failed to parse GreaterExpiryLimit")
+				return q, nil, domain.NewInvalidArgsError("failed to parse GreaterExpiryLimit:" + v[0])
 			}
-			funcs = append(funcs, s.repo.QueryGTExpiryFunction(ge))
+			q = q.Where(columnExpiry, domain.FilterGreater, ge)
 		case queryLesserCreated:
 			lc, err := time.Parse(time.RFC3339, v[0])
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse LesserCreatedLimit")
-				return domain.NewInvalidArgsError("failed to parse LesserCreatedLimit:" + v[0])
+				log.WithError(err).WithField("value", v[0]).Debug("failed to parse LesserCreatedLimit")
+				return q, nil, domain.NewInvalidArgsError("failed to parse LesserCreatedLimit:" + v[0])
 			}
-			funcs = append(funcs, s.repo.QueryLTCreatedFunction(lc))
+			q = q.Where(columnCreatedAt, domain.FilterLess, lc)
 		case queryGreaterCreated:
 			gc, err := time.Parse(time.RFC3339, v[0])
 			if err != nil {
-				s.logger.WithError(err).WithField("value", v[0]).Debug("failed to parse GreaterCreatedLimit")
-				return domain.NewInvalidArgsError("failed to parse GreaterCreatedLimit:" + v[0])
+				log.WithError(err).WithField("value", v[0]).Debug("failed to parse GreaterCreatedLimit")
+				return q, nil, domain.NewInvalidArgsError("failed to parse GreaterCreatedLimit:" + v[0])
 			}
-			funcs = append(funcs, s.repo.QueryGTCreatedFunction(gc))
+			q = q.Where(columnCreatedAt, domain.FilterGreater, gc)
+		default:
+			rest[k] = v
+		}
+	}
+	return q, rest, nil
+}
+
+// log returns the logger of the request of ctx, it carries the request id when the handlers assigned one
+func (s *Service) log(ctx context.Context) *logrus.Entry {
+	return ctxlog.Entry(ctx, s.logger)
+}
+
+// authorize returns a ForbiddenError if authorization is enabled and the caller of ctx may not execute op,
+// only the domain.RoleCouponAdmin role changes coupons
+func (s *Service) authorize(ctx context.Context, op string) error {
+	if !s.authorization {
+		return nil
+	}
+	p, _ := domain.PrincipalFrom(ctx)
+	if p.HasRole(domain.RoleCouponAdmin) {
+		return nil
+	}
+	s.log(ctx).WithField("op", op).WithField("roles", p.Roles).Debug("forbidden coupon change")
+	return domain.NewForbiddenError(op + " requires the " + domain.RoleCouponAdmin + " role")
+}
+
+// validationFailed records err if it is a validation failure
+// The coupon validation errors are recorded with their own reason and every other one with fallback
+func (s *Service) validationFailed(err error, fallback string) {
+	if _, ok := err.(domain.InvalidArgsError); !ok {
+		return
+	}
+	if reason, ok := validationReasons[err]; ok {
+		fallback = reason
+	}
+	s.metrics.ValidationFailed(fallback)
+}
+
+// notFound records op if err is a missing coupon
+func (s *Service) notFound(err error, op string) {
+	if _, ok := err.(domain.CouponNotFoundError); ok {
+		s.metrics.CouponNotFound(op)
+	}
+}
+
+// splitArgs splits comma separated args and drops the empty ones
+func splitArgs(args []string) []string {
+	var values []string
+	for _, arg := range args {
+		for _, v := range strings.Split(arg, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+func batchOperationValidation(op domain.BatchOperation) error {
+	switch op.Op {
+	case domain.BatchCreate:
+		if op.ID != 0 {
+			return domain.NewInvalidArgsError("create operations must not have an id")
+		}
+		return createCouponValidation(op.Coupon)
+	case domain.BatchUpdate:
+		if op.ID == 0 {
+			return domain.NewInvalidArgsError("update operations must have an id")
+		}
+		return updateCouponValidation(op.Coupon)
+	case domain.BatchDelete:
+		if op.ID == 0 {
+			return domain.NewInvalidArgsError("delete operations must have an id")
 		}
+		return nil
 	}
-	funcs = append(funcs, s.repo.QueryBatchingFunction(limit, page))
-	return s.repo.QueryCoupons(coupons, query, funcs...)
+	return domain.NewInvalidArgsError("unknown batch operation:" + op.Op)
 }
 
 func createCouponValidation(APIc domain.APICoupon) error {
 	if APIc.Name == nil || APIc.Brand == nil || APIc.Value == nil || APIc.Expiry == nil {
-		return domain.NewInvalidArgsError("coupon fields must not be nil")
+		return errCouponNilFields
 	}
 	if *APIc.Name == "" {
-		return domain.NewInvalidArgsError("coupon name cannot be empty")
+		return errCouponName
 	}
 	if *APIc.Brand == "" {
-		return domain.NewInvalidArgsError("coupon brand cannot be empty")
+		return errCouponBrand
 	}
 	if *APIc.Value == 0 {
-		return domain.NewInvalidArgsError("coupon value must be bigger than 0")
+		return errCouponValue
 	}
 	if APIc.Expiry.Before(time.Now()) {
-		return domain.NewInvalidArgsError("coupon expiry must be after now")
+		return errCouponExpiry
 	}
 	return nil
 }
 
 func updateCouponValidation(APIc domain.APICoupon) error {
 	if APIc.Name == nil && APIc.Brand == nil && APIc.Value == nil && APIc.Expiry == nil {
-		return domain.NewInvalidArgsError("coupons fields must not be empty")
+		return errCouponEmptyFields
 	}
 	if APIc.Name != nil && *APIc.Name == "" {
-		return domain.NewInvalidArgsError("coupon name cannot be empty")
+		return errCouponName
 	}
 	if APIc.Brand != nil && *APIc.Brand == "" {
-		return domain.NewInvalidArgsError("coupon brand cannot be empty")
+		return errCouponBrand
 	}
 	if APIc.Value != nil && *APIc.Value == 0 {
-		return domain.NewInvalidArgsError("coupon value must be bigger than 0")
+		return errCouponValue
 	}
 	if APIc.Expiry != nil && APIc.Expiry.Before(time.Now()) {
-		return domain.NewInvalidArgsError("coupon expiry must be after now")
+		return errCouponExpiry
 	}
 	return nil
 }
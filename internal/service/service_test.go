@@ -1,6 +1,10 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang/mock/gomock"
@@ -32,6 +36,12 @@ type TestService struct {
 	ctrl *gomock.Controller
 }
 
+// parsedExpiry returns sExpiry as parsed by the service
+func parsedExpiry() time.Time {
+	e, _ := time.Parse(time.RFC3339, sExpiry)
+	return e
+}
+
 func startService(t *testing.T) *TestService {
 	ctrl := gomock.NewController(t)
 	mock := mocks.NewMockRepository(ctrl)
@@ -44,6 +54,14 @@ func startService(t *testing.T) *TestService {
 	}
 }
 
+// expectTx expects a WithTx call which runs its function against the mock itself
+func (s *TestService) expectTx() *gomock.Call {
+	return s.mock.EXPECT().WithTx(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, fn func(tx domain.Repository) error) error {
+			return fn(s.mock)
+		})
+}
+
 func TestCreateCoupon(t *testing.T) {
 	t.Run("success", testCreateCouponSuccess)
 	t.Run("invalidName", testCreateCouponInvalidName)
@@ -64,8 +82,8 @@ func testCreateCouponSuccess(t *testing.T) {
 		Expiry: &Expiry,
 	}
 
-	s.mock.EXPECT().NewCoupon(a).Return(nil)
-	assert.Nil(t, s.CreateCoupon(a))
+	s.mock.EXPECT().NewCoupon(gomock.Any(), a).Return(uint(1), nil)
+	assert.Nil(t, s.CreateCoupon(context.Background(), a))
 }
 
 func testCreateCouponNilName(t *testing.T) {
@@ -77,7 +95,7 @@ func testCreateCouponNilName(t *testing.T) {
 		Expiry: &Expiry,
 	}
 
-	assert.Error(t, s.CreateCoupon(a))
+	assert.Error(t, s.CreateCoupon(context.Background(), a))
 }
 
 func testCreateCouponInvalidName(t *testing.T) {
@@ -91,7 +109,7 @@ func testCreateCouponInvalidName(t *testing.T) {
 		Expiry: &Expiry,
 	}
 
-	assert.Error(t, s.CreateCoupon(a))
+	assert.Error(t, s.CreateCoupon(context.Background(), a))
 }
 
 func testCreateCouponInvalidBrand(t *testing.T) {
@@ -105,7 +123,7 @@ func testCreateCouponInvalidBrand(t *testing.T) {
 		Expiry: &Expiry,
 	}
 
-	assert.Error(t, s.CreateCoupon(a))
+	assert.Error(t, s.CreateCoupon(context.Background(), a))
 }
 
 func testCreateCouponInvalidValue(t *testing.T) {
@@ -119,7 +137,7 @@ func testCreateCouponInvalidValue(t *testing.T) {
 		Expiry: &Expiry,
 	}
 
-	assert.Error(t, s.CreateCoupon(a))
+	assert.Error(t, s.CreateCoupon(context.Background(), a))
 }
 
 func testCreateCouponInvalidExpiry(t *testing.T) {
@@ -133,7 +151,7 @@ func testCreateCouponInvalidExpiry(t *testing.T) {
 		Expiry: &e,
 	}
 
-	assert.Error(t, s.CreateCoupon(a))
+	assert.Error(t, s.CreateCoupon(context.Background(), a))
 }
 
 func TestGetCoupon(t *testing.T) {
@@ -141,16 +159,53 @@ func TestGetCoupon(t *testing.T) {
 	defer s.ctrl.Finish()
 
 	var c domain.Coupon
-	s.mock.EXPECT().GetCouponByID(uint(1), &c).Return(nil)
-	assert.Nil(t, s.GetCoupon(1, &c))
+	s.mock.EXPECT().GetCouponByID(gomock.Any(), uint(1), nil, &c).Return(nil)
+	assert.Nil(t, s.GetCoupon(context.Background(), 1, nil, &c))
+}
+
+func TestGetCouponFields(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var c domain.Coupon
+	s.mock.EXPECT().GetCouponByID(gomock.Any(), uint(1), []string{"id", "name", "value"}, &c).Return(nil)
+	assert.Nil(t, s.GetCoupon(context.Background(), 1, []string{"name", "value"}, &c))
+}
+
+func TestParseFields(t *testing.T) {
+	s := startService(t)
+
+	fields, err := s.ParseFields(map[string][]string{queryFields: {"name,value", "id", "name"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name", "value", "id"}, fields)
+
+	fields, err = s.ParseFields(map[string][]string{})
+	assert.Nil(t, err)
+	assert.Empty(t, fields)
+
+	_, err = s.ParseFields(map[string][]string{queryFields: {"deleted_at"}})
+	assert.IsType(t, domain.InvalidArgsError{}, err)
+
+	// no related resource is expanded, expand is an unknown parameter of the coupon queries
+	var coupons []domain.Coupon
+	assert.IsType(t, domain.InvalidArgsError{}, s.GetCoupons(context.Background(), &coupons, map[string][]string{"expand": {"batch"}}))
 }
 
 func TestDeleteCoupon(t *testing.T) {
 	s := startService(t)
 	defer s.ctrl.Finish()
 
-	s.mock.EXPECT().DeleteCoupon(uint(1)).Return(nil)
-	assert.Nil(t, s.DeleteCoupon(1))
+	s.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(1)).Return(nil)
+	assert.Nil(t, s.DeleteCoupon(context.Background(), 1))
+}
+
+func TestPing(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	pingErr := errors.New("connection refused")
+	s.mock.EXPECT().Ping(gomock.Any()).Return(pingErr)
+	assert.Equal(t, pingErr, s.Ping(context.Background()))
 }
 
 func TestUpdateCoupon(t *testing.T) {
@@ -173,8 +228,8 @@ func testUpdateCouponSuccess(t *testing.T) {
 		Expiry: &Expiry,
 	}
 
-	s.mock.EXPECT().UpdateCoupon(uint(1), a).Return(nil)
-	assert.Nil(t, s.UpdateCoupon(uint(1), a))
+	s.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(1), a).Return(nil)
+	assert.Nil(t, s.UpdateCoupon(context.Background(), uint(1), a))
 }
 
 func testUpdateCouponEmpty(t *testing.T) {
@@ -182,7 +237,7 @@ func testUpdateCouponEmpty(t *testing.T) {
 
 	a := domain.APICoupon{}
 
-	assert.Error(t, s.UpdateCoupon(uint(1), a))
+	assert.Error(t, s.UpdateCoupon(context.Background(), uint(1), a))
 }
 
 func testUpdateCouponInvalidName(t *testing.T) {
@@ -193,7 +248,7 @@ func testUpdateCouponInvalidName(t *testing.T) {
 		Name: &n,
 	}
 
-	assert.Error(t, s.UpdateCoupon(1, a))
+	assert.Error(t, s.UpdateCoupon(context.Background(), 1, a))
 }
 
 func testUpdateCouponInvalidBrand(t *testing.T) {
@@ -204,7 +259,7 @@ func testUpdateCouponInvalidBrand(t *testing.T) {
 		Brand: &b,
 	}
 
-	assert.Error(t, s.UpdateCoupon(1, a))
+	assert.Error(t, s.UpdateCoupon(context.Background(), 1, a))
 }
 
 func testUpdateCouponInvalidValue(t *testing.T) {
@@ -215,7 +270,7 @@ func testUpdateCouponInvalidValue(t *testing.T) {
 		Value: &v,
 	}
 
-	assert.Error(t, s.UpdateCoupon(1, a))
+	assert.Error(t, s.UpdateCoupon(context.Background(), 1, a))
 }
 
 func testUpdateCouponInvalidExpiry(t *testing.T) {
@@ -226,7 +281,7 @@ func testUpdateCouponInvalidExpiry(t *testing.T) {
 		Expiry: &e,
 	}
 
-	assert.Error(t, s.UpdateCoupon(1, a))
+	assert.Error(t, s.UpdateCoupon(context.Background(), 1, a))
 }
 
 func TestGetCoupons(t *testing.T) {
@@ -248,61 +303,61 @@ func TestGetCoupons(t *testing.T) {
 	t.Run("invalidGreaterExpiry", testGetCouponsInvalidGreaterExpiry)
 	t.Run("invalidLesserCreated", testGetCouponsInvalidLesserCreated)
 	t.Run("invalidGreaterCreated", testGetCouponsInvalidGreaterCreated)
+	t.Run("successMultipleValues", testGetCouponsSuccessMultipleValues)
+	t.Run("successILike", testGetCouponsSuccessILike)
+	t.Run("successPrefix", testGetCouponsSuccessPrefix)
+	t.Run("invalidMatch", testGetCouponsInvalidMatch)
+	t.Run("unknownKey", testGetCouponsUnknownKey)
+	t.Run("successFields", testGetCouponsSuccessFields)
+	t.Run("invalidFields", testGetCouponsInvalidFields)
 }
 
 func testGetCouponsSuccessLimit(t *testing.T) {
 	s := startService(t)
 	defer s.ctrl.Finish()
 
-	limit := uint(10)
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryLimit] = []string{"10"}
-	query := make(map[string]interface{})
+	query := domain.CouponQuery{}.Paginate(uint(10), defaultPage)
 
-	s.mock.EXPECT().QueryBatchingFunction(limit, defaultPage)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsSuccessPage(t *testing.T) {
 	s := startService(t)
 	defer s.ctrl.Finish()
 
-	page := uint(10)
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryPage] = []string{"10"}
-	query := make(map[string]interface{})
+	query := domain.CouponQuery{}.Paginate(defaultLimit, uint(10))
 
-	s.mock.EXPECT().QueryBatchingFunction(defaultLimit, page)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsSuccessQuery(t *testing.T) {
 	s := startService(t)
 	defer s.ctrl.Finish()
 
-	//input
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryName] = []string{Name}
 	args[queryBrand] = []string{Brand}
 	args[queryValue] = []string{sValue}
+	query := domain.CouponQuery{}.
+		Where(queryBrand, domain.FilterEqual, Brand).
+		Where(queryName, domain.FilterEqual, Name).
+		Where(queryValue, domain.FilterEqual, Value).
+		Paginate(defaultLimit, defaultPage)
 
-	//expected in mock
-	query := make(map[string]interface{})
-	query[queryName] = Name
-	query[queryBrand] = Brand
-	query[queryValue] = Value
-
-	s.mock.EXPECT().QueryBatchingFunction(defaultLimit, defaultPage)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsSuccessLesserValue(t *testing.T) {
@@ -312,13 +367,11 @@ func testGetCouponsSuccessLesserValue(t *testing.T) {
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryLesserValue] = []string{sValue}
-	query := make(map[string]interface{})
+	query := domain.CouponQuery{}.Where(queryValue, domain.FilterLess, value).Paginate(defaultLimit, defaultPage)
 
-	s.mock.EXPECT().QueryLTValueFunction(value)
-	s.mock.EXPECT().QueryBatchingFunction(defaultLimit, defaultPage)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsSuccessGreaterValue(t *testing.T) {
@@ -328,13 +381,11 @@ func testGetCouponsSuccessGreaterValue(t *testing.T) {
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryGreaterValue] = []string{sValue}
-	query := make(map[string]interface{})
+	query := domain.CouponQuery{}.Where(queryValue, domain.FilterGreater, value).Paginate(defaultLimit, defaultPage)
 
-	s.mock.EXPECT().QueryGTValueFunction(value)
-	s.mock.EXPECT().QueryBatchingFunction(defaultLimit, defaultPage)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsSuccessLesserExpiry(t *testing.T) {
@@ -344,13 +395,11 @@ func testGetCouponsSuccessLesserExpiry(t *testing.T) {
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryLesserExpiry] = []string{sExpiry}
-	query := make(map[string]interface{})
+	query := domain.CouponQuery{}.Where(columnExpiry, domain.FilterLess, parsedExpiry()).Paginate(defaultLimit, defaultPage)
 
-	s.mock.EXPECT().QueryLTExpiryFunction(gomock.Any())
-	s.mock.EXPECT().QueryBatchingFunction(defaultLimit, defaultPage)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsSuccessGreaterExpiry(t *testing.T) {
@@ -360,13 +409,11 @@ func testGetCouponsSuccessGreaterExpiry(t *testing.T) {
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryGreaterExpiry] = []string{sExpiry}
-	query := make(map[string]interface{})
+	query := domain.CouponQuery{}.Where(columnExpiry, domain.FilterGreater, parsedExpiry()).Paginate(defaultLimit, defaultPage)
 
-	s.mock.EXPECT().QueryGTExpiryFunction(gomock.Any())
-	s.mock.EXPECT().QueryBatchingFunction(defaultLimit, defaultPage)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsSuccessLesserCreated(t *testing.T) {
@@ -376,13 +423,11 @@ func testGetCouponsSuccessLesserCreated(t *testing.T) {
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryLesserCreated] = []string{sExpiry}
-	query := make(map[string]interface{})
+	query := domain.CouponQuery{}.Where(columnCreatedAt, domain.FilterLess, parsedExpiry()).Paginate(defaultLimit, defaultPage)
 
-	s.mock.EXPECT().QueryLTCreatedFunction(gomock.Any())
-	s.mock.EXPECT().QueryBatchingFunction(defaultLimit, defaultPage)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsSuccessGreaterCreated(t *testing.T) {
@@ -392,13 +437,11 @@ func testGetCouponsSuccessGreaterCreated(t *testing.T) {
 	var coupons []domain.Coupon
 	args := make(map[string][]string)
 	args[queryGreaterCreated] = []string{sExpiry}
-	query := make(map[string]interface{})
+	query := domain.CouponQuery{}.Where(columnCreatedAt, domain.FilterGreater, parsedExpiry()).Paginate(defaultLimit, defaultPage)
 
-	s.mock.EXPECT().QueryGTCreatedFunction(gomock.Any())
-	s.mock.EXPECT().QueryBatchingFunction(defaultLimit, defaultPage)
-	s.mock.EXPECT().QueryCoupons(&coupons, query, gomock.Any()).Return(nil)
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
 
-	assert.Nil(t, s.GetCoupons(&coupons, args))
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidLimit(t *testing.T) {
@@ -408,7 +451,7 @@ func testGetCouponsInvalidLimit(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryLimit] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidPage(t *testing.T) {
@@ -418,7 +461,7 @@ func testGetCouponsInvalidPage(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryPage] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidValue(t *testing.T) {
@@ -428,7 +471,7 @@ func testGetCouponsInvalidValue(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryValue] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidLesserValue(t *testing.T) {
@@ -438,7 +481,7 @@ func testGetCouponsInvalidLesserValue(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryLesserValue] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidGreaterValue(t *testing.T) {
@@ -448,7 +491,7 @@ func testGetCouponsInvalidGreaterValue(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryGreaterValue] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidLesserExpiry(t *testing.T) {
@@ -458,7 +501,7 @@ func testGetCouponsInvalidLesserExpiry(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryLesserExpiry] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidGreaterExpiry(t *testing.T) {
@@ -468,7 +511,7 @@ func testGetCouponsInvalidGreaterExpiry(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryGreaterExpiry] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidLesserCreated(t *testing.T) {
@@ -478,7 +521,7 @@ func testGetCouponsInvalidLesserCreated(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryLesserCreated] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
 }
 
 func testGetCouponsInvalidGreaterCreated(t *testing.T) {
@@ -488,5 +531,654 @@ func testGetCouponsInvalidGreaterCreated(t *testing.T) {
 	args := make(map[string][]string)
 	args[queryGreaterCreated] = []string{name}
 
-	assert.Error(t, s.GetCoupons(&coupons, args))
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
+}
+
+func testGetCouponsSuccessMultipleValues(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var coupons []domain.Coupon
+	args := make(map[string][]string)
+	args[queryBrand] = []string{Brand, Brand + "2"}
+	args[queryValue] = []string{sValue, "20"}
+	query := domain.CouponQuery{}.
+		Where(queryBrand, domain.FilterEqual, Brand, Brand+"2").
+		Where(queryValue, domain.FilterEqual, value, uint(20)).
+		Paginate(defaultLimit, defaultPage)
+
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
+
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
+}
+
+func testGetCouponsSuccessILike(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var coupons []domain.Coupon
+	args := make(map[string][]string)
+	args[queryName] = []string{Name}
+	args[queryMatch] = []string{matchILike}
+	query := domain.CouponQuery{}.Where(queryName, domain.FilterILike, Name).Paginate(defaultLimit, defaultPage)
+
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
+
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
+}
+
+func testGetCouponsSuccessPrefix(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var coupons []domain.Coupon
+	args := make(map[string][]string)
+	args[queryBrand] = []string{Brand}
+	args[queryMatch] = []string{matchPrefix}
+	query := domain.CouponQuery{}.Where(queryBrand, domain.FilterPrefix, Brand).Paginate(defaultLimit, defaultPage)
+
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
+
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
+}
+
+func testGetCouponsInvalidMatch(t *testing.T) {
+	s := startService(t)
+
+	var coupons []domain.Coupon
+	args := make(map[string][]string)
+	args[queryMatch] = []string{name}
+
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
+}
+
+func testGetCouponsUnknownKey(t *testing.T) {
+	s := startService(t)
+
+	var coupons []domain.Coupon
+	args := make(map[string][]string)
+	args["expiry"] = []string{sExpiry}
+
+	err := s.GetCoupons(context.Background(), &coupons, args)
+	assert.IsType(t, domain.InvalidArgsError{}, err)
+}
+
+func testGetCouponsSuccessFields(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var coupons []domain.Coupon
+	args := make(map[string][]string)
+	args[queryFields] = []string{"name"}
+	query := domain.CouponQuery{}.Select([]string{"id", "name"}).Paginate(defaultLimit, defaultPage)
+
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), &coupons, query).Return(nil)
+
+	assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
+}
+
+// TestGetCouponsConcurrent checks that concurrent requests build their own queries. Run it with -race
+func TestGetCouponsConcurrent(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	const requests = 50
+	// the repository answers with a coupon named after the filter it received
+	s.mock.EXPECT().QueryCoupons(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, coupons *[]domain.Coupon, q domain.CouponQuery) error {
+			filters := q.Filters()
+			if len(filters) != 1 {
+				return errors.New("unexpected filters")
+			}
+			*coupons = []domain.Coupon{{Name: filters[0].Values[0].(string)}}
+			return nil
+		}).
+		Times(requests)
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := name + strconv.Itoa(i)
+			var coupons []domain.Coupon
+			args := map[string][]string{queryName: {n}}
+
+			assert.Nil(t, s.GetCoupons(context.Background(), &coupons, args))
+			assert.Equal(t, n, coupons[0].Name)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func testGetCouponsInvalidFields(t *testing.T) {
+	s := startService(t)
+
+	var coupons []domain.Coupon
+	args := make(map[string][]string)
+	args[queryFields] = []string{"DeletedAt"}
+
+	assert.Error(t, s.GetCoupons(context.Background(), &coupons, args))
+}
+
+func TestGetCouponsStats(t *testing.T) {
+	t.Run("success", testGetCouponsStatsSuccess)
+	t.Run("successFilters", testGetCouponsStatsSuccessFilters)
+	t.Run("invalidGroupBy", testGetCouponsStatsInvalidGroupBy)
+	t.Run("invalidFilter", testGetCouponsStatsInvalidFilter)
+	t.Run("unknownKey", testGetCouponsStatsUnknownKey)
+}
+
+func testGetCouponsStatsSuccess(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var stats []domain.CouponStats
+	args := make(map[string][]string)
+	args[queryGroupBy] = []string{"brand,status", domain.StatsGroupExpiryMonth, domain.StatsGroupBrand}
+	groupBy := []string{domain.StatsGroupBrand, domain.StatsGroupStatus, domain.StatsGroupExpiryMonth}
+
+	s.mock.EXPECT().CouponStats(gomock.Any(), &stats, groupBy, domain.CouponQuery{}).Return(nil)
+
+	assert.Nil(t, s.GetCouponsStats(context.Background(), &stats, args))
+}
+
+func testGetCouponsStatsSuccessFilters(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var stats []domain.CouponStats
+	args := make(map[string][]string)
+	args[queryBrand] = []string{Brand}
+	args[queryGreaterExpiry] = []string{sExpiry}
+	query := domain.CouponQuery{}.
+		Where(queryBrand, domain.FilterEqual, Brand).
+		Where(columnExpiry, domain.FilterGreater, parsedExpiry())
+
+	s.mock.EXPECT().CouponStats(gomock.Any(), &stats, nil, query).Return(nil)
+
+	assert.Nil(t, s.GetCouponsStats(context.Background(), &stats, args))
+}
+
+func testGetCouponsStatsInvalidGroupBy(t *testing.T) {
+	s := startService(t)
+
+	var stats []domain.CouponStats
+	args := make(map[string][]string)
+	args[queryGroupBy] = []string{name}
+
+	assert.IsType(t, domain.InvalidArgsError{}, s.GetCouponsStats(context.Background(), &stats, args))
+}
+
+func testGetCouponsStatsInvalidFilter(t *testing.T) {
+	s := startService(t)
+
+	var stats []domain.CouponStats
+	args := make(map[string][]string)
+	args[queryLesserValue] = []string{name}
+
+	assert.IsType(t, domain.InvalidArgsError{}, s.GetCouponsStats(context.Background(), &stats, args))
+}
+
+func testGetCouponsStatsUnknownKey(t *testing.T) {
+	s := startService(t)
+
+	var stats []domain.CouponStats
+	args := make(map[string][]string)
+	args[queryLimit] = []string{"10"}
+
+	assert.IsType(t, domain.InvalidArgsError{}, s.GetCouponsStats(context.Background(), &stats, args))
+}
+
+func TestCouponHistory(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var entries []domain.AuditEntry
+	s.mock.EXPECT().CouponHistory(gomock.Any(), uint(1), &entries).Return(nil)
+	assert.Nil(t, s.CouponHistory(context.Background(), 1, &entries))
+}
+
+func TestGetAudit(t *testing.T) {
+	t.Run("success", testGetAuditSuccess)
+	t.Run("successFilters", testGetAuditSuccessFilters)
+	t.Run("invalidArgs", testGetAuditInvalidArgs)
+}
+
+func testGetAuditSuccess(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var entries []domain.AuditEntry
+	s.mock.EXPECT().QueryAudit(gomock.Any(), &entries, domain.AuditQuery{Limit: defaultLimit}).Return(nil)
+
+	assert.Nil(t, s.GetAudit(context.Background(), &entries, map[string][]string{}))
+}
+
+func testGetAuditSuccessFilters(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	var entries []domain.AuditEntry
+	args := map[string][]string{
+		queryActor:     {"apikey:1"},
+		queryAction:    {domain.AuditUpdate},
+		queryCouponID:  {"3"},
+		queryRequestID: {"req-1"},
+		queryFrom:      {sExpiry},
+		queryTo:        {sExpiry},
+		queryLimit:     {"10"},
+		queryPage:      {"3"},
+	}
+	query := domain.AuditQuery{
+		Actor:     "apikey:1",
+		Action:    domain.AuditUpdate,
+		CouponID:  3,
+		RequestID: "req-1",
+		From:      parsedExpiry(),
+		To:        parsedExpiry(),
+		Limit:     10,
+		Offset:    20,
+	}
+	s.mock.EXPECT().QueryAudit(gomock.Any(), &entries, query).Return(nil)
+
+	assert.Nil(t, s.GetAudit(context.Background(), &entries, args))
+}
+
+func testGetAuditInvalidArgs(t *testing.T) {
+	s := startService(t)
+
+	var entries []domain.AuditEntry
+	for _, args := range []map[string][]string{
+		{queryAction: {"redeem"}},
+		{queryCouponID: {"0"}},
+		{queryCouponID: {name}},
+		{queryFrom: {"yesterday"}},
+		{queryLimit: {"0"}},
+		{queryPage: {"0"}},
+		{queryName: {name}},
+	} {
+		assert.IsType(t, domain.InvalidArgsError{}, s.GetAudit(context.Background(), &entries, args), args)
+	}
+}
+
+func TestExportCoupons(t *testing.T) {
+	t.Run("success", testExportCouponsSuccess)
+	t.Run("invalidFilter", testExportCouponsInvalidFilter)
+	t.Run("unknownKey", testExportCouponsUnknownKey)
+}
+
+func testExportCouponsSuccess(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	args := make(map[string][]string)
+	args[queryName] = []string{Name}
+	args[queryFields] = []string{"name"}
+	query := domain.CouponQuery{}.Where(queryName, domain.FilterEqual, Name).Select([]string{"id", "name"})
+
+	s.mock.EXPECT().ExportCoupons(gomock.Any(), gomock.Any(), query).Return(nil)
+
+	assert.Nil(t, s.ExportCoupons(context.Background(), args, func(domain.Coupon) error { return nil }))
+}
+
+func testExportCouponsInvalidFilter(t *testing.T) {
+	s := startService(t)
+
+	args := make(map[string][]string)
+	args[queryGreaterCreated] = []string{name}
+
+	assert.IsType(t, domain.InvalidArgsError{}, s.ExportCoupons(context.Background(), args, func(domain.Coupon) error { return nil }))
+}
+
+func testExportCouponsUnknownKey(t *testing.T) {
+	s := startService(t)
+
+	args := make(map[string][]string)
+	args[queryPage] = []string{"2"}
+
+	assert.IsType(t, domain.InvalidArgsError{}, s.ExportCoupons(context.Background(), args, func(domain.Coupon) error { return nil }))
+}
+
+func TestImportCoupons(t *testing.T) {
+	t.Run("success", testImportCouponsSuccess)
+	t.Run("dryRun", testImportCouponsDryRun)
+	t.Run("chunks", testImportCouponsChunks)
+	t.Run("failedChunk", testImportCouponsFailedChunk)
+}
+
+func importRows(n int) []domain.ImportRow {
+	rows := make([]domain.ImportRow, n)
+	for i := range rows {
+		rows[i] = domain.ImportRow{
+			Line: i + 2,
+			Coupon: domain.APICoupon{
+				Name:   &Name,
+				Brand:  &Brand,
+				Value:  &Value,
+				Expiry: &Expiry,
+			},
+		}
+	}
+	return rows
+}
+
+func testImportCouponsSuccess(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	rows := importRows(3)
+	rows[1].Coupon.Name = nil
+	rows[2].Err = domain.NewInvalidArgsError("failed to parse value:a")
+
+	s.expectTx()
+	s.mock.EXPECT().NewCoupon(gomock.Any(), rows[0].Coupon).Return(uint(1), nil)
+
+	report, err := s.ImportCoupons(context.Background(), rows, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 2, report.Invalid)
+	assert.Equal(t, domain.ImportResult{Line: 2, Status: domain.ImportStatusCreated}, report.Rows[0])
+	assert.Equal(t, domain.ImportStatusInvalid, report.Rows[1].Status)
+	assert.Equal(t, 3, report.Rows[1].Line)
+	assert.Equal(t, []string{"failed to parse value:a"}, report.Rows[2].Errors)
+}
+
+func testImportCouponsDryRun(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	rows := importRows(2)
+	v := uint(0)
+	rows[1].Coupon.Value = &v
+
+	report, err := s.ImportCoupons(context.Background(), rows, true)
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 0, report.Created)
+	assert.Equal(t, 1, report.Valid)
+	assert.Equal(t, 1, report.Invalid)
+	assert.Equal(t, domain.ImportStatusValid, report.Rows[0].Status)
+}
+
+func testImportCouponsChunks(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	rows := importRows(importChunkSize + 1)
+
+	s.expectTx().Times(2)
+	s.mock.EXPECT().NewCoupon(gomock.Any(), gomock.Any()).Return(uint(1), nil).Times(importChunkSize + 1)
+
+	report, err := s.ImportCoupons(context.Background(), rows, false)
+	assert.Nil(t, err)
+	assert.Equal(t, importChunkSize+1, report.Created)
+}
+
+func testImportCouponsFailedChunk(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	rows := importRows(importChunkSize + 1)
+
+	// the first chunk is rolled back on its first failure, the second one holds a single coupon
+	s.expectTx().Times(2)
+	gomock.InOrder(
+		s.mock.EXPECT().NewCoupon(gomock.Any(), gomock.Any()).Return(uint(0), errors.New("db")),
+		s.mock.EXPECT().NewCoupon(gomock.Any(), gomock.Any()).Return(uint(1), nil),
+	)
+
+	report, err := s.ImportCoupons(context.Background(), rows, false)
+	assert.Nil(t, err)
+	assert.Equal(t, importChunkSize, report.Failed)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, domain.ImportStatusFailed, report.Rows[0].Status)
+	assert.Equal(t, []string{errImportChunk}, report.Rows[0].Errors)
+	assert.Equal(t, domain.ImportStatusCreated, report.Rows[importChunkSize].Status)
+}
+
+func TestBatchCoupons(t *testing.T) {
+	t.Run("atomic", testBatchCouponsAtomic)
+	t.Run("atomicRollback", testBatchCouponsAtomicRollback)
+	t.Run("atomicTxFailure", testBatchCouponsAtomicTxFailure)
+	t.Run("atomicTimeout", testBatchCouponsAtomicTimeout)
+	t.Run("atomicInvalid", testBatchCouponsAtomicInvalid)
+	t.Run("bestEffort", testBatchCouponsBestEffort)
+	t.Run("bestEffortFailure", testBatchCouponsBestEffortFailure)
+	t.Run("invalidMode", testBatchCouponsInvalidMode)
+	t.Run("empty", testBatchCouponsEmpty)
+	t.Run("tooLarge", testBatchCouponsTooLarge)
+}
+
+func batchOperations() []domain.BatchOperation {
+	return []domain.BatchOperation{
+		{Op: domain.BatchCreate, Coupon: domain.APICoupon{Name: &Name, Brand: &Brand, Value: &Value, Expiry: &Expiry}},
+		{Op: domain.BatchUpdate, ID: 1, Coupon: domain.APICoupon{Value: &Value}},
+		{Op: domain.BatchDelete, ID: 2},
+	}
+}
+
+func testBatchCouponsAtomic(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	ops := batchOperations()
+	expected := []domain.BatchResult{
+		{Index: 0, Op: domain.BatchCreate, ID: 3, Status: domain.BatchStatusOK},
+		{Index: 1, Op: domain.BatchUpdate, ID: 1, Status: domain.BatchStatusOK},
+		{Index: 2, Op: domain.BatchDelete, ID: 2, Status: domain.BatchStatusOK},
+	}
+	s.expectTx()
+	s.mock.EXPECT().NewCoupon(gomock.Any(), ops[0].Coupon).Return(uint(3), nil)
+	s.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(1), ops[1].Coupon).Return(nil)
+	s.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(2)).Return(nil)
+
+	results, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Operations: ops})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, results)
+}
+
+func testBatchCouponsAtomicRollback(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	ops := batchOperations()
+	s.expectTx()
+	s.mock.EXPECT().NewCoupon(gomock.Any(), ops[0].Coupon).Return(uint(3), nil)
+	s.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(1), ops[1].Coupon).Return(domain.NewCouponNotFoundError())
+
+	results, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Operations: ops})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.BatchResult{Index: 0, Op: domain.BatchCreate, Status: domain.BatchStatusAborted}, results[0])
+	assert.Equal(t, domain.BatchResult{Index: 1, Op: domain.BatchUpdate, ID: 1, Status: domain.BatchStatusNotFound, Error: domain.CouponNotFoundErrorMessage}, results[1])
+	assert.Equal(t, domain.BatchResult{Index: 2, Op: domain.BatchDelete, ID: 2, Status: domain.BatchStatusAborted}, results[2])
+}
+
+func testBatchCouponsAtomicTxFailure(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	s.mock.EXPECT().WithTx(gomock.Any(), gomock.Any()).Return(errors.New("db"))
+
+	results, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Operations: batchOperations()})
+	assert.Nil(t, err)
+	for _, r := range results {
+		assert.Equal(t, domain.BatchStatusFailed, r.Status)
+		assert.Equal(t, errBatchOperation, r.Error)
+	}
+}
+
+func testBatchCouponsAtomicTimeout(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	s.mock.EXPECT().WithTx(gomock.Any(), gomock.Any()).Return(context.DeadlineExceeded)
+
+	_, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Operations: batchOperations()})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func testBatchCouponsAtomicInvalid(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	ops := batchOperations()
+	ops[1].ID = 0
+
+	results, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Mode: domain.BatchModeAtomic, Operations: ops})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.BatchStatusAborted, results[0].Status)
+	assert.Equal(t, domain.BatchStatusInvalid, results[1].Status)
+	assert.NotEmpty(t, results[1].Error)
+	assert.Equal(t, domain.BatchStatusAborted, results[2].Status)
+}
+
+func testBatchCouponsBestEffort(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	ops := batchOperations()
+	ops[0].Op = "upsert"
+
+	s.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(1), ops[1].Coupon).Return(domain.NewCouponNotFoundError())
+	s.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(2)).Return(nil)
+
+	results, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Mode: domain.BatchModeBestEffort, Operations: ops})
+	assert.Nil(t, err)
+	assert.Equal(t, domain.BatchStatusInvalid, results[0].Status)
+	assert.Equal(t, domain.BatchResult{Index: 1, Op: domain.BatchUpdate, ID: 1, Status: domain.BatchStatusNotFound, Error: domain.CouponNotFoundErrorMessage}, results[1])
+	assert.Equal(t, domain.BatchResult{Index: 2, Op: domain.BatchDelete, ID: 2, Status: domain.BatchStatusOK}, results[2])
+}
+
+func testBatchCouponsBestEffortFailure(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+
+	ops := batchOperations()[2:]
+	s.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(2)).Return(errors.New(`pq: relation "coupons" does not exist`))
+
+	results, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Mode: domain.BatchModeBestEffort, Operations: ops})
+	assert.Nil(t, err)
+	// the repository error is not reported to the caller
+	assert.Equal(t, domain.BatchResult{Index: 0, Op: domain.BatchDelete, ID: 2, Status: domain.BatchStatusFailed, Error: errBatchOperation}, results[0])
+}
+
+func testBatchCouponsInvalidMode(t *testing.T) {
+	s := startService(t)
+
+	_, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Mode: name, Operations: batchOperations()})
+	assert.IsType(t, domain.InvalidArgsError{}, err)
+}
+
+func testBatchCouponsEmpty(t *testing.T) {
+	s := startService(t)
+
+	_, err := s.BatchCoupons(context.Background(), domain.BatchRequest{})
+	assert.IsType(t, domain.InvalidArgsError{}, err)
+}
+
+func testBatchCouponsTooLarge(t *testing.T) {
+	s := startService(t)
+
+	ops := make([]domain.BatchOperation, maxBatchSize+1)
+	_, err := s.BatchCoupons(context.Background(), domain.BatchRequest{Operations: ops})
+	assert.IsType(t, domain.InvalidArgsError{}, err)
+}
+
+// recordedMetrics is a Metrics recording the service events
+type recordedMetrics struct {
+	created    int
+	validation map[string]int
+	notFound   map[string]int
+}
+
+func newRecordedMetrics() *recordedMetrics {
+	return &recordedMetrics{validation: make(map[string]int), notFound: make(map[string]int)}
+}
+
+func (m *recordedMetrics) CouponsCreated(n int)           { m.created += n }
+func (m *recordedMetrics) ValidationFailed(reason string) { m.validation[reason]++ }
+func (m *recordedMetrics) CouponNotFound(op string)       { m.notFound[op]++ }
+
+func TestMetrics(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+	m := newRecordedMetrics()
+	ms := s.WithMetrics(m)
+	ctx := context.Background()
+
+	a := domain.APICoupon{Name: &Name, Brand: &Brand, Value: &Value, Expiry: &Expiry}
+	s.mock.EXPECT().NewCoupon(gomock.Any(), a).Return(uint(1), nil)
+	assert.Nil(t, ms.CreateCoupon(ctx, a))
+
+	empty := ""
+	assert.NotNil(t, ms.CreateCoupon(ctx, domain.APICoupon{Name: &empty, Brand: &Brand, Value: &Value, Expiry: &Expiry}))
+	assert.NotNil(t, ms.UpdateCoupon(ctx, 1, domain.APICoupon{}))
+
+	var coupons []domain.Coupon
+	assert.NotNil(t, ms.GetCoupons(ctx, &coupons, map[string][]string{queryFields: {"deleted_at"}}))
+	_, err := ms.ParseFields(map[string][]string{queryFields: {"deleted_at"}})
+	assert.NotNil(t, err)
+
+	var c domain.Coupon
+	s.mock.EXPECT().GetCouponByID(gomock.Any(), uint(2), nil, &c).Return(domain.NewCouponNotFoundError())
+	assert.NotNil(t, ms.GetCoupon(ctx, 2, nil, &c))
+	s.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(2)).Return(domain.NewCouponNotFoundError())
+	assert.NotNil(t, ms.DeleteCoupon(ctx, 2))
+
+	rows := importRows(3)
+	rows[1].Err = domain.NewInvalidArgsError("failed to parse value:a")
+	s.mock.EXPECT().WithTx(gomock.Any(), gomock.Any()).Return(nil)
+	ms.ImportCoupons(ctx, rows, false)
+
+	ops := batchOperations()
+	s.mock.EXPECT().NewCoupon(gomock.Any(), ops[0].Coupon).Return(uint(3), nil)
+	s.mock.EXPECT().UpdateCoupon(gomock.Any(), uint(1), ops[1].Coupon).Return(domain.NewCouponNotFoundError())
+	s.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(2)).Return(nil)
+	_, err = ms.BatchCoupons(ctx, domain.BatchRequest{Mode: domain.BatchModeBestEffort, Operations: ops})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 4, m.created)
+	assert.Equal(t, map[string]int{"coupon_name": 1, "coupon_fields": 1, reasonQuery: 2, reasonImportRow: 1}, m.validation)
+	assert.Equal(t, map[string]int{opGet: 1, opDelete: 1, domain.BatchUpdate: 1}, m.notFound)
+
+	// the service without metrics is left unchanged
+	s.mock.EXPECT().NewCoupon(gomock.Any(), a).Return(uint(4), nil)
+	assert.Nil(t, s.CreateCoupon(ctx, a))
+	assert.Equal(t, 4, m.created)
+}
+
+func TestAuthorization(t *testing.T) {
+	s := startService(t)
+	defer s.ctrl.Finish()
+	as := s.WithAuthorization()
+
+	a := domain.APICoupon{Name: &Name, Brand: &Brand, Value: &Value, Expiry: &Expiry}
+	viewer := domain.WithPrincipal(context.Background(), domain.Principal{Subject: "jwt:viewer", Roles: []string{domain.RoleCouponViewer}})
+	admin := domain.WithPrincipal(context.Background(), domain.Principal{Subject: "jwt:admin", Roles: []string{domain.RoleCouponAdmin}})
+
+	// callers without the coupon-admin role change no coupon, the repository is not reached
+	for _, ctx := range []context.Context{context.Background(), viewer} {
+		assert.IsType(t, domain.ForbiddenError{}, as.CreateCoupon(ctx, a))
+		assert.IsType(t, domain.ForbiddenError{}, as.UpdateCoupon(ctx, 1, a))
+		assert.IsType(t, domain.ForbiddenError{}, as.DeleteCoupon(ctx, 1))
+		_, err := as.ImportCoupons(ctx, importRows(1), false)
+		assert.IsType(t, domain.ForbiddenError{}, err)
+		_, err = as.BatchCoupons(ctx, domain.BatchRequest{Operations: batchOperations()})
+		assert.IsType(t, domain.ForbiddenError{}, err)
+	}
+
+	// a dry run changes nothing
+	report, err := as.ImportCoupons(viewer, importRows(1), true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Valid)
+
+	s.mock.EXPECT().NewCoupon(gomock.Any(), a).Return(uint(1), nil)
+	assert.Nil(t, as.CreateCoupon(admin, a))
+	s.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(1)).Return(nil)
+	assert.Nil(t, as.DeleteCoupon(admin, 1))
+
+	// the service without authorization is left unchanged
+	s.mock.EXPECT().DeleteCoupon(gomock.Any(), uint(1)).Return(nil)
+	assert.Nil(t, s.DeleteCoupon(viewer, 1))
 }
@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// Repository is a domain.Repository recording a span for every call of the repository it wraps
+// The SQL statements of a call are recorded as children of its span when the gorm databases are traced
+// with gormtrace, the repositories passed to the WithTx callbacks are traced as well
+type Repository struct {
+	next   domain.Repository
+	tracer *Tracer
+}
+
+// Repository returns repo recording a span for every call
+func (t *Tracer) Repository(repo domain.Repository) *Repository {
+	return &Repository{next: repo, tracer: t}
+}
+
+// WithTx traces the whole transaction, fn included
+func (r *Repository) WithTx(ctx context.Context, fn func(tx domain.Repository) error) error {
+	ctx, span := r.tracer.start(ctx, "Repository.WithTx")
+	err := r.next.WithTx(ctx, func(tx domain.Repository) error {
+		return fn(r.tracer.Repository(tx))
+	})
+	end(span, err)
+	return err
+}
+
+// NewCoupon traces the coupon creation
+func (r *Repository) NewCoupon(ctx context.Context, APIc domain.APICoupon) (uint, error) {
+	ctx, span := r.tracer.start(ctx, "Repository.NewCoupon")
+	id, err := r.next.NewCoupon(ctx, APIc)
+	span.SetAttributes(attribute.Int("coupon.id", int(id)))
+	end(span, err)
+	return id, err
+}
+
+// GetCouponByID traces the coupon read
+func (r *Repository) GetCouponByID(ctx context.Context, id uint, columns []string, c *domain.Coupon) error {
+	ctx, span := r.tracer.start(ctx, "Repository.GetCouponByID", attribute.Int("coupon.id", int(id)), attribute.StringSlice("db.columns", columns))
+	err := r.next.GetCouponByID(ctx, id, columns, c)
+	end(span, err)
+	return err
+}
+
+// DeleteCoupon traces the coupon deletion
+func (r *Repository) DeleteCoupon(ctx context.Context, id uint) error {
+	ctx, span := r.tracer.start(ctx, "Repository.DeleteCoupon", attribute.Int("coupon.id", int(id)))
+	err := r.next.DeleteCoupon(ctx, id)
+	end(span, err)
+	return err
+}
+
+// UpdateCoupon traces the coupon update
+func (r *Repository) UpdateCoupon(ctx context.Context, id uint, APIc domain.APICoupon) error {
+	ctx, span := r.tracer.start(ctx, "Repository.UpdateCoupon", attribute.Int("coupon.id", int(id)))
+	err := r.next.UpdateCoupon(ctx, id, APIc)
+	end(span, err)
+	return err
+}
+
+// QueryCoupons traces the coupons query with the shape of q, the filter values are not recorded
+func (r *Repository) QueryCoupons(ctx context.Context, coupons *[]domain.Coupon, q domain.CouponQuery) error {
+	ctx, span := r.tracer.start(ctx, "Repository.QueryCoupons", queryAttributes(q)...)
+	err := r.next.QueryCoupons(ctx, coupons, q)
+	span.SetAttributes(attribute.Int("coupons.count", len(*coupons)))
+	end(span, err)
+	return err
+}
+
+// ExportCoupons traces the whole export, the time spent by fn writing the coupons included
+func (r *Repository) ExportCoupons(ctx context.Context, fn func(c domain.Coupon) error, q domain.CouponQuery) error {
+	ctx, span := r.tracer.start(ctx, "Repository.ExportCoupons", queryAttributes(q)...)
+	err := r.next.ExportCoupons(ctx, fn, q)
+	end(span, err)
+	return err
+}
+
+// CouponStats traces the coupons aggregation
+func (r *Repository) CouponStats(ctx context.Context, stats *[]domain.CouponStats, groupBy []string, q domain.CouponQuery) error {
+	ctx, span := r.tracer.start(ctx, "Repository.CouponStats", append(queryAttributes(q), attribute.StringSlice("stats.group_by", groupBy))...)
+	err := r.next.CouponStats(ctx, stats, groupBy, q)
+	end(span, err)
+	return err
+}
+
+// CouponHistory traces the coupon history read
+func (r *Repository) CouponHistory(ctx context.Context, id uint, entries *[]domain.AuditEntry) error {
+	ctx, span := r.tracer.start(ctx, "Repository.CouponHistory", attribute.Int("coupon.id", int(id)))
+	err := r.next.CouponHistory(ctx, id, entries)
+	span.SetAttributes(attribute.Int("audit.count", len(*entries)))
+	end(span, err)
+	return err
+}
+
+// QueryAudit traces the audit entries query, the filter values are not recorded
+func (r *Repository) QueryAudit(ctx context.Context, entries *[]domain.AuditEntry, q domain.AuditQuery) error {
+	ctx, span := r.tracer.start(ctx, "Repository.QueryAudit", attribute.Int("query.limit", int(q.Limit)), attribute.Int("query.offset", int(q.Offset)))
+	err := r.next.QueryAudit(ctx, entries, q)
+	span.SetAttributes(attribute.Int("audit.count", len(*entries)))
+	end(span, err)
+	return err
+}
+
+// Ping traces the store ping
+func (r *Repository) Ping(ctx context.Context) error {
+	ctx, span := r.tracer.start(ctx, "Repository.Ping")
+	err := r.next.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+// queryAttributes returns the filtered columns and operators, the selected columns and the page of q
+func queryAttributes(q domain.CouponQuery) []attribute.KeyValue {
+	filters := make([]string, len(q.Filters()))
+	for i, f := range q.Filters() {
+		filters[i] = f.Column + " " + f.Op
+	}
+	return []attribute.KeyValue{
+		attribute.StringSlice("query.filters", filters),
+		attribute.StringSlice("query.columns", q.Columns()),
+		attribute.Int("query.limit", int(q.Limit())),
+		attribute.Int("query.offset", int(q.Offset())),
+	}
+}
@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/handlers"
+)
+
+// Service is a handlers.Service recording a span for every call of the service it wraps
+type Service struct {
+	next   handlers.Service
+	tracer *Tracer
+}
+
+// Service returns s recording a span for every call
+func (t *Tracer) Service(s handlers.Service) *Service {
+	return &Service{next: s, tracer: t}
+}
+
+// CreateCoupon traces the coupon creation
+func (s *Service) CreateCoupon(ctx context.Context, APIc domain.APICoupon) error {
+	ctx, span := s.tracer.start(ctx, "Service.CreateCoupon")
+	err := s.next.CreateCoupon(ctx, APIc)
+	end(span, err)
+	return err
+}
+
+// GetCoupon traces the coupon read
+func (s *Service) GetCoupon(ctx context.Context, id uint, fields []string, c *domain.Coupon) error {
+	ctx, span := s.tracer.start(ctx, "Service.GetCoupon", attribute.Int("coupon.id", int(id)))
+	err := s.next.GetCoupon(ctx, id, fields, c)
+	end(span, err)
+	return err
+}
+
+// DeleteCoupon traces the coupon deletion
+func (s *Service) DeleteCoupon(ctx context.Context, id uint) error {
+	ctx, span := s.tracer.start(ctx, "Service.DeleteCoupon", attribute.Int("coupon.id", int(id)))
+	err := s.next.DeleteCoupon(ctx, id)
+	end(span, err)
+	return err
+}
+
+// UpdateCoupon traces the coupon update
+func (s *Service) UpdateCoupon(ctx context.Context, id uint, APIc domain.APICoupon) error {
+	ctx, span := s.tracer.start(ctx, "Service.UpdateCoupon", attribute.Int("coupon.id", int(id)))
+	err := s.next.UpdateCoupon(ctx, id, APIc)
+	end(span, err)
+	return err
+}
+
+// GetCoupons traces the coupons query
+func (s *Service) GetCoupons(ctx context.Context, coupons *[]domain.Coupon, args map[string][]string) error {
+	ctx, span := s.tracer.start(ctx, "Service.GetCoupons")
+	err := s.next.GetCoupons(ctx, coupons, args)
+	span.SetAttributes(attribute.Int("coupons.count", len(*coupons)))
+	end(span, err)
+	return err
+}
+
+// ParseFields is not traced, it only validates its args
+func (s *Service) ParseFields(args map[string][]string) ([]string, error) {
+	return s.next.ParseFields(args)
+}
+
+// GetCouponsStats traces the coupons aggregation
+func (s *Service) GetCouponsStats(ctx context.Context, stats *[]domain.CouponStats, args map[string][]string) error {
+	ctx, span := s.tracer.start(ctx, "Service.GetCouponsStats")
+	err := s.next.GetCouponsStats(ctx, stats, args)
+	end(span, err)
+	return err
+}
+
+// ExportCoupons traces the whole export, the time spent by fn writing the coupons included
+func (s *Service) ExportCoupons(ctx context.Context, args map[string][]string, fn func(c domain.Coupon) error) error {
+	ctx, span := s.tracer.start(ctx, "Service.ExportCoupons")
+	var n int
+	err := s.next.ExportCoupons(ctx, args, func(c domain.Coupon) error {
+		n++
+		return fn(c)
+	})
+	span.SetAttributes(attribute.Int("coupons.count", n))
+	end(span, err)
+	return err
+}
+
+// ImportCoupons traces the import with the counts of its report
+func (s *Service) ImportCoupons(ctx context.Context, rows []domain.ImportRow, dryRun bool) (domain.ImportReport, error) {
+	ctx, span := s.tracer.start(ctx, "Service.ImportCoupons", attribute.Int("import.rows", len(rows)), attribute.Bool("import.dry_run", dryRun))
+	report, err := s.next.ImportCoupons(ctx, rows, dryRun)
+	span.SetAttributes(
+		attribute.Int("import.created", report.Created),
+		attribute.Int("import.invalid", report.Invalid),
+		attribute.Int("import.failed", report.Failed),
+	)
+	end(span, err)
+	return report, err
+}
+
+// BatchCoupons traces the batch
+func (s *Service) BatchCoupons(ctx context.Context, batch domain.BatchRequest) ([]domain.BatchResult, error) {
+	ctx, span := s.tracer.start(ctx, "Service.BatchCoupons", attribute.Int("batch.operations", len(batch.Operations)), attribute.String("batch.mode", batch.Mode))
+	results, err := s.next.BatchCoupons(ctx, batch)
+	end(span, err)
+	return results, err
+}
+
+// CouponHistory traces the coupon history read
+func (s *Service) CouponHistory(ctx context.Context, id uint, entries *[]domain.AuditEntry) error {
+	ctx, span := s.tracer.start(ctx, "Service.CouponHistory", attribute.Int("coupon.id", int(id)))
+	err := s.next.CouponHistory(ctx, id, entries)
+	end(span, err)
+	return err
+}
+
+// GetAudit traces the audit entries query
+func (s *Service) GetAudit(ctx context.Context, entries *[]domain.AuditEntry, args map[string][]string) error {
+	ctx, span := s.tracer.start(ctx, "Service.GetAudit")
+	err := s.next.GetAudit(ctx, entries, args)
+	span.SetAttributes(attribute.Int("audit.count", len(*entries)))
+	end(span, err)
+	return err
+}
+
+// Ping traces the store ping
+func (s *Service) Ping(ctx context.Context) error {
+	ctx, span := s.tracer.start(ctx, "Service.Ping")
+	err := s.next.Ping(ctx)
+	end(span, err)
+	return err
+}
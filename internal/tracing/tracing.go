@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/handlers"
+	"github.com/jcgfreitas/pb_api/pkg/statuswriter"
+)
+
+const (
+	tracerName  = "github.com/jcgfreitas/pb_api"
+	serviceName = "pb_api"
+)
+
+// Config configures the export of the spans
+type Config struct {
+	// Endpoint is the host:port of the OTLP HTTP collector receiving the spans, tracing is disabled if it is empty
+	Endpoint string
+	// Insecure sends the spans over plain HTTP instead of HTTPS
+	Insecure bool
+	// SampleRatio is the ratio of the traces started by the API which are sampled, between 0 and 1
+	// The traces started by the callers keep the sampling decision of their trace context
+	SampleRatio float64
+}
+
+// Enabled reports whether the spans are exported
+func (cfg Config) Enabled() bool {
+	return cfg.Endpoint != ""
+}
+
+// NewExporter returns an exporter sending the spans to the OTLP collector of cfg
+// The OTEL_EXPORTER_OTLP_* environment variables configure it further, like its headers or timeout
+func NewExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create OTLP exporter")
+	}
+	return exporter, nil
+}
+
+// NewProvider returns a tracer provider sampling the traces according to cfg and batching their spans to exporter
+// Its Shutdown method must be called before exiting to export the last spans
+func NewProvider(exporter sdktrace.SpanExporter, cfg Config) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	)
+}
+
+// Tracer traces the requests through the layers of the API
+// Every layer records its spans as children of the span found in the context it is given
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New is the Tracer constructor, the spans are created by tp
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer:     tp.Tracer(tracerName),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+}
+
+// HTTP is a mux middleware recording a server span named by the method and the template of the route of every request
+// A request carrying a W3C traceparent header continues the trace of its caller
+func (t *Tracer) HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := handlers.Route(r)
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		sw := statuswriter.Wrap(w)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		// client errors are not failures of the API
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// start starts a span of an internal operation
+func (t *Tracer) start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// end records err and ends span
+// Invalid args and missing coupons are recorded without failing the span, they are errors of the caller
+func end(span trace.Span, err error) {
+	defer span.End()
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	switch err.(type) {
+	case domain.InvalidArgsError, domain.CouponNotFoundError:
+		return
+	}
+	span.SetStatus(codes.Error, err.Error())
+}
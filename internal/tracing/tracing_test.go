@@ -0,0 +1,193 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/handlers"
+	"github.com/jcgfreitas/pb_api/internal/repository"
+	"github.com/jcgfreitas/pb_api/internal/repository/migrations"
+	"github.com/jcgfreitas/pb_api/internal/service"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/gormtrace"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/migrate"
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/sqlite"
+)
+
+const traceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+var (
+	exporter = tracetest.NewInMemoryExporter()
+	provider = NewProvider(exporter, Config{SampleRatio: 1})
+)
+
+// TestMain traces the statements of every gorm db, like the API does, the callbacks are global
+func TestMain(m *testing.M) {
+	gormtrace.Register(gorm.DefaultCallback, provider)
+	os.Exit(m.Run())
+}
+
+// spans flushes and returns the recorded spans, then forgets them
+func spans(t *testing.T) tracetest.SpanStubs {
+	assert.Nil(t, provider.ForceFlush(context.Background()))
+	defer exporter.Reset()
+	return exporter.GetSpans()
+}
+
+// byName returns the span named name, it fails the test if there is none
+func byName(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no span named %s", name)
+	return tracetest.SpanStub{}
+}
+
+// startSQLite returns a migrated sqlite GormRepository
+func startSQLite(t *testing.T) *repository.GormRepository {
+	db, err := sqlite.Open(sqlite.Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := migrations.FS(db.Dialect().GetName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	repo := repository.New(db, repository.Timeouts{})
+	t.Cleanup(repo.Close)
+	return repo
+}
+
+// newRouter returns a router serving the coupons through the traced layers like the API router
+func newRouter(tracer *Tracer, repo domain.Repository) *mux.Router {
+	logger := logrus.New()
+	h := handlers.NewHandlers(tracer.Service(service.NewService(tracer.Repository(repo), logger)), logger)
+	r := mux.NewRouter()
+	r.Use(tracer.HTTP)
+	r.HandleFunc(h.GetCouponsPath(), h.GetCouponsHandler).Methods("GET")
+	r.HandleFunc(h.GetCouponPath(), h.GetCouponHandler).Methods("GET")
+	return r
+}
+
+func serve(r http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", path, http.NoBody)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// TestLayers tests that a request is traced from its route down to its SQL statements, within the trace of its caller
+func TestLayers(t *testing.T) {
+	tracer := New(provider)
+	r := newRouter(tracer, startSQLite(t))
+	spans(t)
+
+	w := serve(r, "/coupons?name=secret", http.Header{"Traceparent": {traceparent}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	recorded := spans(t)
+	route := byName(t, recorded, "GET /coupons")
+	svc := byName(t, recorded, "Service.GetCoupons")
+	repo := byName(t, recorded, "Repository.QueryCoupons")
+	query := byName(t, recorded, "SELECT coupons")
+
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", route.SpanContext.TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", route.Parent.SpanID().String())
+	assert.Equal(t, trace.SpanKindServer, route.SpanKind)
+	assert.Equal(t, route.SpanContext.SpanID(), svc.Parent.SpanID())
+	assert.Equal(t, svc.SpanContext.SpanID(), repo.Parent.SpanID())
+	assert.Equal(t, repo.SpanContext.SpanID(), query.Parent.SpanID())
+
+	for _, kv := range query.Attributes {
+		assert.NotContains(t, kv.Value.Emit(), "secret")
+	}
+	for _, kv := range repo.Attributes {
+		if kv.Key == "query.filters" {
+			assert.Equal(t, []string{"name " + domain.FilterEqual}, kv.Value.AsStringSlice())
+		}
+	}
+}
+
+// TestNewTrace tests that a request without trace context starts a new trace
+func TestNewTrace(t *testing.T) {
+	tracer := New(provider)
+	r := newRouter(tracer, repository.NewMemory())
+
+	w := serve(r, "/coupons/1", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	recorded := spans(t)
+	route := byName(t, recorded, "GET /coupons/{id:[0-9]+}")
+	assert.False(t, route.Parent.IsValid())
+	// a missing coupon is an error of the caller
+	assert.Equal(t, codes.Unset, route.Status.Code)
+	assert.Equal(t, codes.Unset, byName(t, recorded, "Service.GetCoupon").Status.Code)
+	assert.Equal(t, 1, len(byName(t, recorded, "Repository.GetCouponByID").Events))
+}
+
+// failingRepository is a domain.Repository whose transactions fail
+type failingRepository struct {
+	domain.Repository
+}
+
+var errTx = errors.New("connection reset")
+
+func (failingRepository) WithTx(ctx context.Context, fn func(tx domain.Repository) error) error {
+	return errTx
+}
+
+// TestError tests that the spans of a failing request are failed
+func TestError(t *testing.T) {
+	tracer := New(provider)
+	svc := tracer.Service(service.NewService(tracer.Repository(failingRepository{repository.NewMemory()}), logrus.New()))
+	spans(t)
+
+	_, err := svc.BatchCoupons(context.Background(), domain.BatchRequest{Operations: []domain.BatchOperation{{Op: domain.BatchDelete, ID: 1}}})
+	assert.Nil(t, err)
+
+	recorded := spans(t)
+	assert.Equal(t, codes.Error, byName(t, recorded, "Repository.WithTx").Status.Code)
+	// the batch reports the failure in its results
+	assert.Equal(t, codes.Unset, byName(t, recorded, "Service.BatchCoupons").Status.Code)
+}
+
+func TestSampling(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := NewProvider(exporter, Config{SampleRatio: 0})
+	r := newRouter(New(tp), repository.NewMemory())
+
+	serve(r, "/coupons", nil)
+	serve(r, "/coupons", http.Header{"Traceparent": {traceparent}})
+	assert.Nil(t, tp.ForceFlush(context.Background()))
+
+	// only the trace sampled by the caller is recorded
+	recorded := exporter.GetSpans()
+	assert.NotEmpty(t, recorded)
+	for _, s := range recorded {
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", s.SpanContext.TraceID().String())
+	}
+}
@@ -0,0 +1,233 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+// headers of the deliveries
+const (
+	// SignatureHeader holds the signature of the payload: t={unix timestamp},v1={Sign of the payload}
+	SignatureHeader = "X-PB-Signature"
+	EventHeader     = "X-PB-Event"
+	DeliveryHeader  = "X-PB-Delivery"
+)
+
+// results of the attempts reported to the Metrics
+const (
+	ResultDelivered = "delivered"
+	ResultFailed    = "failed"
+	ResultDead      = "dead"
+)
+
+const (
+	// batchSize bounds the events fanned out and the deliveries claimed at once
+	batchSize = 100
+	// leaseMargin is added to the timeout of the attempts to lease the claimed deliveries
+	leaseMargin = time.Second * 30
+	// maxResponse bounds the response body read before the connection is reused
+	maxResponse = 64 << 10
+	userAgent   = "pb_api-webhooks"
+)
+
+// Config holds the schedule of the deliveries
+type Config struct {
+	// Interval is the wait between two rounds of the Dispatcher
+	Interval time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+	// MaxAttempts is the number of failed attempts after which a delivery is dead
+	MaxAttempts int
+	// Backoff is the wait after the first failed attempt, doubled after each other one up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Metrics records the attempts of the deliveries
+type Metrics interface {
+	// WebhookAttempted records an attempt with result, one of the Result... values
+	WebhookAttempted(result string)
+}
+
+// nopMetrics is the Metrics of a Dispatcher recording nothing
+type nopMetrics struct{}
+
+func (nopMetrics) WebhookAttempted(result string) {}
+
+// Dispatcher sweeps the expired coupons into the outbox, fans its events out into deliveries and attempts the due
+// deliveries
+// The deliveries are claimed before their attempt, several Dispatchers share a repository without delivering twice
+// the same event
+type Dispatcher struct {
+	repo    domain.WebhookRepository
+	client  *http.Client
+	cfg     Config
+	logger  *logrus.Logger
+	metrics Metrics
+	now     func() time.Time
+}
+
+// NewDispatcher is the Dispatcher constructor, the attempts are posted with client bounded by the config timeout
+func NewDispatcher(repo domain.WebhookRepository, client *http.Client, cfg Config, logger *logrus.Logger) *Dispatcher {
+	return &Dispatcher{repo: repo, client: client, cfg: cfg, logger: logger, metrics: nopMetrics{}, now: time.Now}
+}
+
+// WithMetrics returns a copy of d recording the attempts to m
+func (d *Dispatcher) WithMetrics(m Metrics) *Dispatcher {
+	c := *d
+	c.metrics = m
+	return &c
+}
+
+// Run dispatches every config interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			d.logger.WithError(err).Error("failed to dispatch the webhook deliveries")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch writes the expired events of the coupons whose expiry passed, fans every event of the outbox out, then
+// attempts the due deliveries until none is left
+// The deliveries of a batch are attempted concurrently
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	for {
+		n, err := d.repo.ExpireCoupons(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+		if n < batchSize {
+			break
+		}
+	}
+
+	for {
+		n, err := d.repo.FanOut(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+		if n < batchSize {
+			break
+		}
+	}
+
+	for {
+		var deliveries []domain.Delivery
+		if err := d.repo.ClaimDeliveries(ctx, d.cfg.Timeout+leaseMargin, batchSize, &deliveries); err != nil {
+			return err
+		}
+		var wg sync.WaitGroup
+		for _, del := range deliveries {
+			wg.Add(1)
+			go func(del domain.Delivery) {
+				defer wg.Done()
+				d.attempt(ctx, del)
+			}(del)
+		}
+		wg.Wait()
+		if len(deliveries) < batchSize || ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// attempt posts del to its webhook and records the outcome
+// A delivery whose webhook or outcome could not be read is left claimed, it is attempted again once its lease ends
+func (d *Dispatcher) attempt(ctx context.Context, del domain.Delivery) {
+	log := d.logger.WithFields(logrus.Fields{"delivery_id": del.ID, "webhook_id": del.WebhookID, "event": del.EventType})
+	var w domain.Webhook
+	if err := d.repo.GetWebhookByID(domain.WithTenant(ctx, del.TenantID), del.WebhookID, &w); err != nil {
+		// a webhook deleted meanwhile takes its deliveries along
+		if _, ok := err.(domain.WebhookNotFoundError); !ok {
+			log.WithError(err).Error("failed to read the webhook of a delivery")
+		}
+		return
+	}
+
+	code, err := d.post(ctx, w, del)
+	if ctx.Err() != nil {
+		// stopped during the attempt, which is not counted
+		return
+	}
+	result := ResultDelivered
+	del.Attempts++
+	del.LastStatusCode = code
+	switch {
+	case err == nil && code >= 200 && code < 300:
+		now := d.now()
+		del.Status, del.LastError, del.DeliveredAt = domain.DeliveryDelivered, "", &now
+	default:
+		del.LastError = http.StatusText(code)
+		if err != nil {
+			del.LastError = err.Error()
+		} else if del.LastError == "" {
+			del.LastError = "status " + strconv.Itoa(code)
+		}
+		result = ResultFailed
+		if del.Attempts >= d.cfg.MaxAttempts {
+			del.Status, result = domain.DeliveryDead, ResultDead
+		} else {
+			del.NextAttemptAt = d.now().Add(d.backoff(del.Attempts))
+		}
+		log.WithField("attempts", del.Attempts).WithField("error", del.LastError).Info("webhook delivery failed")
+	}
+	d.metrics.WebhookAttempted(result)
+
+	if err := d.repo.UpdateDelivery(ctx, &del); err != nil {
+		log.WithError(err).Error("failed to record the attempt of a delivery")
+	}
+}
+
+// post posts the signed payload of del to w and returns the status of the response
+func (d *Dispatcher) post(ctx context.Context, w domain.Webhook, del domain.Delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(EventHeader, del.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(del.ID), 10))
+	t := d.now().Unix()
+	req.Header.Set(SignatureHeader, fmt.Sprintf("t=%d,v1=%s", t, Sign(w.Secret, t, del.Payload)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponse))
+	return resp.StatusCode, nil
+}
+
+// backoff returns the wait after the failed attempt number attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.Backoff
+	for i := 1; i < attempts && wait < d.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.cfg.MaxBackoff {
+		wait = d.cfg.MaxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/repository"
+)
+
+// testMetrics counts the attempts by result
+type testMetrics struct {
+	mu      sync.Mutex
+	results map[string]int
+}
+
+func (m *testMetrics) WebhookAttempted(result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[result]++
+}
+
+// receiver is a webhook answering with status and recording the requests it received
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	w.WriteHeader(rc.status)
+}
+
+// setup returns the repositories of a dispatcher delivering to a webhook of acme served by rc
+func setup(t *testing.T, rc *receiver, cfg Config) (*repository.MemoryRepository, *repository.MemoryWebhookRepository, *Dispatcher, *testMetrics, domain.CreatedWebhook) {
+	server := httptest.NewServer(rc)
+	t.Cleanup(server.Close)
+	coupons := repository.NewMemory()
+	repo := repository.NewMemoryWebhooks(coupons)
+	created, err := NewWebhooks(repo).WithPrivateNetworks().CreateWebhook(domain.WithTenant(context.Background(), "acme"), server.URL, []string{domain.EventCouponCreated}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &testMetrics{results: make(map[string]int)}
+	return coupons, repo, NewDispatcher(repo, server.Client(), cfg, logrus.New()).WithMetrics(m), m, created
+}
+
+func newCoupon(t *testing.T, coupons domain.Repository, tenant string) uint {
+	name := "coupon"
+	id, err := coupons.NewCoupon(domain.WithTenant(context.Background(), tenant), domain.APICoupon{Name: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestDispatch(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	coupons, repo, d, m, created := setup(t, rc, Config{Timeout: time.Second, MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour})
+	acme := domain.WithTenant(context.Background(), "acme")
+
+	id := newCoupon(t, coupons, "acme")
+	// another tenant and an event the webhook is not subscribed to are not delivered
+	newCoupon(t, coupons, "globex")
+	assert.Nil(t, coupons.DeleteCoupon(acme, id))
+	assert.Nil(t, d.Dispatch(context.Background()))
+
+	if assert.Len(t, rc.requests, 1) {
+		r, body := rc.requests[0], rc.bodies[0]
+		assert.Equal(t, domain.EventCouponCreated, r.Header.Get(EventHeader))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var ts int64
+		var sig string
+		_, err := fmt.Sscanf(r.Header.Get(SignatureHeader), "t=%d,v1=%s", &ts, &sig)
+		assert.Nil(t, err)
+		assert.Equal(t, Sign(created.Secret, ts, body), sig)
+
+		var e domain.Event
+		assert.Nil(t, json.Unmarshal(body, &e))
+		assert.Equal(t, domain.EventCouponCreated, e.Type)
+		assert.Equal(t, id, e.Data.CouponID)
+	}
+	deliveries, err := NewWebhooks(repo).ListDeliveries(acme, created.ID, nil)
+	assert.Nil(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, domain.DeliveryDelivered, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusNoContent, deliveries[0].LastStatusCode)
+		assert.NotNil(t, deliveries[0].DeliveredAt)
+	}
+	assert.Equal(t, 1, m.results[ResultDelivered])
+
+	// a delivered event is not delivered again
+	assert.Nil(t, d.Dispatch(context.Background()))
+	assert.Len(t, rc.requests, 1)
+}
+
+func TestDispatchExpired(t *testing.T) {
+	rc := &receiver{status: http.StatusNoContent}
+	coupons, repo, d, _, created := setup(t, rc, Config{Timeout: time.Second, MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour})
+	acme := domain.WithTenant(context.Background(), "acme")
+	expired, err := NewWebhooks(repo).WithPrivateNetworks().CreateWebhook(acme, created.URL, []string{domain.EventCouponExpired}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := newCoupon(t, coupons, "acme")
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	assert.Nil(t, coupons.UpdateCoupon(acme, id, domain.APICoupon{Expiry: &past}))
+	assert.Nil(t, coupons.UpdateCoupon(acme, newCoupon(t, coupons, "acme"), domain.APICoupon{Expiry: &future}))
+	assert.Nil(t, d.Dispatch(context.Background()))
+
+	deliveries, err := NewWebhooks(repo).ListDeliveries(acme, expired.ID, nil)
+	assert.Nil(t, err)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, domain.DeliveryDelivered, deliveries[0].Status)
+		var e domain.Event
+		assert.Nil(t, json.Unmarshal(deliveries[0].Payload, &e))
+		assert.Equal(t, domain.EventCouponExpired, e.Type)
+		assert.Equal(t, id, e.Data.CouponID)
+	}
+
+	// an expiry is delivered once
+	assert.Nil(t, d.Dispatch(context.Background()))
+	deliveries, _ = NewWebhooks(repo).ListDeliveries(acme, expired.ID, nil)
+	assert.Len(t, deliveries, 1)
+}
+
+func TestDispatchRetries(t *testing.T) {
+	rc := &receiver{status: http.StatusServiceUnavailable}
+	// without backoff every round attempts the delivery again
+	coupons, repo, d, m, created := setup(t, rc, Config{Timeout: time.Second, MaxAttempts: 3})
+	acme := domain.WithTenant(context.Background(), "acme")
+	newCoupon(t, coupons, "acme")
+	webhooks := NewWebhooks(repo)
+
+	assert.Nil(t, d.Dispatch(context.Background()))
+	deliveries, _ := webhooks.ListDeliveries(acme, created.ID, nil)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, domain.DeliveryPending, deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].LastStatusCode)
+		assert.Equal(t, "Service Unavailable", deliveries[0].LastError)
+	}
+
+	assert.Nil(t, d.Dispatch(context.Background()))
+	assert.Nil(t, d.Dispatch(context.Background()))
+	deliveries, _ = webhooks.ListDeliveries(acme, created.ID, nil)
+	if assert.Len(t, deliveries, 1) {
+		assert.Equal(t, domain.DeliveryDead, deliveries[0].Status)
+		assert.Equal(t, 3, deliveries[0].Attempts)
+	}
+	assert.Equal(t, 2, m.results[ResultFailed])
+	assert.Equal(t, 1, m.results[ResultDead])
+
+	// a dead delivery is left alone until redelivered
+	assert.Nil(t, d.Dispatch(context.Background()))
+	assert.Len(t, rc.requests, 3)
+	rc.status = http.StatusOK
+	assert.Nil(t, webhooks.Redeliver(acme, created.ID, deliveries[0].ID))
+	assert.Nil(t, d.Dispatch(context.Background()))
+	deliveries, _ = webhooks.ListDeliveries(acme, created.ID, nil)
+	assert.Equal(t, domain.DeliveryDelivered, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+}
+
+func TestDispatchBackoff(t *testing.T) {
+	rc := &receiver{status: http.StatusInternalServerError}
+	coupons, repo, d, _, created := setup(t, rc, Config{Timeout: time.Second, MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour})
+	acme := domain.WithTenant(context.Background(), "acme")
+	newCoupon(t, coupons, "acme")
+
+	before := time.Now()
+	assert.Nil(t, d.Dispatch(context.Background()))
+	// the failed delivery is not due until its backoff ends
+	assert.Nil(t, d.Dispatch(context.Background()))
+	assert.Len(t, rc.requests, 1)
+	deliveries, _ := NewWebhooks(repo).ListDeliveries(acme, created.ID, nil)
+	if assert.Len(t, deliveries, 1) {
+		assert.True(t, deliveries[0].NextAttemptAt.After(before.Add(time.Minute)))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcher(nil, nil, Config{Backoff: time.Second * 10, MaxBackoff: time.Minute}, logrus.New())
+	for attempts, wait := range map[int]time.Duration{1: time.Second * 10, 2: time.Second * 20, 3: time.Second * 40, 4: time.Minute, 30: time.Minute} {
+		assert.Equal(t, wait, d.backoff(attempts), attempts)
+	}
+}
@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// dialTimeout and keepAlive are those of the http.DefaultTransport
+const (
+	dialTimeout = time.Second * 30
+	keepAlive   = time.Second * 30
+)
+
+// errNotPublic is returned for the webhook addresses which are not public, see isPublic
+var errNotPublic = errors.New("webhook address is not public")
+
+// reserved holds the blocks of the addresses which are neither private, loopback nor link-local but still reach no
+// public host, or an internal one through a translation
+var reserved = parseCIDRs(
+	"0.0.0.0/8",     // this network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved, broadcast included
+	"64:ff9b::/96",  // NAT64
+	"64:ff9b:1::/48",
+	"2002::/16", // 6to4
+)
+
+// isPublic reports whether ip is a public unicast address, the only addresses the webhooks are delivered to unless
+// private networks are allowed: the deliveries to the loopback, private or link-local addresses, the cloud metadata
+// endpoint among them, would reach the hosts of the deployment on behalf of the tenants
+func isPublic(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, block := range reserved {
+		if block.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClient returns the client posting the deliveries
+// Unless allowPrivate is set it only connects to public addresses, the address dialed is checked once resolved so a
+// host resolving to a public address when the webhook is created cannot later reach a private one. It never
+// follows the redirects, which are failed attempts, so a public webhook cannot redirect the deliveries either
+func NewClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: keepAlive}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+				return errNotPublic
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed in place of the webhooks, the deliveries are made directly
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// parseCIDRs parses the address blocks, which are valid
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	blocks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, block, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		blocks[i] = block
+	}
+	return blocks
+}
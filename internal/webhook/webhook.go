@@ -0,0 +1,245 @@
+// Package webhook manages the webhook subscriptions of the tenants and delivers them the coupon events
+//
+// The repositories write an event in the outbox of the coupons in the transaction of each change. The Dispatcher
+// writes the expired event of each coupon whose expiry passed in the same way, fans the events of the outbox out
+// into a delivery per subscribed webhook, then posts each delivery to its webhook
+// until it is answered with a 2xx status, waiting longer after each failed attempt. A delivery failing every attempt
+// is dead, it is only attempted again once redelivered.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+)
+
+const (
+	// secretBytes are the random bytes of the generated secrets
+	secretBytes = 32
+	// minSecret and maxSecret bound the length of the secrets given by the callers
+	minSecret = 16
+	maxSecret = 256
+	// maxURL bounds the length of the webhook URLs
+	maxURL = 2048
+
+	// defaultLimit and maxLimit bound the deliveries listed at once
+	defaultLimit = uint(100)
+	maxLimit     = uint(1000)
+
+	queryStatus = "status"
+	queryLimit  = "limit"
+	queryPage   = "page"
+)
+
+// Webhooks manages the webhooks of the tenants and lists their deliveries
+type Webhooks struct {
+	repo domain.WebhookRepository
+	// allowPrivate accepts the webhooks on private networks, see isPublic
+	allowPrivate bool
+	// lookup resolves the hosts of the webhooks
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NewWebhooks is the Webhooks constructor, only the webhooks on public addresses are accepted
+func NewWebhooks(repo domain.WebhookRepository) *Webhooks {
+	return &Webhooks{repo: repo, lookup: net.DefaultResolver.LookupIPAddr}
+}
+
+// WithPrivateNetworks returns a copy of wh accepting the webhooks on loopback, private and link-local addresses,
+// for local development. The Dispatcher delivers to them with a client allowing them, see NewClient
+func (wh *Webhooks) WithPrivateNetworks() *Webhooks {
+	c := *wh
+	c.allowPrivate = true
+	return &c
+}
+
+// CreateWebhook subscribes rawURL to the events of the tenant of ctx with types events, their payloads are signed
+// with secret. A random secret is generated if secret is empty. The secret is only returned here
+//
+// It returns a InvalidArgsError if the URL is not an absolute http or https URL, if its host does not resolve to public
+// addresses only, if the events are empty or unknown, or if the secret is too short or too long
+func (wh *Webhooks) CreateWebhook(ctx context.Context, rawURL string, events []string, secret string) (domain.CreatedWebhook, error) {
+	if err := wh.checkURL(ctx, rawURL); err != nil {
+		return domain.CreatedWebhook{}, err
+	}
+	events, err := checkEvents(events)
+	if err != nil {
+		return domain.CreatedWebhook{}, err
+	}
+	if secret == "" {
+		if secret, err = newSecret(); err != nil {
+			return domain.CreatedWebhook{}, err
+		}
+	} else if len(secret) < minSecret || len(secret) > maxSecret {
+		return domain.CreatedWebhook{}, domain.NewInvalidArgsError(fmt.Sprintf("secret must have %d to %d characters", minSecret, maxSecret))
+	}
+
+	w := domain.Webhook{URL: rawURL, Events: events, Secret: secret}
+	if err := wh.repo.NewWebhook(ctx, &w); err != nil {
+		return domain.CreatedWebhook{}, err
+	}
+	return domain.CreatedWebhook{Webhook: w, Secret: secret}, nil
+}
+
+// ListWebhooks returns every webhook, without their secret
+func (wh *Webhooks) ListWebhooks(ctx context.Context) ([]domain.Webhook, error) {
+	var webhooks []domain.Webhook
+	if err := wh.repo.ListWebhooks(ctx, &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetWebhook returns the webhook with id, without its secret
+//
+// It returns a WebhookNotFoundError if there is no webhook with id
+func (wh *Webhooks) GetWebhook(ctx context.Context, id uint) (domain.Webhook, error) {
+	var w domain.Webhook
+	if err := wh.repo.GetWebhookByID(ctx, id, &w); err != nil {
+		return domain.Webhook{}, err
+	}
+	return w, nil
+}
+
+// DeleteWebhook deletes the webhook with id and its deliveries, no event is delivered to it from now on
+//
+// It returns a WebhookNotFoundError if there is no webhook with id
+func (wh *Webhooks) DeleteWebhook(ctx context.Context, id uint) error {
+	return wh.repo.DeleteWebhook(ctx, id)
+}
+
+// ListDeliveries validates query arguments and returns the matching deliveries of the webhook with id, the latest
+// first. Accepted keys are:
+//
+//	queryStatus = "status"
+//	queryLimit  = "limit"
+//	queryPage   = "page"
+//
+// status is one of the domain.Delivery... states, limit defaults to 100 and page to 1.
+//
+// It returns a InvalidArgsError if it fails the validation or if args holds an unknown key, and a
+// WebhookNotFoundError if there is no webhook with id
+func (wh *Webhooks) ListDeliveries(ctx context.Context, id uint, args map[string][]string) ([]domain.Delivery, error) {
+	q := domain.DeliveryQuery{Limit: defaultLimit}
+	page := uint(1)
+	for k, v := range args {
+		switch k {
+		case queryStatus:
+			if !domain.IsDeliveryStatus(v[0]) {
+				return nil, domain.NewInvalidArgsError("invalid status value:" + v[0])
+			}
+			q.Status = v[0]
+		case queryLimit:
+			l64, err := strconv.ParseUint(v[0], 10, 32)
+			if err != nil || l64 == 0 || l64 > uint64(maxLimit) {
+				return nil, domain.NewInvalidArgsError("invalid limit value:" + v[0])
+			}
+			q.Limit = uint(l64)
+		case queryPage:
+			p64, err := strconv.ParseUint(v[0], 10, 32)
+			if err != nil || p64 == 0 {
+				return nil, domain.NewInvalidArgsError("invalid page value:" + v[0])
+			}
+			page = uint(p64)
+		default:
+			return nil, domain.NewInvalidArgsError("unknown query parameter:" + k)
+		}
+	}
+	q.Offset = (page - 1) * q.Limit
+
+	var deliveries []domain.Delivery
+	if err := wh.repo.QueryDeliveries(ctx, id, &deliveries, q); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Redeliver sets the delivery with id of the webhook with webhookID pending, it is attempted again as a new delivery
+//
+// It returns a WebhookNotFoundError if there is no webhook with webhookID, and a DeliveryNotFoundError if it has
+// no delivery with id
+func (wh *Webhooks) Redeliver(ctx context.Context, webhookID, id uint) error {
+	return wh.repo.Redeliver(ctx, webhookID, id)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 with secret of the payload posted at the unix time timestamp, the MAC of
+// "{timestamp}.{payload}". The webhooks check it to authenticate the payloads, and the timestamp to reject the
+// replayed ones
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkURL returns a InvalidArgsError if rawURL is not an absolute http or https URL, or unless private networks are
+// allowed if its host is not public. The addresses of a host name are checked again when they are dialed, see NewClient
+func (wh *Webhooks) checkURL(ctx context.Context, rawURL string) error {
+	if len(rawURL) > maxURL {
+		return domain.NewInvalidArgsError(fmt.Sprintf("url must have at most %d characters", maxURL))
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		return domain.NewInvalidArgsError("url must be an absolute http or https URL: " + rawURL)
+	}
+	if wh.allowPrivate {
+		return nil
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublic(ip) {
+			return domain.NewInvalidArgsError("url must reach a public address: " + host)
+		}
+		return nil
+	}
+	addrs, err := wh.lookup(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return domain.NewInvalidArgsError("failed to resolve the url host: " + host)
+	}
+	for _, addr := range addrs {
+		if !isPublic(addr.IP) {
+			return domain.NewInvalidArgsError("url must reach a public address: " + host)
+		}
+	}
+	return nil
+}
+
+// checkEvents returns the event types without duplicates
+// It returns a InvalidArgsError if there is none or if one is unknown
+func checkEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return nil, domain.NewInvalidArgsError("a webhook needs at least one event, one of: " + strings.Join(domain.EventTypes, ", "))
+	}
+	var checked []string
+	seen := make(map[string]bool)
+	for _, e := range events {
+		if !domain.IsEventType(e) {
+			return nil, domain.NewInvalidArgsError("unknown event: " + e)
+		}
+		if !seen[e] {
+			seen[e] = true
+			checked = append(checked, e)
+		}
+	}
+	return checked, nil
+}
+
+// newSecret generates a hex encoded random secret
+func newSecret() (string, error) {
+	random := make([]byte, secretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgfreitas/pb_api/internal/domain"
+	"github.com/jcgfreitas/pb_api/internal/repository"
+)
+
+// hosts are the addresses the lookup of the tests resolves
+var hosts = map[string][]string{
+	"example.com":  {"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"},
+	"internal.com": {"93.184.215.14", "10.0.0.1"},
+	"metadata.com": {"169.254.169.254"},
+}
+
+// newWebhooks returns Webhooks resolving the hosts without the DNS
+func newWebhooks(repo domain.WebhookRepository) *Webhooks {
+	wh := NewWebhooks(repo)
+	wh.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		addrs, ok := hosts[host]
+		if !ok {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		var ips []net.IPAddr
+		for _, a := range addrs {
+			ips = append(ips, net.IPAddr{IP: net.ParseIP(a)})
+		}
+		return ips, nil
+	}
+	return wh
+}
+
+func TestCreateWebhook(t *testing.T) {
+	webhooks := newWebhooks(repository.NewMemoryWebhooks(repository.NewMemory()))
+	ctx := domain.WithTenant(context.Background(), "acme")
+
+	created, err := webhooks.CreateWebhook(ctx, "https://example.com/hook", []string{domain.EventCouponCreated, domain.EventCouponCreated}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotZero(t, created.ID)
+	assert.Equal(t, "acme", created.TenantID)
+	assert.Equal(t, []string{domain.EventCouponCreated}, created.Events)
+	assert.Len(t, created.Secret, 2*secretBytes)
+
+	created, err = webhooks.CreateWebhook(ctx, "http://93.184.215.14:8080/hook", domain.EventTypes, "0123456789abcdef")
+	assert.Nil(t, err)
+	assert.Equal(t, "0123456789abcdef", created.Secret)
+
+	for name, args := range map[string]struct {
+		url    string
+		events []string
+		secret string
+	}{
+		"relativeURL":   {"/hook", domain.EventTypes, ""},
+		"otherScheme":   {"ftp://example.com/hook", domain.EventTypes, ""},
+		"noHost":        {"https:///hook", domain.EventTypes, ""},
+		"longURL":       {"https://example.com/" + strings.Repeat("a", maxURL), domain.EventTypes, ""},
+		"noEvent":       {"https://example.com/hook", nil, ""},
+		"unknownEvent":  {"https://example.com/hook", []string{domain.EventCouponRedeemed}, ""},
+		"shortSecret":   {"https://example.com/hook", domain.EventTypes, "s3cret"},
+		"userinfo":      {"https://user@example.com/hook", domain.EventTypes, ""},
+		"unknownHost":   {"https://unknown.com/hook", domain.EventTypes, ""},
+		"localhost":     {"http://localhost:8080/hook", domain.EventTypes, ""},
+		"loopback":      {"http://127.0.0.1:8080/hook", domain.EventTypes, ""},
+		"private":       {"http://10.0.0.1/hook", domain.EventTypes, ""},
+		"metadata":      {"http://169.254.169.254/latest/meta-data", domain.EventTypes, ""},
+		"ipv6Loopback":  {"http://[::1]/hook", domain.EventTypes, ""},
+		"mappedIPv4":    {"http://[::ffff:10.0.0.1]/hook", domain.EventTypes, ""},
+		"resolvesTo":    {"https://metadata.com/hook", domain.EventTypes, ""},
+		"resolvesToAny": {"https://internal.com/hook", domain.EventTypes, ""},
+	} {
+		_, err := webhooks.CreateWebhook(ctx, args.url, args.events, args.secret)
+		assert.IsType(t, domain.InvalidArgsError{}, err, name)
+	}
+
+	list, err := webhooks.ListWebhooks(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+
+	// for local development
+	_, err = webhooks.WithPrivateNetworks().CreateWebhook(ctx, "http://localhost:8080/hook", domain.EventTypes, "")
+	assert.Nil(t, err)
+}
+
+func TestListDeliveries(t *testing.T) {
+	coupons := repository.NewMemory()
+	repo := repository.NewMemoryWebhooks(coupons)
+	webhooks := newWebhooks(repo)
+	ctx := domain.WithTenant(context.Background(), "acme")
+
+	created, err := webhooks.CreateWebhook(ctx, "https://example.com/hook", domain.EventTypes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		name := "coupon"
+		if _, err := coupons.NewCoupon(ctx, domain.APICoupon{Name: &name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.FanOut(context.Background(), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := webhooks.ListDeliveries(ctx, created.ID, nil)
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 3)
+	deliveries, err = webhooks.ListDeliveries(ctx, created.ID, map[string][]string{"status": {domain.DeliveryPending}, "limit": {"2"}, "page": {"2"}})
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+
+	for name, args := range map[string]map[string][]string{
+		"status":   {"status": {"failed"}},
+		"limit":    {"limit": {"0"}},
+		"maxLimit": {"limit": {"1001"}},
+		"page":     {"page": {"a"}},
+		"unknown":  {"event": {domain.EventCouponCreated}},
+	} {
+		_, err := webhooks.ListDeliveries(ctx, created.ID, args)
+		assert.IsType(t, domain.InvalidArgsError{}, err, name)
+	}
+	_, err = webhooks.ListDeliveries(domain.WithTenant(context.Background(), "globex"), created.ID, nil)
+	assert.Equal(t, domain.NewWebhookNotFoundError(), err)
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{"id":1}' | openssl dgst -sha256 -hmac s3cret
+	assert.Equal(t, "ee0658aa4e37018df69c24227df01e0f680eb3b87c7f1f9bd936e283cfe01d9b", Sign("s3cret", 1700000000, []byte(`{"id":1}`)))
+}
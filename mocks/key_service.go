@@ -0,0 +1,94 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/jcgfreitas/pb_api/internal/handlers (interfaces: KeyService)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+	domain "github.com/jcgfreitas/pb_api/internal/domain"
+	reflect "reflect"
+)
+
+// MockKeyService is a mock of KeyService interface
+type MockKeyService struct {
+	ctrl     *gomock.Controller
+	recorder *MockKeyServiceMockRecorder
+}
+
+// MockKeyServiceMockRecorder is the mock recorder for MockKeyService
+type MockKeyServiceMockRecorder struct {
+	mock *MockKeyService
+}
+
+// NewMockKeyService creates a new mock instance
+func NewMockKeyService(ctrl *gomock.Controller) *MockKeyService {
+	mock := &MockKeyService{ctrl: ctrl}
+	mock.recorder = &MockKeyServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockKeyService) EXPECT() *MockKeyServiceMockRecorder {
+	return m.recorder
+}
+
+// CreateKey mocks base method
+func (m *MockKeyService) CreateKey(arg0 context.Context, arg1, arg2 string, arg3 []string) (domain.CreatedAPIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateKey", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(domain.CreatedAPIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateKey indicates an expected call of CreateKey
+func (mr *MockKeyServiceMockRecorder) CreateKey(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateKey", reflect.TypeOf((*MockKeyService)(nil).CreateKey), arg0, arg1, arg2, arg3)
+}
+
+// ListKeys mocks base method
+func (m *MockKeyService) ListKeys(arg0 context.Context) ([]domain.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListKeys", arg0)
+	ret0, _ := ret[0].([]domain.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListKeys indicates an expected call of ListKeys
+func (mr *MockKeyServiceMockRecorder) ListKeys(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListKeys", reflect.TypeOf((*MockKeyService)(nil).ListKeys), arg0)
+}
+
+// RevokeKey mocks base method
+func (m *MockKeyService) RevokeKey(arg0 context.Context, arg1 uint) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeKey indicates an expected call of RevokeKey
+func (mr *MockKeyServiceMockRecorder) RevokeKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeKey", reflect.TypeOf((*MockKeyService)(nil).RevokeKey), arg0, arg1)
+}
+
+// RotateKey mocks base method
+func (m *MockKeyService) RotateKey(arg0 context.Context, arg1 uint) (domain.CreatedAPIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateKey", arg0, arg1)
+	ret0, _ := ret[0].(domain.CreatedAPIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateKey indicates an expected call of RotateKey
+func (mr *MockKeyServiceMockRecorder) RotateKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateKey", reflect.TypeOf((*MockKeyService)(nil).RotateKey), arg0, arg1)
+}
@@ -1,14 +1,14 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/jcgfreitas/pb_api/internal/service (interfaces: Repository)
+// Source: github.com/jcgfreitas/pb_api/internal/domain (interfaces: Repository)
 
 // Package mocks is a generated GoMock package.
 package mocks
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	domain "github.com/jcgfreitas/pb_api/internal/domain"
 	reflect "reflect"
-	time "time"
 )
 
 // MockRepository is a mock of Repository interface
@@ -34,175 +34,157 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
-// DeleteCoupon mocks base method
-func (m *MockRepository) DeleteCoupon(arg0 uint) error {
+// CouponHistory mocks base method
+func (m *MockRepository) CouponHistory(arg0 context.Context, arg1 uint, arg2 *[]domain.AuditEntry) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteCoupon", arg0)
+	ret := m.ctrl.Call(m, "CouponHistory", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DeleteCoupon indicates an expected call of DeleteCoupon
-func (mr *MockRepositoryMockRecorder) DeleteCoupon(arg0 interface{}) *gomock.Call {
+// CouponHistory indicates an expected call of CouponHistory
+func (mr *MockRepositoryMockRecorder) CouponHistory(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCoupon", reflect.TypeOf((*MockRepository)(nil).DeleteCoupon), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CouponHistory", reflect.TypeOf((*MockRepository)(nil).CouponHistory), arg0, arg1, arg2)
 }
 
-// GetCouponByID mocks base method
-func (m *MockRepository) GetCouponByID(arg0 uint, arg1 *domain.Coupon) error {
+// CouponStats mocks base method
+func (m *MockRepository) CouponStats(arg0 context.Context, arg1 *[]domain.CouponStats, arg2 []string, arg3 domain.CouponQuery) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCouponByID", arg0, arg1)
+	ret := m.ctrl.Call(m, "CouponStats", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// GetCouponByID indicates an expected call of GetCouponByID
-func (mr *MockRepositoryMockRecorder) GetCouponByID(arg0, arg1 interface{}) *gomock.Call {
+// CouponStats indicates an expected call of CouponStats
+func (mr *MockRepositoryMockRecorder) CouponStats(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCouponByID", reflect.TypeOf((*MockRepository)(nil).GetCouponByID), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CouponStats", reflect.TypeOf((*MockRepository)(nil).CouponStats), arg0, arg1, arg2, arg3)
 }
 
-// NewCoupon mocks base method
-func (m *MockRepository) NewCoupon(arg0 domain.APICoupon) error {
+// DeleteCoupon mocks base method
+func (m *MockRepository) DeleteCoupon(arg0 context.Context, arg1 uint) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NewCoupon", arg0)
+	ret := m.ctrl.Call(m, "DeleteCoupon", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// NewCoupon indicates an expected call of NewCoupon
-func (mr *MockRepositoryMockRecorder) NewCoupon(arg0 interface{}) *gomock.Call {
-	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewCoupon", reflect.TypeOf((*MockRepository)(nil).NewCoupon), arg0)
-}
-
-// QueryBatchingFunction mocks base method
-func (m *MockRepository) QueryBatchingFunction(arg0, arg1 uint) func() error {
-	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "QueryBatchingFunction", arg0, arg1)
-	ret0, _ := ret[0].(func() error)
-	return ret0
-}
-
-// QueryBatchingFunction indicates an expected call of QueryBatchingFunction
-func (mr *MockRepositoryMockRecorder) QueryBatchingFunction(arg0, arg1 interface{}) *gomock.Call {
+// DeleteCoupon indicates an expected call of DeleteCoupon
+func (mr *MockRepositoryMockRecorder) DeleteCoupon(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryBatchingFunction", reflect.TypeOf((*MockRepository)(nil).QueryBatchingFunction), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCoupon", reflect.TypeOf((*MockRepository)(nil).DeleteCoupon), arg0, arg1)
 }
 
-// QueryCoupons mocks base method
-func (m *MockRepository) QueryCoupons(arg0 *[]domain.Coupon, arg1 map[string]interface{}, arg2 ...func() error) error {
+// ExportCoupons mocks base method
+func (m *MockRepository) ExportCoupons(arg0 context.Context, arg1 func(domain.Coupon) error, arg2 domain.CouponQuery) error {
 	m.ctrl.T.Helper()
-	varargs := []interface{}{arg0, arg1}
-	for _, a := range arg2 {
-		varargs = append(varargs, a)
-	}
-	ret := m.ctrl.Call(m, "QueryCoupons", varargs...)
+	ret := m.ctrl.Call(m, "ExportCoupons", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// QueryCoupons indicates an expected call of QueryCoupons
-func (mr *MockRepositoryMockRecorder) QueryCoupons(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+// ExportCoupons indicates an expected call of ExportCoupons
+func (mr *MockRepositoryMockRecorder) ExportCoupons(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	varargs := append([]interface{}{arg0, arg1}, arg2...)
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryCoupons", reflect.TypeOf((*MockRepository)(nil).QueryCoupons), varargs...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportCoupons", reflect.TypeOf((*MockRepository)(nil).ExportCoupons), arg0, arg1, arg2)
 }
 
-// QueryGTCreatedFunction mocks base method
-func (m *MockRepository) QueryGTCreatedFunction(arg0 time.Time) func() error {
+// GetCouponByID mocks base method
+func (m *MockRepository) GetCouponByID(arg0 context.Context, arg1 uint, arg2 []string, arg3 *domain.Coupon) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "QueryGTCreatedFunction", arg0)
-	ret0, _ := ret[0].(func() error)
+	ret := m.ctrl.Call(m, "GetCouponByID", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// QueryGTCreatedFunction indicates an expected call of QueryGTCreatedFunction
-func (mr *MockRepositoryMockRecorder) QueryGTCreatedFunction(arg0 interface{}) *gomock.Call {
+// GetCouponByID indicates an expected call of GetCouponByID
+func (mr *MockRepositoryMockRecorder) GetCouponByID(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryGTCreatedFunction", reflect.TypeOf((*MockRepository)(nil).QueryGTCreatedFunction), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCouponByID", reflect.TypeOf((*MockRepository)(nil).GetCouponByID), arg0, arg1, arg2, arg3)
 }
 
-// QueryGTExpiryFunction mocks base method
-func (m *MockRepository) QueryGTExpiryFunction(arg0 time.Time) func() error {
+// NewCoupon mocks base method
+func (m *MockRepository) NewCoupon(arg0 context.Context, arg1 domain.APICoupon) (uint, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "QueryGTExpiryFunction", arg0)
-	ret0, _ := ret[0].(func() error)
-	return ret0
+	ret := m.ctrl.Call(m, "NewCoupon", arg0, arg1)
+	ret0, _ := ret[0].(uint)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// QueryGTExpiryFunction indicates an expected call of QueryGTExpiryFunction
-func (mr *MockRepositoryMockRecorder) QueryGTExpiryFunction(arg0 interface{}) *gomock.Call {
+// NewCoupon indicates an expected call of NewCoupon
+func (mr *MockRepositoryMockRecorder) NewCoupon(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryGTExpiryFunction", reflect.TypeOf((*MockRepository)(nil).QueryGTExpiryFunction), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewCoupon", reflect.TypeOf((*MockRepository)(nil).NewCoupon), arg0, arg1)
 }
 
-// QueryGTValueFunction mocks base method
-func (m *MockRepository) QueryGTValueFunction(arg0 uint) func() error {
+// Ping mocks base method
+func (m *MockRepository) Ping(arg0 context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "QueryGTValueFunction", arg0)
-	ret0, _ := ret[0].(func() error)
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// QueryGTValueFunction indicates an expected call of QueryGTValueFunction
-func (mr *MockRepositoryMockRecorder) QueryGTValueFunction(arg0 interface{}) *gomock.Call {
+// Ping indicates an expected call of Ping
+func (mr *MockRepositoryMockRecorder) Ping(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryGTValueFunction", reflect.TypeOf((*MockRepository)(nil).QueryGTValueFunction), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockRepository)(nil).Ping), arg0)
 }
 
-// QueryLTCreatedFunction mocks base method
-func (m *MockRepository) QueryLTCreatedFunction(arg0 time.Time) func() error {
+// QueryAudit mocks base method
+func (m *MockRepository) QueryAudit(arg0 context.Context, arg1 *[]domain.AuditEntry, arg2 domain.AuditQuery) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "QueryLTCreatedFunction", arg0)
-	ret0, _ := ret[0].(func() error)
+	ret := m.ctrl.Call(m, "QueryAudit", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// QueryLTCreatedFunction indicates an expected call of QueryLTCreatedFunction
-func (mr *MockRepositoryMockRecorder) QueryLTCreatedFunction(arg0 interface{}) *gomock.Call {
+// QueryAudit indicates an expected call of QueryAudit
+func (mr *MockRepositoryMockRecorder) QueryAudit(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryLTCreatedFunction", reflect.TypeOf((*MockRepository)(nil).QueryLTCreatedFunction), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryAudit", reflect.TypeOf((*MockRepository)(nil).QueryAudit), arg0, arg1, arg2)
 }
 
-// QueryLTExpiryFunction mocks base method
-func (m *MockRepository) QueryLTExpiryFunction(arg0 time.Time) func() error {
+// QueryCoupons mocks base method
+func (m *MockRepository) QueryCoupons(arg0 context.Context, arg1 *[]domain.Coupon, arg2 domain.CouponQuery) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "QueryLTExpiryFunction", arg0)
-	ret0, _ := ret[0].(func() error)
+	ret := m.ctrl.Call(m, "QueryCoupons", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// QueryLTExpiryFunction indicates an expected call of QueryLTExpiryFunction
-func (mr *MockRepositoryMockRecorder) QueryLTExpiryFunction(arg0 interface{}) *gomock.Call {
+// QueryCoupons indicates an expected call of QueryCoupons
+func (mr *MockRepositoryMockRecorder) QueryCoupons(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryLTExpiryFunction", reflect.TypeOf((*MockRepository)(nil).QueryLTExpiryFunction), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryCoupons", reflect.TypeOf((*MockRepository)(nil).QueryCoupons), arg0, arg1, arg2)
 }
 
-// QueryLTValueFunction mocks base method
-func (m *MockRepository) QueryLTValueFunction(arg0 uint) func() error {
+// UpdateCoupon mocks base method
+func (m *MockRepository) UpdateCoupon(arg0 context.Context, arg1 uint, arg2 domain.APICoupon) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "QueryLTValueFunction", arg0)
-	ret0, _ := ret[0].(func() error)
+	ret := m.ctrl.Call(m, "UpdateCoupon", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// QueryLTValueFunction indicates an expected call of QueryLTValueFunction
-func (mr *MockRepositoryMockRecorder) QueryLTValueFunction(arg0 interface{}) *gomock.Call {
+// UpdateCoupon indicates an expected call of UpdateCoupon
+func (mr *MockRepositoryMockRecorder) UpdateCoupon(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryLTValueFunction", reflect.TypeOf((*MockRepository)(nil).QueryLTValueFunction), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCoupon", reflect.TypeOf((*MockRepository)(nil).UpdateCoupon), arg0, arg1, arg2)
 }
 
-// UpdateCoupon mocks base method
-func (m *MockRepository) UpdateCoupon(arg0 uint, arg1 domain.APICoupon) error {
+// WithTx mocks base method
+func (m *MockRepository) WithTx(arg0 context.Context, arg1 func(domain.Repository) error) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateCoupon", arg0, arg1)
+	ret := m.ctrl.Call(m, "WithTx", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateCoupon indicates an expected call of UpdateCoupon
-func (mr *MockRepositoryMockRecorder) UpdateCoupon(arg0, arg1 interface{}) *gomock.Call {
+// WithTx indicates an expected call of WithTx
+func (mr *MockRepositoryMockRecorder) WithTx(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCoupon", reflect.TypeOf((*MockRepository)(nil).UpdateCoupon), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithTx", reflect.TypeOf((*MockRepository)(nil).WithTx), arg0, arg1)
 }
@@ -5,6 +5,7 @@
 package mocks
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	domain "github.com/jcgfreitas/pb_api/internal/domain"
 	reflect "reflect"
@@ -33,72 +34,187 @@ func (m *MockService) EXPECT() *MockServiceMockRecorder {
 	return m.recorder
 }
 
+// BatchCoupons mocks base method
+func (m *MockService) BatchCoupons(arg0 context.Context, arg1 domain.BatchRequest) ([]domain.BatchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BatchCoupons", arg0, arg1)
+	ret0, _ := ret[0].([]domain.BatchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// BatchCoupons indicates an expected call of BatchCoupons
+func (mr *MockServiceMockRecorder) BatchCoupons(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BatchCoupons", reflect.TypeOf((*MockService)(nil).BatchCoupons), arg0, arg1)
+}
+
+// CouponHistory mocks base method
+func (m *MockService) CouponHistory(arg0 context.Context, arg1 uint, arg2 *[]domain.AuditEntry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CouponHistory", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CouponHistory indicates an expected call of CouponHistory
+func (mr *MockServiceMockRecorder) CouponHistory(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CouponHistory", reflect.TypeOf((*MockService)(nil).CouponHistory), arg0, arg1, arg2)
+}
+
 // CreateCoupon mocks base method
-func (m *MockService) CreateCoupon(arg0 domain.APICoupon) error {
+func (m *MockService) CreateCoupon(arg0 context.Context, arg1 domain.APICoupon) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateCoupon", arg0)
+	ret := m.ctrl.Call(m, "CreateCoupon", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CreateCoupon indicates an expected call of CreateCoupon
-func (mr *MockServiceMockRecorder) CreateCoupon(arg0 interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) CreateCoupon(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCoupon", reflect.TypeOf((*MockService)(nil).CreateCoupon), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCoupon", reflect.TypeOf((*MockService)(nil).CreateCoupon), arg0, arg1)
 }
 
 // DeleteCoupon mocks base method
-func (m *MockService) DeleteCoupon(arg0 uint) error {
+func (m *MockService) DeleteCoupon(arg0 context.Context, arg1 uint) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteCoupon", arg0)
+	ret := m.ctrl.Call(m, "DeleteCoupon", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteCoupon indicates an expected call of DeleteCoupon
-func (mr *MockServiceMockRecorder) DeleteCoupon(arg0 interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) DeleteCoupon(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCoupon", reflect.TypeOf((*MockService)(nil).DeleteCoupon), arg0, arg1)
+}
+
+// ExportCoupons mocks base method
+func (m *MockService) ExportCoupons(arg0 context.Context, arg1 map[string][]string, arg2 func(domain.Coupon) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportCoupons", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportCoupons indicates an expected call of ExportCoupons
+func (mr *MockServiceMockRecorder) ExportCoupons(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportCoupons", reflect.TypeOf((*MockService)(nil).ExportCoupons), arg0, arg1, arg2)
+}
+
+// GetAudit mocks base method
+func (m *MockService) GetAudit(arg0 context.Context, arg1 *[]domain.AuditEntry, arg2 map[string][]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAudit", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetAudit indicates an expected call of GetAudit
+func (mr *MockServiceMockRecorder) GetAudit(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCoupon", reflect.TypeOf((*MockService)(nil).DeleteCoupon), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAudit", reflect.TypeOf((*MockService)(nil).GetAudit), arg0, arg1, arg2)
 }
 
 // GetCoupon mocks base method
-func (m *MockService) GetCoupon(arg0 uint, arg1 *domain.Coupon) error {
+func (m *MockService) GetCoupon(arg0 context.Context, arg1 uint, arg2 []string, arg3 *domain.Coupon) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCoupon", arg0, arg1)
+	ret := m.ctrl.Call(m, "GetCoupon", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // GetCoupon indicates an expected call of GetCoupon
-func (mr *MockServiceMockRecorder) GetCoupon(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) GetCoupon(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoupon", reflect.TypeOf((*MockService)(nil).GetCoupon), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoupon", reflect.TypeOf((*MockService)(nil).GetCoupon), arg0, arg1, arg2, arg3)
 }
 
 // GetCoupons mocks base method
-func (m *MockService) GetCoupons(arg0 *[]domain.Coupon, arg1 map[string][]string) error {
+func (m *MockService) GetCoupons(arg0 context.Context, arg1 *[]domain.Coupon, arg2 map[string][]string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCoupons", arg0, arg1)
+	ret := m.ctrl.Call(m, "GetCoupons", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // GetCoupons indicates an expected call of GetCoupons
-func (mr *MockServiceMockRecorder) GetCoupons(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) GetCoupons(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoupons", reflect.TypeOf((*MockService)(nil).GetCoupons), arg0, arg1, arg2)
+}
+
+// GetCouponsStats mocks base method
+func (m *MockService) GetCouponsStats(arg0 context.Context, arg1 *[]domain.CouponStats, arg2 map[string][]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCouponsStats", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetCouponsStats indicates an expected call of GetCouponsStats
+func (mr *MockServiceMockRecorder) GetCouponsStats(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCouponsStats", reflect.TypeOf((*MockService)(nil).GetCouponsStats), arg0, arg1, arg2)
+}
+
+// ImportCoupons mocks base method
+func (m *MockService) ImportCoupons(arg0 context.Context, arg1 []domain.ImportRow, arg2 bool) (domain.ImportReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportCoupons", arg0, arg1, arg2)
+	ret0, _ := ret[0].(domain.ImportReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImportCoupons indicates an expected call of ImportCoupons
+func (mr *MockServiceMockRecorder) ImportCoupons(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportCoupons", reflect.TypeOf((*MockService)(nil).ImportCoupons), arg0, arg1, arg2)
+}
+
+// ParseFields mocks base method
+func (m *MockService) ParseFields(arg0 map[string][]string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ParseFields", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ParseFields indicates an expected call of ParseFields
+func (mr *MockServiceMockRecorder) ParseFields(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseFields", reflect.TypeOf((*MockService)(nil).ParseFields), arg0)
+}
+
+// Ping mocks base method
+func (m *MockService) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping
+func (mr *MockServiceMockRecorder) Ping(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCoupons", reflect.TypeOf((*MockService)(nil).GetCoupons), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockService)(nil).Ping), arg0)
 }
 
 // UpdateCoupon mocks base method
-func (m *MockService) UpdateCoupon(arg0 uint, arg1 domain.APICoupon) error {
+func (m *MockService) UpdateCoupon(arg0 context.Context, arg1 uint, arg2 domain.APICoupon) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateCoupon", arg0, arg1)
+	ret := m.ctrl.Call(m, "UpdateCoupon", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateCoupon indicates an expected call of UpdateCoupon
-func (mr *MockServiceMockRecorder) UpdateCoupon(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) UpdateCoupon(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCoupon", reflect.TypeOf((*MockService)(nil).UpdateCoupon), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCoupon", reflect.TypeOf((*MockService)(nil).UpdateCoupon), arg0, arg1, arg2)
 }
@@ -0,0 +1,28 @@
+// Package ctxlog carries a request-scoped logrus entry in a context.Context
+//
+// The entry holds the fields identifying the request, like its id, so every layer handling the request logs them
+// without passing them along.
+package ctxlog
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// entryKey is the context key of the entry
+type entryKey struct{}
+
+// WithEntry returns a copy of ctx carrying entry
+func WithEntry(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// Entry returns the entry carried by ctx, or an entry of logger without fields if ctx carries none
+// The entry is bound to ctx, so the hooks of its logger may read the context of the request
+func Entry(ctx context.Context, logger *logrus.Logger) *logrus.Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*logrus.Entry); ok {
+		return entry.WithContext(ctx)
+	}
+	return logger.WithContext(ctx)
+}
@@ -0,0 +1,24 @@
+package ctxlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntry(t *testing.T) {
+	logger := logrus.New()
+	other := logrus.New()
+
+	entry := Entry(context.Background(), logger)
+	assert.Equal(t, logger, entry.Logger)
+	assert.Empty(t, entry.Data)
+
+	ctx := WithEntry(context.Background(), other.WithField("request_id", "abc"))
+	entry = Entry(ctx, logger)
+	assert.Equal(t, other, entry.Logger)
+	assert.Equal(t, logrus.Fields{"request_id": "abc"}, entry.Data)
+	assert.Equal(t, ctx, entry.Context)
+}
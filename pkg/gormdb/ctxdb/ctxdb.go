@@ -0,0 +1,66 @@
+// Package ctxdb binds a context.Context to the statements of a gorm database
+//
+// gorm v1 has no context support: statements run through the database/sql methods without a context,
+// so a canceled request never stops its queries. WithContext works around it by handing gorm a connection
+// pool wrapper which calls the *Context variant of every database/sql method.
+package ctxdb
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jinzhu/gorm"
+)
+
+// conn is a gorm.SQLCommon running every statement with ctx
+type conn struct {
+	ctx context.Context
+	db  *sql.DB
+}
+
+// WithContext returns a gorm database running its statements and transactions with ctx
+// Only databases holding a *sql.DB can be bound: a transaction is bound to the context it was started with
+// and is returned unchanged. The returned database doesn't share the LogMode or settings of db
+func WithContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	sqlDB, ok := db.CommonDB().(*sql.DB)
+	if !ok {
+		return db
+	}
+	bound, err := gorm.Open(db.Dialect().GetName(), &conn{ctx: ctx, db: sqlDB})
+	if err != nil {
+		// Open only fails for invalid sources, which a *sql.DB wrapper is not
+		return db
+	}
+	return bound
+}
+
+// Exec implements gorm.SQLCommon
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, query, args...)
+}
+
+// Prepare implements gorm.SQLCommon
+func (c *conn) Prepare(query string) (*sql.Stmt, error) {
+	return c.db.PrepareContext(c.ctx, query)
+}
+
+// Query implements gorm.SQLCommon
+func (c *conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, query, args...)
+}
+
+// QueryRow implements gorm.SQLCommon
+func (c *conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+// Begin starts a transaction bound to the context
+func (c *conn) Begin() (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, nil)
+}
+
+// BeginTx starts a transaction bound to the context, ctx is ignored
+// gorm's Begin calls BeginTx with a background context, which would otherwise unbind the transaction
+func (c *conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return c.db.BeginTx(c.ctx, opts)
+}
@@ -0,0 +1,86 @@
+package ctxdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriver accepts every statement without a database
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+func (fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func init() {
+	sql.Register("ctxdb_fake", fakeDriver{})
+}
+
+func openDB(t *testing.T) *gorm.DB {
+	sqlDB, err := sql.Open("ctxdb_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open("postgres", sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestWithContext(t *testing.T) {
+	t.Run("bound", testWithContextBound)
+	t.Run("canceled", testWithContextCanceled)
+	t.Run("transaction", testWithContextTransaction)
+}
+
+func testWithContextBound(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	bound := WithContext(context.Background(), db)
+
+	assert.Nil(t, bound.Exec("DELETE FROM coupons").Error)
+	assert.Equal(t, "postgres", bound.Dialect().GetName())
+}
+
+func testWithContextCanceled(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	bound := WithContext(ctx, db)
+
+	assert.Equal(t, context.Canceled, bound.Exec("DELETE FROM coupons").Error)
+	assert.Equal(t, context.Canceled, bound.Begin().Error)
+	// the original database is left untouched
+	assert.Nil(t, db.Exec("DELETE FROM coupons").Error)
+}
+
+func testWithContextTransaction(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	assert.Equal(t, tx, WithContext(context.Background(), tx))
+}
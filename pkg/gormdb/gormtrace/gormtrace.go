@@ -0,0 +1,96 @@
+// Package gormtrace traces the statements of gorm databases with OpenTelemetry
+//
+// The statements of a database returned by WithContext are recorded as child spans of the span of its context.
+// Their SQL is recorded with its placeholders: the values are bound as arguments and never recorded,
+// so the spans hold no coupon data.
+package gormtrace
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/jcgfreitas/pb_api/pkg/gormdb/gormtrace"
+	// ctxKey is the gorm setting holding the context of the statements
+	ctxKey = "gormtrace:context"
+	// spanKey is the scope instance setting holding the span of the running statement
+	spanKey = "gormtrace:span"
+)
+
+// WithContext returns a copy of db whose statements are traced as children of the span of ctx
+// It doesn't bind the statements to ctx, see ctxdb.WithContext
+func WithContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.Set(ctxKey, ctx)
+}
+
+// Register registers the tracing callbacks in callback, the spans are created by tp
+// Pass gorm.DefaultCallback to trace the statements of every database, the databases returned by
+// ctxdb.WithContext included, or db.Callback() to only trace db
+func Register(callback *gorm.Callback, tp trace.TracerProvider) {
+	tracer := tp.Tracer(tracerName)
+	callback.Create().Before("gorm:create").Register("gormtrace:before_create", start(tracer, "INSERT"))
+	callback.Create().After("gorm:create").Register("gormtrace:after_create", end)
+	callback.Query().Before("gorm:query").Register("gormtrace:before_query", start(tracer, "SELECT"))
+	callback.Query().After("gorm:query").Register("gormtrace:after_query", end)
+	callback.RowQuery().Before("gorm:row_query").Register("gormtrace:before_row_query", start(tracer, "SELECT"))
+	callback.RowQuery().After("gorm:row_query").Register("gormtrace:after_row_query", end)
+	callback.Update().Before("gorm:update").Register("gormtrace:before_update", start(tracer, "UPDATE"))
+	callback.Update().After("gorm:update").Register("gormtrace:after_update", end)
+	callback.Delete().Before("gorm:delete").Register("gormtrace:before_delete", start(tracer, "DELETE"))
+	callback.Delete().After("gorm:delete").Register("gormtrace:after_delete", end)
+}
+
+// start returns a callback starting the span of an operation, statements without context are not traced
+func start(tracer trace.Tracer, operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.Get(ctxKey)
+		if !ok {
+			return
+		}
+		ctx, ok := v.(context.Context)
+		if !ok {
+			return
+		}
+
+		table := scope.TableName()
+		_, span := tracer.Start(ctx, operation+" "+table,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(system(scope.Dialect().GetName()), semconv.DBOperationName(operation), semconv.DBCollectionName(table)),
+		)
+		scope.InstanceSet(spanKey, span)
+	}
+}
+
+// end ends the span of the statement with its SQL and error
+func end(scope *gorm.Scope) {
+	v, ok := scope.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(semconv.DBQueryText(strings.TrimSpace(scope.SQL)))
+	if err := scope.DB().Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// system returns the db.system attribute of a gorm dialect
+func system(dialect string) attribute.KeyValue {
+	switch dialect {
+	case "postgres":
+		return semconv.DBSystemPostgreSQL
+	case "sqlite3":
+		return semconv.DBSystemSqlite
+	}
+	return semconv.DBSystemKey.String(dialect)
+}
@@ -0,0 +1,92 @@
+package gormtrace
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/sqlite"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+type event struct {
+	ID   int
+	Name string
+}
+
+func openDB(t *testing.T) (*gorm.DB, *tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	db, err := sqlite.Open(sqlite.Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	assert.Nil(t, db.Exec("CREATE TABLE events (id integer PRIMARY KEY, name text)").Error)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	Register(db.Callback(), tp)
+	return db, exporter, tp
+}
+
+// attr returns the value of the attribute key of span
+func attr(span tracetest.SpanStub, key string) string {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestRegister(t *testing.T) {
+	db, exporter, tp := openDB(t)
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	traced := WithContext(ctx, db)
+	assert.Nil(t, traced.Create(&event{ID: 1, Name: "secret"}).Error)
+	var e event
+	assert.Nil(t, traced.Where("name = ?", "secret").First(&e).Error)
+	assert.Nil(t, traced.Model(&e).Update("name", "other").Error)
+	assert.True(t, traced.First(&event{}, 2).RecordNotFound())
+	assert.Nil(t, traced.Delete(&e).Error)
+	// statements without context are not traced
+	assert.Nil(t, db.Find(&[]event{}).Error)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 6, len(spans))
+	names := make([]string, 0, len(spans))
+	for _, span := range spans[:5] {
+		names = append(names, span.Name)
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+		assert.Equal(t, "sqlite", attr(span, string(semconv.DBSystemKey)))
+		assert.Equal(t, "events", attr(span, "db.collection.name"))
+		assert.Equal(t, codes.Unset, span.Status.Code)
+
+		query := attr(span, "db.query.text")
+		assert.NotEmpty(t, query)
+		assert.False(t, strings.Contains(query, "secret"), query)
+		assert.False(t, strings.Contains(query, "other"), query)
+	}
+	assert.Equal(t, []string{"INSERT events", "SELECT events", "UPDATE events", "SELECT events", "DELETE events"}, names)
+}
+
+func TestRegisterError(t *testing.T) {
+	db, exporter, tp := openDB(t)
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	traced := WithContext(ctx, db)
+	assert.Nil(t, traced.Create(&event{ID: 1}).Error)
+	assert.NotNil(t, traced.Create(&event{ID: 1}).Error)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 3, len(spans))
+	assert.Equal(t, codes.Unset, spans[0].Status.Code)
+	assert.Equal(t, codes.Error, spans[1].Status.Code)
+}
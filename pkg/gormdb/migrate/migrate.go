@@ -0,0 +1,292 @@
+// Package migrate applies versioned SQL migrations to a gorm database
+//
+// Migrations are read from a fs.FS holding {version}_{name}.up.sql and {version}_{name}.down.sql files.
+// Applied versions are recorded in the schema_migrations table. On postgres an advisory lock is held while
+// migrating, so concurrent replicas wait for each other instead of applying the same migration twice.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+const (
+	// lockID is the postgres advisory lock key held while migrating
+	lockID = int64(7281945001)
+	// table holds the applied migrations
+	table = "schema_migrations"
+	// createTable creates the schema_migrations table, it is the only schema not managed through migrations
+	// It is formatted with the time type of the dialect
+	createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	applied_at %s NOT NULL
+)`
+)
+
+// timeTypes holds the time column type of the dialects which don't support timestamp with time zone
+// sqlite only reads back datetime and timestamp columns as time values
+var timeTypes = map[string]string{
+	"sqlite3": "datetime",
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change
+// Down is empty if the migration can't be rolled back
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is the state of a migration in the database
+type Status struct {
+	Version   uint
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the schema_migrations table
+type schemaMigration struct {
+	Version   uint `gorm:"primary_key"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// TableName implements gorm's tabler interface
+func (schemaMigration) TableName() string {
+	return table
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New is the Migrator constructor, it loads the migrations of fsys
+func New(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Load reads the migrations of fsys, sorted by version
+// Every version must have an up file, down files are optional
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read migrations")
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		match := fileName.FindStringSubmatch(f.Name())
+		if match == nil {
+			continue
+		}
+		v64, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid migration version: %s", f.Name())
+		}
+		version := uint(v64)
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(fsys, f.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read migration %s", f.Name())
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration in version order and returns the applied ones
+// Each migration runs in its own transaction along with its schema_migrations record
+func (m *Migrator) Up() ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(func() error {
+		done, err := m.applied(true)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := done[mig.Version]; ok {
+				continue
+			}
+			record := schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now()}
+			if err := m.run(mig.Up, func(tx *gorm.DB) error { return tx.Create(&record).Error }); err != nil {
+				return errors.Wrapf(err, "failed to apply migration %d_%s", mig.Version, mig.Name)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations, most recent first, and returns the rolled back ones
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := m.locked(func() error {
+		done, err := m.applied(true)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := done[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("migration %d_%s can't be rolled back", mig.Version, mig.Name)
+			}
+			record := schemaMigration{Version: mig.Version}
+			if err := m.run(mig.Down, func(tx *gorm.DB) error { return tx.Delete(&record).Error }); err != nil {
+				return errors.Wrapf(err, "failed to roll back migration %d_%s", mig.Version, mig.Name)
+			}
+			rolledBack = append(rolledBack, mig)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status returns the state of every known migration in version order
+func (m *Migrator) Status() ([]Status, error) {
+	done, err := m.applied(false)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		status[i] = Status{Version: mig.Version, Name: mig.Name}
+		if r, ok := done[mig.Version]; ok {
+			appliedAt := r.AppliedAt
+			status[i].Applied = true
+			status[i].AppliedAt = &appliedAt
+		}
+	}
+	return status, nil
+}
+
+// Pending returns the number of migrations which are not applied yet
+func (m *Migrator) Pending() (int, error) {
+	done, err := m.applied(false)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, mig := range m.migrations {
+		if _, ok := done[mig.Version]; !ok {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// applied returns the schema_migrations records by version
+// If create is true the table is created when it is missing, otherwise a missing table means no migration was applied
+func (m *Migrator) applied(create bool) (map[uint]schemaMigration, error) {
+	if create {
+		timeType, ok := timeTypes[m.db.Dialect().GetName()]
+		if !ok {
+			timeType = "timestamp with time zone"
+		}
+		if err := m.db.Exec(fmt.Sprintf(createTable, timeType)).Error; err != nil {
+			return nil, errors.Wrap(err, "failed to create schema_migrations table")
+		}
+	} else if !m.db.HasTable(table) {
+		return map[uint]schemaMigration{}, nil
+	}
+	var records []schemaMigration
+	if err := m.db.Find(&records).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to read schema_migrations table")
+	}
+	done := make(map[uint]schemaMigration, len(records))
+	for _, r := range records {
+		done[r.Version] = r
+	}
+	return done, nil
+}
+
+// run executes a migration script and records it in a single transaction
+func (m *Migrator) run(script string, record func(tx *gorm.DB) error) error {
+	tx := m.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := tx.Exec(script).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// locked runs fn while holding the migrations advisory lock
+// The lock is a postgres session lock, so it is taken and released on a dedicated connection of the pool
+// Other dialects have no such lock and run fn directly
+func (m *Migrator) locked(fn func() error) error {
+	if m.db.Dialect().GetName() != "postgres" {
+		return fn()
+	}
+
+	sqlDB := m.db.DB()
+	if sqlDB == nil {
+		return fn()
+	}
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get a lock connection")
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return errors.Wrap(err, "failed to take migrations lock")
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockID)
+
+	return fn()
+}
@@ -0,0 +1,125 @@
+// +build integration
+
+package migrate
+
+import (
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jcgfreitas/pb_api/pkg/gormdb/postgres"
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	host     = "localhost"
+	port     = "5432"
+	user     = "postgres"
+	dbName   = "postgres"
+	password = "password1"
+)
+
+var testMigrations = fstest.MapFS{
+	"0001_create_items.up.sql":   {Data: []byte("CREATE TABLE migrate_items (id serial PRIMARY KEY);")},
+	"0001_create_items.down.sql": {Data: []byte("DROP TABLE migrate_items;")},
+	"0002_add_name.up.sql":       {Data: []byte("ALTER TABLE migrate_items ADD COLUMN name text;")},
+	"0002_add_name.down.sql":     {Data: []byte("ALTER TABLE migrate_items DROP COLUMN name;")},
+}
+
+func TestMigrator(t *testing.T) {
+	db := startDB(t)
+	defer db.Close()
+	m, err := New(db, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := m.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, pending)
+
+	applied, err := m.Up()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(applied))
+	assert.True(t, db.Dialect().HasColumn("migrate_items", "name"))
+
+	applied, err = m.Up()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(applied))
+
+	rolledBack, err := m.Down(1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(2), rolledBack[0].Version)
+	assert.False(t, db.Dialect().HasColumn("migrate_items", "name"))
+
+	status, err := m.Status()
+	assert.Nil(t, err)
+	assert.True(t, status[0].Applied)
+	assert.NotNil(t, status[0].AppliedAt)
+	assert.False(t, status[1].Applied)
+
+	_, err = m.Down(5)
+	assert.Nil(t, err)
+	assert.False(t, db.HasTable("migrate_items"))
+}
+
+func TestMigratorFailure(t *testing.T) {
+	db := startDB(t)
+	defer db.Close()
+	fsys := fstest.MapFS{
+		"0001_create_items.up.sql": testMigrations["0001_create_items.up.sql"],
+		"0002_broken.up.sql":       {Data: []byte("ALTER TABLE missing_table ADD COLUMN name text;")},
+	}
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Up()
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(applied))
+
+	pending, err := m.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, pending)
+}
+
+// TestMigratorConcurrent checks that the advisory lock keeps replicas from applying the same migration twice
+func TestMigratorConcurrent(t *testing.T) {
+	db := startDB(t)
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	counts := make([]int, 4)
+	for i := range counts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := New(db, testMigrations)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			applied, err := m.Up()
+			assert.Nil(t, err)
+			counts[i] = len(applied)
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	assert.Equal(t, 2, total)
+}
+
+func startDB(t *testing.T) *gorm.DB {
+	db, err := postgres.Open(postgres.Config{Host: host, Port: port, User: user, Name: dbName, Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DropTableIfExists("migrate_items", table)
+	return db
+}
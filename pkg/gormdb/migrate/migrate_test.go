@@ -0,0 +1,65 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("sorted", testLoadSorted)
+	t.Run("optionalDown", testLoadOptionalDown)
+	t.Run("missingUp", testLoadMissingUp)
+	t.Run("conflictingNames", testLoadConflictingNames)
+}
+
+func testLoadSorted(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0010_add_index.up.sql":        {Data: []byte("CREATE INDEX")},
+		"0010_add_index.down.sql":      {Data: []byte("DROP INDEX")},
+		"0002_create_table.up.sql":     {Data: []byte("CREATE TABLE")},
+		"0002_create_table.down.sql":   {Data: []byte("DROP TABLE")},
+		"README.md":                    {Data: []byte("not a migration")},
+		"0003_not_a_migration.sql.bak": {Data: []byte("")},
+	}
+
+	migrations, err := Load(fsys)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 2, Name: "create_table", Up: "CREATE TABLE", Down: "DROP TABLE"},
+		{Version: 10, Name: "add_index", Up: "CREATE INDEX", Down: "DROP INDEX"},
+	}, migrations)
+}
+
+func testLoadOptionalDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_data.up.sql": {Data: []byte("UPDATE coupons")},
+	}
+
+	migrations, err := Load(fsys)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(migrations))
+	assert.Equal(t, "", migrations[0].Down)
+}
+
+func testLoadMissingUp(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_table.down.sql": {Data: []byte("DROP TABLE")},
+	}
+
+	_, err := Load(fsys)
+	assert.Error(t, err)
+}
+
+func testLoadConflictingNames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_create_table.up.sql": {Data: []byte("CREATE TABLE")},
+		"0001_other_name.down.sql": {Data: []byte("DROP TABLE")},
+	}
+
+	_, err := Load(fsys)
+	assert.Error(t, err)
+}
@@ -2,6 +2,8 @@ package postgres
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -9,12 +11,106 @@ import (
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
+// SSL modes supported by the postgres driver
+const (
+	SSLDisable    = "disable"
+	SSLRequire    = "require"
+	SSLVerifyCA   = "verify-ca"
+	SSLVerifyFull = "verify-full"
+)
+
+// Config holds the connection and pool settings of a postgres database
+type Config struct {
+	// DSN is a libpq connection string or postgres:// URL, when set the connection fields below are ignored
+	DSN      string
+	Host     string
+	Port     string
+	User     string
+	Name     string
+	Password string
+	// SSLMode is one of the SSL... modes, SSLDisable if empty
+	SSLMode string
+	// SSLRootCert is the CA file verifying the server certificate in the SSLVerifyCA and SSLVerifyFull modes
+	SSLRootCert string
+
+	// MaxOpenConns is the maximum number of open connections, 0 means unlimited
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections, 0 keeps the database/sql default
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum duration a connection is reused, 0 means forever
+	// A limited lifetime replaces the connections to a restarted or failed over server
+	ConnMaxLifetime time.Duration
+
+	// ConnectRetries is the number of times a failed connection is retried before Open gives up
+	ConnectRetries int
+	// RetryBackoff is the wait before the first retry, it doubles after every failed retry
+	RetryBackoff time.Duration
+}
+
 // Open starts a gorm postgres database instance
-func Open(host, port, user, dbName, password string) (db *gorm.DB, err error) {
-	dbArgs := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable", host, port, user, dbName, password)
-	db, err = gorm.Open("postgres", dbArgs)
+// The connection is checked with a ping and retried according to the config, so the db may still be starting
+func Open(cfg Config) (db *gorm.DB, err error) {
+	dsn, err := cfg.dsn()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to connect to database")
+		return nil, err
+	}
+
+	backoff := cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		// gorm.Open pings the db
+		db, err = gorm.Open("postgres", dsn)
+		if err == nil || attempt >= cfg.ConnectRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to database after %d attempts", cfg.ConnectRetries+1)
+	}
+
+	sqlDB := db.DB()
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
 	}
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	return db, nil
 }
+
+// dsn returns the data source name of the config
+func (cfg Config) dsn() (string, error) {
+	if cfg.DSN != "" {
+		return cfg.DSN, nil
+	}
+
+	sslMode := cfg.SSLMode
+	switch sslMode {
+	case "":
+		sslMode = SSLDisable
+	case SSLDisable, SSLRequire, SSLVerifyCA, SSLVerifyFull:
+	default:
+		return "", fmt.Errorf("unknown sslmode: %s", sslMode)
+	}
+	if cfg.SSLRootCert != "" && sslMode != SSLVerifyCA && sslMode != SSLVerifyFull {
+		return "", fmt.Errorf("sslrootcert requires the %s or %s sslmode", SSLVerifyCA, SSLVerifyFull)
+	}
+
+	params := []string{
+		"host=" + quote(cfg.Host),
+		"port=" + quote(cfg.Port),
+		"user=" + quote(cfg.User),
+		"dbname=" + quote(cfg.Name),
+		"password=" + quote(cfg.Password),
+		"sslmode=" + sslMode,
+	}
+	if cfg.SSLRootCert != "" {
+		params = append(params, "sslrootcert="+quote(cfg.SSLRootCert))
+	}
+	return strings.Join(params, " "), nil
+}
+
+// quote quotes a libpq connection string value, so it may hold spaces and quotes
+func quote(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigDSN(t *testing.T) {
+	cfg := Config{Host: "localhost", Port: "5432", User: "postgres", Name: "coupons", Password: `it's a \secret`}
+
+	dsn, err := cfg.dsn()
+	assert.Nil(t, err)
+	assert.Equal(t, `host='localhost' port='5432' user='postgres' dbname='coupons' password='it\'s a \\secret' sslmode=disable`, dsn)
+
+	cfg.SSLMode = SSLVerifyFull
+	cfg.SSLRootCert = "/etc/ssl/ca.pem"
+	dsn, err = cfg.dsn()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(dsn, "sslmode=verify-full sslrootcert='/etc/ssl/ca.pem'"))
+
+	cfg.DSN = "postgres://postgres@localhost/coupons"
+	dsn, err = cfg.dsn()
+	assert.Nil(t, err)
+	assert.Equal(t, cfg.DSN, dsn)
+}
+
+func TestConfigDSNInvalid(t *testing.T) {
+	_, err := Config{SSLMode: "sometimes"}.dsn()
+	assert.NotNil(t, err)
+
+	_, err = Config{SSLMode: SSLRequire, SSLRootCert: "/etc/ssl/ca.pem"}.dsn()
+	assert.NotNil(t, err)
+}
+
+func TestOpenRetries(t *testing.T) {
+	// nothing listens on port 1, every attempt is refused
+	cfg := Config{Host: "127.0.0.1", Port: "1", ConnectRetries: 2, RetryBackoff: 10 * time.Millisecond}
+
+	start := time.Now()
+	_, err := Open(cfg)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "after 3 attempts")
+	// 10ms then 20ms of backoff
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+}
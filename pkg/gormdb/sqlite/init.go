@@ -0,0 +1,94 @@
+// Package sqlite opens gorm sqlite databases which behave like the postgres ones
+//
+// sqlite has no time type: times are stored as text and compared as strings, which only orders them
+// if they share a time zone. The databases opened here convert every time argument to UTC, so time
+// comparisons, soft delete and date functions agree with postgres whatever the zone of the values.
+package sqlite
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+const (
+	// driverName is the database/sql name of the UTC sqlite driver
+	driverName = "sqlite3_utc"
+	// dialect is the gorm dialect of the sqlite databases
+	dialect = "sqlite3"
+	// Memory is the path of a private in memory database
+	Memory = ":memory:"
+	// params are the connection parameters of file databases: writers wait for each other
+	// instead of failing with SQLITE_BUSY, and transactions take the write lock when they begin
+	params = "_busy_timeout=5000&_txlock=immediate&_journal_mode=WAL"
+)
+
+func init() {
+	sql.Register(driverName, &utcDriver{})
+}
+
+// utcDriver is a sqlite driver whose connections convert time arguments to UTC
+type utcDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+// Open implements driver.Driver
+func (d *utcDriver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &utcConn{SQLiteConn: c.(*sqlite3.SQLiteConn)}, nil
+}
+
+// utcConn is a sqlite connection converting time arguments to UTC
+type utcConn struct {
+	*sqlite3.SQLiteConn
+}
+
+// CheckNamedValue implements driver.NamedValueChecker
+// Other arguments are left to the default database/sql conversion
+func (c *utcConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if t, ok := nv.Value.(time.Time); ok {
+		nv.Value = t.UTC()
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+// Open starts a gorm sqlite database instance stored in the file at path
+// If path is Memory the database only lives in memory, it is lost once closed.
+// A "file:" URI is used as is, so it can set its own connection parameters
+func Open(path string) (db *gorm.DB, err error) {
+	sqlDB, err := sql.Open(driverName, dsn(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+	if path == Memory {
+		// every connection to :memory: opens a different database
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	db, err = gorm.Open(dialect, sqlDB)
+	if err != nil {
+		sqlDB.Close()
+		return nil, errors.Wrap(err, "failed to connect to database")
+	}
+	return db, nil
+}
+
+// dsn returns the data source name of the database at path
+func dsn(path string) string {
+	if path == Memory {
+		return path
+	}
+	if strings.HasPrefix(path, "file:") {
+		return path
+	}
+	return "file:" + path + "?" + params
+}
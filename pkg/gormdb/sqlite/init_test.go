@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type event struct {
+	ID int
+	At time.Time
+}
+
+// TestOpen tests that times of different zones are stored in UTC, so they are compared in order
+func TestOpen(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	assert.Nil(t, db.Exec("CREATE TABLE events (id integer PRIMARY KEY, at datetime)").Error)
+
+	at := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	// 11:00 UTC stored with a later wall clock than 12:00 UTC
+	east := at.Add(-time.Hour).In(time.FixedZone("east", 5*60*60))
+	assert.Nil(t, db.Create(&event{ID: 1, At: at}).Error)
+	assert.Nil(t, db.Create(&event{ID: 2, At: east}).Error)
+
+	var events []event
+	assert.Nil(t, db.Where("at < ?", at.In(time.FixedZone("west", -5*60*60))).Find(&events).Error)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, 2, events[0].ID)
+	assert.True(t, east.Equal(events[0].At))
+
+	var month string
+	assert.Nil(t, db.Raw("SELECT strftime('%Y-%m', at) FROM events WHERE id = 2").Row().Scan(&month))
+	assert.Equal(t, "2020-01", month)
+}
+
+func TestOpenMemory(t *testing.T) {
+	db, err := Open(Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// every statement must see the same database
+	assert.Nil(t, db.Exec("CREATE TABLE events (id integer PRIMARY KEY, at datetime)").Error)
+	assert.True(t, db.HasTable("events"))
+}
@@ -0,0 +1,56 @@
+// Package statuswriter records the status code and size of the responses written by http handlers, for the
+// middlewares which report them once the handler returns
+package statuswriter
+
+import "net/http"
+
+// Writer is a http.ResponseWriter recording the status code and the number of body bytes written through it
+// It implements http.Flusher whenever the writer it wraps does, so streamed responses are still flushed
+type Writer struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Wrap returns a Writer writing to w
+func Wrap(w http.ResponseWriter) *Writer {
+	return &Writer{ResponseWriter: w}
+}
+
+// WriteHeader records the status code and writes it
+func (w *Writer) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the implicit 200 status if no status was written yet
+func (w *Writer) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush flushes the wrapped writer if it is a http.Flusher
+func (w *Writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Status returns the status code of the response, 200 if the handler wrote nothing
+func (w *Writer) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Bytes returns the number of body bytes written
+func (w *Writer) Bytes() int64 {
+	return w.bytes
+}
@@ -0,0 +1,46 @@
+package statuswriter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		bytes   int64
+	}{
+		{"nothing", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+		{"write", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("[{}"))
+			w.Write([]byte("]"))
+		}, http.StatusOK, 4},
+		{"header", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }, http.StatusNotFound, 0},
+		{"superfluousHeader", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusCreated, 0},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		w := Wrap(rec)
+		c.handler(w, httptest.NewRequest("GET", "/", http.NoBody))
+		assert.Equal(t, c.status, w.Status(), c.name)
+		assert.Equal(t, c.bytes, w.Bytes(), c.name)
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = Wrap(rec)
+
+	f, ok := w.(http.Flusher)
+	assert.True(t, ok)
+	f.Flush()
+	assert.True(t, rec.Flushed)
+}